@@ -0,0 +1,299 @@
+// Package admin serves the minimal operational console of a borm time
+// series engine: the shards with their sizes, tags and retention status, the
+// write and read rates, and a query form downloading the records as NDJSON.
+// The handler answers:
+//
+//	GET /                                   the console
+//	GET /api/shards                         the shards, newest first
+//	GET /api/stats                          the engine statistics
+//	GET /api/query?start=&end=&filter=&limit=&cursor=
+//	                                        the records, newline delimited JSON
+//	GET /debug/borm?start=&end=             the Bolt statistics of the shards
+//
+// The rates are computed by the console from the counters of two polls of
+// /api/stats. A query is limited to Options.MaxRecords, its last line has
+// the error of a query failed midway.
+//
+// The queries are paginated by key: the last line of a page cut by the
+// limit has the next_cursor to pass as the cursor of the next page, along
+// with the same start, end and filter. The next page starts right after the
+// last record of the page, so the records appended meanwhile never shift
+// the pages, they are read by the last one. Protect the handler with the auth
+// package and serve it with TLS, see borm.TLSConfig, it shows the records.
+//
+// Without a time range /debug/borm has the shards open for writing only,
+// PublishExpvar publishes the same under /debug/vars.
+package admin
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+//go:embed ui/index.html
+var indexHTML []byte
+
+// DefaultMaxRecords is the default of Options.MaxRecords.
+const DefaultMaxRecords = 10000
+
+// Options configures the handler.
+type Options struct {
+	// Retention, when set, is the policy the shards are checked against, the
+	// ones it would delete are marked as expiring.
+	Retention *borm.RetentionPolicy
+
+	// MaxRecords limits the records of a query, it defaults to
+	// DefaultMaxRecords.
+	MaxRecords int
+}
+
+// Handler is the console http.Handler, mount it with http.StripPrefix
+// under a path other than the root.
+type Handler struct {
+	db   *borm.TSEngine
+	opts Options
+}
+
+// NewHandler returns the console handler for the engine.
+func NewHandler(db *borm.TSEngine, opts *Options) *Handler {
+	h := &Handler{db: db}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.MaxRecords <= 0 {
+		h.opts.MaxRecords = DefaultMaxRecords
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "", "/index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	case "/api/shards":
+		h.serveJSON(w, h.shards)
+	case "/api/stats":
+		h.serveJSON(w, h.stats)
+	case "/api/query":
+		h.query(w, r)
+	case "/debug/borm":
+		h.boltStats(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveJSON(w http.ResponseWriter, get func() (interface{}, error)) {
+	result, err := get()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// shard is a shard of /api/shards.
+type shard struct {
+	Name      string            `json:"name"`
+	Start     time.Time         `json:"start"`
+	End       time.Time         `json:"end"`
+	Size      int64             `json:"size"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Pinned    bool              `json:"pinned,omitempty"`
+	PinReason string            `json:"pin_reason,omitempty"`
+	Expiring  bool              `json:"expiring,omitempty"`
+}
+
+func (h *Handler) shards() (interface{}, error) {
+	infos, err := h.db.Shards()
+	if err != nil {
+		return nil, err
+	}
+	expiring := map[string]bool{}
+	if h.opts.Retention != nil {
+		candidates, err := h.db.RetentionCandidates(*h.opts.Retention)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range candidates {
+			expiring[info.Path] = true
+		}
+	}
+
+	shards := make([]shard, len(infos))
+	for i, info := range infos {
+		shards[i] = shard{
+			Name:      filepath.Base(info.Path),
+			Start:     info.StartTime,
+			End:       info.EndTime,
+			Size:      info.Size,
+			Tags:      info.Tags,
+			Pinned:    info.Pinned,
+			PinReason: info.PinReason,
+			Expiring:  expiring[info.Path],
+		}
+	}
+	return shards, nil
+}
+
+// stats are the statistics of /api/stats.
+type stats struct {
+	Time           time.Time `json:"time"`
+	RecordsWritten int64     `json:"records_written"`
+	RecordsRead    int64     `json:"records_read"`
+	OpenWriters    int       `json:"open_writers"`
+	OpenFiles      int       `json:"open_files"`
+}
+
+func (h *Handler) stats() (interface{}, error) {
+	s := h.db.Stats()
+	return &stats{
+		Time:           time.Now(),
+		RecordsWritten: s.RecordsWritten,
+		RecordsRead:    s.RecordsRead,
+		OpenWriters:    s.OpenWriters,
+		OpenFiles:      s.Files.Open,
+	}, nil
+}
+
+func (h *Handler) boltStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("start") == "" && query.Get("end") == "" {
+		h.serveJSON(w, func() (interface{}, error) {
+			return h.db.OpenBoltStats()
+		})
+		return
+	}
+	start, err := time.Parse(time.RFC3339Nano, query.Get("start"))
+	if err != nil {
+		http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse(time.RFC3339Nano, query.Get("end"))
+	if err != nil {
+		http.Error(w, "invalid end: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.serveJSON(w, func() (interface{}, error) {
+		return h.db.BoltStats(start, end)
+	})
+}
+
+// PublishExpvar publishes the engine statistics and the Bolt statistics of
+// the shards open for writing as the expvar name. It panics if the name is
+// already published, like expvar.Publish.
+func PublishExpvar(name string, db *borm.TSEngine) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		shards, err := db.OpenBoltStats()
+		if err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+		return map[string]interface{}{"engine": db.Stats(), "shards": shards}
+	}))
+}
+
+// line is a line of /api/query, the value is the record if it is JSON, Raw
+// otherwise.
+type line struct {
+	Key        string          `json:"key,omitempty"`
+	Time       *time.Time      `json:"time,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	Raw        []byte          `json:"raw,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+var errLimit = errors.New("record limit reached")
+
+func (h *Handler) query(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start, err := time.Parse(time.RFC3339Nano, query.Get("start"))
+	if err != nil {
+		http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse(time.RFC3339Nano, query.Get("end"))
+	if err != nil {
+		http.Error(w, "invalid end: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := query.Get("filter")
+	if filter != "" {
+		if _, err := borm.ParseFilter(filter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := h.opts.MaxRecords
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit "+s, http.StatusBadRequest)
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if query.Get("download") != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="records.ndjson"`)
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	count := 0
+	last := ""
+	opts := &borm.QueryOptions{Filter: filter, ResumeToken: query.Get("cursor")}
+	err = h.db.QueryWith(start, end, opts, func(it *borm.Iterator) error {
+		for it.Next() {
+			if err := r.Context().Err(); err != nil {
+				return err
+			}
+			if count >= limit {
+				return errLimit
+			}
+			count++
+			last = it.Token()
+			l := line{Key: string(it.Key())}
+			if t := borm.TimeFromID(l.Key); !t.IsZero() {
+				l.Time = &t
+			}
+			if value := it.Value(); json.Valid(value) {
+				l.Value = value
+			} else {
+				l.Raw = value
+			}
+			if err := enc.Encode(&l); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errLimit):
+		enc.Encode(&line{NextCursor: last})
+	case errors.Is(err, borm.ErrInvalidToken) && count == 0:
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	case err != nil:
+		enc.Encode(&line{Error: err.Error()})
+	}
+	bw.Flush()
+}
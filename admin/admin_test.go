@@ -0,0 +1,287 @@
+package admin_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/admin"
+)
+
+type event struct {
+	Action string
+	Bytes  float64
+}
+
+func get(t *testing.T, srv *httptest.Server, path string, result interface{}) *http.Response {
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatalf("Error getting %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("Error decoding %s response: %s", path, err)
+		}
+	}
+	return resp
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+	var entries []borm.Entry
+	for i := 0; i < 20; i++ {
+		action := "allow"
+		if i%4 == 0 {
+			action = "block"
+		}
+		entries = append(entries, borm.Entry{Time: now.Add(-time.Duration(i) * time.Second), Value: &event{Action: action, Bytes: float64(i)}})
+	}
+	entries = append(entries, borm.Entry{Time: old, Value: &event{Action: "allow"}})
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+
+	srv := httptest.NewServer(admin.NewHandler(db, &admin.Options{
+		Retention:  &borm.RetentionPolicy{MaxAge: 5 * 24 * time.Hour},
+		MaxRecords: 15,
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("Error getting the console: %s", err)
+	}
+	page, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "api/shards") {
+		t.Fatalf("Console got %d %q.", resp.StatusCode, page)
+	}
+
+	var shards []struct {
+		Name     string
+		Size     int64
+		Expiring bool
+	}
+	get(t, srv, "/api/shards", &shards)
+	if len(shards) != 2 {
+		t.Fatalf("Shards are %+v wanted 2.", shards)
+	}
+	for _, s := range shards {
+		if s.Size <= 0 || s.Expiring != (s.Name == borm.Daily.Name(old)) {
+			t.Fatalf("Shard is %+v.", s)
+		}
+	}
+
+	var before, after struct {
+		RecordsWritten int64 `json:"records_written"`
+		RecordsRead    int64 `json:"records_read"`
+	}
+	get(t, srv, "/api/stats", &before)
+	if before.RecordsWritten != int64(len(entries)) {
+		t.Fatalf("Records written are %d wanted %d.", before.RecordsWritten, len(entries))
+	}
+
+	params := url.Values{
+		"start":  {now.Add(-time.Minute).Format(time.RFC3339Nano)},
+		"end":    {now.Add(time.Second).Format(time.RFC3339Nano)},
+		"filter": {`Action = "block"`},
+	}
+	resp, err = http.Get(srv.URL + "/api/query?" + params.Encode())
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	var lines []map[string]json.RawMessage
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var l map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("Error decoding line %q: %s", scanner.Text(), err)
+		}
+		lines = append(lines, l)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content type is %q.", ct)
+	}
+	if len(lines) != 5 {
+		t.Fatalf("Query got %d lines wanted 5: %v", len(lines), lines)
+	}
+	for _, l := range lines {
+		var e event
+		if err := json.Unmarshal(l["value"], &e); err != nil || e.Action != "block" || l["key"] == nil || l["time"] == nil {
+			t.Fatalf("Line is %v.", l)
+		}
+	}
+
+	get(t, srv, "/api/stats", &after)
+	if after.RecordsRead <= before.RecordsRead {
+		t.Fatalf("Records read went from %d to %d.", before.RecordsRead, after.RecordsRead)
+	}
+
+	params.Del("filter")
+	params.Set("download", "1")
+	resp, err = http.Get(srv.URL + "/api/query?" + params.Encode())
+	if err != nil {
+		t.Fatalf("Error downloading: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if n := strings.Count(string(body), "\n"); n != 16 || !strings.Contains(string(body), `"next_cursor"`) {
+		t.Fatalf("Download got %d lines wanted the 15 of MaxRecords and the next cursor.", n)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), "attachment") {
+		t.Fatalf("Download is not an attachment: %q.", resp.Header.Get("Content-Disposition"))
+	}
+
+	var bolt []borm.ShardBoltStats
+	get(t, srv, "/debug/borm", &bolt)
+	if len(bolt) != 0 {
+		t.Fatalf("Bolt stats are %+v wanted no shard open, the records were backfilled.", bolt)
+	}
+	get(t, srv, "/debug/borm?"+url.Values{"start": {old.Add(-time.Hour).Format(time.RFC3339Nano)}, "end": params["end"]}.Encode(), &bolt)
+	if len(bolt) != 2 || bolt[0].PageSize == 0 || bolt[0].Bolt.TxN == 0 {
+		t.Fatalf("Bolt stats are %+v wanted the 2 shards.", bolt)
+	}
+
+	admin.PublishExpvar("borm_test", db)
+	var published struct {
+		Engine borm.EngineStats
+		Shards []borm.ShardBoltStats
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("borm_test").String()), &published); err != nil {
+		t.Fatalf("Error decoding the expvar: %s", err)
+	}
+	if published.Engine.RecordsWritten != int64(len(entries)) {
+		t.Fatalf("Published stats are %+v.", published)
+	}
+
+	for path, code := range map[string]int{
+		"/api/query?start=yesterday": http.StatusBadRequest,
+		"/api/query?" + url.Values{"start": params["start"], "end": params["end"], "filter": {"Action ="}}.Encode(): http.StatusBadRequest,
+		"/api/nothing":                http.StatusNotFound,
+		"/debug/borm?start=yesterday": http.StatusBadRequest,
+		"/api/query?" + url.Values{"start": params["start"], "end": params["end"], "cursor": {"!"}}.Encode(): http.StatusBadRequest,
+	} {
+		if resp := get(t, srv, path, nil); resp.StatusCode != code {
+			t.Fatalf("%s got %d wanted %d.", path, resp.StatusCode, code)
+		}
+	}
+}
+
+// page returns the keys of a page of the query and its next cursor.
+func page(t *testing.T, srv *httptest.Server, params url.Values) ([]string, string) {
+	resp, err := http.Get(srv.URL + "/api/query?" + params.Encode())
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	defer resp.Body.Close()
+	var keys []string
+	next := ""
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var l struct {
+			Key        string `json:"key"`
+			Error      string `json:"error"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := dec.Decode(&l); err != nil {
+			t.Fatalf("Error decoding page: %s", err)
+		}
+		if l.Error != "" {
+			t.Fatalf("Page failed: %s", l.Error)
+		}
+		if l.NextCursor != "" {
+			next = l.NextCursor
+		} else {
+			keys = append(keys, l.Key)
+		}
+	}
+	return keys, next
+}
+
+func TestPagination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	start := time.Now().Add(-time.Hour)
+	write := func(i int) {
+		at := start.Add(time.Duration(i) * time.Second)
+		if err := db.Write(at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(db.NewID(at), &event{Action: "allow", Bytes: float64(i)})
+		}); err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		write(i)
+	}
+
+	srv := httptest.NewServer(admin.NewHandler(db, nil))
+	defer srv.Close()
+
+	params := url.Values{
+		"start": {start.Add(-time.Second).Format(time.RFC3339Nano)},
+		"end":   {start.Add(time.Minute).Format(time.RFC3339Nano)},
+		"limit": {"4"},
+	}
+	var all []string
+	for written := 10; ; written++ {
+		keys, next := page(t, srv, params)
+		all = append(all, keys...)
+		if next == "" {
+			break
+		}
+		// appended between the pages, read by the last one
+		write(written)
+		params.Set("cursor", next)
+	}
+
+	seen := map[string]bool{}
+	for _, key := range all {
+		if seen[key] {
+			t.Fatalf("Key %s is on two pages.", key)
+		}
+		seen[key] = true
+	}
+	if n, _ := db.Count(start.Add(-time.Second), start.Add(time.Minute), nil); int64(len(all)) != n || n != 12 {
+		t.Fatalf("Pages have %d records of the %d.", len(all), n)
+	}
+}
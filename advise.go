@@ -0,0 +1,27 @@
+package borm
+
+import (
+	"os"
+	"time"
+)
+
+// Prefetch advises the kernel to read the shards of the time range into the
+// page cache ahead of a planned query.
+func (db *TSEngine) Prefetch(start, end time.Time) error {
+	return filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if err := fadvise(fileName, fadvWillNeed); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// dropCache advises the kernel to evict the shard from the page cache after
+// a scan, so it doesn't push the hot shard out. Shards open for writing are
+// left alone, the pages of their memory map stay resident anyway.
+func (db *TSEngine) dropCache(fileName string, day time.Time) {
+	if !db.isHistorical(day) || db.isOpen(fileName) {
+		return
+	}
+	fadvise(fileName, fadvDontNeed)
+}
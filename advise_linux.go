@@ -0,0 +1,30 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package borm
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fadvWillNeed = 3
+	fadvDontNeed = 4
+)
+
+// fadvise gives the kernel the advice about the page cache of the whole file.
+func fadvise(fileName string, advice int) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, uintptr(advice), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux || (!amd64 && !arm64)
+// +build !linux !amd64,!arm64
+
+package borm
+
+const (
+	fadvWillNeed = 3
+	fadvDontNeed = 4
+)
+
+// fadvise is a no-op where posix_fadvise is not available.
+func fadvise(fileName string, advice int) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package borm
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Aggregation is a group by executed by the engine while iterating, so only
+// the compact result goes back to the caller.
+type Aggregation struct {
+	// GroupBy returns the group of a record, records it returns false for
+	// are not counted.
+	GroupBy func(key, value []byte) (string, bool)
+
+	// Value returns the number summed per group, it may be nil when only
+	// counts are needed.
+	Value func(key, value []byte) float64
+
+	// TopN limits the result to the N first groups, 0 returns all groups.
+	TopN int
+
+	// OrderBySum orders the groups by decreasing sum, by default they are
+	// ordered by decreasing count.
+	OrderBySum bool
+}
+
+// Group is a row of an aggregation result.
+type Group struct {
+	Key   string
+	Count int64
+	Sum   float64
+}
+
+// Aggregate runs the aggregation over the records in the time range, e.g.
+// the top 20 source IPs of the last 6 hours.
+func (db *TSEngine) Aggregate(start, end time.Time, opts *QueryOptions, agg *Aggregation) ([]Group, error) {
+	return db.aggregate(db.reader(opts.lane()), start, end, opts, agg)
+}
+
+// Count returns the number of records in the time range matching the
+// options, e.g. QueryOptions.Filter.
+func (db *TSEngine) Count(start, end time.Time, opts *QueryOptions) (int64, error) {
+	return db.count(db.reader(opts.lane()), start, end, opts)
+}
+
+func (db *TSEngine) count(read readFunc, start, end time.Time, opts *QueryOptions) (int64, error) {
+	var n int64
+	err := db.queryWith(read, start, end, opts, func(it *Iterator) error {
+		for it.Next() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (db *TSEngine) aggregate(read readFunc, start, end time.Time, opts *QueryOptions, agg *Aggregation) ([]Group, error) {
+	if agg == nil || agg.GroupBy == nil {
+		return nil, errors.New("aggregation has no GroupBy")
+	}
+
+	groups := map[string]*Group{}
+	err := db.queryWith(read, start, end, opts, func(it *Iterator) error {
+		for it.Next() {
+			key, ok := agg.GroupBy(it.Key(), it.Value())
+			if !ok {
+				continue
+			}
+			g := groups[key]
+			if g == nil {
+				g = &Group{Key: key}
+				groups[key] = g
+			}
+			g.Count++
+			if agg.Value != nil {
+				g.Sum += agg.Value(it.Key(), it.Value())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		results = append(results, *g)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if agg.OrderBySum && a.Sum != b.Sum {
+			return a.Sum > b.Sum
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Key < b.Key
+	})
+	if agg.TopN > 0 && len(results) > agg.TopN {
+		results = results[:agg.TopN]
+	}
+	return results, nil
+}
@@ -0,0 +1,49 @@
+package borm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestAggregate(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			for i := range testData {
+				if err := bkt.Insert(borm.CreateID(now, uint32(i)), &testData[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error writing test data: %s", err)
+		}
+
+		groups, err := db.Aggregate(now.Add(-time.Second), now.Add(time.Second), nil, &borm.Aggregation{
+			GroupBy: func(key, value []byte) (string, bool) {
+				var item ItemTest
+				if err := borm.DefaultDecode(value, &item); err != nil {
+					return "", false
+				}
+				return item.Category, true
+			},
+			TopN: 2,
+		})
+		if err != nil {
+			t.Fatalf("Error aggregating: %s", err)
+		}
+
+		expected := []borm.Group{{Key: "animal", Count: 7}, {Key: "food", Count: 5}}
+		if len(groups) != len(expected) {
+			t.Fatalf("Aggregate returned %v wanted %v.", groups, expected)
+		}
+		for i := range expected {
+			if groups[i] != expected[i] {
+				t.Fatalf("Aggregate returned %v wanted %v.", groups, expected)
+			}
+		}
+	})
+}
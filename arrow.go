@@ -0,0 +1,343 @@
+package borm
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Arrow type ids of the Type union and message header ids, see Schema.fbs
+// and Message.fbs of the format.
+const (
+	arrowFloatingPoint = 3
+	arrowUtf8          = 5
+	arrowTimestamp     = 10
+
+	arrowSchema      = 1
+	arrowRecordBatch = 3
+
+	arrowV5 = 4
+)
+
+// QueryArrow streams the records in the time range as an Arrow IPC stream,
+// one record batch per shard with the columns key, time and the columns of
+// the schema, which are nullable. Number columns are float64, string
+// columns utf8 and time is a timestamp in nanoseconds.
+func (db *TSEngine) QueryArrow(start, end time.Time, schema []Column, w io.Writer) error {
+	if err := writeArrowMessage(w, arrowSchemaMessage(schema), nil); err != nil {
+		return err
+	}
+
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		batch, keys, err := db.extractColumns(fileName, keyRanges(position, start, end), schema)
+		if err != nil {
+			return err
+		}
+		if batch.Len() == 0 {
+			return nil
+		}
+		meta, body := arrowRecordBatchMessage(batch, keys, schema)
+		return writeArrowMessage(w, meta, body)
+	})
+	if err != nil {
+		return err
+	}
+
+	// end of stream
+	_, err = w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	return err
+}
+
+// writeArrowMessage writes an encapsulated message, the metadata is padded
+// so the body starts 8 byte aligned.
+func writeArrowMessage(w io.Writer, meta, body []byte) error {
+	size := (len(meta) + 8 + 7) &^ 7
+	msg := make([]byte, 8, size+len(body))
+	binary.LittleEndian.PutUint32(msg, 0xffffffff)
+	binary.LittleEndian.PutUint32(msg[4:], uint32(size-8))
+	msg = append(msg, meta...)
+	msg = append(msg, make([]byte, size-len(msg))...)
+	msg = append(msg, body...)
+	_, err := w.Write(msg)
+	return err
+}
+
+func arrowSchemaMessage(schema []Column) []byte {
+	var b fbBuilder
+	fields := []uint32{
+		arrowField(&b, "key", false, arrowUtf8),
+		arrowField(&b, "time", false, arrowTimestamp),
+	}
+	for _, col := range schema {
+		typ := byte(arrowFloatingPoint)
+		if col.Kind == StringColumn {
+			typ = arrowUtf8
+		}
+		fields = append(fields, arrowField(&b, col.Name, true, typ))
+	}
+	fieldsVec := b.offsetVector(fields)
+
+	b.startTable()
+	b.addOffset(1, fieldsVec)
+	return arrowMessage(&b, arrowSchema, b.endTable(), 0)
+}
+
+func arrowField(b *fbBuilder, name string, nullable bool, typ byte) uint32 {
+	nameOff := b.createString(name)
+	var typeOff uint32
+	switch typ {
+	case arrowFloatingPoint:
+		b.startTable()
+		b.addInt16(0, 2) // DOUBLE
+		typeOff = b.endTable()
+	case arrowTimestamp:
+		tz := b.createString("UTC")
+		b.startTable()
+		b.addInt16(0, 3) // NANOSECOND
+		b.addOffset(1, tz)
+		typeOff = b.endTable()
+	default:
+		b.startTable()
+		typeOff = b.endTable()
+	}
+	children := b.offsetVector(nil)
+
+	b.startTable()
+	b.addOffset(0, nameOff)
+	b.addOffset(3, typeOff)
+	b.addOffset(5, children)
+	if nullable {
+		b.addByte(1, 1)
+	}
+	b.addByte(2, typ)
+	return b.endTable()
+}
+
+func arrowMessage(b *fbBuilder, headerType byte, header uint32, bodyLength int64) []byte {
+	b.startTable()
+	b.addInt64(3, bodyLength)
+	b.addOffset(2, header)
+	b.addInt16(0, arrowV5)
+	b.addByte(1, headerType)
+	return b.finish(b.endTable())
+}
+
+// arrowRecordBatchMessage encodes the batch as a record batch message and
+// its body.
+func arrowRecordBatchMessage(batch *ColumnBatch, keys []string, schema []Column) ([]byte, []byte) {
+	rows := batch.Len()
+	var body []byte
+	var nodes, buffers [][2]int64
+	buffer := func(data []byte) {
+		buffers = append(buffers, [2]int64{int64(len(body)), int64(len(data))})
+		body = append(body, data...)
+		body = append(body, make([]byte, (8-len(body)%8)%8)...)
+	}
+	validity := func(nulls []bool) int64 {
+		if nulls == nil {
+			buffer(nil)
+			return 0
+		}
+		bitmap := make([]byte, (rows+7)/8)
+		var count int64
+		for i, null := range nulls {
+			if null {
+				count++
+			} else {
+				bitmap[i/8] |= 1 << uint(i%8)
+			}
+		}
+		buffer(bitmap)
+		return count
+	}
+	strings := func(values []string, nulls []bool) {
+		count := validity(nulls)
+		offsets := make([]byte, 0, 4*(rows+1))
+		var data []byte
+		offsets = binary.LittleEndian.AppendUint32(offsets, 0)
+		for _, v := range values {
+			data = append(data, v...)
+			offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+		}
+		buffer(offsets)
+		buffer(data)
+		nodes = append(nodes, [2]int64{int64(rows), count})
+	}
+
+	strings(keys, nil)
+
+	validity(nil)
+	times := make([]byte, 0, 8*rows)
+	for _, t := range batch.Times {
+		times = binary.LittleEndian.AppendUint64(times, uint64(t.UnixNano()))
+	}
+	buffer(times)
+	nodes = append(nodes, [2]int64{int64(rows), 0})
+
+	for _, col := range schema {
+		nulls := batch.Nulls[col.Name]
+		if col.Kind == StringColumn {
+			strings(batch.Strings[col.Name], nulls)
+			continue
+		}
+		count := validity(nulls)
+		values := make([]byte, 0, 8*rows)
+		for _, v := range batch.Numbers[col.Name] {
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(v))
+		}
+		buffer(values)
+		nodes = append(nodes, [2]int64{int64(rows), count})
+	}
+
+	var b fbBuilder
+	buffersVec := b.structVector(buffers)
+	nodesVec := b.structVector(nodes)
+	b.startTable()
+	b.addInt64(0, int64(rows))
+	b.addOffset(1, nodesVec)
+	b.addOffset(2, buffersVec)
+	return arrowMessage(&b, arrowRecordBatch, b.endTable(), int64(len(body))), body
+}
+
+// fbBuilder builds a flatbuffer back to front like the reference builders,
+// the bytes are kept reversed in rev and offsets count from the end of the
+// buffer.
+type fbBuilder struct {
+	rev    []byte
+	fields map[int]uint32
+	start  uint32
+}
+
+func (b *fbBuilder) offset() uint32 {
+	return uint32(len(b.rev))
+}
+
+// prep pads so that size bytes are aligned after writing additional bytes.
+func (b *fbBuilder) prep(size, additional int) {
+	for (len(b.rev)+additional)%size != 0 {
+		b.rev = append(b.rev, 0)
+	}
+}
+
+func (b *fbBuilder) prepend(data ...byte) {
+	for i := len(data) - 1; i >= 0; i-- {
+		b.rev = append(b.rev, data[i])
+	}
+}
+
+func (b *fbBuilder) prependUint16(v uint16) {
+	b.prepend(binary.LittleEndian.AppendUint16(nil, v)...)
+}
+
+func (b *fbBuilder) prependUint32(v uint32) {
+	b.prepend(binary.LittleEndian.AppendUint32(nil, v)...)
+}
+
+func (b *fbBuilder) prependUOffset(off uint32) {
+	b.prep(4, 0)
+	b.prependUint32(b.offset() + 4 - off)
+}
+
+func (b *fbBuilder) createString(s string) uint32 {
+	b.prep(4, len(s)+1)
+	b.prepend(0)
+	b.prepend([]byte(s)...)
+	b.prependUint32(uint32(len(s)))
+	return b.offset()
+}
+
+func (b *fbBuilder) offsetVector(offs []uint32) uint32 {
+	b.prep(4, 4*len(offs))
+	for i := len(offs) - 1; i >= 0; i-- {
+		b.prependUOffset(offs[i])
+	}
+	b.prependUint32(uint32(len(offs)))
+	return b.offset()
+}
+
+// structVector adds a vector of structs of two longs, like the FieldNode and
+// Buffer structs.
+func (b *fbBuilder) structVector(elems [][2]int64) uint32 {
+	b.prep(4, 16*len(elems))
+	b.prep(8, 16*len(elems))
+	for i := len(elems) - 1; i >= 0; i-- {
+		b.prepend(binary.LittleEndian.AppendUint64(nil, uint64(elems[i][1]))...)
+		b.prepend(binary.LittleEndian.AppendUint64(nil, uint64(elems[i][0]))...)
+	}
+	b.prependUint32(uint32(len(elems)))
+	return b.offset()
+}
+
+func (b *fbBuilder) startTable() {
+	b.fields = map[int]uint32{}
+	b.start = b.offset()
+}
+
+func (b *fbBuilder) addByte(slot int, v byte) {
+	b.prepend(v)
+	b.fields[slot] = b.offset()
+}
+
+func (b *fbBuilder) addInt16(slot int, v int16) {
+	b.prep(2, 0)
+	b.prependUint16(uint16(v))
+	b.fields[slot] = b.offset()
+}
+
+func (b *fbBuilder) addInt64(slot int, v int64) {
+	b.prep(8, 0)
+	b.prepend(binary.LittleEndian.AppendUint64(nil, uint64(v))...)
+	b.fields[slot] = b.offset()
+}
+
+func (b *fbBuilder) addOffset(slot int, off uint32) {
+	b.prependUOffset(off)
+	b.fields[slot] = b.offset()
+}
+
+// endTable writes the vtable of the table before it.
+func (b *fbBuilder) endTable() uint32 {
+	b.prep(4, 0)
+	b.prependUint32(0)
+	table := b.offset()
+
+	slots := 0
+	for slot := range b.fields {
+		if slot+1 > slots {
+			slots = slot + 1
+		}
+	}
+	for slot := slots - 1; slot >= 0; slot-- {
+		var off uint16
+		if field, ok := b.fields[slot]; ok {
+			off = uint16(table - field)
+		}
+		b.prependUint16(off)
+	}
+	b.prependUint16(uint16(table - b.start))
+	b.prependUint16(uint16(4 + 2*slots))
+	vtable := b.offset()
+
+	// the soffset from the table to its vtable
+	soffset := binary.LittleEndian.AppendUint32(nil, vtable-table)
+	for i, c := range soffset {
+		b.rev[int(table)-1-i] = c
+	}
+	b.fields = nil
+	return table
+}
+
+func (b *fbBuilder) finish(root uint32) []byte {
+	b.prep(8, 4)
+	b.prependUOffset(root)
+	buf := make([]byte, len(b.rev))
+	for i, c := range b.rev {
+		buf[len(buf)-1-i] = c
+	}
+	return buf
+}
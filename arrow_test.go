@@ -0,0 +1,89 @@
+package borm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestQueryArrow(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	day := startOfDay(time.Now().AddDate(0, 0, -2))
+	var entries []borm.Entry
+	for i, item := range testData {
+		item := item
+		entries = append(entries, borm.Entry{Time: day.Add(time.Duration(i) * 12 * time.Hour), Value: &item})
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+
+	schema := []borm.Column{
+		{Name: "category", Kind: borm.StringColumn, String: func(key, value []byte) (string, bool) {
+			var item ItemTest
+			if json.Unmarshal(value, &item) != nil {
+				return "", false
+			}
+			return item.Category, true
+		}},
+	}
+	var buf bytes.Buffer
+	if err := db.QueryArrow(day, day.Add(36*time.Hour), schema, &buf); err != nil {
+		t.Fatalf("Error querying arrow: %s", err)
+	}
+
+	// the schema, a record batch per shard and the end of stream
+	data := buf.Bytes()
+	var messages []int64
+	for len(data) >= 8 {
+		if binary.LittleEndian.Uint32(data) != 0xffffffff {
+			t.Fatalf("Message has no continuation marker.")
+		}
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		if size == 0 {
+			data = data[8:]
+			break
+		}
+		if (8+size)%8 != 0 || 8+size > len(data) {
+			t.Fatalf("Invalid metadata size %d.", size)
+		}
+		meta := data[8 : 8+size]
+		root := binary.LittleEndian.Uint32(meta)
+		table := meta[root:]
+		vtable := meta[int(root)-int(int32(binary.LittleEndian.Uint32(table))):]
+		// the bodyLength field of the message
+		var bodyLength int64
+		if binary.LittleEndian.Uint16(vtable) > 10 {
+			if off := binary.LittleEndian.Uint16(vtable[10:]); off != 0 {
+				bodyLength = int64(binary.LittleEndian.Uint64(table[off:]))
+			}
+		}
+		messages = append(messages, bodyLength)
+		data = data[8+size+int(bodyLength):]
+	}
+	if len(data) != 0 {
+		t.Fatalf("Unexpected %d bytes after the end of stream.", len(data))
+	}
+	if len(messages) != 3 || messages[0] != 0 || messages[1] == 0 || messages[2] == 0 {
+		t.Fatalf("Expected a schema and 2 record batches, found %v.", messages)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(testData[1].Category)) {
+		t.Fatalf("Stream has no value %s.", testData[1].Category)
+	}
+}
@@ -0,0 +1,88 @@
+package borm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaAudit is the meta bucket of the audit log, keyed by sequence.
+var metaAudit = []byte("audit")
+
+// AuditRecord is an entry of the audit log of the destructive operations,
+// dry runs included.
+type AuditRecord struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Op     string    `json:"op"`
+	DryRun bool      `json:"dry_run,omitempty"`
+	Detail string    `json:"detail"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// actor returns who runs the operations, see TSOptions.Actor.
+func (db *TSEngine) actor() string {
+	if db.options.Actor != "" {
+		return db.options.Actor
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// audit appends a record to the audit log, opErr is the error the operation
+// failed with, if any.
+func (db *TSEngine) audit(op string, dryRun bool, detail string, opErr error) error {
+	rec := AuditRecord{
+		Time:   db.now(),
+		Actor:  db.actor(),
+		Op:     op,
+		DryRun: dryRun,
+		Detail: detail,
+	}
+	if opErr != nil {
+		rec.Error = opErr.Error()
+	}
+	return db.updateMeta(metaAudit, func(bkt *bolt.Bucket) error {
+		seq, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		rec.Seq = seq
+		data, err := json.Marshal(&rec)
+		if err != nil {
+			return err
+		}
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], seq)
+		return bkt.Put(key[:], data)
+	})
+}
+
+// AuditLog returns the audit records since the time, oldest first. The log
+// is append-only, no API removes its records.
+func (db *TSEngine) AuditLog(since time.Time) ([]AuditRecord, error) {
+	var records []AuditRecord
+	err := db.viewMeta(metaAudit, func(bkt *bolt.Bucket) error {
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var rec AuditRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if !rec.Time.Before(since) {
+				records = append(records, rec)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
@@ -0,0 +1,92 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+	src := tempdir(t)
+	defer os.RemoveAll(src)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Actor: "oncall"})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	started := time.Now()
+	day := startOfDay(started.AddDate(0, 0, -1)).Add(time.Hour)
+	for i := range testData {
+		ts := day.Add(time.Duration(i) * time.Minute)
+		err := db.Write(ts, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(ts, 1), &testData[i])
+		})
+		if err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+
+	n, err := db.DeleteRange(day, day.Add(4*time.Minute), true)
+	if err != nil || n != 4 {
+		t.Fatalf("Expected a dry run deleting 4 records, got %d %v.", n, err)
+	}
+	n, err = db.DeleteRange(day, day.Add(4*time.Minute), false)
+	if err != nil || n != 4 {
+		t.Fatalf("Expected 4 deleted records, got %d %v.", n, err)
+	}
+	n, err = db.DeleteRange(day, day.AddDate(0, 0, 1), true)
+	if err != nil || n != int64(len(testData)-4) {
+		t.Fatalf("Expected %d records left, got %d %v.", len(testData)-4, n, err)
+	}
+
+	// a merge dry run writes nothing
+	srcDB, err := borm.OpenTS(src)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", src, err)
+	}
+	other := day.AddDate(0, 0, -3)
+	err = srcDB.Write(other, func(bkt *borm.Bucket) error {
+		return bkt.Insert(borm.CreateID(other, 1), &testData[0])
+	})
+	if err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+	srcDB.Close()
+	result, err := db.MergeFrom(src, &borm.MergeOptions{DryRun: true})
+	if err != nil || result.Copied != 1 {
+		t.Fatalf("Expected a dry run copying 1 record, got %v %v.", result, err)
+	}
+	if shards, _ := db.Shards(); len(shards) != 1 {
+		t.Fatalf("Merge dry run created shards: %v.", shards)
+	}
+
+	if _, err := db.ApplyRetention(borm.RetentionPolicy{Before: started}, true); err != nil {
+		t.Fatalf("Error applying retention: %s", err)
+	}
+
+	records, err := db.AuditLog(started)
+	if err != nil {
+		t.Fatalf("Error reading audit log: %s", err)
+	}
+	ops := []struct {
+		op     string
+		dryRun bool
+	}{{"delete-range", true}, {"delete-range", false}, {"delete-range", true}, {"merge", true}, {"retention", true}}
+	if len(records) != len(ops) {
+		t.Fatalf("Expected %d audit records, got %v.", len(ops), records)
+	}
+	for i, rec := range records {
+		if rec.Op != ops[i].op || rec.DryRun != ops[i].dryRun || rec.Actor != "oncall" || rec.Seq != uint64(i+1) {
+			t.Fatalf("Unexpected audit record %d: %+v.", i, rec)
+		}
+	}
+	if records, err := db.AuditLog(time.Now().Add(time.Hour)); err != nil || len(records) != 0 {
+		t.Fatalf("Expected no audit records in the future, got %v %v.", records, err)
+	}
+}
@@ -0,0 +1,294 @@
+// Package auth protects the HTTP handlers of borm, like the grafana and
+// influx ones, and its gRPC services, like the replica one, with bearer
+// tokens. A token has a role, read, write or admin,
+// each including the previous ones, and may be scoped to namespaces, e.g.
+// the engines of a deployment serving several tenants.
+//
+//	a, err := auth.New([]auth.Token{
+//		{Subject: "grafana", Secret: readToken, Role: auth.Read},
+//		{Subject: "telegraf", Secret: writeToken, Role: auth.Write, Namespaces: []string{"metrics"}},
+//	})
+//	http.Handle("/grafana/", a.Require(auth.Read, http.StripPrefix("/grafana", grafanaHandler)))
+//	s := grpc.NewServer(grpc.StreamInterceptor(a.StreamInterceptor(auth.Read)))
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Role is the access level of a token.
+type Role int
+
+const (
+	// Read allows queries.
+	Read Role = iota + 1
+	// Write allows queries and writes.
+	Write
+	// Admin allows everything, e.g. the destructive operations.
+	Admin
+)
+
+func (r Role) String() string {
+	switch r {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Admin:
+		return "admin"
+	}
+	return "none"
+}
+
+// ParseRole returns the role named read, write or admin.
+func ParseRole(name string) (Role, error) {
+	switch strings.ToLower(name) {
+	case "read":
+		return Read, nil
+	case "write":
+		return Write, nil
+	case "admin":
+		return Admin, nil
+	}
+	return 0, errors.New("invalid role " + name)
+}
+
+// Token is a bearer token given to a client.
+type Token struct {
+	// Subject is who uses the token, e.g. the service.
+	Subject string
+	Secret  string
+	Role    Role
+
+	// Namespaces are the namespaces the token is limited to, empty allows
+	// all of them.
+	Namespaces []string
+}
+
+func (t *Token) allows(namespace string) bool {
+	if len(t.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range t.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizer checks the tokens of the requests.
+type Authorizer struct {
+	// tokens are keyed by the hash of their secret, so looking up a secret
+	// doesn't leak its prefix through timing.
+	tokens map[[sha256.Size]byte]*Token
+
+	// Namespace returns the namespace of a request, nil or an empty
+	// namespace means the request is not scoped and needs a token allowing
+	// all namespaces. See PathNamespace and QueryNamespace.
+	Namespace func(r *http.Request) string
+
+	// MethodNamespace is Namespace for the gRPC calls, given their context
+	// and full method. See MetadataNamespace.
+	MethodNamespace func(ctx context.Context, method string) string
+}
+
+// New returns an authorizer accepting the tokens.
+func New(tokens []Token) (*Authorizer, error) {
+	a := &Authorizer{tokens: map[[sha256.Size]byte]*Token{}}
+	for i := range tokens {
+		t := tokens[i]
+		if t.Secret == "" {
+			return nil, errors.New("token of " + t.Subject + " has no secret")
+		}
+		if t.Role < Read || t.Role > Admin {
+			return nil, errors.New("token of " + t.Subject + " has no role")
+		}
+		key := sha256.Sum256([]byte(t.Secret))
+		if _, ok := a.tokens[key]; ok {
+			return nil, errors.New("token of " + t.Subject + " is not unique")
+		}
+		a.tokens[key] = &t
+	}
+	return a, nil
+}
+
+// PathNamespace returns the first segment of the path after prefix as the
+// namespace, /prefix/<namespace>/...
+func PathNamespace(prefix string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			path = path[:i]
+		}
+		return path
+	}
+}
+
+// QueryNamespace returns the query parameter as the namespace, e.g. the db
+// parameter of the influx write endpoint.
+func QueryNamespace(param string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}
+}
+
+// MetadataNamespace returns the value of the gRPC metadata key as the
+// namespace.
+func MetadataNamespace(key string) func(ctx context.Context, method string) string {
+	return func(ctx context.Context, method string) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+}
+
+// Principal is the client of an authorized request.
+type Principal struct {
+	Subject   string
+	Role      Role
+	Namespace string
+}
+
+type contextKey struct{}
+
+// FromContext returns the principal of the request context, nil if the
+// request was not authorized by Require or the interceptors.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// Authorize returns the principal of the request if it has a token of at
+// least role for its namespace. The token is taken from the Authorization
+// header, "Bearer <token>", or for the clients only sending basic auth, the
+// password.
+func (a *Authorizer) Authorize(r *http.Request, role Role) (*Principal, int) {
+	secret := bearer(r)
+	if secret == "" {
+		return nil, http.StatusUnauthorized
+	}
+	namespace := ""
+	if a.Namespace != nil {
+		namespace = a.Namespace(r)
+	}
+	return a.authorize(secret, role, namespace)
+}
+
+// authorize returns the principal of the secret if it is a token of at least
+// role for the namespace.
+func (a *Authorizer) authorize(secret string, role Role, namespace string) (*Principal, int) {
+	t, ok := a.tokens[sha256.Sum256([]byte(secret))]
+	if !ok {
+		return nil, http.StatusUnauthorized
+	}
+	if t.Role < role || (namespace == "" && len(t.Namespaces) > 0) || !t.allows(namespace) {
+		return nil, http.StatusForbidden
+	}
+	return &Principal{Subject: t.Subject, Role: t.Role, Namespace: namespace}, http.StatusOK
+}
+
+func bearer(r *http.Request) string {
+	if secret := bearerOf(r.Header.Get("Authorization")); secret != "" {
+		return secret
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// bearerOf returns the token of the authorization "Bearer <token>".
+func bearerOf(header string) string {
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// Require serves the requests authorized for role with next, the others
+// are answered 401 without a valid token and 403 with a token of a lower
+// role or another namespace.
+func (a *Authorizer) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, status := a.Authorize(r, role)
+		if p == nil {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="borm"`)
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, p)))
+	})
+}
+
+// AuthorizeContext returns the principal of the gRPC call of the method if
+// its "authorization" metadata, "Bearer <token>", has a token of at least
+// role for its namespace, or the error of the call, Unauthenticated or
+// PermissionDenied.
+func (a *Authorizer) AuthorizeContext(ctx context.Context, method string, role Role) (*Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	secret := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		secret = bearerOf(values[0])
+	}
+	if secret == "" {
+		return nil, status.Error(codes.Unauthenticated, http.StatusText(http.StatusUnauthorized))
+	}
+	namespace := ""
+	if a.MethodNamespace != nil {
+		namespace = a.MethodNamespace(ctx, method)
+	}
+	p, code := a.authorize(secret, role, namespace)
+	switch code {
+	case http.StatusUnauthorized:
+		return nil, status.Error(codes.Unauthenticated, http.StatusText(code))
+	case http.StatusForbidden:
+		return nil, status.Error(codes.PermissionDenied, http.StatusText(code))
+	}
+	return p, nil
+}
+
+// UnaryInterceptor serves the unary gRPC calls authorized for role, as
+// Require the requests, with the principal in their context.
+func (a *Authorizer) UnaryInterceptor(role Role) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		p, err := a.AuthorizeContext(ctx, info.FullMethod, role)
+		if err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, contextKey{}, p), req)
+	}
+}
+
+// StreamInterceptor serves the gRPC streams authorized for role, as
+// UnaryInterceptor the calls.
+func (a *Authorizer) StreamInterceptor(role Role) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p, err := a.AuthorizeContext(ss.Context(), info.FullMethod, role)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &principalStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), contextKey{}, p)})
+	}
+}
+
+// principalStream is a stream whose context has the principal.
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context { return s.ctx }
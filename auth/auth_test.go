@@ -0,0 +1,133 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/runner-mei/borm/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequire(t *testing.T) {
+	a, err := auth.New([]auth.Token{
+		{Subject: "grafana", Secret: "r", Role: auth.Read},
+		{Subject: "telegraf", Secret: "w", Role: auth.Write, Namespaces: []string{"metrics"}},
+		{Subject: "ops", Secret: "a", Role: auth.Admin},
+	})
+	if err != nil {
+		t.Fatalf("Error creating authorizer: %s", err)
+	}
+	a.Namespace = auth.PathNamespace("/ns")
+
+	h := func(role auth.Role) http.Handler {
+		return a.Require(role, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := auth.FromContext(r.Context())
+			w.Write([]byte(p.Subject + "@" + p.Namespace))
+		}))
+	}
+
+	cases := []struct {
+		role   auth.Role
+		path   string
+		token  string
+		status int
+		body   string
+	}{
+		{auth.Read, "/ns/metrics/query", "", http.StatusUnauthorized, ""},
+		{auth.Read, "/ns/metrics/query", "bad", http.StatusUnauthorized, ""},
+		{auth.Read, "/ns/metrics/query", "r", http.StatusOK, "grafana@metrics"},
+		{auth.Write, "/ns/metrics/write", "r", http.StatusForbidden, ""},
+		{auth.Write, "/ns/metrics/write", "w", http.StatusOK, "telegraf@metrics"},
+		{auth.Write, "/ns/events/write", "w", http.StatusForbidden, ""},
+		{auth.Write, "/", "w", http.StatusForbidden, ""},
+		{auth.Admin, "/ns/events/retention", "w", http.StatusForbidden, ""},
+		{auth.Admin, "/ns/events/retention", "a", http.StatusOK, "ops@events"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		h(c.role).ServeHTTP(rec, req)
+		if rec.Code != c.status || (c.body != "" && rec.Body.String() != c.body) {
+			t.Fatalf("%s %s with %q: expected %d %q, got %d %q.", c.role, c.path, c.token, c.status, c.body, rec.Code, rec.Body.String())
+		}
+	}
+
+	// the password of basic auth
+	req := httptest.NewRequest(http.MethodGet, "/ns/metrics/query", nil)
+	req.SetBasicAuth("grafana", "r")
+	rec := httptest.NewRecorder()
+	h(auth.Read).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected basic auth to be accepted, got %d.", rec.Code)
+	}
+
+	if _, err := auth.New([]auth.Token{{Subject: "a", Secret: "x", Role: auth.Read}, {Subject: "b", Secret: "x", Role: auth.Read}}); err == nil {
+		t.Fatalf("Expected an error for duplicate secrets.")
+	}
+	if role, err := auth.ParseRole("Write"); err != nil || role != auth.Write {
+		t.Fatalf("Error parsing role: %v %v", role, err)
+	}
+}
+
+// stream is a server stream of a gRPC call.
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context { return s.ctx }
+
+func TestInterceptors(t *testing.T) {
+	a, err := auth.New([]auth.Token{
+		{Subject: "grafana", Secret: "r", Role: auth.Read},
+		{Subject: "telegraf", Secret: "w", Role: auth.Write, Namespaces: []string{"metrics"}},
+	})
+	if err != nil {
+		t.Fatalf("Error creating authorizer: %s", err)
+	}
+	a.MethodNamespace = auth.MetadataNamespace("namespace")
+
+	unary := a.UnaryInterceptor(auth.Write)
+	intercept := a.StreamInterceptor(auth.Read)
+	cases := []struct {
+		md     []string
+		unary  codes.Code
+		stream codes.Code
+		body   string
+	}{
+		{nil, codes.Unauthenticated, codes.Unauthenticated, ""},
+		{[]string{"authorization", "Bearer bad"}, codes.Unauthenticated, codes.Unauthenticated, ""},
+		{[]string{"authorization", "Bearer r"}, codes.PermissionDenied, codes.OK, "grafana@"},
+		{[]string{"authorization", "Bearer w"}, codes.PermissionDenied, codes.PermissionDenied, ""},
+		{[]string{"authorization", "Bearer w", "namespace", "events"}, codes.PermissionDenied, codes.PermissionDenied, ""},
+		{[]string{"authorization", "Bearer w", "namespace", "metrics"}, codes.OK, codes.OK, "telegraf@metrics"},
+	}
+	for _, c := range cases {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(c.md...))
+		body, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/borm.Test/Write"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			p := auth.FromContext(ctx)
+			return p.Subject + "@" + p.Namespace, nil
+		})
+		if status.Code(err) != c.unary || (c.unary == codes.OK && body != c.body) {
+			t.Fatalf("Unary call with %v: expected %s %q, got %v %v.", c.md, c.unary, c.body, body, err)
+		}
+
+		body = nil
+		err = intercept(nil, &stream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/borm.Test/Query"}, func(srv interface{}, ss grpc.ServerStream) error {
+			p := auth.FromContext(ss.Context())
+			body = p.Subject + "@" + p.Namespace
+			return nil
+		})
+		if status.Code(err) != c.stream || (c.stream == codes.OK && body != c.body) {
+			t.Fatalf("Stream with %v: expected %s %q, got %v %v.", c.md, c.stream, c.body, body, err)
+		}
+	}
+}
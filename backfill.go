@@ -0,0 +1,250 @@
+package borm
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Entry is a record written by Backfill into the shard of its time.
+type Entry struct {
+	Time time.Time
+	// Key defaults to a new ObjectId created from Time.
+	Key   string
+	Value interface{}
+}
+
+// BackfillOptions allows you set different options from the defaults for a Backfill
+type BackfillOptions struct {
+	// Overwrite updates records whose key already exists, by default
+	// Backfill fails with ErrKeyExists.
+	Overwrite bool
+
+	// BatchSize is the number of records committed in one transaction,
+	// 0 commits all records of a shard in one transaction.
+	BatchSize int
+
+	// Progress is called after every committed batch.
+	Progress ProgressFunc
+
+	// DeadLetter, when set, is the origin of the dead letters of the
+	// records rejected by a validator, for the skew of their time or by
+	// retention, which are then preserved instead of failing the Backfill,
+	// see DeadLetter. They are counted as skipped by the Progress.
+	DeadLetter string
+}
+
+// BackfillResult is the number of records written into each shard file.
+type BackfillResult map[string]int
+
+// Backfill writes old-timestamped entries. Entries are sorted and grouped by
+// shard, every shard is written with bulk transactions through its own
+// handle, so the open write handles are not thrashed back and forth as with
+// Write. The entries are routed as by Write: a skewed one goes to the
+// quarantine shard, or fails the Backfill with ErrClockSkew before anything
+// is written. So does an entry of the hot shard deleted by retention, with
+// ErrShardDeleted, or an older one than the retention horizon whose shard is
+// gone, with ErrRetention. The statistics of the sealed shards written are
+// computed again.
+func (db *TSEngine) Backfill(entries []Entry, opts *BackfillOptions) (BackfillResult, error) {
+	if opts == nil {
+		opts = &BackfillOptions{}
+	}
+
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	result := BackfillResult{}
+	var progress Progress
+	files := make([]string, 0, len(sorted))
+	routed := sorted[:0]
+	var letters []DeadLetter
+	horizon, err := db.RetentionHorizon()
+	if err != nil {
+		return result, err
+	}
+	gone := map[string]error{}
+	for _, entry := range sorted {
+		file, err := db.route(entry.Time)
+		if err == nil {
+			err = db.retained(file, entry.Time, horizon, gone)
+		}
+		if (err == ErrClockSkew || err == ErrShardDeleted || err == ErrRetention) && opts.DeadLetter != "" {
+			letter, err := db.rejectedLetter(opts.DeadLetter, entry, err)
+			if err != nil {
+				return result, err
+			}
+			letters = append(letters, letter)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		routed = append(routed, entry)
+		files = append(files, file)
+	}
+	if len(letters) > 0 {
+		if err := db.addDeadLetters(letters); err != nil {
+			return result, err
+		}
+		progress.Skipped += int64(len(letters))
+	}
+
+	sorted = routed
+	for len(sorted) > 0 {
+		fileName := files[0]
+		n := 1
+		for n < len(sorted) && files[n] == fileName {
+			n++
+		}
+
+		// a bulk load yields to the queries shard by shard
+		skipped := progress.Skipped
+		leave := db.lanes.enter(BackgroundRead, db.backgroundYield())
+		err := db.backfillShard(fileName, sorted[:n], opts, &progress)
+		leave()
+		if err != nil {
+			return result, err
+		}
+		result[fileName] += n - int(progress.Skipped-skipped)
+		sorted, files = sorted[n:], files[n:]
+	}
+	return result, nil
+}
+
+// retained returns ErrShardDeleted for the hot shard file deleted by
+// retention, or ErrRetention if the shard file of t is gone behind the
+// horizon. The errors are cached by file in gone.
+func (db *TSEngine) retained(file string, t, horizon time.Time, gone map[string]error) error {
+	if err, ok := gone[file]; ok {
+		return err
+	}
+	db.handlesMu.Lock()
+	deleted := db.deleted[file]
+	db.handlesMu.Unlock()
+	var err error
+	if deleted {
+		err = ErrShardDeleted
+	} else if t.Before(horizon) && file != db.QuarantineFile() {
+		if _, statErr := os.Stat(file); os.IsNotExist(statErr) {
+			err = ErrRetention
+		}
+	}
+	gone[file] = err
+	return err
+}
+
+// rejectedLetter returns the dead letter of an entry rejected before being
+// written, for the skew of its time or by retention.
+func (db *TSEngine) rejectedLetter(origin string, entry Entry, reason error) (DeadLetter, error) {
+	encode := db.options.Encoder
+	if encode == nil {
+		encode = DefaultEncode
+	}
+	raw, err := encode(entry.Value)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	return DeadLetter{Origin: origin, Reason: reason.Error(), Key: entry.Key, RecordTime: entry.Time, Raw: raw}, nil
+}
+
+func (db *TSEngine) backfillShard(fileName string, entries []Entry, opts *BackfillOptions, progress *Progress) error {
+	progress.Shard = fileName
+	if h := db.acquireHandle(fileName); h != nil {
+		defer db.unref(h)
+		return db.writeEntries(h.bkt, entries, opts, progress)
+	}
+
+	store, bkt, err := db.open(fileName)
+	if err != nil {
+		return err
+	}
+	if db.options.SyncPolicy == SyncHotOnly && db.isHistorical(entries[0].Time) {
+		store.db.NoSync = true
+	}
+
+	if err := db.writeEntries(bkt, entries, opts, progress); err != nil {
+		closeStore(store)
+		return err
+	}
+	return closeStore(store)
+}
+
+func (db *TSEngine) writeEntries(bkt *Bucket, entries []Entry, opts *BackfillOptions, progress *Progress) error {
+	sealed, err := isSealed(bkt)
+	if err != nil {
+		return err
+	}
+	if err := unseal(bkt); err != nil {
+		return err
+	}
+	if err := db.writeBatches(bkt, entries, opts, progress); err != nil {
+		return err
+	}
+	if sealed {
+		// the statistics cover the records written
+		return db.seal(bkt)
+	}
+	return nil
+}
+
+func (db *TSEngine) writeBatches(bkt *Bucket, entries []Entry, opts *BackfillOptions, progress *Progress) error {
+	var err error
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(entries)
+	}
+
+	for len(entries) > 0 {
+		batch := entries
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+
+		var letters []DeadLetter
+		err = bkt.Write(func(u Updater) error {
+			letters = letters[:0]
+			for _, entry := range batch {
+				key := entry.Key
+				if key == "" {
+					key = db.NewID(entry.Time)
+				}
+
+				var err error
+				if opts.Overwrite {
+					err = u.Upsert(key, entry.Value)
+				} else {
+					err = u.Insert(key, entry.Value)
+				}
+				if err != nil {
+					letter, ok := deadLetterOf(opts.DeadLetter, entry.Time, err)
+					if !ok || opts.DeadLetter == "" {
+						return err
+					}
+					letters = append(letters, letter)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(letters) > 0 {
+			if err := db.addDeadLetters(letters); err != nil {
+				return err
+			}
+		}
+		entries = entries[len(batch):]
+
+		progress.Items += int64(len(batch) - len(letters))
+		progress.Skipped += int64(len(letters))
+		if opts.Progress != nil {
+			opts.Progress(*progress)
+		}
+	}
+	return nil
+}
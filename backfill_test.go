@@ -0,0 +1,190 @@
+package borm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestBackfill(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		if err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(now, 1), &ItemTest{Name: "hot", Created: now})
+		}); err != nil {
+			t.Fatalf("Error writing hot shard: %s", err)
+		}
+
+		var entries []borm.Entry
+		for i := 0; i < 10; i++ {
+			created := now.AddDate(0, 0, -(i%3)-1)
+			entries = append(entries, borm.Entry{
+				Time:  created,
+				Value: &ItemTest{ID: i, Name: "old", Created: created},
+			})
+		}
+		entries = append(entries, borm.Entry{
+			Time:  now,
+			Value: &ItemTest{ID: 10, Name: "hot", Created: now},
+		})
+
+		result, err := db.Backfill(entries, &borm.BackfillOptions{BatchSize: 2})
+		if err != nil {
+			t.Fatalf("Error backfilling: %s", err)
+		}
+		if len(result) != 4 {
+			t.Fatalf("Backfill wrote %d shards wanted %d: %v", len(result), 4, result)
+		}
+
+		count := 0
+		err = db.Query(now.AddDate(0, 0, -4), now.Add(time.Hour), func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying backfilled data: %s", err)
+		}
+		if count != len(entries)+1 {
+			t.Fatalf("Query result count is %d wanted %d.", count, len(entries)+1)
+		}
+	})
+}
+
+func TestBackfillSkew(t *testing.T) {
+	now := time.Now()
+	future := now.AddDate(80, 0, 0)
+	entries := []borm.Entry{
+		{Time: now, Value: &ItemTest{Name: "now", Created: now}},
+		{Time: future, Key: borm.CreateID(future, 1), Value: &ItemTest{Name: "future", Created: future}},
+	}
+
+	dir := tempdir(t)
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{MaxFutureSkew: time.Hour})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	// rejected before anything is written
+	if _, err := db.Backfill(entries, nil); err != borm.ErrClockSkew {
+		t.Fatalf("Backfill got %v wanted %s.", err, borm.ErrClockSkew)
+	}
+	if shards, err := borm.ListShards(dir, time.Local); err != nil || len(shards) != 0 {
+		t.Fatalf("Skewed backfill created %d shards, %v.", len(shards), err)
+	}
+
+	// or dead-lettered
+	result, err := db.Backfill(entries, &borm.BackfillOptions{DeadLetter: "import"})
+	if err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if len(result) != 1 || result[db.QuarantineFile()] != 0 {
+		t.Fatalf("Backfill wrote %v wanted the record of now.", result)
+	}
+	var letters []borm.DeadLetter
+	if err := db.DeadLetters("import", func(letter borm.DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error reading dead letters: %s", err)
+	}
+	if len(letters) != 1 || letters[0].Key != entries[1].Key || letters[0].Reason != borm.ErrClockSkew.Error() {
+		t.Fatalf("Dead letters got %+v wanted the future record.", letters)
+	}
+
+	// or quarantined
+	dir = tempdir(t)
+	quarantine, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{MaxFutureSkew: time.Hour, SkewAction: borm.SkewQuarantine})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer quarantine.Close()
+	if result, err = quarantine.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if len(result) != 2 || result[quarantine.QuarantineFile()] != 1 {
+		t.Fatalf("Backfill wrote %v wanted the future record in quarantine.", result)
+	}
+	if shards, err := borm.ListShards(dir, time.Local); err != nil || len(shards) != 1 {
+		t.Fatalf("Quarantined backfill created %d shards, %v.", len(shards), err)
+	}
+}
+
+func TestBackfillRetention(t *testing.T) {
+	dir := tempdir(t)
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{HotShard: borm.DeleteHotShard})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	old, kept := now.AddDate(0, 0, -10), now.AddDate(0, 0, -5)
+	entry := func(at time.Time, name string) borm.Entry {
+		return borm.Entry{Time: at, Value: &ItemTest{Name: name, Created: at}}
+	}
+	if _, err := db.Backfill([]borm.Entry{entry(old, "old"), entry(kept, "kept")}, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if _, err := db.ApplyRetention(borm.RetentionPolicy{Before: now.AddDate(0, 0, -7)}, false); err != nil {
+		t.Fatalf("Error applying retention: %s", err)
+	}
+	if horizon, err := db.RetentionHorizon(); err != nil || horizon.Before(old) {
+		t.Fatalf("Retention horizon is %s wanted after %s, %v.", horizon, old, err)
+	}
+
+	// rejected before anything is written
+	entries := []borm.Entry{entry(kept, "kept"), entry(old, "old")}
+	if _, err := db.Backfill(entries, nil); err != borm.ErrRetention {
+		t.Fatalf("Backfill got %v wanted %s.", err, borm.ErrRetention)
+	}
+	if shards, err := db.Shards(); err != nil || len(shards) != 1 {
+		t.Fatalf("Backfill behind the horizon left %d shards, %v.", len(shards), err)
+	}
+
+	// or dead-lettered, the statistics of the sealed shard are computed again
+	if err := db.Seal(kept); err != nil {
+		t.Fatalf("Error sealing: %s", err)
+	}
+	result, err := db.Backfill(entries, &borm.BackfillOptions{DeadLetter: "import"})
+	if err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Backfill wrote %v wanted the kept record.", result)
+	}
+	var letters []borm.DeadLetter
+	if err := db.DeadLetters("import", func(letter borm.DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error reading dead letters: %s", err)
+	}
+	if len(letters) != 1 || letters[0].Reason != borm.ErrRetention.Error() {
+		t.Fatalf("Dead letters got %+v wanted the old record.", letters)
+	}
+	stats, err := db.ShardStats(kept, kept)
+	if err != nil {
+		t.Fatalf("Error reading stats: %s", err)
+	}
+	if len(stats) != 1 || stats[0].Count != 2 {
+		t.Fatalf("Stats are %+v wanted the 2 records of the sealed shard.", stats)
+	}
+
+	// the hot shard deleted by retention is not recreated
+	if _, err := db.Backfill([]borm.Entry{entry(now, "hot")}, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if _, err := db.ApplyRetention(borm.RetentionPolicy{Before: now.AddDate(0, 0, 2)}, false); err != nil {
+		t.Fatalf("Error applying retention: %s", err)
+	}
+	if _, err := db.Backfill([]borm.Entry{entry(now, "hot")}, nil); err != borm.ErrShardDeleted {
+		t.Fatalf("Backfill got %v wanted %s.", err, borm.ErrShardDeleted)
+	}
+	if shards, err := db.Shards(); err != nil || len(shards) != 0 {
+		t.Fatalf("Backfill recreated %d shards, %v.", len(shards), err)
+	}
+}
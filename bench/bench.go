@@ -0,0 +1,201 @@
+// Package bench generates reproducible write, read and mixed workloads on a
+// borm time series engine and reports their throughput, latencies and the
+// resulting file sizes, so performance can be compared across releases,
+// options and hardware.
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// Workload describes a benchmark run.
+type Workload struct {
+	// Preload is the number of records written before the measured
+	// operations, e.g. for a read-only workload.
+	Preload int
+
+	// Ops is the number of measured operations.
+	Ops int
+
+	// ReadRatio is the fraction of the operations that are reads of a random
+	// written record, 0 for a write-only workload, 1 for a read-only one.
+	ReadRatio float64
+
+	// ValueSize is the payload size of a record in bytes.
+	ValueSize int
+
+	// Span is the time range the records are spread over, it defaults to a
+	// day. The records are written in time order.
+	Span time.Duration
+
+	// Seed makes the runs reproducible.
+	Seed int64
+
+	// Options are the options of the engine.
+	Options *borm.TSOptions
+}
+
+// Record is the record written by the workloads.
+type Record struct {
+	Seq     int
+	Payload []byte
+}
+
+// Report is the outcome of a benchmark run.
+type Report struct {
+	Writes   int
+	Reads    int
+	Duration time.Duration
+
+	// Throughput is the measured operations per second.
+	Throughput float64
+
+	// P50, P99 and Max are the latencies of the measured operations.
+	P50, P99, Max time.Duration
+
+	// Shards and FileSize are the count and total size of the shard files.
+	Shards   int
+	FileSize int64
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("writes=%d reads=%d duration=%s throughput=%.0f op/s p50=%s p99=%s max=%s shards=%d size=%d",
+		r.Writes, r.Reads, r.Duration, r.Throughput, r.P50, r.P99, r.Max, r.Shards, r.FileSize)
+}
+
+// Run runs the workload on an engine in dir, which should be empty.
+func Run(dir string, w Workload) (*Report, error) {
+	if w.Span <= 0 {
+		w.Span = 24 * time.Hour
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := borm.OpenTSWithOptions(dir, w.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	g := newGenerator(w)
+	for i := 0; i < w.Preload; i++ {
+		if err := g.write(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	report := &Report{}
+	latencies := make([]time.Duration, 0, w.Ops)
+	started := time.Now()
+	for i := 0; i < w.Ops; i++ {
+		read := len(g.ids) > 0 && g.rng.Float64() < w.ReadRatio
+
+		opStarted := time.Now()
+		if read {
+			err = g.read(db)
+			report.Reads++
+		} else {
+			err = g.write(db)
+			report.Writes++
+		}
+		latencies = append(latencies, time.Since(opStarted))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	report.Duration = time.Since(started)
+
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+
+	if report.Duration > 0 {
+		report.Throughput = float64(w.Ops) / report.Duration.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 0.50)
+	report.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		report.Max = latencies[len(latencies)-1]
+	}
+
+	report.Shards, report.FileSize, err = shardFiles(dir)
+	return report, err
+}
+
+// generator creates the records of a workload.
+type generator struct {
+	w     Workload
+	rng   *rand.Rand
+	start time.Time
+	total int
+	ids   []string
+}
+
+func newGenerator(w Workload) *generator {
+	return &generator{
+		w:     w,
+		rng:   rand.New(rand.NewSource(w.Seed)),
+		start: time.Now().Add(-w.Span),
+		total: w.Preload + w.Ops,
+	}
+}
+
+func (g *generator) write(db *borm.TSEngine) error {
+	seq := len(g.ids)
+	t := g.start
+	if g.total > 0 {
+		t = t.Add(time.Duration(int64(g.w.Span) * int64(seq) / int64(g.total)))
+	}
+
+	payload := make([]byte, g.w.ValueSize)
+	g.rng.Read(payload)
+
+	id := borm.CreateID(t, uint32(seq))
+	err := db.Write(t, func(bkt *borm.Bucket) error {
+		return bkt.Insert(id, &Record{Seq: seq, Payload: payload})
+	})
+	if err != nil {
+		return err
+	}
+	g.ids = append(g.ids, id)
+	return nil
+}
+
+func (g *generator) read(db *borm.TSEngine) error {
+	var record Record
+	return db.Get(g.ids[g.rng.Intn(len(g.ids))], &record)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+// shardFiles returns the count and total size of the shard files in dir.
+func shardFiles(dir string) (int, int64, error) {
+	var count int
+	var size int64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".ts") {
+			count++
+			size += fi.Size()
+		}
+		return nil
+	})
+	return count, size, err
+}
@@ -0,0 +1,28 @@
+package bench_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/runner-mei/borm/bench"
+)
+
+func TestRunMixed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	report, err := bench.Run(dir, bench.Workload{Preload: 50, Ops: 200, ReadRatio: 0.5, ValueSize: 64, Seed: 1})
+	if err != nil {
+		t.Fatalf("Error running workload: %s", err)
+	}
+	if report.Writes+report.Reads != 200 || report.Reads == 0 || report.Writes == 0 {
+		t.Fatalf("Workload report is %s.", report)
+	}
+	if report.Shards == 0 || report.FileSize == 0 || report.P99 < report.P50 {
+		t.Fatalf("Workload report is %s.", report)
+	}
+}
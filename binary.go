@@ -0,0 +1,354 @@
+package borm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The wire types of the binary codec.
+const (
+	wireVarint   = 0
+	wireFixed64  = 1
+	wireBytes    = 2
+	wireInterned = 3
+)
+
+var errBinaryTruncated = errors.New("binary record is truncated")
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// BinaryCodec is a compact self-describing binary record format. Every
+// non-zero field of a struct is written as a varint of its tag and wire type
+// followed by its value, as protocol buffers do: integers as varints, floats
+// as 8 bytes, strings, bytes and nested structs length-prefixed, slices as a
+// repeated field, times as the varint of the unix nanoseconds. Strings of
+// the intern table are written as their varint index in it.
+//
+// A field tag is set with the struct tag `borm:"<tag>"`, it defaults to the
+// field position plus one, and `borm:"-"` skips the field. The options
+// following a comma, e.g. `borm:"3,index"`, are the ones of bormgen. Fields
+// of unknown tags are skipped on decode, so tags may be added and removed
+// over time.
+type BinaryCodec struct {
+	intern []string
+	index  map[string]uint64
+}
+
+// NewBinaryCodec returns a binary codec interning the given strings, e.g.
+// the country codes. Records must be decoded with the intern table they were
+// encoded with, so it may only be appended to.
+func NewBinaryCodec(intern ...string) *BinaryCodec {
+	c := &BinaryCodec{intern: intern, index: make(map[string]uint64, len(intern))}
+	for i, s := range intern {
+		c.index[s] = uint64(i)
+	}
+	return c
+}
+
+var defaultBinaryCodec = NewBinaryCodec()
+
+// BinaryEncode is the encoding func of the binary codec without interning
+func BinaryEncode(value interface{}) ([]byte, error) {
+	return defaultBinaryCodec.Encode(value)
+}
+
+// BinaryDecode is the decoding func of the binary codec without interning
+func BinaryDecode(data []byte, value interface{}) error {
+	return defaultBinaryCodec.Decode(data, value)
+}
+
+// Encode encodes the struct value, it is an EncodeFunc.
+func (c *BinaryCodec) Encode(value interface{}) ([]byte, error) {
+	return c.AppendEncode(nil, value)
+}
+
+// AppendEncode appends the encoded struct value to dst, it is an AppendEncodeFunc.
+func (c *BinaryCodec) AppendEncode(dst []byte, value interface{}) ([]byte, error) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("binary codec can't encode a nil pointer")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("binary codec can't encode %s, only structs", v.Type())
+	}
+	return c.appendStruct(dst, v)
+}
+
+// Decode decodes data into the struct value points to, it is a DecodeFunc.
+func (c *BinaryCodec) Decode(data []byte, value interface{}) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("binary codec decodes into a pointer to a struct only")
+	}
+	return c.decodeStruct(data, v.Elem())
+}
+
+type binaryField struct {
+	index int
+	tag   uint64
+}
+
+type binaryFields struct {
+	fields []binaryField
+	byTag  map[uint64]int
+}
+
+var binaryFieldCache sync.Map
+
+func fieldsOf(typ reflect.Type) (*binaryFields, error) {
+	if cached, ok := binaryFieldCache.Load(typ); ok {
+		return cached.(*binaryFields), nil
+	}
+
+	fs := &binaryFields{byTag: map[uint64]int{}}
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := uint64(i + 1)
+		s := f.Tag.Get("borm")
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			// options of bormgen, e.g. "3,index"
+			s = s[:i]
+		}
+		if s == "-" {
+			continue
+		} else if s != "" {
+			n, err := strconv.ParseUint(s, 10, 32)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("field %s.%s has an invalid tag %q", typ, f.Name, s)
+			}
+			tag = n
+		}
+		if _, dup := fs.byTag[tag]; dup {
+			return nil, fmt.Errorf("field %s.%s reuses tag %d", typ, f.Name, tag)
+		}
+		fs.byTag[tag] = i
+		fs.fields = append(fs.fields, binaryField{i, tag})
+	}
+	binaryFieldCache.Store(typ, fs)
+	return fs, nil
+}
+
+func (c *BinaryCodec) appendStruct(buf []byte, v reflect.Value) ([]byte, error) {
+	fs, err := fieldsOf(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fs.fields {
+		fv := v.Field(f.index)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < fv.Len(); i++ {
+				if buf, err = c.appendField(buf, f.tag, fv.Index(i)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if fv.IsZero() {
+			continue
+		}
+		if buf, err = c.appendField(buf, f.tag, fv); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendKey(buf []byte, tag uint64, wire int) []byte {
+	return binary.AppendUvarint(buf, tag<<3|uint64(wire))
+}
+
+func (c *BinaryCodec) appendField(buf []byte, tag uint64, v reflect.Value) ([]byte, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return buf, nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		buf = appendKey(buf, tag, wireVarint)
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(appendKey(buf, tag, wireVarint), v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(appendKey(buf, tag, wireVarint), v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return binary.BigEndian.AppendUint64(appendKey(buf, tag, wireFixed64), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		s := v.String()
+		if i, ok := c.index[s]; ok {
+			return binary.AppendUvarint(appendKey(buf, tag, wireInterned), i), nil
+		}
+		buf = binary.AppendUvarint(appendKey(buf, tag, wireBytes), uint64(len(s)))
+		return append(buf, s...), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("binary codec can't encode %s", v.Type())
+		}
+		buf = binary.AppendUvarint(appendKey(buf, tag, wireBytes), uint64(v.Len()))
+		return append(buf, v.Bytes()...), nil
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return binary.AppendVarint(appendKey(buf, tag, wireVarint), v.Interface().(time.Time).UnixNano()), nil
+		}
+		nested, err := c.appendStruct(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(appendKey(buf, tag, wireBytes), uint64(len(nested)))
+		return append(buf, nested...), nil
+	default:
+		return nil, fmt.Errorf("binary codec can't encode %s", v.Type())
+	}
+}
+
+func (c *BinaryCodec) decodeStruct(data []byte, v reflect.Value) error {
+	fs, err := fieldsOf(v.Type())
+	if err != nil {
+		return err
+	}
+	return scanBinary(data, func(tag uint64, wire int, num uint64, raw []byte) error {
+		index, ok := fs.byTag[tag]
+		if !ok {
+			// a field removed from the struct
+			return nil
+		}
+		fv := v.Field(index)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			fv.Set(reflect.Append(fv, reflect.Zero(fv.Type().Elem())))
+			fv = fv.Index(fv.Len() - 1)
+		}
+		if err := c.decodeField(fv, wire, num, raw); err != nil {
+			return fmt.Errorf("field %s.%s: %s", v.Type(), v.Type().Field(index).Name, err)
+		}
+		return nil
+	})
+}
+
+// scanBinary calls fn with the tag, the wire type and the raw value of every
+// field of a binary record, num is the value of the varint and fixed64 ones.
+func scanBinary(data []byte, fn func(tag uint64, wire int, num uint64, raw []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errBinaryTruncated
+		}
+		data = data[n:]
+		tag, wire := key>>3, int(key&7)
+
+		// the raw value of the field
+		var raw []byte
+		var num uint64
+		switch wire {
+		case wireVarint, wireInterned:
+			num, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errBinaryTruncated
+			}
+			raw, data = data[:n], data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errBinaryTruncated
+			}
+			num = binary.BigEndian.Uint64(data)
+			data = data[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errBinaryTruncated
+			}
+			raw, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return fmt.Errorf("binary record has an invalid wire type %d", wire)
+		}
+
+		if err := fn(tag, wire, num, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *BinaryCodec) decodeField(v reflect.Value, wire int, num uint64, raw []byte) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	mismatch := fmt.Errorf("wire type %d doesn't decode into %s", wire, v.Type())
+	switch v.Kind() {
+	case reflect.Bool:
+		if wire != wireVarint {
+			return mismatch
+		}
+		v.SetBool(num != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if wire != wireVarint {
+			return mismatch
+		}
+		i, _ := binary.Varint(raw)
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if wire != wireVarint {
+			return mismatch
+		}
+		v.SetUint(num)
+	case reflect.Float32, reflect.Float64:
+		if wire != wireFixed64 {
+			return mismatch
+		}
+		v.SetFloat(math.Float64frombits(num))
+	case reflect.String:
+		switch wire {
+		case wireBytes:
+			v.SetString(string(raw))
+		case wireInterned:
+			if num >= uint64(len(c.intern)) {
+				return fmt.Errorf("interned string %d is not in the intern table", num)
+			}
+			v.SetString(c.intern[num])
+		default:
+			return mismatch
+		}
+	case reflect.Slice:
+		if wire != wireBytes || v.Type().Elem().Kind() != reflect.Uint8 {
+			return mismatch
+		}
+		v.SetBytes(append([]byte(nil), raw...))
+	case reflect.Struct:
+		if v.Type() == timeType {
+			if wire != wireVarint {
+				return mismatch
+			}
+			ns, _ := binary.Varint(raw)
+			v.Set(reflect.ValueOf(time.Unix(0, ns)))
+			return nil
+		}
+		if wire != wireBytes {
+			return mismatch
+		}
+		return c.decodeStruct(raw, v)
+	default:
+		return mismatch
+	}
+	return nil
+}
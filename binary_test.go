@@ -0,0 +1,109 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+type attackRecord struct {
+	SrcIP    string    `borm:"1"`
+	DstPort  uint16    `borm:"2"`
+	Severity int       `borm:"3"`
+	Score    float64   `borm:"4"`
+	Country  string    `borm:"5"`
+	Blocked  bool      `borm:"6"`
+	Seen     time.Time `borm:"7"`
+	Tags     []string  `borm:"8"`
+	Payload  []byte    `borm:"9"`
+	Geo      *geoInfo  `borm:"10"`
+	Ignored  string    `borm:"-"`
+}
+
+type geoInfo struct {
+	City string
+	Lat  float64
+}
+
+// attackRecordV2 dropped Score and Tags and added Rule.
+type attackRecordV2 struct {
+	SrcIP    string `borm:"1"`
+	DstPort  uint16 `borm:"2"`
+	Severity int    `borm:"3"`
+	Country  string `borm:"5"`
+	Rule     string `borm:"11"`
+}
+
+func TestBinaryCodec(t *testing.T) {
+	codec := borm.NewBinaryCodec("CN", "FR", "US")
+	in := &attackRecord{
+		SrcIP:    "10.1.2.3",
+		DstPort:  443,
+		Severity: -2,
+		Score:    0.75,
+		Country:  "FR",
+		Blocked:  true,
+		Seen:     time.Now(),
+		Tags:     []string{"scan", "", "US"},
+		Payload:  []byte{0, 1, 2},
+		Geo:      &geoInfo{City: "Lyon", Lat: 45.75},
+		Ignored:  "x",
+	}
+
+	data, err := codec.Encode(in)
+	if err != nil {
+		t.Fatalf("Error encoding: %s", err)
+	}
+	var out attackRecord
+	if err := codec.Decode(data, &out); err != nil {
+		t.Fatalf("Error decoding: %s", err)
+	}
+	if out.SrcIP != in.SrcIP || out.DstPort != in.DstPort || out.Severity != in.Severity ||
+		out.Score != in.Score || out.Country != in.Country || !out.Blocked || !out.Seen.Equal(in.Seen) ||
+		len(out.Tags) != 3 || out.Tags[2] != "US" || string(out.Payload) != string(in.Payload) ||
+		out.Geo == nil || *out.Geo != *in.Geo || out.Ignored != "" {
+		t.Fatalf("Binary codec round trips %+v to %+v.", in, out)
+	}
+
+	js, _ := json.Marshal(in)
+	if len(data)*2 > len(js) {
+		t.Fatalf("Binary record is %d bytes, JSON is %d.", len(data), len(js))
+	}
+
+	var v2 attackRecordV2
+	if err := codec.Decode(data, &v2); err != nil {
+		t.Fatalf("Error decoding into a newer struct: %s", err)
+	}
+	if v2.SrcIP != in.SrcIP || v2.Country != "FR" || v2.Rule != "" {
+		t.Fatalf("Binary codec decodes %+v into %+v.", in, v2)
+	}
+
+	if err := borm.BinaryDecode(data, &out); err == nil {
+		t.Fatalf("Decoding interned strings without the intern table didn't fail.")
+	}
+	if err := codec.Decode(data[:len(data)-1], &out); err == nil {
+		t.Fatalf("Decoding a truncated record didn't fail.")
+	}
+}
+
+func TestBinaryCodecBucket(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", borm.BinaryEncode, borm.BinaryDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket for binary codec test: %s", err)
+		}
+		data := &ItemTest{Name: "Test Name", Category: "food", Created: time.Now(), Tags: []string{"a"}}
+		if err := bkt.Insert("testKey", data); err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+		result := &ItemTest{}
+		if err := bkt.Get("testKey", result); err != nil {
+			t.Fatalf("Error getting data from borm: %s", err)
+		}
+		if !data.equal(result) {
+			t.Fatalf("Got %v wanted %v.", result, data)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+// Package bormtest has the fixtures of the tests of applications built on
+// borm: temporary engines, records generated across days, deliberately
+// corrupted shards and assertions on the shard layout.
+//
+//	db := bormtest.Engine(t, borm.WithCodec(borm.CodecJSON))
+//	bormtest.Generate(t, db, 100, 3, start)
+//	bormtest.AssertShards(t, db, start, start.AddDate(0, 0, 1), start.AddDate(0, 0, 2))
+//	bormtest.AssertGolden(t, db, "testdata/layout.golden")
+package bormtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// Update rewrites the golden files of AssertGolden instead of comparing
+// them, set it from a flag of the test, e.g.
+//
+//	flag.BoolVar(&bormtest.Update, "update", false, "update the golden files")
+var Update bool
+
+// Record is the record Generate writes.
+type Record struct {
+	Seq     int
+	Day     int
+	Name    string
+	Created time.Time
+}
+
+// Dir returns a temporary directory removed at the end of the test.
+func Dir(t testing.TB) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "bormtest-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// Engine opens an engine in a temporary directory with the options, closed
+// and removed at the end of the test.
+func Engine(t testing.TB, opts ...borm.Option) *borm.TSEngine {
+	t.Helper()
+	dir := Dir(t)
+	db, err := borm.OpenTSWith(dir, opts...)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Generate writes n Records spread over days days from the day of start, a
+// record a second from the start of each day, and returns their keys in
+// the order written. The keys and the records are the same for the same
+// arguments.
+func Generate(t testing.TB, db *borm.TSEngine, n, days int, start time.Time) []string {
+	t.Helper()
+	if days <= 0 {
+		days = 1
+	}
+	first := borm.Daily.Truncate(start)
+	entries := make([]borm.Entry, n)
+	keys := make([]string, n)
+	for i := range entries {
+		day := i % days
+		at := first.AddDate(0, 0, day).Add(time.Duration(i/days) * time.Second)
+		keys[i] = borm.CreateID(at, uint32(i))
+		entries[i] = borm.Entry{
+			Time:  at,
+			Key:   keys[i],
+			Value: &Record{Seq: i, Day: day, Name: fmt.Sprintf("record-%d", i), Created: at},
+		}
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error generating %d records: %s", n, err)
+	}
+	return keys
+}
+
+// shardOf returns the shard holding t.
+func shardOf(t testing.TB, db *borm.TSEngine, at time.Time) borm.ShardInfo {
+	t.Helper()
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	for _, info := range shards {
+		if !at.Before(info.StartTime) && at.Before(info.EndTime) {
+			return info
+		}
+	}
+	t.Fatalf("No shard holds %s.", at)
+	return borm.ShardInfo{}
+}
+
+// CorruptShard overwrites the meta pages of the shard holding t with
+// garbage, so Bolt fails to open it, and returns its path. The shard must
+// not be open for writing, e.g. written by Generate.
+func CorruptShard(t testing.TB, db *borm.TSEngine, at time.Time) string {
+	t.Helper()
+	path := shardOf(t, db, at).Path
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", path, err)
+	}
+	defer f.Close()
+	garbage := []byte(strings.Repeat("corrupt!", 2*os.Getpagesize()/8))
+	if _, err := f.WriteAt(garbage, 0); err != nil {
+		t.Fatalf("Error corrupting %s: %s", path, err)
+	}
+	return path
+}
+
+// AssertShards fails the test unless the shards of the engine are exactly
+// the ones holding the times.
+func AssertShards(t testing.TB, db *borm.TSEngine, times ...time.Time) {
+	t.Helper()
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	want := map[string]bool{}
+	for _, at := range times {
+		want[shardOf(t, db, at).Path] = true
+	}
+	var got []string
+	for _, info := range shards {
+		got = append(got, filepath.Base(info.Path))
+		if !want[info.Path] {
+			t.Fatalf("Unexpected shard %s from %s to %s.", info.Path, info.StartTime, info.EndTime)
+		}
+	}
+	if len(shards) != len(want) {
+		t.Fatalf("Shards are %v wanted %d.", got, len(want))
+	}
+}
+
+// Layout returns the shard layout of the engine, a line per shard with its
+// name, its record count and its tags, oldest first.
+func Layout(t testing.TB, db *borm.TSEngine) string {
+	t.Helper()
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	var b strings.Builder
+	for i := len(shards) - 1; i >= 0; i-- {
+		info := shards[i]
+		count, err := db.Count(info.StartTime, info.EndTime.Add(-time.Nanosecond), nil)
+		if err != nil {
+			t.Fatalf("Error counting the records of %s: %s", info.Path, err)
+		}
+		fmt.Fprintf(&b, "%s records=%d", filepath.Base(info.Path), count)
+		tags := make([]string, 0, len(info.Tags))
+		for k, v := range info.Tags {
+			tags = append(tags, k+"="+v)
+		}
+		sort.Strings(tags)
+		if len(tags) > 0 {
+			fmt.Fprintf(&b, " tags=%s", strings.Join(tags, ","))
+		}
+		if info.Pinned {
+			b.WriteString(" pinned")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// AssertGolden fails the test unless the Layout of the engine is the one
+// of the golden file, which is written instead if it doesn't exist or with
+// Update.
+func AssertGolden(t testing.TB, db *borm.TSEngine, golden string) {
+	t.Helper()
+	layout := Layout(t, db)
+	want, err := ioutil.ReadFile(golden)
+	if Update || os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("Error creating %s: %s", filepath.Dir(golden), err)
+		}
+		if err := ioutil.WriteFile(golden, []byte(layout), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", golden, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", golden, err)
+	}
+	if layout != string(want) {
+		t.Fatalf("Layout is\n%s\nwanted the one of %s\n%s", layout, golden, want)
+	}
+}
@@ -0,0 +1,46 @@
+package bormtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/bormtest"
+)
+
+func TestGenerate(t *testing.T) {
+	db := bormtest.Engine(t, borm.WithCodec(borm.CodecJSON))
+	start := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	keys := bormtest.Generate(t, db, 10, 3, start)
+	if len(keys) != 10 {
+		t.Fatalf("Generated %d keys wanted 10.", len(keys))
+	}
+
+	var rec bormtest.Record
+	if err := db.Get(keys[4], &rec); err != nil {
+		t.Fatalf("Error getting %s: %s", keys[4], err)
+	}
+	if rec.Seq != 4 || rec.Day != 1 || !rec.Created.Equal(start.AddDate(0, 0, 1).Add(time.Second)) {
+		t.Fatalf("Record 4 is %+v.", rec)
+	}
+	if again := bormtest.Generate(t, bormtest.Engine(t), 10, 3, start); again[9] != keys[9] {
+		t.Fatalf("Keys differ, %s and %s.", again[9], keys[9])
+	}
+
+	bormtest.AssertShards(t, db, start, start.AddDate(0, 0, 1), start.AddDate(0, 0, 2))
+	bormtest.AssertGolden(t, db, "testdata/layout.golden")
+}
+
+func TestCorruptShard(t *testing.T) {
+	db := bormtest.Engine(t)
+	start := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	bormtest.Generate(t, db, 4, 2, start)
+
+	bormtest.CorruptShard(t, db, start)
+	if _, err := db.Count(start, start.Add(time.Hour), nil); err == nil {
+		t.Fatalf("Counting the records of the corrupted shard succeeded.")
+	}
+	if n, err := db.Count(start.AddDate(0, 0, 1), start.AddDate(0, 0, 1).Add(time.Hour), nil); err != nil || n != 2 {
+		t.Fatalf("Count of the intact shard is %d, %v wanted 2.", n, err)
+	}
+}
@@ -1,6 +1,12 @@
 package borm
 
-import "time"
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
 
 // Bucket is the Interface to implement to skip reflect calls on all data passed into the bolthold
 type Bucket struct {
@@ -9,6 +15,198 @@ type Bucket struct {
 	name   []byte
 	encode EncodeFunc
 	decode DecodeFunc
+	typ    reflect.Type
+	hooks  []WriteHook
+
+	// enrich, when set, completes the values put, see TSOptions.Enrichers.
+	// It returns false for a value not to store.
+	enrich func(tx *bolt.Tx, key, value []byte) ([]byte, bool, error)
+
+	appendEncode AppendEncodeFunc
+
+	// dict is the compression dictionary of the shard, see TSOptions.DictCompression.
+	dict []byte
+
+	// checksums stores the values with a checksum, see SetChecksums.
+	checksums bool
+
+	// tx, when set, is the read transaction pinned by TSEngine.FreezeRange
+	// the reads of the bucket go through instead of their own ones.
+	tx *bolt.Tx
+
+	// changes are the changes of the running write transaction, logged
+	// once it commits, see TSOptions.ChangeLog.
+	changes txBatch
+
+	// logged are the changes of the shard known to be in the change log.
+	logged *loggedChanges
+
+	// forwards are the records of the running write transaction queued for
+	// the webhooks once it commits.
+	forwards txBatch
+
+	// matches are the rules matched by the running write transaction,
+	// applied once it commits.
+	matches txBatch
+
+	// written are the records put by the running write transaction,
+	// counted once it commits.
+	written txBatch
+}
+
+// view runs fn in the pinned read transaction of the bucket, or in a new one.
+func (b *Bucket) view(fn func(tx *bolt.Tx) error) error {
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	return b.store.db.View(fn)
+}
+
+// SetAppendEncoder makes the bucket encode records by appending them to a
+// buffer shared by the writes of a transaction and reused across
+// transactions, instead of allocating every encoded record. Write hooks must
+// not keep the value past the transaction then. nil restores the encoder of
+// the bucket.
+func (b *Bucket) SetAppendEncoder(encoder AppendEncodeFunc) {
+	b.appendEncode = encoder
+}
+
+// arenaChunkSize is the size of the chunks of an arena.
+const arenaChunkSize = 64 * 1024
+
+// arena holds the records encoded in a write transaction.
+type arena struct {
+	buf []byte
+}
+
+var arenaPool = sync.Pool{New: func() interface{} {
+	return &arena{buf: make([]byte, 0, arenaChunkSize)}
+}}
+
+func getArena() *arena {
+	return arenaPool.Get().(*arena)
+}
+
+// release returns the arena to the pool, once the transaction referencing
+// its records is closed.
+func (a *arena) release() {
+	if cap(a.buf) > 16*arenaChunkSize {
+		return
+	}
+	a.buf = a.buf[:0]
+	arenaPool.Put(a)
+}
+
+// encodeTo encodes a record to be put in the bucket, into the arena if the
+// bucket has an append encoder.
+func (b *Bucket) encodeTo(a *arena, data interface{}) ([]byte, error) {
+	if b.appendEncode == nil || a == nil {
+		return b.encodeValue(data)
+	}
+	if err := b.checkType(data); err != nil {
+		return nil, err
+	}
+	return b.appendTo(a, data)
+}
+
+// appendTo encodes a record with the append encoder of the bucket into the
+// arena.
+func (b *Bucket) appendTo(a *arena, data interface{}) ([]byte, error) {
+	if cap(a.buf)-len(a.buf) < arenaChunkSize/16 {
+		// the records in the full chunk stay referenced by the transaction
+		a.buf = make([]byte, 0, arenaChunkSize)
+	}
+	start := len(a.buf)
+	out, err := b.appendEncode(a.buf, data)
+	if err != nil {
+		return nil, err
+	}
+	// when the encoder has grown a new array, the records of the old one are
+	// still referenced by the transaction
+	a.buf = out
+	return out[start:len(out):len(out)], nil
+}
+
+// WriteHook is called in the transaction of every put and delete of a bucket
+// with the key and the encoded value, the value is nil for a delete. An error
+// aborts the write.
+type WriteHook func(tx *bolt.Tx, key, value []byte) error
+
+// txBatch is the batch of a write hook for the running write transaction
+// of a store, flushed once the transaction commits, so a write rolled back
+// has no effect past the store. A Bolt file has one write transaction at a
+// time, the batch of a transaction rolled back is dropped by the next one.
+type txBatch struct {
+	tx    *bolt.Tx
+	items *[]interface{}
+}
+
+// add adds the item to the batch of the transaction, flush is called with
+// the items once it commits.
+func (b *txBatch) add(tx *bolt.Tx, item interface{}, flush func(items []interface{})) {
+	if b.tx != tx {
+		items := new([]interface{})
+		b.tx, b.items = tx, items
+		// the items of the handler are its own, the next transaction may
+		// start before it runs
+		tx.OnCommit(func() { flush(*items) })
+	}
+	*b.items = append(*b.items, item)
+}
+
+// AddWriteHook registers a hook called on every write of the bucket.
+func (b *Bucket) AddWriteHook(hook WriteHook) {
+	b.hooks = append(b.hooks, hook)
+}
+
+// put puts a new value, enriched.
+func (b *Bucket) put(tx *bolt.Tx, bkt *bolt.Bucket, key, value []byte) error {
+	if b.enrich != nil {
+		enriched, ok, err := b.enrich(tx, key, value)
+		if err != nil || !ok {
+			return err
+		}
+		value = enriched
+	}
+	return b.putValue(tx, bkt, key, value)
+}
+
+// putRaw puts a value stored by another engine as it is, e.g. one
+// replicated or merged which is already enriched, without its checksum if it
+// has one.
+func (b *Bucket) putRaw(tx *bolt.Tx, bkt *bolt.Bucket, key, value []byte) error {
+	value, err := stripChecksum(value)
+	if err != nil {
+		return b.checksumError(key, err)
+	}
+	return b.putValue(tx, bkt, key, value)
+}
+
+// putValue puts a value through the hooks, the checksum is added only to
+// the value stored.
+func (b *Bucket) putValue(tx *bolt.Tx, bkt *bolt.Bucket, key, value []byte) error {
+	if value == nil {
+		// nil is a delete for the hooks
+		value = []byte{}
+	}
+	for _, hook := range b.hooks {
+		if err := hook(tx, key, value); err != nil {
+			return err
+		}
+	}
+	if b.checksums {
+		value = addChecksum(value)
+	}
+	return bkt.Put(key, value)
+}
+
+func (b *Bucket) del(tx *bolt.Tx, bkt *bolt.Bucket, key []byte) error {
+	for _, hook := range b.hooks {
+		if err := hook(tx, key, nil); err != nil {
+			return err
+		}
+	}
+	return bkt.Delete(key)
 }
 
 // Record is a data record
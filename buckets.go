@@ -0,0 +1,117 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// schemaBucket is the store bucket of the metadata of the buckets, so an
+// unknown store can be explored without the code that wrote it.
+var schemaBucket = []byte("_schema")
+
+// BucketInfo is the metadata of a bucket of a store.
+type BucketInfo struct {
+	Name string
+	// Type is the declared record type, e.g. "main.Event", "" if none.
+	Type string `json:",omitempty"`
+	// Codec is "gob", "json", "binary" or "custom", "" if the bucket was
+	// created before the metadata was recorded.
+	Codec string `json:",omitempty"`
+	// Views are the buckets derived from the records of the bucket, the
+	// indexes of the TSEngine views.
+	Views []string `json:",omitempty"`
+	// Internal is set for the buckets borm keeps for itself, e.g. the
+	// statistics or the compression dictionary.
+	Internal bool `json:"-"`
+}
+
+// codecName returns the name of a codec of borm, "custom" for the others.
+func codecName(encoder EncodeFunc) string {
+	codecs := []struct {
+		name   string
+		encode EncodeFunc
+	}{
+		{"gob", DefaultEncode},
+		{"json", JSONEncode},
+		{"binary", BinaryEncode},
+	}
+	if encoder == nil {
+		encoder = DefaultEncode
+	}
+	p := reflect.ValueOf(encoder).Pointer()
+	for _, c := range codecs {
+		if reflect.ValueOf(c.encode).Pointer() == p {
+			return c.name
+		}
+	}
+	return "custom"
+}
+
+// describe records the metadata of the bucket, unless it is recorded already.
+func (s *Store) describe(tx *bolt.Tx, b *Bucket, views []string) error {
+	info := BucketInfo{Name: b.Name, Codec: codecName(b.encode), Views: views}
+	if b.typ != nil {
+		info.Type = b.typ.String()
+	}
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	schema, err := tx.CreateBucketIfNotExists(schemaBucket)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(schema.Get(b.name), data) {
+		return nil
+	}
+	return schema.Put(b.name, data)
+}
+
+// Buckets returns the metadata of the buckets of the store, in name order.
+func (s *Store) Buckets() ([]BucketInfo, error) {
+	var infos []BucketInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		schema := tx.Bucket(schemaBucket)
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			info, err := bucketInfo(schema, name)
+			if err != nil {
+				return err
+			}
+			infos = append(infos, *info)
+			return nil
+		})
+	})
+	return infos, err
+}
+
+// BucketInfo returns the metadata of the bucket, ErrBucketNotFound if the
+// store has no such bucket.
+func (s *Store) BucketInfo(name string) (*BucketInfo, error) {
+	var info *BucketInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(name)) == nil {
+			return ErrBucketNotFound
+		}
+		var err error
+		info, err = bucketInfo(tx.Bucket(schemaBucket), []byte(name))
+		return err
+	})
+	return info, err
+}
+
+func bucketInfo(schema *bolt.Bucket, name []byte) (*BucketInfo, error) {
+	info := &BucketInfo{Name: string(name)}
+	if schema != nil {
+		if data := schema.Get(name); data != nil {
+			if err := json.Unmarshal(data, info); err != nil {
+				return nil, err
+			}
+		}
+	}
+	info.Internal = strings.HasPrefix(info.Name, "_")
+	return info, nil
+}
@@ -0,0 +1,580 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaChanges is the meta bucket of the change log, keyed by sequence.
+var metaChanges = []byte("changes")
+
+// ErrChangesTruncated is returned by Changes when the changes after the
+// sequence were truncated, the follower needs a new snapshot.
+var ErrChangesTruncated = errors.New("changes are truncated")
+
+// Change is a record written or deleted in a shard.
+type Change struct {
+	Seq uint64 `json:"seq"`
+
+	// Shard is the shard file relative to the engine path.
+	Shard string `json:"shard"`
+	Key   string `json:"key"`
+
+	// Value is the encoded record, nil for a delete.
+	Value []byte `json:"value,omitempty"`
+}
+
+// changesBucket is the shard bucket of the changes of the shard, keyed by a
+// sequence of the shard. A change is put there by the write transaction of
+// the record, then appended to the change log once it commits, so a crash
+// in between doesn't lose it: it is appended when the engine is opened
+// again. The changes in the change log are dropped by the next write.
+var changesBucket = []byte("_changes")
+
+// loggedChanges are the changes of the changes bucket of a shard known to be
+// in the change log, which the write transactions drop.
+type loggedChanges struct {
+	// through is the last one.
+	through atomic.Uint64
+	// read is whether through was read from the meta store.
+	read bool
+}
+
+// changeLog returns the write hook of the records bucket appending the
+// records to the change log.
+func (db *TSEngine) changeLog(bkt *Bucket) WriteHook {
+	return func(tx *bolt.Tx, key, value []byte) error {
+		return db.logChange(bkt, tx, key, value)
+	}
+}
+
+// logChange puts the record in the changes bucket of the shard, it is
+// appended to the change log once the write transaction commits, with the
+// other changes of the transaction.
+func (db *TSEngine) logChange(bkt *Bucket, tx *bolt.Tx, key, value []byte) error {
+	change := Change{Shard: string(db.shardKey(tx.DB().Path())), Key: string(key)}
+	if value != nil {
+		// the value may be in the arena of an append encoder
+		change.Value = append([]byte{}, value...)
+	}
+	changes, err := db.shardChanges(bkt, tx)
+	if err != nil {
+		return err
+	}
+	seq, err := changes.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&change)
+	if err != nil {
+		return err
+	}
+	if err := changes.Put(seqKey(seq), data); err != nil {
+		return err
+	}
+	bkt.changes.add(tx, seq, func(items []interface{}) {
+		db.appendChanges(bkt, items[len(items)-1].(uint64))
+	})
+	return nil
+}
+
+// shardChanges returns the changes bucket of the shard, dropping the
+// changes in the change log on the first change of a write transaction.
+func (db *TSEngine) shardChanges(bkt *Bucket, tx *bolt.Tx) (*bolt.Bucket, error) {
+	changes, err := tx.CreateBucketIfNotExists(changesBucket)
+	if err != nil || bkt.changes.tx == tx {
+		return changes, err
+	}
+	if !bkt.logged.read {
+		meta, err := db.readShardMeta(tx.DB().Path())
+		if err != nil {
+			return nil, err
+		}
+		setMax(&bkt.logged.through, meta.ChangesLogged)
+		bkt.logged.read = true
+	}
+	logged := bkt.logged.through.Load()
+	// the bucket may be new or copied without its sequence, the changes
+	// continue the ones in the change log
+	if changes.Sequence() < logged {
+		if err := changes.SetSequence(logged); err != nil {
+			return nil, err
+		}
+	}
+	c := changes.Cursor()
+	for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= logged; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// appendChanges appends the changes of a write transaction to the change
+// log. The write is committed already, a failure is logged to the Logger,
+// the changes are appended with the next write of the shard or when the
+// engine is opened again.
+func (db *TSEngine) appendChanges(bkt *Bucket, through uint64) {
+	logged, _, err := db.logChanges(bkt.store.db, through)
+	if err != nil {
+		db.logger().Printf("engine failed to log the changes of %s: %s", bkt.store.db.Path(), err)
+		return
+	}
+	setMax(&bkt.logged.through, logged)
+
+	db.changesMu.Lock()
+	if db.changed != nil {
+		close(db.changed)
+		db.changed = nil
+	}
+	db.changesMu.Unlock()
+}
+
+// logChanges appends the changes of the changes bucket of the shard after
+// the last one in the change log, through the sequence or all of them if it
+// is 0, and returns the last one in the change log and the number appended.
+// The changes of a write transaction committing after the next one are
+// appended by the next one, so the changes of a shard are logged in the
+// order of its writes.
+func (db *TSEngine) logChanges(shard *bolt.DB, through uint64) (uint64, int, error) {
+	key := db.shardKey(shard.Path())
+	var logged uint64
+	var n int
+	err := db.updateMeta(metaChanges, func(bkt *bolt.Bucket) error {
+		shards, err := bkt.Tx().CreateBucketIfNotExists(metaShards)
+		if err != nil {
+			return err
+		}
+		return putShardMeta(shards, key, func(meta *shardMeta) error {
+			logged = meta.ChangesLogged
+			err := shard.View(func(tx *bolt.Tx) error {
+				changes := tx.Bucket(changesBucket)
+				if changes == nil {
+					return nil
+				}
+				c := changes.Cursor()
+				for k, v := c.Seek(seqKey(logged + 1)); k != nil; k, v = c.Next() {
+					if through != 0 && binary.BigEndian.Uint64(k) > through {
+						break
+					}
+					var change Change
+					if err := json.Unmarshal(v, &change); err != nil {
+						return err
+					}
+					seq, err := bkt.NextSequence()
+					if err != nil {
+						return err
+					}
+					change.Seq = seq
+					data, err := json.Marshal(&change)
+					if err != nil {
+						return err
+					}
+					if err := bkt.Put(seqKey(seq), data); err != nil {
+						return err
+					}
+					logged = binary.BigEndian.Uint64(k)
+					n++
+				}
+				return nil
+			})
+			meta.ChangesLogged = logged
+			return err
+		})
+	})
+	return logged, n, err
+}
+
+// recoverChanges appends the changes of the shards the engine failed to
+// log before it was closed, e.g. by a crash.
+func (db *TSEngine) recoverChanges() ([]Repair, error) {
+	shards, err := db.listShards(db.location())
+	if err != nil {
+		return nil, err
+	}
+	var repairs []Repair
+	for _, shard := range shards {
+		store, err := bolt.Open(shard.path, db.fileMode(), &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+		if err != nil {
+			return repairs, err
+		}
+		_, n, err := db.logChanges(store, 0)
+		store.Close()
+		if err != nil {
+			return repairs, err
+		}
+		if n > 0 {
+			action := "logged " + strconv.Itoa(n) + " changes"
+			db.logger().Printf("engine repaired %s, %s", shard.path, action)
+			repairs = append(repairs, Repair{Path: shard.path, Action: action})
+		}
+	}
+	return repairs, nil
+}
+
+// seqKey returns the key of the sequence.
+func seqKey(seq uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], seq)
+	return key[:]
+}
+
+// setMax sets v to seq if it is larger.
+func setMax(v *atomic.Uint64, seq uint64) {
+	for {
+		old := v.Load()
+		if seq <= old || v.CompareAndSwap(old, seq) {
+			return
+		}
+	}
+}
+
+// LastChange returns the sequence of the last change logged, 0 if none.
+func (db *TSEngine) LastChange() (uint64, error) {
+	var seq uint64
+	err := db.viewMeta(metaChanges, func(bkt *bolt.Bucket) error {
+		if bkt != nil {
+			seq = bkt.Sequence()
+		}
+		return nil
+	})
+	return seq, err
+}
+
+// Changes returns up to limit changes logged after the sequence, oldest
+// first. It returns ErrChangesTruncated if some of them were truncated.
+func (db *TSEngine) Changes(after uint64, limit int) ([]Change, error) {
+	var changes []Change
+	err := db.viewMeta(metaChanges, func(bkt *bolt.Bucket) error {
+		if bkt == nil || after >= bkt.Sequence() {
+			return nil
+		}
+		var from [8]byte
+		binary.BigEndian.PutUint64(from[:], after+1)
+		c := bkt.Cursor()
+		k, v := c.Seek(from[:])
+		if k == nil || binary.BigEndian.Uint64(k) != after+1 {
+			return ErrChangesTruncated
+		}
+		for ; k != nil && (limit <= 0 || len(changes) < limit); k, v = c.Next() {
+			var change Change
+			if err := json.Unmarshal(v, &change); err != nil {
+				return err
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// WaitChanges waits at most timeout for a change logged after the sequence,
+// it returns whether there is one.
+func (db *TSEngine) WaitChanges(after uint64, timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		db.changesMu.Lock()
+		if db.changed == nil {
+			db.changed = make(chan struct{})
+		}
+		changed := db.changed
+		db.changesMu.Unlock()
+
+		seq, err := db.LastChange()
+		if err != nil || seq > after {
+			return seq > after, err
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false, nil
+		}
+	}
+}
+
+// TruncateChanges drops the changes up to the sequence, once all the
+// followers have applied them.
+func (db *TSEngine) TruncateChanges(through uint64) error {
+	return db.updateMeta(metaChanges, func(bkt *bolt.Bucket) error {
+		c := bkt.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= through; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SnapshotChanges calls cb with a change writing every record of the
+// engine, shard by shard from the oldest one, and returns the sequence of
+// the change log before the snapshot. The records of a shard follow a change
+// of the shard without key, empty shards included. Applying the snapshot
+// then the changes after the sequence gives a copy of the engine, since
+// changes made during the snapshot are applied again, see ApplySnapshot. The
+// value of a change is only valid during the callback.
+func (db *TSEngine) SnapshotChanges(cb func(change Change) error) (uint64, error) {
+	seq, err := db.LastChange()
+	if err != nil {
+		return 0, err
+	}
+	shards, err := db.listShards(db.location())
+	if err != nil {
+		return 0, err
+	}
+	for i := len(shards) - 1; i >= 0; i-- {
+		shard := string(db.shardKey(shards[i].path))
+		if err := cb(Change{Shard: shard}); err != nil {
+			return 0, err
+		}
+		err := db.readBackground(shards[i].path, func(bkt *Bucket) error {
+			return bkt.store.db.View(func(tx *bolt.Tx) error {
+				data := tx.Bucket(bkt.name)
+				if data == nil {
+					return nil
+				}
+				return data.ForEach(func(k, v []byte) error {
+					value, err := bkt.plain(v)
+					if err != nil {
+						return bkt.checksumError(k, err)
+					}
+					return cb(Change{Shard: shard, Key: string(k), Value: value})
+				})
+			})
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// ApplyChanges writes the changes of another engine, e.g. the leader of a
+// follower, into the shards of the engine. Applying a change again has no
+// effect, so changes may be replayed after a failure. The writes go through
+// the hooks, so views are maintained and the engine logs the changes if it
+// has a change log itself. Both engines need the same shard interval. The
+// changes without key of a snapshot are skipped.
+func (db *TSEngine) ApplyChanges(changes []Change) error {
+	for len(changes) > 0 {
+		if changes[0].Key == "" {
+			changes = changes[1:]
+			continue
+		}
+		n := 1
+		for n < len(changes) && changes[n].Shard == changes[0].Shard && changes[n].Key != "" {
+			n++
+		}
+		if err := db.applyShard(changes[:n]); err != nil {
+			return err
+		}
+		changes = changes[n:]
+	}
+	return nil
+}
+
+func (db *TSEngine) applyShard(changes []Change) error {
+	fileName, err := db.changeShard(changes[0].Shard)
+	if err != nil {
+		return err
+	}
+	return db.updateShard(fileName, func(tx *bolt.Tx, bkt *Bucket, data *bolt.Bucket) error {
+		for _, change := range changes {
+			if err := applyChange(tx, bkt, data, change); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// changeShard returns the shard file of a change.
+func (db *TSEngine) changeShard(name string) (string, error) {
+	// the shard may neither escape the engine path nor be the meta store
+	shard := path.Clean("/" + name)[1:]
+	if shard == "" || strings.HasPrefix(path.Base(shard), ".") {
+		return "", errors.New("invalid shard " + name)
+	}
+	return filepath.Join(db.basePath, filepath.FromSlash(shard)), nil
+}
+
+// updateShard runs fn in a write transaction of the shard file with its
+// records bucket, unsealing the shard first.
+func (db *TSEngine) updateShard(fileName string, fn func(tx *bolt.Tx, bkt *Bucket, data *bolt.Bucket) error) error {
+	return db.read(fileName, func(bkt *Bucket) error {
+		if err := unseal(bkt); err != nil {
+			return err
+		}
+		return bkt.store.db.Update(func(tx *bolt.Tx) error {
+			return fn(tx, bkt, tx.Bucket(bkt.name))
+		})
+	})
+}
+
+func applyChange(tx *bolt.Tx, bkt *Bucket, data *bolt.Bucket, change Change) error {
+	if change.Value == nil {
+		return bkt.del(tx, data, []byte(change.Key))
+	}
+	return bkt.putRaw(tx, data, []byte(change.Key), change.Value)
+}
+
+// SnapshotApplier applies the snapshot of another engine, see
+// TSEngine.ApplySnapshot.
+type SnapshotApplier struct {
+	db *TSEngine
+
+	// shards are the shards of the snapshot, oldest is the first one.
+	shards map[string]bool
+	oldest string
+
+	// shard is the shard applied, last the last key of its records, nil
+	// before the first one.
+	shard string
+	last  []byte
+}
+
+// ApplySnapshot returns the applier of the snapshot of another engine, see
+// SnapshotChanges, making the engine a copy of it: the records the snapshot
+// doesn't have are deleted, e.g. the ones the other engine deleted while a
+// follower of it was behind. The shards older than the oldest one of the
+// snapshot are kept, every engine runs its own retention.
+func (db *TSEngine) ApplySnapshot() *SnapshotApplier {
+	return &SnapshotApplier{db: db, shards: map[string]bool{}}
+}
+
+// Apply applies the next changes of the snapshot, in the order of
+// SnapshotChanges.
+func (a *SnapshotApplier) Apply(changes []Change) error {
+	for len(changes) > 0 {
+		if changes[0].Key == "" {
+			if err := a.endShard(); err != nil {
+				return err
+			}
+			a.shard, a.last = changes[0].Shard, nil
+			if a.oldest == "" {
+				a.oldest = a.shard
+			}
+			a.shards[a.shard] = true
+			changes = changes[1:]
+			continue
+		}
+
+		n := 0
+		for prev := a.last; n < len(changes) && changes[n].Key != ""; n++ {
+			if changes[n].Shard != a.shard || (prev != nil && changes[n].Key <= string(prev)) {
+				return errors.New("snapshot change " + changes[n].Key + " of " + changes[n].Shard + " is out of order")
+			}
+			prev = []byte(changes[n].Key)
+		}
+		fileName, err := a.db.changeShard(a.shard)
+		if err != nil {
+			return err
+		}
+		last := a.last
+		err = a.db.updateShard(fileName, func(tx *bolt.Tx, bkt *Bucket, data *bolt.Bucket) error {
+			for _, change := range changes[:n] {
+				key := []byte(change.Key)
+				if err := deleteBetween(tx, bkt, data, last, key); err != nil {
+					return err
+				}
+				if err := applyChange(tx, bkt, data, change); err != nil {
+					return err
+				}
+				last = key
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		a.last = last
+		changes = changes[n:]
+	}
+	return nil
+}
+
+// Finish deletes the records after the last ones of the snapshot in their
+// shards, and the records of the shards the snapshot doesn't have.
+func (a *SnapshotApplier) Finish() error {
+	if err := a.endShard(); err != nil {
+		return err
+	}
+	a.shard = ""
+	if a.oldest == "" {
+		return nil
+	}
+	fileName, err := a.db.changeShard(a.oldest)
+	if err != nil {
+		return err
+	}
+	oldest, err := a.db.openShard(fileName, a.db.location())
+	if err != nil {
+		return err
+	}
+	shards, err := a.db.listShards(a.db.location())
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if a.shards[string(a.db.shardKey(shard.path))] || shard.startTime.Before(oldest.startTime) {
+			continue
+		}
+		if err := a.db.updateShard(shard.path, func(tx *bolt.Tx, bkt *Bucket, data *bolt.Bucket) error {
+			return deleteBetween(tx, bkt, data, nil, nil)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endShard deletes the records of the shard applied after its last one.
+func (a *SnapshotApplier) endShard() error {
+	if a.shard == "" {
+		return nil
+	}
+	fileName, err := a.db.changeShard(a.shard)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	return a.db.updateShard(fileName, func(tx *bolt.Tx, bkt *Bucket, data *bolt.Bucket) error {
+		return deleteBetween(tx, bkt, data, a.last, nil)
+	})
+}
+
+// deleteBetween deletes the records with a key after from and before to,
+// nil bounds not limiting them.
+func deleteBetween(tx *bolt.Tx, bkt *Bucket, data *bolt.Bucket, from, to []byte) error {
+	var keys [][]byte
+	c := data.Cursor()
+	k, _ := c.First()
+	if from != nil {
+		if k, _ = c.Seek(from); bytes.Equal(k, from) {
+			k, _ = c.Next()
+		}
+	}
+	for ; k != nil && (to == nil || bytes.Compare(k, to) < 0); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, key := range keys {
+		if err := bkt.del(tx, data, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package borm
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strconv"
+)
+
+// checksumMarker starts the values stored with a checksum, followed by the
+// 4 bytes CRC-32C of the rest. No value of the Gob, JSON or binary codecs,
+// nor a compressed value, starts with it.
+const checksumMarker = 0xf7
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is matched by errors.Is with the ChecksumErrors of the records
+// that don't match their checksum.
+var ErrChecksum = errors.New("record checksum mismatch")
+
+// ChecksumError is the error of a record that doesn't match its checksum,
+// e.g. corrupted by the storage.
+type ChecksumError struct {
+	Shard string
+	Key   string
+}
+
+func (e *ChecksumError) Error() string {
+	return "checksum mismatch of the record " + strconv.Quote(e.Key) + " in " + e.Shard
+}
+
+// Is makes errors.Is match the error with ErrChecksum.
+func (e *ChecksumError) Is(err error) bool {
+	return err == ErrChecksum
+}
+
+// SetChecksums makes the bucket store a checksum with every value written,
+// verified on every read. The values with a checksum are verified whatever
+// the setting, so it may be turned on and off over time. A value of the
+// codec starting with a 0xf7 byte is read back with the checksums on only.
+func (b *Bucket) SetChecksums(on bool) {
+	b.checksums = on
+}
+
+// addChecksum returns the value with its checksum.
+func addChecksum(value []byte) []byte {
+	out := make([]byte, 5+len(value))
+	out[0] = checksumMarker
+	binary.BigEndian.PutUint32(out[1:5], crc32.Checksum(value, castagnoli))
+	copy(out[5:], value)
+	return out
+}
+
+// stripChecksum returns the value without its checksum, ErrChecksum if it
+// doesn't match, or the value itself when it has none.
+func stripChecksum(value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != checksumMarker {
+		return value, nil
+	}
+	if len(value) < 5 || binary.BigEndian.Uint32(value[1:5]) != crc32.Checksum(value[5:], castagnoli) {
+		return nil, ErrChecksum
+	}
+	return value[5:], nil
+}
+
+// checksumError adds the key and the shard to a checksum mismatch.
+func (b *Bucket) checksumError(key []byte, err error) error {
+	if err != ErrChecksum {
+		return err
+	}
+	return &ChecksumError{Shard: b.store.db.Path(), Key: string(key)}
+}
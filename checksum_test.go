@@ -0,0 +1,213 @@
+package borm_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+)
+
+func TestChecksums(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	options := &borm.TSOptions{Checksums: true}
+	db, err := borm.OpenTSWithOptions(dir, options)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(now, uint32(i)), &ItemTest{ID: i, Name: "checked", Created: now})
+		})
+		if err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+	var item ItemTest
+	if err := db.Get(borm.CreateID(now, 1), &item); err != nil || item.ID != 1 {
+		t.Fatalf("Error reading a checksummed record: %v", err)
+	}
+	shards, err := db.Shards()
+	if err != nil || len(shards) != 1 {
+		t.Fatalf("Expected one shard, got %v: %v", shards, err)
+	}
+	db.Close()
+
+	// flip a bit of the second record, as flaky storage would
+	bad := borm.CreateID(now, 1)
+	shard := shards[0].Path
+	raw, err := bolt.Open(shard, 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", shard, err)
+	}
+	err = raw.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("attack"))
+		value := append([]byte(nil), bkt.Get([]byte(bad))...)
+		value[len(value)-1] ^= 0x01
+		return bkt.Put([]byte(bad), value)
+	})
+	raw.Close()
+	if err != nil {
+		t.Fatalf("Error corrupting the record: %s", err)
+	}
+
+	db, err = borm.OpenTSWithOptions(dir, options)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	var ce *borm.ChecksumError
+	err = db.Get(bad, &item)
+	if !errors.As(err, &ce) || ce.Key != bad || !errors.Is(err, borm.ErrChecksum) {
+		t.Fatalf("Expected a checksum error of %s, got %v", bad, err)
+	}
+
+	read := 0
+	err = db.QueryWith(now.Add(-time.Minute), now.Add(time.Minute), &borm.QueryOptions{SkipUndecodable: true}, func(it *borm.Iterator) error {
+		for it.Next() {
+			if err := it.Read(&item); err != nil {
+				return err
+			}
+			read++
+		}
+		return nil
+	})
+	if err != nil || read != 2 {
+		t.Fatalf("Expected the corrupted record skipped, read %d: %v", read, err)
+	}
+
+	// the raw value of the corrupted record is not leaked
+	var values [][]byte
+	err = db.Query(now.Add(-time.Minute), now.Add(time.Minute), func(it *borm.Iterator) error {
+		for it.Next() {
+			if value := it.Value(); value != nil {
+				values = append(values, append([]byte(nil), value...))
+			}
+		}
+		return nil
+	})
+	var ie *borm.IterError
+	if !errors.As(err, &ie) || ie.Op != borm.OpDecode || ie.Key != bad || !errors.Is(err, borm.ErrChecksum) {
+		t.Fatalf("Expected a decode error of %s, got %v", bad, err)
+	}
+	if len(values) != 1 || values[0][0] == 0xf7 {
+		t.Fatalf("Expected the value before the corrupted record, got %v.", values)
+	}
+}
+
+func TestChecksumsMarkerValue(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	// a value of the codec may start like a checksummed one
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Checksums: true,
+		Encoder: func(value interface{}) ([]byte, error) {
+			return append([]byte{0xf7}, value.([]byte)...), nil
+		},
+		Decoder: func(data []byte, value interface{}) error {
+			*value.(*[]byte) = append([]byte(nil), data[1:]...)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	key := borm.CreateID(now, 1)
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(key, []byte("0123"))
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	var value []byte
+	if err := db.Get(key, &value); err != nil || string(value) != "0123" {
+		t.Fatalf("Expected 0123, got %q: %v", value, err)
+	}
+}
+
+func TestChecksumsHooks(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	// the hooks get the encoded values, without their checksums
+	plain := func(value []byte) bool {
+		var item ItemTest
+		if borm.DefaultDecode(value, &item) != nil {
+			return false
+		}
+		encoded, err := borm.DefaultEncode(&item)
+		return err == nil && bytes.Equal(encoded, value)
+	}
+	var checksummed []string
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Checksums: true,
+		Views: []borm.View{{Name: "plain", Filter: func(key, value []byte) bool {
+			return plain(value)
+		}}},
+		Namespace: func(key, value []byte) string {
+			if !plain(value) {
+				checksummed = append(checksummed, string(key))
+			}
+			return ""
+		},
+		GlobalQuota: borm.Quota{Records: 100},
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		for i := 0; i < 3; i++ {
+			if err := bkt.Insert(borm.CreateID(now, uint32(i)), &ItemTest{ID: i, Name: "checked", Created: now}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if len(checksummed) != 0 {
+		t.Fatalf("Namespace got the checksummed values of %v.", checksummed)
+	}
+
+	countView := func() int {
+		count := 0
+		err := db.QueryView("plain", now.Add(-time.Second), now.Add(time.Second), func(it *borm.Iterator) error {
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying view: %s", err)
+		}
+		return count
+	}
+	if count := countView(); count != 3 {
+		t.Fatalf("View holds %d records wanted %d.", count, 3)
+	}
+	if err := db.RebuildView("plain", now, now); err != nil {
+		t.Fatalf("Error rebuilding view: %s", err)
+	}
+	if count := countView(); count != 3 {
+		t.Fatalf("Rebuilt view holds %d records wanted %d.", count, 3)
+	}
+}
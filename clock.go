@@ -0,0 +1,66 @@
+package borm
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time the engine consults: the hot shard, the retention ages,
+// the clock skew guard, the quota days and the dedup window. Tests replace
+// it with a ManualClock to check rollover and retention without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock, the default of TSOptions.Clock.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ManualClock is a Clock moving only when set or advanced.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a clock stopped at t.
+func NewManualClock(t time.Time) *ManualClock {
+	return &ManualClock{now: t}
+}
+
+// Now returns the time of the clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to t.
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// Advance moves the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// now returns the time of the engine clock.
+func (db *TSEngine) now() time.Time {
+	if db.options.Clock != nil {
+		return db.options.Clock.Now()
+	}
+	return time.Now()
+}
+
+// location returns the location of the engine clock, the shards are named
+// in it.
+func (db *TSEngine) location() *time.Location {
+	return db.now().Location()
+}
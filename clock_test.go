@@ -0,0 +1,57 @@
+package borm_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestManualClock(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	day := time.Date(2020, 3, 1, 12, 0, 0, 0, time.Local)
+	clock := borm.NewManualClock(day)
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Clock:         clock,
+		MaxFutureSkew: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	write := func(at time.Time) error {
+		return db.Write(at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(db.NewID(at), &ItemTest{Name: "car", Created: at})
+		})
+	}
+	if err := write(day); err != nil {
+		t.Fatalf("Error writing at %s: %s", day, err)
+	}
+	tomorrow := day.AddDate(0, 0, 1)
+	if err := write(tomorrow); !errors.Is(err, borm.ErrClockSkew) {
+		t.Fatalf("Writing tomorrow got %v wanted %s.", err, borm.ErrClockSkew)
+	}
+
+	clock.Advance(24 * time.Hour)
+	if err := write(tomorrow); err != nil {
+		t.Fatalf("Error writing at %s: %s", tomorrow, err)
+	}
+
+	policy := borm.RetentionPolicy{MaxAge: 48 * time.Hour}
+	if deleted, err := db.ApplyRetention(policy, false); err != nil || len(deleted) != 0 {
+		t.Fatalf("Retention deleted %v, %v wanted nothing yet.", deleted, err)
+	}
+	clock.Set(day.AddDate(0, 0, 3))
+	deleted, err := db.ApplyRetention(policy, false)
+	if err != nil {
+		t.Fatalf("Error applying retention: %s", err)
+	}
+	if len(deleted) != 1 || !deleted[0].StartTime.Equal(borm.Daily.Truncate(day)) {
+		t.Fatalf("Retention deleted %+v wanted the shard of %s.", deleted, day)
+	}
+}
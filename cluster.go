@@ -0,0 +1,167 @@
+package borm
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ringReplicas is the number of points of every directory on the hash ring.
+const ringReplicas = 64
+
+type ringPoint struct {
+	hash   uint64
+	engine int
+}
+
+// Cluster distributes series across engines in several directories, e.g. on
+// different disks, with consistent hashing of the series name. Queries fan
+// out to all the engines and merge the records in key order.
+type Cluster struct {
+	engines []*TSEngine
+	ring    []ringPoint
+}
+
+// OpenCluster opens an engine in every path with the given options. The
+// series are placed by the position of the path in paths, the order must
+// not change between runs.
+func OpenCluster(paths []string, options *TSOptions) (*Cluster, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("cluster has no path")
+	}
+
+	c := &Cluster{}
+	for i, path := range paths {
+		db, err := OpenTSWithOptions(path, options)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.engines = append(c.engines, db)
+
+		for r := 0; r < ringReplicas; r++ {
+			c.ring = append(c.ring, ringPoint{ringHash(strconv.Itoa(i) + "#" + strconv.Itoa(r)), i})
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool {
+		return c.ring[i].hash < c.ring[j].hash
+	})
+	return c, nil
+}
+
+func ringHash(s string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(s))
+	return mix64(hash.Sum64())
+}
+
+// Engines returns the engines of the cluster.
+func (c *Cluster) Engines() []*TSEngine {
+	return c.engines
+}
+
+// Engine returns the engine the series is placed on.
+func (c *Cluster) Engine(series string) *TSEngine {
+	hash := ringHash(series)
+	i := sort.Search(len(c.ring), func(i int) bool {
+		return c.ring[i].hash >= hash
+	})
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.engines[c.ring[i].engine]
+}
+
+// Write writes into the shard of t of the engine of the series.
+func (c *Cluster) Write(series string, t time.Time, cb func(bkt *Bucket) error) error {
+	return c.Engine(series).Write(t, cb)
+}
+
+// Get retrieves a record by id from whichever engine has it.
+func (c *Cluster) Get(id string, record interface{}) error {
+	for _, db := range c.engines {
+		err := db.Get(id, record)
+		if err != ErrNotFound {
+			return err
+		}
+	}
+	return ErrNotFound
+}
+
+// Query retrieves the records in the time range from all the engines, in key
+// order. The record is only valid during the callback.
+func (c *Cluster) Query(start, end time.Time, cb func(rec *RawRecord) error) error {
+	first := c.engines[0]
+	err := filesRead(first.nameWith, first.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		for _, span := range keyRanges(position, start, end) {
+			// every engine keeps a read transaction on the shard open
+			// while the iterators are merged
+			var its []*Iterator
+			var open func(i int) error
+			open = func(i int) error {
+				if i == len(c.engines) {
+					return mergeIterators(its, cb)
+				}
+				db := c.engines[i]
+				file := db.nameWith(day)
+				if _, err := os.Stat(file); os.IsNotExist(err) {
+					return open(i + 1)
+				}
+				return db.read(file, func(bkt *Bucket) error {
+					return bkt.getRange(span.from, span.to, func(it *Iterator) error {
+						its = append(its, it)
+						return open(i + 1)
+					})
+				})
+			}
+			if err := open(0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return stopped(err)
+}
+
+// mergeIterators calls cb with the records of the iterators in key order.
+func mergeIterators(its []*Iterator, cb func(rec *RawRecord) error) error {
+	var live []*Iterator
+	for _, it := range its {
+		if it.Next() {
+			live = append(live, it)
+		}
+	}
+
+	for len(live) > 0 {
+		min := 0
+		for i := 1; i < len(live); i++ {
+			if bytes.Compare(live[i].key, live[min].key) < 0 {
+				min = i
+			}
+		}
+
+		it := live[min]
+		if err := cb(&RawRecord{Key: it.key, Value: it.Value(), decode: it.B.decodeValue}); err != nil {
+			return err
+		}
+		if !it.Next() {
+			live = append(live[:min], live[min+1:]...)
+		}
+	}
+	return nil
+}
+
+// Close closes all the engines.
+func (c *Cluster) Close() error {
+	var err error
+	for _, db := range c.engines {
+		if e := db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package borm_test
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestCluster(t *testing.T) {
+	var paths []string
+	for i := 0; i < 3; i++ {
+		dir := tempdir(t)
+		defer os.RemoveAll(dir)
+		paths = append(paths, dir)
+	}
+
+	c, err := borm.OpenCluster(paths, nil)
+	if err != nil {
+		t.Fatalf("Error opening cluster: %s", err)
+	}
+	defer c.Close()
+
+	now := time.Now()
+	perEngine := map[*borm.TSEngine]int{}
+	for i := 0; i < 30; i++ {
+		series := "series" + strconv.Itoa(i)
+		if c.Engine(series) != c.Engine(series) {
+			t.Fatalf("Series %s isn't placed consistently.", series)
+		}
+		perEngine[c.Engine(series)]++
+
+		id := borm.CreateID(now, uint32(i))
+		err := c.Write(series, now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(id, &ItemTest{ID: i, Name: series, Created: now})
+		})
+		if err != nil {
+			t.Fatalf("Error writing %s: %s", series, err)
+		}
+	}
+	if len(perEngine) != 3 {
+		t.Fatalf("Series are placed on %d engines, expected 3.", len(perEngine))
+	}
+
+	var item ItemTest
+	if err := c.Get(borm.CreateID(now, 7), &item); err != nil || item.ID != 7 {
+		t.Fatalf("Error getting record 7: %v %s", item, err)
+	}
+
+	var last []byte
+	count := 0
+	err = c.Query(now.Add(-time.Minute), now.Add(time.Minute), func(rec *borm.RawRecord) error {
+		if last != nil && bytes.Compare(last, rec.Key) >= 0 {
+			t.Fatalf("Records aren't merged in key order: %x after %x", rec.Key, last)
+		}
+		last = append(last[:0], rec.Key...)
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if count != 30 {
+		t.Fatalf("Query returned %d records, expected 30.", count)
+	}
+}
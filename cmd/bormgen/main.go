@@ -0,0 +1,411 @@
+// Command bormgen generates the reflection-free codec of record types, for
+// the users who care about the ingest CPU. The records are encoded in the
+// format of borm.CodecBinary, so both codecs read the records of the other,
+// and written with borm.CodecGenerated:
+//
+//	//go:generate bormgen $GOFILE
+//
+//	//borm:generate
+//	type Event struct {
+//		Time   time.Time
+//		Source string `borm:"2,index"`
+//		Bytes  int64  `borm:"3,index"`
+//	}
+//
+// For every struct marked with the borm:generate directive, bormgen writes
+// the MarshalBorm and UnmarshalBorm methods of its pointer into the
+// <file>_borm.go file, and, when fields are tagged with the index option, a
+// <Type>Columns func returning their extractors, e.g. for TSOptions.Columns.
+// The field tags are the ones of the binary codec. The fields may be bools,
+// integers, floats, strings, byte slices, time.Time or slices of those,
+// named types need the binary codec.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// directive marks the structs to generate the codec of.
+const directive = "//borm:generate"
+
+// The kinds of the supported field types.
+const (
+	kindInt    = "int"
+	kindUint   = "uint"
+	kindFloat  = "float"
+	kindBool   = "bool"
+	kindString = "string"
+	kindBytes  = "bytes"
+	kindTime   = "time"
+)
+
+type field struct {
+	name     string
+	tag      uint64
+	kind     string
+	typ      string // the Go type of the value, of the element if repeated
+	repeated bool
+	index    bool
+}
+
+type record struct {
+	name   string
+	fields []field
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("bormgen: ")
+	output := flag.String("output", "", "output file name, default <file>_borm.go")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bormgen [-output file] file.go")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	file := flag.Arg(0)
+	if file == "" {
+		file = os.Getenv("GOFILE")
+	}
+	if file == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = outputName(file)
+	}
+
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := Generate(file, src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*output, out, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// outputName returns the name of the file generated from the source file.
+func outputName(file string) string {
+	if strings.HasSuffix(file, "_test.go") {
+		return strings.TrimSuffix(file, "_test.go") + "_borm_test.go"
+	}
+	return strings.TrimSuffix(file, ".go") + "_borm.go"
+}
+
+// Generate returns the source of the codec of the marked structs of the
+// source file.
+func Generate(file string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok || !(marked(gen.Doc) || marked(ts.Doc)) {
+				continue
+			}
+			rec, err := parseRecord(ts.Name.Name, st)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, rec)
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s has no struct marked with %s", file, directive)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by bormgen from %s. DO NOT EDIT.\n\n", file)
+	fmt.Fprintf(&buf, "package %s\n\n", f.Name.Name)
+	fmt.Fprintf(&buf, "import \"github.com/runner-mei/borm\"\n")
+	for _, rec := range records {
+		writeRecord(&buf, rec)
+	}
+	return format.Source(buf.Bytes())
+}
+
+func marked(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRecord returns the fields of the struct, numbered as the binary codec
+// does: the position of the field plus one unless tagged.
+func parseRecord(name string, st *ast.StructType) (record, error) {
+	rec := record{name: name}
+	seen := map[uint64]string{}
+	position := 0
+	for _, f := range st.Fields.List {
+		names := f.Names
+		if len(names) == 0 {
+			position++
+			if embeddedName(f.Type) != "" && ast.IsExported(embeddedName(f.Type)) {
+				return rec, fmt.Errorf("%s embeds %s, embedded fields aren't supported", name, embeddedName(f.Type))
+			}
+			continue
+		}
+
+		var opts string
+		tag := uint64(0)
+		if f.Tag != nil {
+			s, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return rec, err
+			}
+			s = reflect.StructTag(s).Get("borm")
+			if i := strings.IndexByte(s, ','); i >= 0 {
+				s, opts = s[:i], s[i+1:]
+			}
+			if s == "-" {
+				position += len(names)
+				continue
+			}
+			if s != "" {
+				n, err := strconv.ParseUint(s, 10, 32)
+				if err != nil || n == 0 {
+					return rec, fmt.Errorf("field %s.%s has an invalid tag %q", name, names[0].Name, s)
+				}
+				tag = n
+			}
+		}
+
+		for _, ident := range names {
+			position++
+			if !ident.IsExported() {
+				continue
+			}
+			fd := field{name: ident.Name, tag: uint64(position)}
+			if tag != 0 {
+				fd.tag = tag
+			}
+			if prev, dup := seen[fd.tag]; dup {
+				return rec, fmt.Errorf("field %s.%s reuses tag %d of %s", name, fd.name, fd.tag, prev)
+			}
+			seen[fd.tag] = fd.name
+
+			var err error
+			if fd.kind, fd.typ, fd.repeated, err = fieldType(f.Type); err != nil {
+				return rec, fmt.Errorf("field %s.%s: %s", name, fd.name, err)
+			}
+			for _, opt := range strings.Split(opts, ",") {
+				switch opt {
+				case "":
+				case "index":
+					if fd.repeated || (fd.kind != kindInt && fd.kind != kindUint && fd.kind != kindFloat &&
+						fd.kind != kindBool && fd.kind != kindString) {
+						return rec, fmt.Errorf("field %s.%s can't be indexed, only numbers, bools and strings", name, fd.name)
+					}
+					fd.index = true
+				default:
+					return rec, fmt.Errorf("field %s.%s has an unknown option %q", name, fd.name, opt)
+				}
+			}
+			rec.fields = append(rec.fields, fd)
+		}
+	}
+	return rec, nil
+}
+
+func embeddedName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	}
+	return ""
+}
+
+var errUnsupported = errors.New("unsupported type, only bools, integers, floats, strings, byte slices, time.Time and slices of those")
+
+// fieldType returns the kind and the Go type of the values of a field.
+func fieldType(expr ast.Expr) (kind, typ string, repeated bool, err error) {
+	if arr, ok := expr.(*ast.ArrayType); ok && arr.Len == nil {
+		if id, ok := arr.Elt.(*ast.Ident); ok && (id.Name == "byte" || id.Name == "uint8") {
+			return kindBytes, "[]" + id.Name, false, nil
+		}
+		kind, typ, repeated, err = fieldType(arr.Elt)
+		if err != nil || repeated {
+			return "", "", false, errUnsupported
+		}
+		return kind, typ, true, nil
+	}
+
+	switch e := expr.(type) {
+	case *ast.Ident:
+		switch e.Name {
+		case "int", "int8", "int16", "int32", "int64":
+			return kindInt, e.Name, false, nil
+		case "uint", "uint8", "byte", "uint16", "uint32", "uint64":
+			return kindUint, e.Name, false, nil
+		case "float32", "float64":
+			return kindFloat, e.Name, false, nil
+		case "bool":
+			return kindBool, e.Name, false, nil
+		case "string":
+			return kindString, e.Name, false, nil
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := e.X.(*ast.Ident); ok && pkg.Name == "time" && e.Sel.Name == "Time" {
+			return kindTime, "time.Time", false, nil
+		}
+	}
+	return "", "", false, errUnsupported
+}
+
+// appendCall returns the call appending the value v of a field of the kind.
+func appendCall(fd field, v string) string {
+	switch fd.kind {
+	case kindInt:
+		return fmt.Sprintf("borm.AppendBinaryInt(dst, %d, int64(%s))", fd.tag, v)
+	case kindUint:
+		return fmt.Sprintf("borm.AppendBinaryUint(dst, %d, uint64(%s))", fd.tag, v)
+	case kindFloat:
+		return fmt.Sprintf("borm.AppendBinaryFloat(dst, %d, float64(%s))", fd.tag, v)
+	case kindBool:
+		return fmt.Sprintf("borm.AppendBinaryBool(dst, %d, %s)", fd.tag, v)
+	case kindString:
+		return fmt.Sprintf("borm.AppendBinaryString(dst, %d, %s)", fd.tag, v)
+	case kindBytes:
+		return fmt.Sprintf("borm.AppendBinaryBytes(dst, %d, %s)", fd.tag, v)
+	default:
+		return fmt.Sprintf("borm.AppendBinaryTime(dst, %d, %s)", fd.tag, v)
+	}
+}
+
+// nonZero returns the condition of a field being written, the zero values
+// are skipped as by the binary codec.
+func nonZero(fd field, v string) string {
+	switch fd.kind {
+	case kindBool:
+		return v
+	case kindString:
+		return v + ` != ""`
+	case kindBytes:
+		return v + " != nil"
+	case kindTime:
+		return "!" + v + ".IsZero()"
+	default:
+		return v + " != 0"
+	}
+}
+
+// decodeCall returns the method of borm.BinaryField decoding the kind and
+// the conversion of its result into the Go type.
+func decodeCall(fd field) (string, string) {
+	switch fd.kind {
+	case kindInt:
+		return "Int", fd.typ + "(v)"
+	case kindUint:
+		return "Uint", fd.typ + "(v)"
+	case kindFloat:
+		return "Float", fd.typ + "(v)"
+	case kindBool:
+		return "Bool", "v"
+	case kindString:
+		return "Text", "v"
+	case kindBytes:
+		return "Bytes", "v"
+	default:
+		return "Time", "v"
+	}
+}
+
+func writeRecord(buf *bytes.Buffer, rec record) {
+	fmt.Fprintf(buf, "\n// MarshalBorm appends the binary encoding of the record to dst.\n")
+	fmt.Fprintf(buf, "func (x *%s) MarshalBorm(dst []byte) ([]byte, error) {\n", rec.name)
+	for _, fd := range rec.fields {
+		if fd.repeated {
+			fmt.Fprintf(buf, "for _, v := range x.%s {\ndst = %s\n}\n", fd.name, appendCall(fd, "v"))
+			continue
+		}
+		v := "x." + fd.name
+		fmt.Fprintf(buf, "if %s {\ndst = %s\n}\n", nonZero(fd, v), appendCall(fd, v))
+	}
+	fmt.Fprintf(buf, "return dst, nil\n}\n")
+
+	fmt.Fprintf(buf, "\n// UnmarshalBorm decodes the binary encoding of the record, the fields of\n")
+	fmt.Fprintf(buf, "// unknown tags are skipped.\n")
+	fmt.Fprintf(buf, "func (x *%s) UnmarshalBorm(data []byte) error {\n", rec.name)
+	if len(rec.fields) == 0 {
+		fmt.Fprintf(buf, "return borm.ScanBinary(data, func(f borm.BinaryField) error {\nreturn nil\n})\n}\n")
+	} else {
+		fmt.Fprintf(buf, "return borm.ScanBinary(data, func(f borm.BinaryField) error {\nswitch f.Tag {\n")
+		for _, fd := range rec.fields {
+			method, conv := decodeCall(fd)
+			fmt.Fprintf(buf, "case %d:\nv, err := f.%s()\nif err != nil {\nreturn err\n}\n", fd.tag, method)
+			if fd.repeated {
+				fmt.Fprintf(buf, "x.%s = append(x.%s, %s)\n", fd.name, fd.name, conv)
+			} else {
+				fmt.Fprintf(buf, "x.%s = %s\n", fd.name, conv)
+			}
+		}
+		fmt.Fprintf(buf, "}\nreturn nil\n})\n}\n")
+	}
+
+	var indexed []field
+	for _, fd := range rec.fields {
+		if fd.index {
+			indexed = append(indexed, fd)
+		}
+	}
+	if len(indexed) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "\n// %sColumns returns the extractors of the indexed fields of %s, reading\n", rec.name, rec.name)
+	fmt.Fprintf(buf, "// the binary records without decoding them.\n")
+	fmt.Fprintf(buf, "func %sColumns() []borm.Column {\nreturn []borm.Column{\n", rec.name)
+	for _, fd := range indexed {
+		method, _ := decodeCall(fd)
+		if fd.kind == kindString {
+			fmt.Fprintf(buf, "{Name: %q, Kind: borm.StringColumn, String: func(key, value []byte) (string, bool) {\n", fd.name)
+			fmt.Fprintf(buf, "f, found, err := borm.FindBinaryField(value, %d)\nif err != nil || !found {\nreturn \"\", err == nil\n}\n", fd.tag)
+			fmt.Fprintf(buf, "v, err := f.Text()\nreturn v, err == nil\n}},\n")
+			continue
+		}
+		fmt.Fprintf(buf, "{Name: %q, Kind: borm.NumberColumn, Number: func(key, value []byte) (float64, bool) {\n", fd.name)
+		fmt.Fprintf(buf, "f, found, err := borm.FindBinaryField(value, %d)\nif err != nil || !found {\nreturn 0, err == nil\n}\n", fd.tag)
+		if fd.kind == kindBool {
+			fmt.Fprintf(buf, "v, err := f.Bool()\nif v {\nreturn 1, err == nil\n}\nreturn 0, err == nil\n}},\n")
+		} else {
+			fmt.Fprintf(buf, "v, err := f.%s()\nreturn float64(v), err == nil\n}},\n", method)
+		}
+	}
+	fmt.Fprintf(buf, "}\n}\n")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGenerateGolden(t *testing.T) {
+	src, err := ioutil.ReadFile("../../generated_test.go")
+	if err != nil {
+		t.Fatalf("Error reading source: %s", err)
+	}
+	golden, err := ioutil.ReadFile("../../generated_borm_test.go")
+	if err != nil {
+		t.Fatalf("Error reading generated file: %s", err)
+	}
+	out, err := Generate("generated_test.go", src)
+	if err != nil {
+		t.Fatalf("Error generating: %s", err)
+	}
+	if string(out) != string(golden) {
+		t.Fatalf("Generated code differs from generated_borm_test.go, run go generate:\n%s", out)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	cases := map[string]string{
+		"embedded":    "type T struct {\n\tBase\n}",
+		"unsupported": "type T struct {\n\tM map[string]int\n}",
+		"named":       "type T struct {\n\tL Level\n}",
+		"index":       "type T struct {\n\tC time.Time `borm:\",index\"`\n}",
+		"duplicate":   "type T struct {\n\tA int `borm:\"2\"`\n\tB int\n}",
+		"option":      "type T struct {\n\tA int `borm:\"1,unique\"`\n}",
+	}
+	for name, decl := range cases {
+		src := "package p\n\n//borm:generate\n" + decl + "\n"
+		if _, err := Generate(name+".go", []byte(src)); err == nil {
+			t.Fatalf("Generating %s succeeded.", name)
+		}
+	}
+
+	if _, err := Generate("none.go", []byte("package p\n\ntype T struct{}\n")); err == nil || !strings.Contains(err.Error(), "no struct") {
+		t.Fatalf("Generating without marked structs got %v.", err)
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	if name := outputName("event.go"); name != "event_borm.go" {
+		t.Fatalf("Output of event.go is %s.", name)
+	}
+	if name := outputName("event_test.go"); name != "event_borm_test.go" {
+		t.Fatalf("Output of event_test.go is %s.", name)
+	}
+}
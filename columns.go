@@ -0,0 +1,509 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ColumnKind is the type of the values of a column.
+type ColumnKind int
+
+const (
+	NumberColumn ColumnKind = iota
+	StringColumn
+)
+
+// Column is a field of the records extracted into the columnar sidecar of
+// the sealed shards, see TSOptions.Columns.
+type Column struct {
+	Name string
+	Kind ColumnKind
+
+	// Number extracts the value of a NumberColumn, String the one of a
+	// StringColumn. False stores a null.
+	Number func(key, value []byte) (float64, bool)
+	String func(key, value []byte) (string, bool)
+}
+
+// columnsSuffix is appended to the shard file name to get its sidecar.
+const columnsSuffix = ".cols"
+
+// columnsMagic starts and ends the sidecar files.
+var columnsMagic = []byte("BORMCOL1")
+
+var errInvalidColumns = errors.New("invalid columns file")
+
+// ColumnBatch holds the columns of the records of a shard, row i of every
+// column is the record of Times[i]. Nulls are missing from a column of
+// Nulls when the column has none.
+type ColumnBatch struct {
+	Shard   string
+	Times   []time.Time
+	Numbers map[string][]float64
+	Strings map[string][]string
+	Nulls   map[string][]bool
+}
+
+// Len returns the number of rows.
+func (b *ColumnBatch) Len() int {
+	return len(b.Times)
+}
+
+func newColumnBatch(shard string) *ColumnBatch {
+	return &ColumnBatch{
+		Shard:   shard,
+		Numbers: map[string][]float64{},
+		Strings: map[string][]string{},
+		Nulls:   map[string][]bool{},
+	}
+}
+
+// add appends a row extracted from a record.
+func (b *ColumnBatch) add(cols []Column, key, value []byte) {
+	row := len(b.Times)
+	t := TimeFromID(string(key))
+	b.Times = append(b.Times, t)
+	for _, col := range cols {
+		var ok bool
+		switch col.Kind {
+		case NumberColumn:
+			var v float64
+			if col.Number != nil {
+				v, ok = col.Number(key, value)
+			}
+			b.Numbers[col.Name] = append(b.Numbers[col.Name], v)
+		case StringColumn:
+			var v string
+			if col.String != nil {
+				v, ok = col.String(key, value)
+			}
+			b.Strings[col.Name] = append(b.Strings[col.Name], v)
+		}
+		if !ok {
+			nulls := b.Nulls[col.Name]
+			if nulls == nil {
+				nulls = make([]bool, row, row+1)
+			}
+			b.Nulls[col.Name] = append(nulls, true)
+		} else if nulls, has := b.Nulls[col.Name]; has {
+			b.Nulls[col.Name] = append(nulls, false)
+		}
+	}
+}
+
+// filter keeps the rows in [start, end).
+func (b *ColumnBatch) filter(start, end time.Time) {
+	keep := make([]int, 0, len(b.Times))
+	for i, t := range b.Times {
+		if !t.Before(start) && t.Before(end) {
+			keep = append(keep, i)
+		}
+	}
+	if len(keep) == len(b.Times) {
+		return
+	}
+
+	times := make([]time.Time, len(keep))
+	for i, row := range keep {
+		times[i] = b.Times[row]
+	}
+	b.Times = times
+	for name, values := range b.Numbers {
+		kept := make([]float64, len(keep))
+		for i, row := range keep {
+			kept[i] = values[row]
+		}
+		b.Numbers[name] = kept
+	}
+	for name, values := range b.Strings {
+		kept := make([]string, len(keep))
+		for i, row := range keep {
+			kept[i] = values[row]
+		}
+		b.Strings[name] = kept
+	}
+	for name, values := range b.Nulls {
+		kept := make([]bool, len(keep))
+		for i, row := range keep {
+			kept[i] = values[row]
+		}
+		b.Nulls[name] = kept
+	}
+}
+
+// columnsIndex is the footer of a sidecar file.
+type columnsIndex struct {
+	Rows    int
+	Columns []columnSection
+}
+
+type columnSection struct {
+	Name   string
+	Kind   ColumnKind
+	Offset int64
+	Length int64
+}
+
+// timeColumn is the section of the record times.
+const timeColumn = "_time"
+
+// writeColumns writes the sidecar of the shard of the bucket.
+func (db *TSEngine) writeColumns(tx *bolt.Tx, bkt *Bucket) error {
+	records := tx.Bucket(bkt.name)
+	if records == nil {
+		return ErrBucketNotFound
+	}
+	batch := newColumnBatch(bkt.store.db.Path())
+	err := records.ForEach(func(k, v []byte) error {
+		v, err := bkt.plain(v)
+		if err != nil {
+			return bkt.checksumError(k, err)
+		}
+		batch.add(db.options.Columns, k, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(columnsMagic)
+	index := columnsIndex{Rows: batch.Len()}
+	section := func(name string, kind ColumnKind, data []byte) {
+		index.Columns = append(index.Columns, columnSection{name, kind, int64(buf.Len()), int64(len(data))})
+		buf.Write(data)
+	}
+
+	var data []byte
+	var last int64
+	for _, t := range batch.Times {
+		ns := t.UnixNano()
+		data = binary.AppendVarint(data, ns-last)
+		last = ns
+	}
+	section(timeColumn, NumberColumn, data)
+
+	for _, col := range db.options.Columns {
+		data = appendNulls(nil, batch.Nulls[col.Name], batch.Len())
+		switch col.Kind {
+		case NumberColumn:
+			for _, v := range batch.Numbers[col.Name] {
+				data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+			}
+		case StringColumn:
+			data = appendDictionary(data, batch.Strings[col.Name])
+		}
+		section(col.Name, col.Kind, data)
+	}
+
+	footer, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	buf.Write(footer)
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(footer)))
+	buf.Write(size[:])
+	buf.Write(columnsMagic)
+
+	path := batch.Shard + columnsSuffix
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// appendNulls appends the null bitmap of a column, one bit per row.
+func appendNulls(data []byte, nulls []bool, rows int) []byte {
+	bitmap := make([]byte, (rows+7)/8)
+	for i, null := range nulls {
+		if null {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return append(data, bitmap...)
+}
+
+// appendDictionary appends the distinct values of a string column and the
+// index of the value of every row.
+func appendDictionary(data []byte, values []string) []byte {
+	ids := map[string]uint64{}
+	var dict []string
+	for _, v := range values {
+		if _, ok := ids[v]; !ok {
+			ids[v] = uint64(len(dict))
+			dict = append(dict, v)
+		}
+	}
+	data = binary.AppendUvarint(data, uint64(len(dict)))
+	for _, v := range dict {
+		data = binary.AppendUvarint(data, uint64(len(v)))
+		data = append(data, v...)
+	}
+	for _, v := range values {
+		data = binary.AppendUvarint(data, ids[v])
+	}
+	return data
+}
+
+// readColumns reads the named columns of the sidecar of a shard, only their
+// sections of the file are read.
+func readColumns(shard string, names []string) (*ColumnBatch, error) {
+	f, err := os.Open(shard + columnsSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tail := make([]byte, 16)
+	if fi.Size() < int64(2*len(columnsMagic)+8) {
+		return nil, errInvalidColumns
+	}
+	if _, err := f.ReadAt(tail, fi.Size()-16); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tail[8:], columnsMagic) {
+		return nil, errInvalidColumns
+	}
+	footerLen := int64(binary.LittleEndian.Uint64(tail))
+	if footerLen > fi.Size()-16 {
+		return nil, errInvalidColumns
+	}
+	footer := make([]byte, footerLen)
+	if _, err := f.ReadAt(footer, fi.Size()-16-footerLen); err != nil {
+		return nil, err
+	}
+	var index columnsIndex
+	if err := json.Unmarshal(footer, &index); err != nil {
+		return nil, errInvalidColumns
+	}
+
+	sections := map[string]columnSection{}
+	for _, s := range index.Columns {
+		sections[s.Name] = s
+	}
+	read := func(s columnSection) ([]byte, error) {
+		data := make([]byte, s.Length)
+		_, err := f.ReadAt(data, s.Offset)
+		if err == io.EOF {
+			err = errInvalidColumns
+		}
+		return data, err
+	}
+
+	batch := newColumnBatch(shard)
+	data, err := read(sections[timeColumn])
+	if err != nil {
+		return nil, err
+	}
+	batch.Times = make([]time.Time, 0, index.Rows)
+	var ns int64
+	for i := 0; i < index.Rows; i++ {
+		delta, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, errInvalidColumns
+		}
+		data = data[n:]
+		ns += delta
+		batch.Times = append(batch.Times, time.Unix(0, ns))
+	}
+
+	for _, name := range names {
+		s, ok := sections[name]
+		if !ok {
+			return nil, errors.New("shard " + shard + " has no column " + name)
+		}
+		data, err := read(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := batch.decodeSection(s, index.Rows, data); err != nil {
+			return nil, err
+		}
+	}
+	return batch, nil
+}
+
+func (b *ColumnBatch) decodeSection(s columnSection, rows int, data []byte) error {
+	bitmapLen := (rows + 7) / 8
+	if len(data) < bitmapLen {
+		return errInvalidColumns
+	}
+	var nulls []bool
+	for i := 0; i < rows; i++ {
+		if data[i/8]&(1<<uint(i%8)) != 0 {
+			if nulls == nil {
+				nulls = make([]bool, rows)
+			}
+			nulls[i] = true
+		}
+	}
+	if nulls != nil {
+		b.Nulls[s.Name] = nulls
+	}
+	data = data[bitmapLen:]
+
+	switch s.Kind {
+	case NumberColumn:
+		if len(data) != 8*rows {
+			return errInvalidColumns
+		}
+		values := make([]float64, rows)
+		for i := range values {
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		}
+		b.Numbers[s.Name] = values
+	case StringColumn:
+		count, n := binary.Uvarint(data)
+		if n <= 0 || count > uint64(len(data)) {
+			return errInvalidColumns
+		}
+		data = data[n:]
+		dict := make([]string, 0, count)
+		for i := uint64(0); i < count; i++ {
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errInvalidColumns
+			}
+			dict = append(dict, string(data[n:n+int(size)]))
+			data = data[n+int(size):]
+		}
+		values := make([]string, rows)
+		for i := range values {
+			id, n := binary.Uvarint(data)
+			if n <= 0 || id >= uint64(len(dict)) {
+				return errInvalidColumns
+			}
+			data = data[n:]
+			values[i] = dict[id]
+		}
+		b.Strings[s.Name] = values
+	}
+	return nil
+}
+
+// ScanColumns calls cb with the named columns of the records in [start,
+// end), one batch per shard. Sealed shards are read from their columnar
+// sidecar, which holds only the columns, the others are extracted from the
+// records.
+func (db *TSEngine) ScanColumns(start, end time.Time, names []string, cb func(batch *ColumnBatch) error) error {
+	var cols []Column
+	for _, name := range names {
+		col, ok := db.column(name)
+		if !ok {
+			return errors.New("column " + name + " is not configured")
+		}
+		cols = append(cols, col)
+	}
+
+	return filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		batch, err := readColumns(fileName, names)
+		if os.IsNotExist(err) {
+			batch, _, err = db.extractColumns(fileName, keyRanges(position, start, end), cols)
+		}
+		if err != nil {
+			return err
+		}
+		if position != positionMiddle {
+			batch.filter(start, end)
+		}
+		return cb(batch)
+	})
+}
+
+// extractColumns extracts the columns of the records in the key spans of a
+// shard, with the keys of the records.
+func (db *TSEngine) extractColumns(fileName string, spans []keySpan, cols []Column) (*ColumnBatch, []string, error) {
+	batch := newColumnBatch(fileName)
+	var keys []string
+	err := db.read(fileName, func(bkt *Bucket) error {
+		return bkt.getRanges(spans, func(it *Iterator) error {
+			for it.Next() {
+				keys = append(keys, string(it.Key()))
+				batch.add(cols, it.Key(), it.Value())
+			}
+			return nil
+		})
+	})
+	return batch, keys, err
+}
+
+func (db *TSEngine) column(name string) (Column, bool) {
+	for _, col := range db.options.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// AggregateColumns counts the records in [start, end) by the value of the
+// groupBy string column, summing the sum number column if not empty, from
+// the columnar sidecars. Nulls are skipped. topN limits the result to the N
+// groups of highest count, 0 returns all groups.
+func (db *TSEngine) AggregateColumns(start, end time.Time, groupBy, sum string, topN int) ([]Group, error) {
+	names := []string{groupBy}
+	if sum != "" {
+		names = append(names, sum)
+	}
+
+	groups := map[string]*Group{}
+	err := db.ScanColumns(start, end, names, func(batch *ColumnBatch) error {
+		keys := batch.Strings[groupBy]
+		if keys == nil {
+			return errors.New("column " + groupBy + " is not a string column")
+		}
+		for i, key := range keys {
+			if nulls := batch.Nulls[groupBy]; nulls != nil && nulls[i] {
+				continue
+			}
+			g := groups[key]
+			if g == nil {
+				g = &Group{Key: key}
+				groups[key] = g
+			}
+			g.Count++
+			if sum != "" {
+				if nulls := batch.Nulls[sum]; nulls == nil || !nulls[i] {
+					g.Sum += batch.Numbers[sum][i]
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		results = append(results, *g)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Count != results[j].Count {
+			return results[i].Count > results[j].Count
+		}
+		return results[i].Key < results[j].Key
+	})
+	if topN > 0 && len(results) > topN {
+		results = results[:topN]
+	}
+	return results, nil
+}
@@ -0,0 +1,110 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestColumns(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+		Columns: []borm.Column{
+			{Name: "category", Kind: borm.StringColumn, String: func(key, value []byte) (string, bool) {
+				var item ItemTest
+				if json.Unmarshal(value, &item) != nil || item.Category == "" {
+					return "", false
+				}
+				return item.Category, true
+			}},
+			{Name: "id", Kind: borm.NumberColumn, Number: func(key, value []byte) (float64, bool) {
+				var item ItemTest
+				if json.Unmarshal(value, &item) != nil {
+					return 0, false
+				}
+				return float64(item.ID), true
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	sealed := startOfDay(time.Now().AddDate(0, 0, -3)).Add(time.Hour)
+	open := sealed.AddDate(0, 0, 1)
+	counts := map[string]int64{}
+	sums := map[string]float64{}
+	var entries []borm.Entry
+	for i := 0; i < 200; i++ {
+		day := sealed
+		if i%2 == 1 {
+			day = open
+		}
+		item := testData[i%len(testData)]
+		item.ID = i
+		ts := day.Add(time.Duration(i) * time.Second)
+		entries = append(entries, borm.Entry{Time: ts, Value: &item})
+		counts[item.Category]++
+		sums[item.Category] += float64(i)
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if err := db.Seal(sealed); err != nil {
+		t.Fatalf("Error sealing shard: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, borm.Daily.Name(sealed)) + ".cols"); err != nil {
+		t.Fatalf("Error finding the columns of the sealed shard: %s", err)
+	}
+	shards, err := borm.ListShards(dir, time.Local)
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 2 {
+		t.Fatalf("Expected 2 shards, found %d.", len(shards))
+	}
+
+	groups, err := db.AggregateColumns(sealed.Add(-time.Hour), open.Add(time.Hour), "category", "id", 0)
+	if err != nil {
+		t.Fatalf("Error aggregating columns: %s", err)
+	}
+	if len(groups) != len(counts) {
+		t.Fatalf("Expected %d groups, found %d.", len(counts), len(groups))
+	}
+	for _, g := range groups {
+		if g.Count != counts[g.Key] || g.Sum != sums[g.Key] {
+			t.Fatalf("Expected group %s %d/%v, found %d/%v.", g.Key, counts[g.Key], sums[g.Key], g.Count, g.Sum)
+		}
+	}
+
+	// edge shards are filtered by time
+	rows := 0
+	err = db.ScanColumns(sealed.Add(10*time.Second), sealed.Add(20*time.Second), []string{"id"}, func(batch *borm.ColumnBatch) error {
+		for i, id := range batch.Numbers["id"] {
+			if id < 10 || id >= 20 || batch.Times[i].Before(sealed.Add(10*time.Second)) {
+				t.Fatalf("Unexpected row %v at %s.", id, batch.Times[i])
+			}
+		}
+		rows += batch.Len()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error scanning columns: %s", err)
+	}
+	if rows != 5 {
+		t.Fatalf("Expected 5 rows, found %d.", rows)
+	}
+
+	if err := db.ScanColumns(sealed, open, []string{"missing"}, func(*borm.ColumnBatch) error { return nil }); err == nil {
+		t.Fatalf("Expected an error scanning an unknown column.")
+	}
+}
@@ -0,0 +1,470 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of an engine and of the components serving
+// it, read by ReadConfig from a YAML, TOML or JSON file, so the commands and
+// servers don't need flags for every option. The keys are the field names
+// in snake case, e.g. shard_interval, the sections are the struct fields:
+//
+//	path: /var/lib/attacks
+//	shard_interval: hourly
+//	codec: json
+//	retention:
+//	  max_age: 30d
+//	  protect_tags: [legal-hold]
+//	listen:
+//	  http: ":8080"
+//
+// Unknown keys are rejected, to catch the typos.
+type Config struct {
+	// Path is the engine directory, relative to the directory of the file.
+	Path string
+
+	// ShardInterval is "daily", the default, or "hourly". ShardPattern and
+	// ShardExtension are the ones of TSOptions.
+	ShardInterval  string
+	ShardPattern   string
+	ShardExtension string
+
+	// Codec is "gob", the default, "json", "binary" or "generated".
+	Codec string
+
+	// Compression is TSOptions.DictCompression.
+	Compression bool
+	Checksums   bool
+
+	// Sync is "always", the default, or "hot-only".
+	Sync string
+
+	MaxOpenWriters int
+	ChangeLog      bool
+	NodeID         string
+	Actor          string
+
+	Retention RetentionConfig
+	Listen    ListenConfig
+
+	// SlowOps are the thresholds of the slow operations logged.
+	SlowOps SlowOpsConfig
+
+	// TLS, when set, is the TLS of the servers.
+	TLS *TLSConfig
+}
+
+// RetentionConfig is the retention of a Config, see RetentionPolicy.
+type RetentionConfig struct {
+	MaxAge      Duration
+	MaxSize     int64
+	KeepAtLeast int
+	ProtectTags []string
+
+	// Interval is how often the server applies the retention, 0 never.
+	Interval Duration
+}
+
+// Policy returns the retention policy.
+func (c RetentionConfig) Policy() RetentionPolicy {
+	return RetentionPolicy{
+		MaxAge:      time.Duration(c.MaxAge),
+		MaxSize:     c.MaxSize,
+		KeepAtLeast: c.KeepAtLeast,
+		ProtectTags: c.ProtectTags,
+	}
+}
+
+// SlowOpsConfig are the slow operation thresholds of a Config, see
+// SlowOpThresholds.
+type SlowOpsConfig struct {
+	Commit Duration
+	Open   Duration
+	Query  Duration
+}
+
+// Thresholds returns the slow operation thresholds.
+func (c SlowOpsConfig) Thresholds() SlowOpThresholds {
+	return SlowOpThresholds{
+		Commit: time.Duration(c.Commit),
+		Open:   time.Duration(c.Open),
+		Query:  time.Duration(c.Query),
+	}
+}
+
+// ListenConfig are the addresses the servers listen on, "" disables one.
+type ListenConfig struct {
+	HTTP    string
+	Syslog  string
+	Replica string
+}
+
+// Duration is a duration of a Config, written as by time.ParseDuration or
+// with a days suffix, e.g. "30d".
+type Duration time.Duration
+
+// ParseDuration parses a duration of a Config.
+func ParseDuration(s string) (Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	return Duration(d), err
+}
+
+// UnmarshalJSON reads the duration from a string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration %s isn't a string", data)
+	}
+	v, err := ParseDuration(s)
+	if err == nil {
+		*d = v
+	}
+	return err
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ReadConfig reads the configuration file, its format is the one of its
+// extension: .yaml or .yml, .toml or .json. Only the subsets of YAML and
+// TOML needed by a Config are supported: scalars, lists and one level of
+// sections.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	case ".toml":
+		values, err = parseTOML(data)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("config %s isn't a .yaml, .toml or .json file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+
+	// the keys are matched with the field names without the underscores,
+	// encoding/json ignores the case
+	normalized, err := json.Marshal(normalizeKeys(values))
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(normalized))
+	dec.DisallowUnknownFields()
+	config := &Config{}
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+
+	if config.Path == "" {
+		return nil, fmt.Errorf("config %s has no path", path)
+	}
+	if !filepath.IsAbs(config.Path) {
+		config.Path = filepath.Join(filepath.Dir(path), config.Path)
+	}
+	return config, nil
+}
+
+func normalizeKeys(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, value := range m {
+		k = strings.NewReplacer("_", "", "-", "").Replace(k)
+		out[k] = normalizeKeys(value)
+	}
+	return out
+}
+
+// TSOptions returns the options of the engine.
+func (c *Config) TSOptions() (*TSOptions, error) {
+	options := &TSOptions{
+		NamePattern:     c.ShardPattern,
+		ShardExtension:  c.ShardExtension,
+		DictCompression: c.Compression,
+		Checksums:       c.Checksums,
+		MaxOpenWriters:  c.MaxOpenWriters,
+		ChangeLog:       c.ChangeLog,
+		NodeID:          c.NodeID,
+		Actor:           c.Actor,
+		SlowOps:         c.SlowOps.Thresholds(),
+	}
+
+	switch c.ShardInterval {
+	case "", Daily.String():
+		options.ShardInterval = Daily
+	case Hourly.String():
+		options.ShardInterval = Hourly
+	default:
+		return nil, fmt.Errorf("unknown shard interval %q", c.ShardInterval)
+	}
+
+	codecs := map[string]Codec{
+		"":          CodecGob,
+		"gob":       CodecGob,
+		"json":      CodecJSON,
+		"binary":    CodecBinary,
+		"generated": CodecGenerated,
+	}
+	codec, ok := codecs[c.Codec]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", c.Codec)
+	}
+	options.Encoder, options.Decoder = codec.Encode, codec.Decode
+
+	switch c.Sync {
+	case "", "always":
+		options.SyncPolicy = SyncAlways
+	case "hot-only":
+		options.SyncPolicy = SyncHotOnly
+	default:
+		return nil, fmt.Errorf("unknown sync policy %q", c.Sync)
+	}
+	return options, nil
+}
+
+// Open opens the engine of the configuration.
+func (c *Config) Open() (*TSEngine, error) {
+	options, err := c.TSOptions()
+	if err != nil {
+		return nil, err
+	}
+	return OpenTSWithOptions(c.Path, options)
+}
+
+// LoadConfig reads the configuration file and opens its engine, the
+// configuration is returned for the servers, e.g. their addresses.
+func LoadConfig(path string) (*TSEngine, *Config, error) {
+	config, err := ReadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := config.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, config, nil
+}
+
+// parseYAML parses the YAML subset of a Config: "key: value" lines,
+// sections of indented keys, inline "[a, b]" and block "- a" lists.
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	var section map[string]interface{}
+	var sectionIndent int
+	var listKey string
+	var list *[]interface{}
+	listParent := root
+
+	for n, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripComment(line), " \t\r")
+		if strings.TrimSpace(text) == "" || text == "---" {
+			continue
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+		if strings.HasPrefix(strings.TrimLeft(text, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't indent YAML", n+1)
+		}
+		text = strings.TrimSpace(text)
+
+		if strings.HasPrefix(text, "- ") || text == "-" {
+			if list == nil {
+				return nil, fmt.Errorf("line %d: list item without a key", n+1)
+			}
+			v, err := parseScalar(strings.TrimSpace(strings.TrimPrefix(text, "-")), true)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n+1, err)
+			}
+			*list = append(*list, v)
+			listParent[listKey] = *list
+			continue
+		}
+		list = nil
+
+		i := strings.Index(text, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected key: value", n+1)
+		}
+		key, value := strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+
+		parent := root
+		if indent == 0 {
+			section = nil
+		} else {
+			if section == nil || (sectionIndent != 0 && indent != sectionIndent) {
+				return nil, fmt.Errorf("line %d: unexpected indentation", n+1)
+			}
+			sectionIndent = indent
+			parent = section
+		}
+
+		if value == "" {
+			// a section, or a block list
+			items := []interface{}{}
+			list, listKey, listParent = &items, key, parent
+			if indent == 0 {
+				section = map[string]interface{}{}
+				sectionIndent = 0
+				root[key] = section
+			}
+			continue
+		}
+		v, err := parseScalar(value, true)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+		parent[key] = v
+	}
+	return root, nil
+}
+
+// parseTOML parses the TOML subset of a Config: "key = value" lines, the
+// [section] tables and inline arrays.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	current := root
+	for n, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimSpace(stripComment(line))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			name := strings.TrimSpace(text[1 : len(text)-1])
+			if name == "" || strings.ContainsAny(name, ".[]") {
+				return nil, fmt.Errorf("line %d: unsupported table %s", n+1, text)
+			}
+			if _, dup := root[name]; dup {
+				return nil, fmt.Errorf("line %d: table %s is defined twice", n+1, name)
+			}
+			current = map[string]interface{}{}
+			root[name] = current
+			continue
+		}
+
+		i := strings.Index(text, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n+1)
+		}
+		key, value := strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+		v, err := parseScalar(value, false)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+		current[key] = v
+	}
+	return root, nil
+}
+
+// stripComment removes the # comment of a line, outside of the quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseScalar parses a value: a quoted string, a bool, a number or an
+// inline list, a bare string for YAML only.
+func parseScalar(s string, bare bool) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated list %s", s)
+		}
+		items := []interface{}{}
+		for _, item := range splitList(s[1 : len(s)-1]) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := parseScalar(item, bare)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	}
+	if i, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	if !bare {
+		return nil, errors.New("invalid value " + s + ", strings must be quoted")
+	}
+	return s, nil
+}
+
+// splitList splits the items of an inline list at the commas outside of the
+// quotes.
+func splitList(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
@@ -0,0 +1,163 @@
+package borm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+const yamlConfig = `# attacks of the edge sensors
+path: data
+shard_interval: hourly
+codec: json
+checksums: true
+sync: hot-only
+retention:
+  max_age: 30d
+  keep_at_least: 2
+  protect_tags: [legal-hold, "incident #4"]
+  interval: 1h
+listen:
+  http: ":8080"
+  syslog: 0.0.0.0:514
+slow_ops:
+  commit: 100ms
+tls:
+  cert_file: server.pem
+  key_file: server.key
+`
+
+const tomlConfig = `# attacks of the edge sensors
+path = "data"
+shard_interval = "hourly"
+codec = "json"
+checksums = true
+sync = "hot-only"
+
+[retention]
+max_age = "30d"
+keep_at_least = 2
+protect_tags = ["legal-hold", "incident #4"]
+interval = "1h"
+
+[listen]
+http = ":8080"
+syslog = "0.0.0.0:514"
+
+[slow_ops]
+commit = "100ms"
+
+[tls]
+cert_file = "server.pem"
+key_file = "server.key"
+`
+
+const jsonConfig = `{
+	"path": "data",
+	"shard_interval": "hourly",
+	"codec": "json",
+	"checksums": true,
+	"sync": "hot-only",
+	"retention": {"max_age": "30d", "keep_at_least": 2, "protect_tags": ["legal-hold", "incident #4"], "interval": "1h"},
+	"listen": {"http": ":8080", "syslog": "0.0.0.0:514"},
+	"slow_ops": {"commit": "100ms"},
+	"tls": {"cert_file": "server.pem", "key_file": "server.key"}
+}`
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Error writing %s: %s", file, err)
+	}
+	return file
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", dir, err)
+	}
+
+	want := &borm.Config{
+		Path:          filepath.Join(dir, "data"),
+		ShardInterval: "hourly",
+		Codec:         "json",
+		Checksums:     true,
+		Sync:          "hot-only",
+		Retention: borm.RetentionConfig{
+			MaxAge:      borm.Duration(30 * 24 * time.Hour),
+			KeepAtLeast: 2,
+			ProtectTags: []string{"legal-hold", "incident #4"},
+			Interval:    borm.Duration(time.Hour),
+		},
+		Listen:  borm.ListenConfig{HTTP: ":8080", Syslog: "0.0.0.0:514"},
+		SlowOps: borm.SlowOpsConfig{Commit: borm.Duration(100 * time.Millisecond)},
+		TLS:     &borm.TLSConfig{CertFile: "server.pem", KeyFile: "server.key"},
+	}
+	blocks := strings.Replace(yamlConfig, `  protect_tags: [legal-hold, "incident #4"]`, "  protect_tags:\n    - legal-hold\n    - 'incident #4'", 1)
+	for name, content := range map[string]string{"borm.yaml": yamlConfig, "blocks.yml": blocks, "borm.toml": tomlConfig, "borm.json": jsonConfig} {
+		config, err := borm.ReadConfig(writeConfig(t, dir, name, content))
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", name, err)
+		}
+		if !reflect.DeepEqual(config, want) {
+			t.Fatalf("Config of %s is %+v wanted %+v.", name, config, want)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"typo.yaml":     "path: data\nshard_intervall: hourly\n",
+		"bare.toml":     "path = data\n",
+		"duration.yaml": "path: data\nretention:\n  max_age: forever\n",
+		"nopath.toml":   "codec = \"json\"\n",
+		"borm.ini":      "path = data\n",
+	} {
+		if _, err := borm.ReadConfig(writeConfig(t, dir, name, content)); err == nil {
+			t.Fatalf("Reading %s succeeded.", name)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", dir, err)
+	}
+
+	db, config, err := borm.LoadConfig(writeConfig(t, dir, "borm.yaml", yamlConfig))
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	defer db.Close()
+	if config.Listen.HTTP != ":8080" || config.Retention.Policy().MaxAge != 30*24*time.Hour {
+		t.Fatalf("Loaded config is %+v.", config)
+	}
+
+	now := time.Now()
+	tx := db.Begin(now)
+	if _, err := tx.Insert(&ItemTest{Name: "car", Created: now}); err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data", borm.Hourly.Name(now))); err != nil {
+		t.Fatalf("Error statting the hourly shard: %s", err)
+	}
+	rec, err := db.FindFirst(now.Add(-time.Second), now.Add(time.Second), "")
+	if err != nil || !strings.HasPrefix(string(rec.Value), "{") {
+		t.Fatalf("Record got %v, %v wanted JSON.", rec, err)
+	}
+
+	if _, _, err := borm.LoadConfig(writeConfig(t, dir, "codec.toml", "path = \"data\"\ncodec = \"xml\"\n")); err == nil {
+		t.Fatalf("Loading an unknown codec succeeded.")
+	}
+}
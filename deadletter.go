@@ -0,0 +1,144 @@
+package borm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaDeadLetters is the meta bucket of the dead letters, keyed by sequence.
+var metaDeadLetters = []byte("dead_letters")
+
+// DeadLetter is an input preserved raw because it was rejected or couldn't
+// be decoded, e.g. a message the syslog parser doesn't understand or a
+// record of a Backfill rejected by a validator, to be reprocessed once the
+// parser or the validator is fixed. The dead letters are kept in the meta
+// store, whatever their time, until deleted.
+type DeadLetter struct {
+	Seq uint64 `json:"seq"`
+
+	// Time is when the input was dead-lettered.
+	Time time.Time `json:"time"`
+
+	// Origin is where the input comes from, e.g. "syslog" or the name of an
+	// import.
+	Origin string `json:"origin"`
+
+	// Reason is the error the input was rejected with, Rule the rule of a
+	// ValidationError.
+	Reason string `json:"reason"`
+	Rule   string `json:"rule,omitempty"`
+
+	// Key and RecordTime are those of a rejected record, empty for an input
+	// which couldn't be decoded.
+	Key        string    `json:"key,omitempty"`
+	RecordTime time.Time `json:"record_time,omitempty"`
+
+	// Raw is the input, the encoded value of a rejected record.
+	Raw []byte `json:"raw"`
+}
+
+// deadLetterOf returns the dead letter of a record rejected by a validator,
+// false for the other errors.
+func deadLetterOf(origin string, t time.Time, err error) (DeadLetter, bool) {
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		return DeadLetter{}, false
+	}
+	return DeadLetter{
+		Origin:     origin,
+		Reason:     invalid.Error(),
+		Rule:       invalid.Rule,
+		Key:        invalid.Key,
+		RecordTime: t,
+		Raw:        invalid.value,
+	}, true
+}
+
+// AddDeadLetter preserves an input, its Seq and its Time, when zero, are
+// set. It returns the sequence of the dead letter.
+func (db *TSEngine) AddDeadLetter(letter DeadLetter) (uint64, error) {
+	letters := []DeadLetter{letter}
+	if err := db.addDeadLetters(letters); err != nil {
+		return 0, err
+	}
+	return letters[0].Seq, nil
+}
+
+// addDeadLetters preserves the inputs in one transaction, setting their
+// sequences.
+func (db *TSEngine) addDeadLetters(letters []DeadLetter) error {
+	now := db.now()
+	return db.updateMeta(metaDeadLetters, func(bkt *bolt.Bucket) error {
+		for i := range letters {
+			seq, err := bkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			letters[i].Seq = seq
+			if letters[i].Time.IsZero() {
+				letters[i].Time = now
+			}
+			data, err := json.Marshal(&letters[i])
+			if err != nil {
+				return err
+			}
+			var key [8]byte
+			binary.BigEndian.PutUint64(key[:], seq)
+			if err := bkt.Put(key[:], data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeadLetters calls cb with the dead letters of the origin, of all origins
+// if it is empty, oldest first. The dead letters must not be deleted in cb.
+func (db *TSEngine) DeadLetters(origin string, cb func(letter DeadLetter) error) error {
+	return db.deadLettersAfter(origin, 0, cb)
+}
+
+// deadLettersAfter calls cb with the dead letters of the origin, of all
+// origins if it is empty, following the sequence after.
+func (db *TSEngine) deadLettersAfter(origin string, after uint64, cb func(letter DeadLetter) error) error {
+	return db.viewMeta(metaDeadLetters, func(bkt *bolt.Bucket) error {
+		if bkt == nil {
+			return nil
+		}
+		var start [8]byte
+		binary.BigEndian.PutUint64(start[:], after+1)
+		c := bkt.Cursor()
+		for k, v := c.Seek(start[:]); k != nil; k, v = c.Next() {
+			var letter DeadLetter
+			if err := json.Unmarshal(v, &letter); err != nil {
+				return err
+			}
+			if origin != "" && letter.Origin != origin {
+				continue
+			}
+			if err := cb(letter); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteDeadLetters removes the dead letters of the sequences, e.g. once
+// they are reprocessed. Unknown sequences are ignored.
+func (db *TSEngine) DeleteDeadLetters(seqs ...uint64) error {
+	return db.updateMeta(metaDeadLetters, func(bkt *bolt.Bucket) error {
+		for _, seq := range seqs {
+			var key [8]byte
+			binary.BigEndian.PutUint64(key[:], seq)
+			if err := bkt.Delete(key[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,90 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestBackfillDeadLetter(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWith(dir,
+		borm.WithCodec(borm.CodecJSON),
+		borm.WithValidators(borm.RequireFields("Category")))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	entries := []borm.Entry{
+		{Time: yesterday, Value: &ItemTest{Name: "car", Category: "vehicle"}},
+		{Time: yesterday, Value: map[string]string{"Name": "bike"}},
+		{Time: now, Value: &ItemTest{Name: "truck", Category: "vehicle"}},
+		{Time: now, Value: map[string]string{"Name": "boat"}},
+	}
+	if _, err := db.Backfill(entries, nil); !errors.Is(err, borm.ErrInvalidRecord) {
+		t.Fatalf("Backfill got %v wanted %s.", err, borm.ErrInvalidRecord)
+	}
+
+	var skipped int64
+	result, err := db.Backfill(entries, &borm.BackfillOptions{
+		Overwrite:  true,
+		DeadLetter: "import",
+		Progress:   func(p borm.Progress) { skipped = p.Skipped },
+	})
+	if err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	written := 0
+	for _, n := range result {
+		written += n
+	}
+	if written != 2 || skipped != 2 {
+		t.Fatalf("Backfill wrote %v and skipped %d wanted 2 and 2.", result, skipped)
+	}
+
+	var letters []borm.DeadLetter
+	collect := func(letter borm.DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}
+	if err := db.DeadLetters("import", collect); err != nil {
+		t.Fatalf("Error reading dead letters: %s", err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("Dead letters are %+v wanted 2.", letters)
+	}
+	for i, name := range []string{"bike", "boat"} {
+		letter := letters[i]
+		var value map[string]string
+		if err := json.Unmarshal(letter.Raw, &value); err != nil || value["Name"] != name {
+			t.Fatalf("Dead letter %d is %+v wanted %s.", i, letter, name)
+		}
+		if letter.Rule != borm.RuleRequired || letter.Key == "" || !letter.RecordTime.Equal(entries[2*i+1].Time) || letter.Time.IsZero() {
+			t.Fatalf("Dead letter %d is %+v.", i, letter)
+		}
+	}
+
+	seq, err := db.AddDeadLetter(borm.DeadLetter{Origin: "parser", Reason: "unparsable", Raw: []byte("\x01garbage")})
+	if err != nil || seq != 3 {
+		t.Fatalf("Adding a dead letter got %d, %v wanted 3.", seq, err)
+	}
+	if err := db.DeleteDeadLetters(letters[0].Seq, letters[1].Seq); err != nil {
+		t.Fatalf("Error deleting dead letters: %s", err)
+	}
+	letters = nil
+	if err := db.DeadLetters("", collect); err != nil {
+		t.Fatalf("Error reading dead letters: %s", err)
+	}
+	if len(letters) != 1 || letters[0].Origin != "parser" || string(letters[0].Raw) != "\x01garbage" {
+		t.Fatalf("Dead letters are %+v wanted the parser one.", letters)
+	}
+}
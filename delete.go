@@ -21,7 +21,7 @@ func (b *Bucket) Delete(key string) error {
 			return ErrBucketNotFound
 		}
 		// delete data
-		return bkt.Delete([]byte(key))
+		return b.del(tx, bkt, []byte(key))
 	})
 }
 
@@ -52,7 +52,9 @@ func (b *Bucket) DeleteRange(start, end string) error {
 		}
 
 		for it.Next() {
-			bkt.Delete(it.Key())
+			if err := b.del(tx, bkt, it.Key()); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
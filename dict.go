@@ -0,0 +1,249 @@
+package borm
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// dictBucket is the shard bucket of the compression dictionary trained when
+// the shard is sealed.
+var dictBucket = []byte("_dict")
+var dictKey = []byte("dict")
+
+const (
+	// compressedMarker starts the compressed values. No value of the Gob,
+	// JSON or binary codecs starts with it.
+	compressedMarker = 0x00
+
+	// dictSize is the maximum size of a dictionary, the deflate window.
+	dictSize = 32 * 1024
+
+	// dictSamples is the number of values sampled to train a dictionary.
+	dictSamples = 1024
+
+	// compressBatch is the number of values rewritten per transaction.
+	compressBatch = 1000
+)
+
+var errCorruptValue = errors.New("compressed value is corrupted")
+
+var inflaters sync.Pool
+
+// plain returns the value decompressed with the dictionary of the shard, or
+// the value itself when it's not compressed.
+func (b *Bucket) plain(value []byte) ([]byte, error) {
+	return plainWith(value, b.dict)
+}
+
+// plainWith returns the value without its checksum, verified, and
+// decompressed with the dictionary.
+func plainWith(value, dict []byte) ([]byte, error) {
+	value, err := stripChecksum(value)
+	if err != nil || len(value) == 0 || value[0] != compressedMarker {
+		return value, err
+	}
+	return inflate(value, dict)
+}
+
+// decompressValue returns the value decompressed, with its checksum if it
+// has one.
+func decompressValue(value, dict []byte) ([]byte, error) {
+	plain, err := plainWith(value, dict)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > 0 && value[0] == checksumMarker {
+		return addChecksum(plain), nil
+	}
+	return plain, nil
+}
+
+func inflate(value, dict []byte) ([]byte, error) {
+	if dict == nil {
+		return nil, errCorruptValue
+	}
+	src := bytes.NewReader(value[1:])
+	r, _ := inflaters.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReaderDict(src, dict)
+	} else if err := r.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+	defer inflaters.Put(r)
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errCorruptValue
+	}
+	return data, nil
+}
+
+// loadDict returns the compression dictionary of the shard of the bucket,
+// nil if it has none.
+func loadDict(bkt *Bucket) ([]byte, error) {
+	var dict []byte
+	err := bkt.store.db.View(func(tx *bolt.Tx) error {
+		dict = txDict(tx)
+		return nil
+	})
+	return dict, err
+}
+
+func txDict(tx *bolt.Tx) []byte {
+	dictBkt := tx.Bucket(dictBucket)
+	if dictBkt == nil {
+		return nil
+	}
+	if data := dictBkt.Get(dictKey); data != nil {
+		return append([]byte(nil), data...)
+	}
+	return nil
+}
+
+// trainDict builds a dictionary of values sampled in the records, the
+// deflate matches against it are what makes short similar records compress.
+func trainDict(records *bolt.Bucket) ([]byte, error) {
+	samples := make([][]byte, 0, dictSamples)
+	seen := 0
+	err := records.ForEach(func(k, v []byte) error {
+		v, err := stripChecksum(v)
+		if err != nil || len(v) == 0 || v[0] == compressedMarker {
+			return err
+		}
+		seen++
+		idx := len(samples)
+		if idx >= dictSamples {
+			idx = rand.Intn(seen)
+			if idx >= dictSamples {
+				return nil
+			}
+			samples[idx] = append(samples[idx][:0], v...)
+			return nil
+		}
+		samples = append(samples, append([]byte(nil), v...))
+		return nil
+	})
+	if err != nil || len(samples) == 0 {
+		return nil, err
+	}
+
+	dict := make([]byte, 0, dictSize)
+	for _, s := range samples {
+		if len(dict)+len(s) > dictSize {
+			break
+		}
+		dict = append(dict, s...)
+	}
+	return dict, nil
+}
+
+// compressShard compresses the values of the bucket with the dictionary of
+// the shard, which is trained first if the shard has none. Values that
+// don't get smaller are left as they are.
+func compressShard(bkt *Bucket) error {
+	err := bkt.store.db.Update(func(tx *bolt.Tx) error {
+		if txDict(tx) != nil {
+			return nil
+		}
+		records := tx.Bucket(bkt.name)
+		if records == nil {
+			return ErrBucketNotFound
+		}
+		dict, err := trainDict(records)
+		if err != nil || dict == nil {
+			return err
+		}
+		dictBkt, err := tx.CreateBucketIfNotExists(dictBucket)
+		if err != nil {
+			return err
+		}
+		return dictBkt.Put(dictKey, dict)
+	})
+	if err != nil {
+		return err
+	}
+	if bkt.dict, err = loadDict(bkt); err != nil || bkt.dict == nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriterDict(&buf, flate.BestCompression, bkt.dict)
+	if err != nil {
+		return err
+	}
+
+	var after []byte
+	for {
+		done := true
+		err := bkt.store.db.Update(func(tx *bolt.Tx) error {
+			records := tx.Bucket(bkt.name)
+			if records == nil {
+				return ErrBucketNotFound
+			}
+
+			type update struct{ key, value []byte }
+			var updates []update
+			c := records.Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if k != nil && bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil; k, v = c.Next() {
+				if len(updates) == compressBatch {
+					done = false
+					break
+				}
+				after = append(after[:0], k...)
+				if v == nil {
+					continue
+				}
+				checksummed := v[0] == checksumMarker
+				v, err := stripChecksum(v)
+				if err != nil {
+					return bkt.checksumError(k, err)
+				}
+				if len(v) == 0 || v[0] == compressedMarker {
+					continue
+				}
+
+				buf.Reset()
+				buf.WriteByte(compressedMarker)
+				w.Reset(&buf)
+				if _, err := w.Write(v); err != nil {
+					return err
+				}
+				if err := w.Close(); err != nil {
+					return err
+				}
+				if buf.Len() < len(v) {
+					value := append([]byte(nil), buf.Bytes()...)
+					if checksummed {
+						value = addChecksum(value)
+					}
+					updates = append(updates, update{append([]byte(nil), k...), value})
+				}
+			}
+
+			// not through the write hooks, the records don't change
+			for _, u := range updates {
+				if err := records.Put(u.key, u.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package borm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// sketchBucket is the shard bucket of the persisted HyperLogLog sketches,
+// keyed by sketch name.
+var sketchBucket = []byte("_sketches")
+
+// CountDistinct estimates the number of distinct values extract returns for
+// the records in the time range. A nil value returned by extract is not counted.
+func (db *TSEngine) CountDistinct(start, end time.Time, extract func(raw []byte) []byte) (uint64, error) {
+	sketch := NewHyperLogLog()
+	err := db.Query(start, end, func(it *Iterator) error {
+		for it.Next() {
+			if value := extract(it.Value()); value != nil {
+				sketch.Add(value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sketch.Count(), nil
+}
+
+// CountDistinctSketch is CountDistinct with the extractor registered in
+// TSOptions.Sketches under name. The sketch of a whole sealed shard is
+// persisted in the shard the first time it is computed, so cardinality
+// queries over months don't scan the data again.
+func (db *TSEngine) CountDistinctSketch(start, end time.Time, name string) (uint64, error) {
+	extract := db.options.Sketches[name]
+	if extract == nil {
+		return 0, errors.New("sketch " + name + " is not registered")
+	}
+
+	sketch := NewHyperLogLog()
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		return db.read(fileName, func(bkt *Bucket) error {
+			if position == positionMiddle && db.isHistorical(day) {
+				shardSketch, err := shardSketch(bkt, name, extract)
+				if err != nil {
+					return err
+				}
+				sketch.Merge(shardSketch)
+				return nil
+			}
+
+			return bkt.getRanges(keyRanges(position, start, end), func(it *Iterator) error {
+				for it.Next() {
+					if value := extract(it.Value()); value != nil {
+						sketch.Add(value)
+					}
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sketch.Count(), nil
+}
+
+// shardSketch loads the persisted sketch of the whole bucket, computing and
+// persisting it when missing.
+func shardSketch(bkt *Bucket, name string, extract func(raw []byte) []byte) (*HyperLogLog, error) {
+	sketch := NewHyperLogLog()
+
+	var found bool
+	err := bkt.store.db.View(func(tx *bolt.Tx) error {
+		sketches := tx.Bucket(sketchBucket)
+		if sketches == nil {
+			return nil
+		}
+		data := sketches.Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return sketch.UnmarshalBinary(data)
+	})
+	if err != nil || found {
+		return sketch, err
+	}
+
+	return sketch, bkt.store.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bkt.name)
+		if records == nil {
+			return ErrBucketNotFound
+		}
+		err := records.ForEach(func(k, v []byte) error {
+			v, err := bkt.plain(v)
+			if err != nil {
+				return bkt.checksumError(k, err)
+			}
+			if value := extract(v); value != nil {
+				sketch.Add(value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		sketches, err := tx.CreateBucketIfNotExists(sketchBucket)
+		if err != nil {
+			return err
+		}
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return sketches.Put([]byte(name), data)
+	})
+}
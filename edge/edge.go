@@ -0,0 +1,323 @@
+// Package edge merges the engines of a fleet of edge nodes, e.g. sensors
+// each writing their own borm directory, into a central engine. An edge
+// engine has a node ID (TSOptions.NodeID) in the keys of its records and a
+// change log (TSOptions.ChangeLog). Its Pusher sends the changes to the
+// Receiver of the central engine whenever it can reach it:
+//
+//	GET  /push?node=N   the sequence of the node acknowledged
+//	POST /push          {"node": N, "changes": [...]}, answers {"seq": S}
+//
+// Keys never collide across nodes and applying a change again has no
+// effect, so batches interrupted by a lost connection are simply sent
+// again. The receiver remembers the acknowledged sequence of every node
+// with the engine cursors, and skips the changes it already applied.
+package edge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// cursorPrefix prefixes the node IDs in the cursors of the central engine.
+const cursorPrefix = "edge:"
+
+// Push is the body of a push request.
+type Push struct {
+	Node    string        `json:"node"`
+	Changes []borm.Change `json:"changes"`
+}
+
+// Ack is the answer of a push request.
+type Ack struct {
+	// Seq is the sequence of the last change of the node applied.
+	Seq uint64 `json:"seq"`
+}
+
+// Receiver applies the changes pushed by the edge nodes to the central
+// engine.
+type Receiver struct {
+	db *borm.TSEngine
+
+	// mu serializes the pushes, a node retrying a batch it believes lost
+	// must not apply it concurrently with the first attempt.
+	mu sync.Mutex
+}
+
+// NewReceiver returns the receiver of the central engine.
+func NewReceiver(db *borm.TSEngine) *Receiver {
+	return &Receiver{db: db}
+}
+
+func (h *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimSuffix(r.URL.Path, "/") != "/push" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var node string
+	var changes []borm.Change
+	switch r.Method {
+	case http.MethodGet:
+		node = r.URL.Query().Get("node")
+	case http.MethodPost:
+		var push Push
+		if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+			http.Error(w, "invalid push: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		node, changes = push.Node, push.Changes
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if node == "" {
+		http.Error(w, "missing node", http.StatusBadRequest)
+		return
+	}
+
+	seq, err := h.apply(node, changes)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*KeyError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&Ack{Seq: seq})
+}
+
+// KeyError is a pushed change whose key doesn't belong to the node.
+type KeyError struct {
+	Node string
+	Key  string
+}
+
+func (e *KeyError) Error() string {
+	return "key " + e.Key + " is not of node " + e.Node
+}
+
+// apply applies the changes of the node after its acknowledged sequence and
+// returns the new one.
+func (h *Receiver) apply(node string, changes []borm.Change) (uint64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seq, err := h.db.Cursor(cursorPrefix + node)
+	if err != nil {
+		return 0, err
+	}
+	var fresh []borm.Change
+	for _, change := range changes {
+		if change.Seq <= seq {
+			continue
+		}
+		if borm.NodeFromID(change.Key) != node {
+			return seq, &KeyError{Node: node, Key: change.Key}
+		}
+		fresh = append(fresh, change)
+	}
+	if len(fresh) == 0 {
+		return seq, nil
+	}
+
+	if err := h.db.ApplyChanges(fresh); err != nil {
+		return seq, err
+	}
+	last := fresh[len(fresh)-1].Seq
+	if err := h.db.SetCursor(cursorPrefix+node, last); err != nil {
+		return seq, err
+	}
+	return last, nil
+}
+
+// NodeSeq returns the sequence of the last change of the node applied to
+// the central engine.
+func (h *Receiver) NodeSeq(node string) (uint64, error) {
+	return h.db.Cursor(cursorPrefix + node)
+}
+
+// Options configures a Pusher.
+type Options struct {
+	// Client defaults to http.DefaultClient, or a client with TLS if TLS is
+	// set.
+	Client *http.Client
+	TLS    *borm.TLSConfig
+
+	// Headers are added to the requests, e.g. the authorization.
+	Headers map[string]string
+
+	// BatchSize is the largest number of changes of a push, it defaults to
+	// 1000.
+	BatchSize int
+
+	// Interval is the wait between pushes once all changes are sent, it
+	// defaults to 10 seconds.
+	Interval time.Duration
+
+	// MaxBackoff limits the wait between retries while the central engine
+	// is unreachable, it defaults to 5 minutes.
+	MaxBackoff time.Duration
+
+	// Truncate drops the acknowledged changes from the change log of the
+	// edge engine, unless something else reads it.
+	Truncate bool
+
+	// OnError is called with the failed pushes, nil logs them.
+	OnError func(err error)
+}
+
+// Pusher sends the changes of an edge engine to the central receiver.
+type Pusher struct {
+	db   *borm.TSEngine
+	url  string
+	opts Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPusher returns the pusher of the edge engine to the receiver at url.
+func NewPusher(db *borm.TSEngine, url string, opts *Options) (*Pusher, error) {
+	if db.NodeID() == "" {
+		return nil, errors.New("edge: engine has no node ID")
+	}
+	p := &Pusher{db: db, url: strings.TrimSuffix(url, "/"), done: make(chan struct{})}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.Client == nil {
+		client, err := p.opts.TLS.HTTPClient()
+		if err != nil {
+			return nil, err
+		}
+		p.opts.Client = client
+	}
+	if p.opts.BatchSize <= 0 {
+		p.opts.BatchSize = 1000
+	}
+	if p.opts.Interval <= 0 {
+		p.opts.Interval = 10 * time.Second
+	}
+	if p.opts.MaxBackoff <= 0 {
+		p.opts.MaxBackoff = 5 * time.Minute
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p, nil
+}
+
+// Run pushes the changes until Stop, retrying with exponential backoff
+// while the receiver is unreachable.
+func (p *Pusher) Run() {
+	defer close(p.done)
+	backoff := time.Duration(0)
+	for {
+		wait := p.opts.Interval
+		more, err := p.Push()
+		switch {
+		case err != nil:
+			if p.ctx.Err() != nil {
+				return
+			}
+			if p.opts.OnError != nil {
+				p.opts.OnError(err)
+			} else {
+				log.Printf("edge pusher failed to push records: %s", err)
+			}
+			if backoff == 0 {
+				backoff = time.Second
+			} else if backoff *= 2; backoff > p.opts.MaxBackoff {
+				backoff = p.opts.MaxBackoff
+			}
+			wait = backoff
+		case more:
+			backoff, wait = 0, 0
+		default:
+			backoff = 0
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Stop stops Run, waiting for the push in flight.
+func (p *Pusher) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// Push sends one batch of the changes the receiver didn't acknowledge yet,
+// it returns whether more are left.
+func (p *Pusher) Push() (bool, error) {
+	// the receiver is the authority on what it applied, the edge may have
+	// lost its own cursor or the answer of the last push
+	var ack Ack
+	if err := p.do(http.MethodGet, "/push?node="+url.QueryEscape(p.db.NodeID()), nil, &ack); err != nil {
+		return false, err
+	}
+	if p.opts.Truncate && ack.Seq > 0 {
+		if err := p.db.TruncateChanges(ack.Seq); err != nil {
+			return false, err
+		}
+	}
+
+	changes, err := p.db.Changes(ack.Seq, p.opts.BatchSize)
+	if err != nil {
+		return false, err
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+	body, err := json.Marshal(&Push{Node: p.db.NodeID(), Changes: changes})
+	if err != nil {
+		return false, err
+	}
+	if err := p.do(http.MethodPost, "/push", body, &ack); err != nil {
+		return false, err
+	}
+	if p.opts.Truncate {
+		if err := p.db.TruncateChanges(ack.Seq); err != nil {
+			return false, err
+		}
+	}
+	return len(changes) == p.opts.BatchSize, nil
+}
+
+func (p *Pusher) do(method, path string, body []byte, ack *Ack) error {
+	req, err := http.NewRequest(method, p.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range p.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.opts.Client.Do(req.WithContext(p.ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("edge: %s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(ack)
+}
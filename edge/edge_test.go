@@ -0,0 +1,158 @@
+package edge_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/edge"
+)
+
+type reading struct {
+	Sensor string
+	Value  float64
+}
+
+func open(t *testing.T, dir, node string) *borm.TSEngine {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", dir, err)
+	}
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder:   borm.JSONEncode,
+		Decoder:   borm.JSONDecode,
+		NodeID:    node,
+		ChangeLog: node != "",
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	return db
+}
+
+func write(t *testing.T, db *borm.TSEngine, start time.Time, n int) {
+	var entries []borm.Entry
+	for i := 0; i < n; i++ {
+		entries = append(entries, borm.Entry{Time: start.Add(time.Duration(i) * time.Minute), Value: &reading{Sensor: db.NodeID(), Value: float64(i)}})
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+}
+
+func count(t *testing.T, db *borm.TSEngine, start, end time.Time) map[string]int {
+	counts := map[string]int{}
+	err := db.Query(start, end, func(it *borm.Iterator) error {
+		for it.Next() {
+			var r reading
+			if err := it.Read(&r); err != nil {
+				return err
+			}
+			counts[r.Sensor]++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	return counts
+}
+
+func TestPushReceive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edge")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	central := open(t, filepath.Join(dir, "central"), "")
+	defer central.Close()
+	receiver := edge.NewReceiver(central)
+
+	// the link of the edge nodes goes down and up
+	var down int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) != 0 {
+			http.Error(w, "unreachable", http.StatusServiceUnavailable)
+			return
+		}
+		receiver.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	end := time.Now().Add(time.Hour)
+
+	pushers := map[string]*edge.Pusher{}
+	for _, node := range []string{"sensor-1", "sensor-2"} {
+		db := open(t, filepath.Join(dir, node), node)
+		defer db.Close()
+		write(t, db, start, 5)
+		p, err := edge.NewPusher(db, srv.URL, &edge.Options{BatchSize: 3, Truncate: node == "sensor-2"})
+		if err != nil {
+			t.Fatalf("Error creating pusher: %s", err)
+		}
+		pushers[node] = p
+	}
+
+	atomic.StoreInt32(&down, 1)
+	if _, err := pushers["sensor-1"].Push(); err == nil {
+		t.Fatalf("Expected a push to fail while the link is down.")
+	}
+	atomic.StoreInt32(&down, 0)
+
+	for node, p := range pushers {
+		for {
+			more, err := p.Push()
+			if err != nil {
+				t.Fatalf("Error pushing %s: %s", node, err)
+			}
+			if !more {
+				break
+			}
+		}
+		// a push with nothing new is a no-op
+		if more, err := p.Push(); err != nil || more {
+			t.Fatalf("Expected nothing left to push for %s, got %v %v.", node, more, err)
+		}
+		if seq, _ := receiver.NodeSeq(node); seq != 5 {
+			t.Fatalf("Expected 5 changes of %s acknowledged, got %d.", node, seq)
+		}
+	}
+	counts := count(t, central, start, end)
+	if counts["sensor-1"] != 5 || counts["sensor-2"] != 5 {
+		t.Fatalf("Expected 5 records of each sensor, got %v.", counts)
+	}
+
+	// a batch sent again is skipped
+	body, _ := json.Marshal(&edge.Push{Node: "sensor-1", Changes: []borm.Change{{Seq: 1, Shard: "x.ts", Key: borm.CreateNodeID(start, "sensor-1", 1), Value: []byte(`{}`)}}})
+	resp, err := http.Post(srv.URL+"/push", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("Error pushing: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a replayed batch to be accepted, got %s.", resp.Status)
+	}
+
+	// a node can't push the records of another one
+	body, _ = json.Marshal(&edge.Push{Node: "sensor-1", Changes: []borm.Change{{Seq: 6, Shard: "x.ts", Key: borm.CreateNodeID(start, "sensor-2", 9), Value: []byte(`{}`)}}})
+	resp, err = http.Post(srv.URL+"/push", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("Error pushing: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a foreign key to be rejected, got %s.", resp.Status)
+	}
+	if counts := count(t, central, start, end); counts["sensor-1"] != 5 || counts["sensor-2"] != 5 {
+		t.Fatalf("Expected the records unchanged, got %v.", counts)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"sync"
 )
 
 // EncodeFunc is a function for encoding a value into bytes
@@ -16,46 +17,81 @@ type EncodeFunc func(value interface{}) ([]byte, error)
 // DecodeFunc is a function for decoding a value from bytes
 type DecodeFunc func(data []byte, value interface{}) error
 
+// AppendEncodeFunc is a function for encoding a value by appending it to dst,
+// see Bucket.SetAppendEncoder
+type AppendEncodeFunc func(dst []byte, value interface{}) ([]byte, error)
+
+// bufferPool holds the scratch buffers of the encoders.
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledBuffer is the largest buffer kept by bufferPool, so a large
+// record doesn't pin its buffer in the pool.
+const maxPooledBuffer = 64 * 1024
+
+func getBuffer() *bytes.Buffer {
+	buff := bufferPool.Get().(*bytes.Buffer)
+	buff.Reset()
+	return buff
+}
+
+// takeBuffer returns the encoded bytes of the buffer, which the caller owns.
+// A small buffer is copied and pooled again, a large one is handed over.
+func takeBuffer(buff *bytes.Buffer) []byte {
+	if buff.Cap() > maxPooledBuffer {
+		return buff.Bytes()
+	}
+	data := append([]byte(nil), buff.Bytes()...)
+	bufferPool.Put(buff)
+	return data
+}
+
+// putBuffer gives the buffer back to bufferPool, unless it is large.
+func putBuffer(buff *bytes.Buffer) {
+	if buff.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buff)
+	}
+}
+
 // DefaultEncode is the default encoding func for borm (Gob)
 func DefaultEncode(value interface{}) ([]byte, error) {
-	var buff bytes.Buffer
+	buff := getBuffer()
 
-	en := gob.NewEncoder(&buff)
+	en := gob.NewEncoder(buff)
 
 	err := en.Encode(value)
 	if err != nil {
+		putBuffer(buff)
 		return nil, err
 	}
 
-	return buff.Bytes(), nil
+	return takeBuffer(buff), nil
 }
 
 // DefaultDecode is the default decoding func for borm (Gob)
 func DefaultDecode(data []byte, value interface{}) error {
-	var buff bytes.Buffer
-	de := gob.NewDecoder(&buff)
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
 
-	_, err := buff.Write(data)
-	if err != nil {
-		return err
-	}
+// JSONEncode is the default encoding func for borm (JSON)
+func JSONEncode(value interface{}) ([]byte, error) {
+	buff := getBuffer()
 
-	err = de.Decode(value)
+	err := json.NewEncoder(buff).Encode(value)
 	if err != nil {
-		return err
+		putBuffer(buff)
+		return nil, err
 	}
 
-	return nil
+	return takeBuffer(buff), nil
 }
 
-// JSONEncode is the default encoding func for borm (JSON)
-func JSONEncode(value interface{}) ([]byte, error) {
-	var buff bytes.Buffer
-	err := json.NewEncoder(&buff).Encode(value)
+// JSONAppendEncode is the appender version of JSONEncode
+func JSONAppendEncode(dst []byte, value interface{}) ([]byte, error) {
+	buff := bytes.NewBuffer(dst)
+	err := json.NewEncoder(buff).Encode(value)
 	if err != nil {
-		return nil, err
+		return dst, err
 	}
-
 	return buff.Bytes(), nil
 }
 
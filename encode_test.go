@@ -0,0 +1,42 @@
+package borm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestEncodeOwned(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		encode borm.EncodeFunc
+		decode borm.DecodeFunc
+	}{
+		{"gob", borm.DefaultEncode, borm.DefaultDecode},
+		{"json", borm.JSONEncode, borm.JSONDecode},
+	} {
+		// the buffers of the large records are handed over, the small ones
+		// copied out of the pool
+		for _, size := range []int{10, 100 * 1024} {
+			first, err := c.encode(&ItemTest{Name: strings.Repeat("a", size)})
+			if err != nil {
+				t.Fatalf("Error encoding %s: %s", c.name, err)
+			}
+			kept := append([]byte(nil), first...)
+			for i := 0; i < 3; i++ {
+				if _, err := c.encode(&ItemTest{Name: strings.Repeat("b", size)}); err != nil {
+					t.Fatalf("Error encoding %s: %s", c.name, err)
+				}
+			}
+			if !bytes.Equal(first, kept) {
+				t.Fatalf("Encoding %s of %d bytes was overwritten by the next ones.", c.name, size)
+			}
+			var item ItemTest
+			if err := c.decode(first, &item); err != nil || item.Name != strings.Repeat("a", size) {
+				t.Fatalf("Error decoding %s of %d bytes: %v", c.name, size, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package borm
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrEnrichment is matched by the errors of the writes failed by an
+// enricher, see EnrichError.
+var ErrEnrichment = errors.New("enrichment failed")
+
+// EnrichFunc completes the encoded value of a record before it is stored,
+// e.g. with the location of its IP address. The value returned is stored,
+// nil leaves the record as it is.
+type EnrichFunc func(key, value []byte) ([]byte, error)
+
+// EnrichPolicy is what happens to a record an enricher fails on.
+type EnrichPolicy int
+
+const (
+	// EnrichFail fails the write with an EnrichError.
+	EnrichFail EnrichPolicy = iota
+	// EnrichSkip stores the record without the enrichment, the next
+	// enrichers still run.
+	EnrichSkip
+	// EnrichDeadLetter stores the record as a dead letter of the origin
+	// EnrichOrigin instead of a record, once the write commits, the other
+	// records of the write are stored.
+	EnrichDeadLetter
+)
+
+// EnrichOrigin is the origin of the dead letters of the enrichers.
+const EnrichOrigin = "enrich"
+
+// Enricher is a stage of the enrichment of the records written.
+type Enricher struct {
+	// Name identifies the enricher in the errors and the statistics.
+	Name   string
+	Enrich EnrichFunc
+
+	// OnError is the policy of the records it fails on, EnrichFail by
+	// default.
+	OnError EnrichPolicy
+}
+
+// EnrichError is the error of a record an enricher failed on, errors.Is
+// matches it with ErrEnrichment.
+type EnrichError struct {
+	Enricher string
+	Key      string
+	Err      error
+}
+
+func (e *EnrichError) Error() string {
+	return "enrichment " + e.Enricher + " of the record " + strconv.Quote(e.Key) + ": " + e.Err.Error()
+}
+
+// Is makes errors.Is match the error with ErrEnrichment.
+func (e *EnrichError) Is(err error) bool {
+	return err == ErrEnrichment
+}
+
+func (e *EnrichError) Unwrap() error {
+	return e.Err
+}
+
+// enrich runs the enrichers on a value put, in order. It returns false for
+// a record dead-lettered.
+func (db *TSEngine) enrich(tx *bolt.Tx, key, value []byte) ([]byte, bool, error) {
+	for _, enricher := range db.options.Enrichers {
+		enriched, err := enricher.Enrich(key, value)
+		if err == nil {
+			if enriched != nil {
+				value = enriched
+			}
+			continue
+		}
+
+		db.enrichFailures.add(enricher.Name)
+		err = &EnrichError{Enricher: enricher.Name, Key: string(key), Err: err}
+		switch enricher.OnError {
+		case EnrichSkip:
+			continue
+		case EnrichDeadLetter:
+			letter := DeadLetter{
+				Origin:     EnrichOrigin,
+				Reason:     err.Error(),
+				Key:        string(key),
+				RecordTime: TimeFromID(string(key)),
+				// the value may be in the arena of an append encoder
+				Raw: append([]byte(nil), value...),
+			}
+			tx.OnCommit(func() {
+				if _, err := db.AddDeadLetter(letter); err != nil {
+					db.logger().Printf("engine failed to add the dead letter of %q: %s", letter.Key, err)
+				}
+			})
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return value, true, nil
+}
@@ -0,0 +1,107 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestEnrichers(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	field := func(name string, values map[string]string) borm.EnrichFunc {
+		return func(key, value []byte) ([]byte, error) {
+			var record map[string]string
+			if err := json.Unmarshal(value, &record); err != nil {
+				return nil, err
+			}
+			v, ok := values[record["IP"]]
+			if !ok {
+				return nil, errors.New("unknown address " + record["IP"])
+			}
+			record[name] = v
+			return json.Marshal(record)
+		}
+	}
+	db, err := borm.OpenTSWith(dir,
+		borm.WithCodec(borm.CodecJSON),
+		borm.WithValidators(borm.RequireFields("ASN")),
+		borm.WithEnrichers(
+			borm.Enricher{Name: "geo", Enrich: field("Country", map[string]string{"10.0.0.1": "FR"}), OnError: borm.EnrichSkip},
+			borm.Enricher{Name: "asn", Enrich: field("ASN", map[string]string{"10.0.0.1": "64512", "10.0.0.2": "64513"}), OnError: borm.EnrichDeadLetter},
+			borm.Enricher{Name: "strict", Enrich: func(key, value []byte) ([]byte, error) {
+				var record map[string]string
+				if err := json.Unmarshal(value, &record); err != nil || record["Name"] == "fail" {
+					return nil, errors.New("rejected")
+				}
+				return nil, nil
+			}}))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	keys := map[string]string{}
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		for name, ip := range map[string]string{"full": "10.0.0.1", "partial": "10.0.0.2", "unknown": "10.0.0.3"} {
+			keys[name] = db.NewID(now)
+			if err := bkt.Insert(keys[name], map[string]string{"Name": name, "IP": ip}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), map[string]string{"Name": "fail", "IP": "10.0.0.1"})
+	})
+	var enrichErr *borm.EnrichError
+	if !errors.Is(err, borm.ErrEnrichment) || !errors.As(err, &enrichErr) || enrichErr.Enricher != "strict" {
+		t.Fatalf("Writing got %v wanted the error of the strict enricher.", err)
+	}
+
+	for name, want := range map[string]map[string]string{
+		"full":    {"Name": "full", "IP": "10.0.0.1", "Country": "FR", "ASN": "64512"},
+		"partial": {"Name": "partial", "IP": "10.0.0.2", "ASN": "64513"},
+	} {
+		var record map[string]string
+		if err := db.Get(keys[name], &record); err != nil {
+			t.Fatalf("Error getting %s: %s", name, err)
+		}
+		if len(record) != len(want) || record["Country"] != want["Country"] || record["ASN"] != want["ASN"] {
+			t.Fatalf("Record %s is %v wanted %v.", name, record, want)
+		}
+	}
+	if err := db.Get(keys["unknown"], &map[string]string{}); err != borm.ErrNotFound {
+		t.Fatalf("Getting the dead letter got %v wanted %s.", err, borm.ErrNotFound)
+	}
+
+	var letters []borm.DeadLetter
+	err = db.DeadLetters(borm.EnrichOrigin, func(letter borm.DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	})
+	if err != nil || len(letters) != 1 || letters[0].Key != keys["unknown"] {
+		t.Fatalf("Dead letters got %+v, %v wanted the unknown address.", letters, err)
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(letters[0].Raw, &raw); err != nil || raw["Name"] != "unknown" || raw["ASN"] != "" {
+		t.Fatalf("Dead letter is %q, %v wanted the record not enriched.", letters[0].Raw, err)
+	}
+
+	failures := db.Stats().EnrichFailures
+	want := map[string]int64{"geo": 2, "asn": 1, "strict": 1}
+	for name, n := range want {
+		if failures[name] != n {
+			t.Fatalf("Enrich failures are %v wanted %v.", failures, want)
+		}
+	}
+}
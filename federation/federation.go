@@ -0,0 +1,219 @@
+// Package federation queries several borm engines as one, e.g. the engines
+// of the sites of a deployment. A Federation fans a query out to its
+// sources, local engines or remote ones served by Server, and merges their
+// records in time order. A failing source doesn't fail the query, the
+// records of the others are returned and the failure is reported per
+// source.
+package federation
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// Record is a record of a source.
+type Record struct {
+	Source string
+	Key    string
+	Time   time.Time
+	Value  []byte
+}
+
+// Query is a query of the sources.
+type Query struct {
+	Start, End time.Time
+
+	// Filter is the filter expression the records must match, see
+	// borm.ParseFilter, the sources evaluate it.
+	Filter string
+}
+
+// Source is an engine of the federation.
+type Source interface {
+	Name() string
+
+	// Query calls cb with the records of the query in time order, until
+	// cb fails or ctx is done.
+	Query(ctx context.Context, q *Query, cb func(key string, value []byte) error) error
+}
+
+// Local returns the source of an engine of the process.
+func Local(name string, db *borm.TSEngine) Source {
+	return &local{name: name, db: db}
+}
+
+type local struct {
+	name string
+	db   *borm.TSEngine
+}
+
+func (s *local) Name() string { return s.name }
+
+func (s *local) Query(ctx context.Context, q *Query, cb func(key string, value []byte) error) error {
+	return s.db.QueryWith(q.Start, q.End, &borm.QueryOptions{Filter: q.Filter}, func(it *borm.Iterator) error {
+		for it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := cb(string(it.Key()), append([]byte(nil), it.Value()...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Report is the outcome of a query per source.
+type Report struct {
+	// Records counts the records of every source.
+	Records map[string]int
+
+	// Errors are the failures of the sources, their records read before
+	// the failure were returned.
+	Errors map[string]error
+}
+
+// Failed returns the names of the failed sources, sorted.
+func (r *Report) Failed() []string {
+	names := make([]string, 0, len(r.Errors))
+	for name := range r.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrAllFailed is returned by Query when every source failed.
+var ErrAllFailed = errors.New("federation: all sources failed")
+
+// Federation is a set of sources queried as one.
+type Federation struct {
+	sources []Source
+
+	// Buffer is the number of records read ahead per source, it defaults
+	// to 256.
+	Buffer int
+}
+
+// New returns the federation of the sources, their names must be unique.
+func New(sources ...Source) (*Federation, error) {
+	names := map[string]bool{}
+	for _, src := range sources {
+		if names[src.Name()] {
+			return nil, errors.New("federation: duplicate source " + src.Name())
+		}
+		names[src.Name()] = true
+	}
+	return &Federation{sources: sources}, nil
+}
+
+// stream is the records of a source read ahead.
+type stream struct {
+	src     Source
+	records chan Record
+	err     error
+	head    Record
+}
+
+// Query calls cb with the records of all the sources in time order, ties
+// broken by key then source. It returns the error of cb, ErrAllFailed if no
+// source answered, and the report of every source.
+func (f *Federation) Query(ctx context.Context, q *Query, cb func(r *Record) error) (*Report, error) {
+	buffer := f.Buffer
+	if buffer <= 0 {
+		buffer = 256
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	streams := make([]*stream, len(f.sources))
+	for i, src := range f.sources {
+		s := &stream{src: src, records: make(chan Record, buffer)}
+		streams[i] = s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(s.records)
+			s.err = s.src.Query(ctx, q, func(key string, value []byte) error {
+				r := Record{Source: s.src.Name(), Key: key, Time: borm.TimeFromID(key), Value: value}
+				select {
+				case s.records <- r:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		}()
+	}
+
+	report := &Report{Records: map[string]int{}, Errors: map[string]error{}}
+	h := &heads{}
+	next := func(s *stream) {
+		if r, ok := <-s.records; ok {
+			s.head = r
+			heap.Push(h, s)
+		}
+	}
+	for _, s := range streams {
+		next(s)
+	}
+
+	var err error
+	for h.Len() > 0 {
+		s := heap.Pop(h).(*stream)
+		report.Records[s.src.Name()]++
+		if err = cb(&s.head); err != nil {
+			break
+		}
+		next(s)
+	}
+	cancel()
+	wg.Wait()
+	if err != nil {
+		return report, err
+	}
+
+	for _, s := range streams {
+		if s.err != nil {
+			report.Errors[s.src.Name()] = s.err
+		}
+	}
+	if len(streams) > 0 && len(report.Errors) == len(streams) {
+		return report, ErrAllFailed
+	}
+	return report, nil
+}
+
+// heads orders the streams by their next record.
+type heads []*stream
+
+func (h heads) Len() int { return len(h) }
+
+func (h heads) Less(i, j int) bool {
+	a, b := &h[i].head, &h[j].head
+	if !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time)
+	}
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.Source < b.Source
+}
+
+func (h heads) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *heads) Push(x interface{}) { *h = append(*h, x.(*stream)) }
+
+func (h *heads) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
@@ -0,0 +1,135 @@
+package federation_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/federation"
+	"google.golang.org/grpc"
+)
+
+type event struct {
+	Site  string
+	Level int
+}
+
+func open(t *testing.T, dir, site string, start time.Time, offset int) *borm.TSEngine {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", dir, err)
+	}
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	var entries []borm.Entry
+	for i := 0; i < 5; i++ {
+		at := start.Add(time.Duration(3*i+offset) * time.Minute)
+		entries = append(entries, borm.Entry{Time: at, Value: &event{Site: site, Level: i}})
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	return db
+}
+
+func TestFederationQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "federation")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Hour)
+	east := open(t, filepath.Join(dir, "east"), "east", start, 0)
+	defer east.Close()
+	west := open(t, filepath.Join(dir, "west"), "west", start, 1)
+	defer west.Close()
+	north := open(t, filepath.Join(dir, "north"), "north", start, 2)
+	defer north.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	srv := grpc.NewServer()
+	federation.NewServer(north).Register(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	remote, err := federation.Remote("north", lis.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Error creating remote: %s", err)
+	}
+	defer remote.(io.Closer).Close()
+
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	down.Close()
+	broken, err := federation.Remote("south", down.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Error creating remote: %s", err)
+	}
+	defer broken.(io.Closer).Close()
+
+	f, err := federation.New(federation.Local("east", east), federation.Local("west", west), remote, broken)
+	if err != nil {
+		t.Fatalf("Error creating federation: %s", err)
+	}
+
+	q := &federation.Query{Start: start, End: start.Add(time.Hour)}
+	var sites []string
+	var last time.Time
+	report, err := f.Query(context.Background(), q, func(r *federation.Record) error {
+		if r.Time.Before(last) {
+			t.Fatalf("Record %s of %s out of order.", r.Key, r.Source)
+		}
+		last = r.Time
+		sites = append(sites, r.Source)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if len(sites) != 15 || sites[0] != "east" || sites[1] != "west" || sites[2] != "north" {
+		t.Fatalf("Expected the records of the sites interleaved, got %v.", sites)
+	}
+	if failed := report.Failed(); len(failed) != 1 || failed[0] != "south" || report.Records["north"] != 5 {
+		t.Fatalf("Unexpected report %+v.", report)
+	}
+
+	// the filter is evaluated by the sources
+	q.Filter = "Level >= 3"
+	n := 0
+	if _, err := f.Query(context.Background(), q, func(r *federation.Record) error {
+		n++
+		return nil
+	}); err != nil || n != 6 {
+		t.Fatalf("Expected 6 filtered records, got %d: %v", n, err)
+	}
+
+	// the callback stops the query
+	stop := errors.New("stop")
+	n = 0
+	if _, err := f.Query(context.Background(), &federation.Query{Start: start, End: start.Add(time.Hour)}, func(r *federation.Record) error {
+		if n++; n == 4 {
+			return stop
+		}
+		return nil
+	}); err != stop || n != 4 {
+		t.Fatalf("Expected the query stopped after 4 records, got %d: %v", n, err)
+	}
+
+	only, _ := federation.New(broken)
+	if _, err := only.Query(context.Background(), q, func(r *federation.Record) error { return nil }); err != federation.ErrAllFailed {
+		t.Fatalf("Expected ErrAllFailed, got %v.", err)
+	}
+}
@@ -0,0 +1,207 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the name of the gRPC service of a remote engine, it has a
+// server stream, Query, sending the records of a query in time order.
+const ServiceName = "borm.federation.Federation"
+
+// codecName is the content subtype of the messages, the JSON codec of the
+// replica service.
+const codecName = "borm-json"
+
+// batchSize is the number of records of a message.
+const batchSize = 1000
+
+// jsonCodec encodes the messages of the service in JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return codecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// request is the request of the query stream.
+type request struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Filter string    `json:"filter,omitempty"`
+}
+
+// record is a record of a message.
+type record struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// message is a message of the query stream.
+type message struct {
+	Records []record `json:"records,omitempty"`
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Query", ServerStreams: true, Handler: serveQuery},
+	},
+}
+
+// queryStream is the stream of serviceDesc.
+var queryStream = &serviceDesc.Streams[0]
+
+// Server serves the queries of remote federations on an engine:
+//
+//	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+//	federation.NewServer(db).Register(s)
+//	go s.Serve(lis)
+type Server struct {
+	db *borm.TSEngine
+}
+
+// NewServer returns the server of the engine.
+func NewServer(db *borm.TSEngine) *Server {
+	return &Server{db: db}
+}
+
+// Register registers the service on the gRPC server.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	r.RegisterService(&serviceDesc, s)
+}
+
+func serveQuery(srv interface{}, stream grpc.ServerStream) error {
+	var req request
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	return srv.(*Server).query(&req, stream)
+}
+
+func (s *Server) query(req *request, stream grpc.ServerStream) error {
+	if req.Filter != "" {
+		if _, err := borm.ParseFilter(req.Filter); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	ctx := stream.Context()
+	batch := make([]record, 0, batchSize)
+	err := s.db.QueryWith(req.Start, req.End, &borm.QueryOptions{Filter: req.Filter}, func(it *borm.Iterator) error {
+		for it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// the value is only valid during the iteration
+			batch = append(batch, record{Key: string(it.Key()), Value: append([]byte(nil), it.Value()...)})
+			if len(batch) < batchSize {
+				continue
+			}
+			if err := stream.SendMsg(&message{Records: batch}); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		return nil
+	})
+	if err != nil || len(batch) == 0 {
+		return err
+	}
+	return stream.SendMsg(&message{Records: batch})
+}
+
+// RemoteOptions configures a remote source.
+type RemoteOptions struct {
+	// TLS connects to the server with TLS, nil connects without.
+	TLS *borm.TLSConfig
+
+	// DialOptions are added to the options of the connection.
+	DialOptions []grpc.DialOption
+
+	// Headers are sent with the requests, e.g. the authorization.
+	Headers map[string]string
+}
+
+// Remote returns the source of the engine served by a Server at the target,
+// e.g. "site:7071". The source is an io.Closer closing its connection.
+func Remote(name, target string, opts *RemoteOptions) (Source, error) {
+	s := &remote{name: name}
+	if opts != nil {
+		s.opts = *opts
+	}
+	creds := insecure.NewCredentials()
+	if s.opts.TLS != nil {
+		config, err := s.opts.TLS.Client()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(config)
+	}
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codecName)),
+	}, s.opts.DialOptions...)
+	conn, err := grpc.Dial(target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+type remote struct {
+	name string
+	conn *grpc.ClientConn
+	opts RemoteOptions
+}
+
+func (s *remote) Name() string { return s.name }
+
+func (s *remote) Close() error { return s.conn.Close() }
+
+func (s *remote) Query(ctx context.Context, q *Query, cb func(key string, value []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for k, v := range s.opts.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+	stream, err := s.conn.NewStream(ctx, queryStream, "/"+ServiceName+"/"+queryStream.StreamName)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&request{Start: q.Start, End: q.End, Filter: q.Filter}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		var msg message
+		if err := stream.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		for _, r := range msg.Records {
+			if err := cb(r.Key, r.Value); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package borm
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrFileBudget is returned by Open when no file of the budget was released
+// within the timeout of the bolt options.
+var ErrFileBudget = errors.New("open file budget exhausted")
+
+// FileStats is the usage of the open file budget.
+type FileStats struct {
+	// Open is the number of stores open with Open, Limit is the budget, 0
+	// for no budget.
+	Open  int
+	Limit int
+
+	// Waiting is the number of opens queued for a file, Waited how many
+	// opens were queued so far.
+	Waiting int
+	Waited  int64
+}
+
+// fileBudget is the budget of all the stores of the process.
+var fileBudget budget
+
+type budget struct {
+	mu      sync.Mutex
+	open    int
+	limit   int
+	waited  int64
+	waiters []chan struct{}
+}
+
+// SetMaxOpenFiles sets the budget of the stores open at once by Open, shared
+// by all the engines of the process, so parallel queries plus the open
+// writers don't exceed ulimit -n. Opens past the budget wait in order for a
+// store to be closed. 0, the default, removes the budget.
+func SetMaxOpenFiles(n int) {
+	b := &fileBudget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = n
+	b.wake()
+}
+
+// OpenFiles returns the usage of the open file budget.
+func OpenFiles() FileStats {
+	b := &fileBudget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return FileStats{Open: b.open, Limit: b.limit, Waiting: len(b.waiters), Waited: b.waited}
+}
+
+// acquire takes a file of the budget, waiting at most timeout for one if
+// timeout is not 0.
+func (b *budget) acquire(timeout time.Duration) error {
+	b.mu.Lock()
+	if b.limit <= 0 || (b.open < b.limit && len(b.waiters) == 0) {
+		b.open++
+		b.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	b.waiters = append(b.waiters, ready)
+	b.waited++
+	b.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-expired:
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, w := range b.waiters {
+		if w == ready {
+			b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+			return ErrFileBudget
+		}
+	}
+	// woken up meanwhile, the file is taken
+	return nil
+}
+
+func (b *budget) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open--
+	b.wake()
+}
+
+// wake hands the free files to the waiters in order, b.mu is held.
+func (b *budget) wake() {
+	for len(b.waiters) > 0 && (b.limit <= 0 || b.open < b.limit) {
+		b.open++
+		close(b.waiters[0])
+		b.waiters = b.waiters[1:]
+	}
+}
@@ -0,0 +1,59 @@
+package borm_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+)
+
+func TestOpenFileBudget(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+	base := borm.OpenFiles().Open
+	borm.SetMaxOpenFiles(base + 1)
+	defer borm.SetMaxOpenFiles(0)
+
+	first, err := borm.Open(filepath.Join(dir, "first"), 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening: %s", err)
+	}
+
+	// past the budget an open times out, or waits for a close
+	if _, err := borm.Open(filepath.Join(dir, "second"), 0666, &bolt.Options{Timeout: 20 * time.Millisecond}); err != borm.ErrFileBudget {
+		t.Fatalf("Expected ErrFileBudget, got %v.", err)
+	}
+	opened := make(chan *borm.Store)
+	go func() {
+		s, err := borm.Open(filepath.Join(dir, "second"), 0666, nil)
+		if err != nil {
+			t.Errorf("Error opening: %s", err)
+		}
+		opened <- s
+	}()
+	for borm.OpenFiles().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-opened:
+		t.Fatalf("Expected the open to wait for the budget.")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	first.Close()
+	second := <-opened
+	stats := borm.OpenFiles()
+	if stats.Open != base+1 || stats.Waiting != 0 || stats.Waited < 2 {
+		t.Fatalf("Unexpected file stats %+v.", stats)
+	}
+	second.Close()
+	if open := borm.OpenFiles().Open; open != base {
+		t.Fatalf("Expected %d open files, got %d.", base, open)
+	}
+}
@@ -0,0 +1,391 @@
+package borm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FilterError is the error returned for an invalid filter expression
+type FilterError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter %q: %s at offset %d", e.Expr, e.Msg, e.Pos)
+}
+
+// Filter is a compiled filter expression over JSON records, such as
+//
+//	src_ip = "1.2.3.4" AND (severity >= 3 OR NOT acked = true)
+//
+// A comparison is a field, one of = != < <= > >=, and a string, number or
+// boolean literal. Fields are top-level members of the record, or paths into
+// nested objects like geo.country. A comparison with a missing field, or
+// with a value of another type than the literal, is false.
+type Filter struct {
+	expr   string
+	root   filterNode
+	fields []string
+}
+
+// ParseFilter compiles a filter expression.
+func ParseFilter(expr string) (*Filter, error) {
+	p := &filterParser{expr: expr}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+
+	f := &Filter{expr: expr, root: root}
+	seen := map[string]bool{}
+	root.fields(func(field string) {
+		if top := strings.SplitN(field, ".", 2)[0]; !seen[top] {
+			seen[top] = true
+			f.fields = append(f.fields, top)
+		}
+	})
+	return f, nil
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the JSON record matches the filter, a value which is
+// not a JSON object never does.
+func (f *Filter) Match(value []byte) bool {
+	raw := make(map[string][]byte, len(f.fields))
+	err := scanJSONObject(value, func(key, rawKey, value []byte) {
+		for _, field := range f.fields {
+			if string(key) == field {
+				raw[field] = value
+				return
+			}
+		}
+	})
+	if err != nil {
+		return false
+	}
+	return f.root.eval(&filterRecord{raw: raw})
+}
+
+// filterRecord decodes the fields of a record on first use.
+type filterRecord struct {
+	raw     map[string][]byte
+	decoded map[string]interface{}
+}
+
+func (r *filterRecord) lookup(path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	if r.decoded == nil {
+		r.decoded = map[string]interface{}{}
+	}
+	v, ok := r.decoded[parts[0]]
+	if !ok {
+		data, found := r.raw[parts[0]]
+		if !found || json.Unmarshal(data, &v) != nil {
+			return nil, false
+		}
+		r.decoded[parts[0]] = v
+	}
+	for _, part := range parts[1:] {
+		obj, isObj := v.(map[string]interface{})
+		if !isObj {
+			return nil, false
+		}
+		if v, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+type filterNode interface {
+	eval(r *filterRecord) bool
+	fields(fn func(field string))
+}
+
+type filterAnd struct{ left, right filterNode }
+type filterOr struct{ left, right filterNode }
+type filterNot struct{ node filterNode }
+
+type filterCompare struct {
+	field string
+	op    string
+	value interface{} // string, float64 or bool
+}
+
+func (n *filterAnd) eval(r *filterRecord) bool { return n.left.eval(r) && n.right.eval(r) }
+func (n *filterOr) eval(r *filterRecord) bool  { return n.left.eval(r) || n.right.eval(r) }
+func (n *filterNot) eval(r *filterRecord) bool { return !n.node.eval(r) }
+
+func (n *filterAnd) fields(fn func(string))     { n.left.fields(fn); n.right.fields(fn) }
+func (n *filterOr) fields(fn func(string))      { n.left.fields(fn); n.right.fields(fn) }
+func (n *filterNot) fields(fn func(string))     { n.node.fields(fn) }
+func (n *filterCompare) fields(fn func(string)) { fn(n.field) }
+
+func (n *filterCompare) eval(r *filterRecord) bool {
+	v, ok := r.lookup(n.field)
+	if !ok {
+		return false
+	}
+
+	var cmp int
+	switch want := n.value.(type) {
+	case string:
+		got, ok := v.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(got, want)
+	case float64:
+		got, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case got < want:
+			cmp = -1
+		case got > want:
+			cmp = 1
+		}
+	case bool:
+		got, ok := v.(bool)
+		if !ok {
+			return false
+		}
+		switch n.op {
+		case "=":
+			return got == want
+		case "!=":
+			return got != want
+		}
+		return false
+	}
+
+	switch n.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+const (
+	tokIdent = iota
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type filterToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+type filterParser struct {
+	expr   string
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	pos := len(p.expr)
+	if p.pos < len(p.tokens) {
+		pos = p.tokens[p.pos].pos
+	}
+	return &FilterError{Expr: p.expr, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		!first && ('0' <= c && c <= '9' || c == '.')
+}
+
+func (p *filterParser) lex() error {
+	s := p.expr
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, filterToken{tokLParen, "(", i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, filterToken{tokRParen, ")", i})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return &FilterError{Expr: s, Pos: i, Msg: "unterminated string"}
+			}
+			unquoted, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return &FilterError{Expr: s, Pos: i, Msg: "invalid string"}
+			}
+			p.tokens = append(p.tokens, filterToken{tokString, unquoted, i})
+			i = j + 1
+		case c == '-' || '0' <= c && c <= '9':
+			j := i + 1
+			for j < len(s) && ('0' <= s[j] && s[j] <= '9' || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				(s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			p.tokens = append(p.tokens, filterToken{tokNumber, s[i:j], i})
+			i = j
+		case strings.IndexByte("=!<>", c) >= 0:
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			op := s[i:j]
+			if op == "!" || op == "==" {
+				return &FilterError{Expr: s, Pos: i, Msg: "invalid operator " + op}
+			}
+			p.tokens = append(p.tokens, filterToken{tokOp, op, i})
+			i = j
+		case isIdentByte(c, true):
+			j := i + 1
+			for j < len(s) && isIdentByte(s[j], false) {
+				j++
+			}
+			p.tokens = append(p.tokens, filterToken{tokIdent, s[i:j], i})
+			i = j
+		default:
+			return &FilterError{Expr: s, Pos: i, Msg: fmt.Sprintf("unexpected %q", c)}
+		}
+	}
+	return nil
+}
+
+// keyword reports whether the next token is the keyword, and consumes it.
+func (p *filterParser) keyword(word string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokIdent && strings.EqualFold(p.tokens[p.pos].text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.keyword("NOT") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node}, nil
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	if p.tokens[p.pos].kind == tokLParen {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokRParen {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *filterParser) parseCompare() (filterNode, error) {
+	field := p.tokens[p.pos]
+	if field.kind != tokIdent {
+		return nil, p.errorf("expected a field, got %s", field.text)
+	}
+	p.pos++
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokOp {
+		return nil, p.errorf("expected an operator after %s", field.text)
+	}
+	op := p.tokens[p.pos].text
+	p.pos++
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorf("expected a value after %s", op)
+	}
+
+	tok := p.tokens[p.pos]
+	n := &filterCompare{field: field.text, op: op}
+	switch {
+	case tok.kind == tokString:
+		n.value = tok.text
+	case tok.kind == tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.text)
+		}
+		n.value = f
+	case tok.kind == tokIdent && (strings.EqualFold(tok.text, "true") || strings.EqualFold(tok.text, "false")):
+		if op != "=" && op != "!=" {
+			return nil, p.errorf("booleans only support = and !=")
+		}
+		n.value = strings.EqualFold(tok.text, "true")
+	default:
+		return nil, p.errorf("expected a value, got %s", tok.text)
+	}
+	p.pos++
+	return n, nil
+}
@@ -0,0 +1,95 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestFilterMatch(t *testing.T) {
+	record := []byte(`{"src_ip":"1.2.3.4","severity":4,"acked":false,"geo":{"country":"FR"},"note":"x"}`)
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`src_ip = "1.2.3.4"`, true},
+		{`src_ip = "1.2.3.4" AND severity >= 3`, true},
+		{`src_ip = "1.2.3.4" AND severity >= 5`, false},
+		{`severity < 3 OR acked = false`, true},
+		{`NOT acked = true and geo.country = "FR"`, true},
+		{`(severity > 4 or severity <= 1) and src_ip != "9.9.9.9"`, false},
+		{`missing = "x"`, false},
+		{`severity = "4"`, false},
+		{`geo.city = "Paris"`, false},
+	}
+	for _, test := range tests {
+		f, err := borm.ParseFilter(test.expr)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %s", test.expr, err)
+		}
+		if got := f.Match(record); got != test.match {
+			t.Fatalf("Filter %s matches %v, expected %v.", test.expr, got, test.match)
+		}
+	}
+	if f, _ := borm.ParseFilter(`severity = 4`); f.Match([]byte("not json")) {
+		t.Fatalf("Filter matches a value which is not JSON.")
+	}
+}
+
+func TestFilterParseErrors(t *testing.T) {
+	for _, expr := range []string{``, `severity`, `severity >=`, `severity == 3`, `(a = 1`, `a = 1 b = 2`, `a = "x`, `a < true`, `a = 1 AND`} {
+		_, err := borm.ParseFilter(expr)
+		if _, ok := err.(*borm.FilterError); !ok {
+			t.Fatalf("Filter %q didn't fail with a FilterError, got %v", expr, err)
+		}
+	}
+}
+
+func TestTSQueryFilter(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for i := range testData {
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(now, uint32(i)), testData[i])
+		})
+		if err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+
+	count := 0
+	opts := &borm.QueryOptions{Filter: `Category = "food" AND ID > 0`}
+	err = db.QueryWith(now.Add(-time.Minute), now.Add(time.Minute), opts, func(it *borm.Iterator) error {
+		for it.Next() {
+			var item ItemTest
+			if err := it.Read(&item); err != nil {
+				return err
+			}
+			if item.Category != "food" {
+				t.Fatalf("Filter let %v through.", item)
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if count != 5 {
+		t.Fatalf("Filtered query returned %d records, expected 5.", count)
+	}
+
+	if err := db.QueryWith(now, now, &borm.QueryOptions{Filter: "Category ="}, func(*borm.Iterator) error { return nil }); err == nil {
+		t.Fatalf("Query with an invalid filter didn't fail.")
+	}
+}
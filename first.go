@@ -0,0 +1,138 @@
+package borm
+
+import (
+	"bytes"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// FindFirst returns the earliest record in the time range matching the
+// filter expression, "" matches every record, or ErrNotFound. The shards
+// are scanned from the start of the range and the scan stops at the first
+// shard with a match.
+func (db *TSEngine) FindFirst(start, end time.Time, filter string) (*RawRecord, error) {
+	return db.find(start, end, filter, false)
+}
+
+// FindLast returns the latest record in the time range matching the filter
+// expression as FindFirst does, scanning the shards from the end of the
+// range backwards.
+func (db *TSEngine) FindLast(start, end time.Time, filter string) (*RawRecord, error) {
+	return db.find(start, end, filter, true)
+}
+
+func (db *TSEngine) find(start, end time.Time, expr string, last bool) (*RawRecord, error) {
+	var filter *Filter
+	if expr != "" {
+		var err error
+		if filter, err = ParseFilter(expr); err != nil {
+			return nil, err
+		}
+	}
+
+	type shard struct {
+		position int
+		fileName string
+	}
+	var shards []shard
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		shards = append(shards, shard{position, fileName})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if last {
+		for i, j := 0, len(shards)-1; i < j; i, j = i+1, j-1 {
+			shards[i], shards[j] = shards[j], shards[i]
+		}
+	}
+
+	for _, sh := range shards {
+		if _, err := os.Stat(sh.fileName); os.IsNotExist(err) {
+			continue
+		}
+		var found *RawRecord
+		err := db.read(sh.fileName, func(bkt *Bucket) error {
+			return bkt.view(func(tx *bolt.Tx) error {
+				data := tx.Bucket(bkt.name)
+				if data == nil {
+					return nil
+				}
+				// the IDs of every precision sort apart, the match of each
+				// range is compared by time
+				for _, span := range keyRanges(sh.position, start, end) {
+					rec, err := findInSpan(bkt, data.Cursor(), span, filter, last)
+					if err != nil {
+						return err
+					}
+					if rec != nil && (found == nil || recordBefore(rec, found) != last) {
+						found = rec
+					}
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// recordBefore returns whether the ID of a is older than the one of b.
+func recordBefore(a, b *RawRecord) bool {
+	return TimeFromID(string(a.Key)).Before(TimeFromID(string(b.Key)))
+}
+
+// findInSpan returns the first record of the key range matching the filter,
+// or the last one if last, nil if none does.
+func findInSpan(bkt *Bucket, c *bolt.Cursor, span keySpan, filter *Filter, last bool) (*RawRecord, error) {
+	from, to := []byte(span.from), []byte(span.to)
+	var k, v []byte
+	switch {
+	case !last && span.from == "":
+		k, v = c.First()
+	case !last:
+		k, v = c.Seek(from)
+	case span.to == "":
+		k, v = c.Last()
+	default:
+		if k, v = c.Seek(to); k == nil {
+			k, v = c.Last()
+		} else if bytes.Compare(k, to) > 0 {
+			k, v = c.Prev()
+		}
+	}
+
+	for k != nil {
+		if last && span.from != "" && bytes.Compare(k, from) < 0 {
+			break
+		}
+		if !last && span.to != "" && bytes.Compare(k, to) > 0 {
+			break
+		}
+		value, err := bkt.plain(v)
+		if err != nil {
+			return nil, bkt.checksumError(k, err)
+		}
+		if filter == nil || filter.Match(value) {
+			return &RawRecord{
+				Key:    append([]byte(nil), k...),
+				Value:  append([]byte(nil), value...),
+				decode: bkt.decodeValue,
+			}, nil
+		}
+		if last {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Next()
+		}
+	}
+	return nil, nil
+}
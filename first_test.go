@@ -0,0 +1,70 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestFindFirstLast(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	start := startOfDay(now).AddDate(0, 0, -2)
+	var entries []borm.Entry
+	for day := 0; day < 3; day++ {
+		for i, category := range []string{"vehicle", "fruit", "fruit", "vehicle"} {
+			at := start.AddDate(0, 0, day).Add(time.Duration(i+1) * time.Minute)
+			entries = append(entries, borm.Entry{Time: at, Value: &ItemTest{ID: day*10 + i, Category: category, Created: at}})
+		}
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error writing records: %s", err)
+	}
+
+	end := now.Add(time.Minute)
+	tests := []struct {
+		find   func(start, end time.Time, filter string) (*borm.RawRecord, error)
+		filter string
+		id     int
+	}{
+		{db.FindFirst, `Category = "fruit"`, 1},
+		{db.FindLast, `Category = "fruit"`, 22},
+		{db.FindFirst, "", 0},
+		{db.FindLast, "", 23},
+		{db.FindFirst, `ID >= 12`, 12},
+		{db.FindLast, `ID < 12`, 11},
+	}
+	for _, tst := range tests {
+		rec, err := tst.find(start, end, tst.filter)
+		if err != nil {
+			t.Fatalf("Error finding %q: %s", tst.filter, err)
+		}
+		var item ItemTest
+		if err := rec.Read(&item); err != nil {
+			t.Fatalf("Error reading record: %s", err)
+		}
+		if item.ID != tst.id {
+			t.Fatalf("Finding %q got %d wanted %d.", tst.filter, item.ID, tst.id)
+		}
+	}
+
+	if _, err := db.FindFirst(start, end, `Category = "tool"`); err != borm.ErrNotFound {
+		t.Fatalf("Finding no match got %v wanted ErrNotFound.", err)
+	}
+	if _, err := db.FindLast(start, start.Add(time.Hour), `ID = 22`); err != borm.ErrNotFound {
+		t.Fatalf("Finding out of the range got %v wanted ErrNotFound.", err)
+	}
+}
@@ -0,0 +1,375 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Webhook forwards the written records matching its filter to an HTTP
+// endpoint, e.g. a SOAR or ticketing system. Matched records are queued in
+// a persisted outbox of the meta store and POSTed in batches as a JSON array
+// of {"key", "time", "value"} objects, a failed batch is retried with
+// exponential backoff until it is accepted, across restarts.
+type Webhook struct {
+	Name string
+	URL  string
+
+	// Filter is the filter expression the records must match, see
+	// ParseFilter, empty forwards all records. JSON record values are sent
+	// as is, the others base64 encoded.
+	Filter string
+
+	// Headers are added to the requests, e.g. an authorization.
+	Headers map[string]string
+
+	// BatchSize is the largest number of records of a request, it
+	// defaults to 100.
+	BatchSize int
+
+	// FlushInterval is the longest time a record waits for its batch, it
+	// defaults to a second.
+	FlushInterval time.Duration
+
+	// MaxBackoff limits the wait between retries, it defaults to a minute.
+	MaxBackoff time.Duration
+
+	// Client defaults to http.DefaultClient, or a client with TLS if TLS is
+	// set.
+	Client *http.Client
+	TLS    *TLSConfig
+
+	// OnError is called with the failed deliveries, nil logs them.
+	OnError func(err error)
+}
+
+// forwardedRecord is a record of a webhook request.
+type forwardedRecord struct {
+	Key   string          `json:"key"`
+	Time  time.Time       `json:"time"`
+	Value json.RawMessage `json:"value"`
+}
+
+type activeWebhook struct {
+	Webhook
+	filter *Filter
+	outbox []byte
+
+	mu      sync.Mutex
+	pending int
+	notify  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// outboxBucket returns the meta bucket of the outbox of a webhook.
+func outboxBucket(name string) []byte {
+	return []byte("outbox:" + name)
+}
+
+// AddWebhook registers the webhook and starts its delivery, records left in
+// its outbox by a previous run are delivered first.
+func (db *TSEngine) AddWebhook(hook Webhook) error {
+	if hook.Name == "" || hook.URL == "" {
+		return errors.New("webhook needs a name and an URL")
+	}
+	if hook.BatchSize <= 0 {
+		hook.BatchSize = 100
+	}
+	if hook.FlushInterval <= 0 {
+		hook.FlushInterval = time.Second
+	}
+	if hook.MaxBackoff <= 0 {
+		hook.MaxBackoff = time.Minute
+	}
+	if hook.Client == nil {
+		client, err := hook.TLS.HTTPClient()
+		if err != nil {
+			return err
+		}
+		hook.Client = client
+	}
+
+	w := &activeWebhook{
+		Webhook: hook,
+		outbox:  outboxBucket(hook.Name),
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if hook.Filter != "" {
+		filter, err := ParseFilter(hook.Filter)
+		if err != nil {
+			return err
+		}
+		w.filter = filter
+	}
+	// the delivery uses the meta store, open it before
+	if _, err := db.metaStore(); err != nil {
+		return err
+	}
+
+	db.rulesMu.Lock()
+	var replaced *activeWebhook
+	for i, existing := range db.webhooks {
+		if existing.Name == hook.Name {
+			replaced = existing
+			db.webhooks[i] = w
+		}
+	}
+	if replaced == nil {
+		db.webhooks = append(db.webhooks, w)
+	}
+	db.rulesMu.Unlock()
+
+	if replaced != nil {
+		replaced.halt()
+	}
+	go db.deliver(w)
+	return nil
+}
+
+// RemoveWebhook stops the webhook and drops its outbox.
+func (db *TSEngine) RemoveWebhook(name string) error {
+	db.rulesMu.Lock()
+	var removed *activeWebhook
+	for i, w := range db.webhooks {
+		if w.Name == name {
+			removed = w
+			db.webhooks = append(db.webhooks[:i], db.webhooks[i+1:]...)
+			break
+		}
+	}
+	db.rulesMu.Unlock()
+	if removed == nil {
+		return nil
+	}
+
+	removed.halt()
+	store, err := db.metaStore()
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(removed.outbox) == nil {
+			return nil
+		}
+		return tx.DeleteBucket(removed.outbox)
+	})
+}
+
+// stopWebhooks stops the deliveries, the outboxes are kept.
+func (db *TSEngine) stopWebhooks() {
+	db.rulesMu.Lock()
+	webhooks := db.webhooks
+	db.webhooks = nil
+	db.rulesMu.Unlock()
+	for _, w := range webhooks {
+		w.halt()
+	}
+}
+
+func (w *activeWebhook) halt() {
+	close(w.stop)
+	<-w.done
+}
+
+// forwardRecords returns the write hook of the records bucket queueing the
+// matching records into the outboxes of the webhooks once the write commits.
+func (db *TSEngine) forwardRecords(bkt *Bucket) WriteHook {
+	return func(tx *bolt.Tx, key, value []byte) error {
+		if value == nil {
+			return nil
+		}
+
+		db.rulesMu.Lock()
+		defer db.rulesMu.Unlock()
+		for _, w := range db.webhooks {
+			if w.filter != nil && !w.filter.Match(value) {
+				continue
+			}
+
+			rec := forwardedRecord{Key: string(key), Time: TimeFromID(string(key)), Value: value}
+			if !json.Valid(value) {
+				rec.Value, _ = json.Marshal(value)
+			}
+			data, err := json.Marshal(&rec)
+			if err != nil {
+				return err
+			}
+			bkt.forwards.add(tx, forward{webhook: w, data: data}, db.queueForwards)
+		}
+		return nil
+	}
+}
+
+// forward is a record of a write transaction for the outbox of a webhook.
+type forward struct {
+	webhook *activeWebhook
+	data    []byte
+}
+
+// queueForwards appends the records of a write transaction to the outboxes
+// of their webhooks. The write is committed already, a record failing to be
+// queued is logged to the Logger, the webhook misses it.
+func (db *TSEngine) queueForwards(items []interface{}) {
+	var webhooks []*activeWebhook
+	outboxes := map[*activeWebhook][][]byte{}
+	for _, item := range items {
+		f := item.(forward)
+		if _, ok := outboxes[f.webhook]; !ok {
+			webhooks = append(webhooks, f.webhook)
+		}
+		outboxes[f.webhook] = append(outboxes[f.webhook], f.data)
+	}
+
+	for _, w := range webhooks {
+		records := outboxes[w]
+		err := db.updateMeta(w.outbox, func(bkt *bolt.Bucket) error {
+			for _, data := range records {
+				seq, err := bkt.NextSequence()
+				if err != nil {
+					return err
+				}
+				var id [8]byte
+				binary.BigEndian.PutUint64(id[:], seq)
+				if err := bkt.Put(id[:], data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.logger().Printf("engine failed to queue %d records for the webhook %s: %s", len(records), w.Name, err)
+			continue
+		}
+
+		w.mu.Lock()
+		w.pending += len(records)
+		full := w.pending >= w.BatchSize
+		w.mu.Unlock()
+		if full {
+			select {
+			case w.notify <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// deliver sends the outbox of the webhook until it is stopped.
+func (db *TSEngine) deliver(w *activeWebhook) {
+	defer close(w.done)
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	backoff := time.Duration(0)
+	for {
+		var wait <-chan time.Time
+		if backoff > 0 {
+			wait = time.After(backoff)
+		} else {
+			wait = ticker.C
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-w.notify:
+			if backoff > 0 {
+				continue
+			}
+		case <-wait:
+		}
+
+		err := db.flushOutbox(w)
+		if err == nil {
+			backoff = 0
+			continue
+		}
+		if w.OnError != nil {
+			w.OnError(err)
+		} else {
+			db.logger().Printf("engine failed to flush the webhook %s: %s", w.Name, err)
+		}
+		if backoff == 0 {
+			backoff = w.FlushInterval
+		} else if backoff *= 2; backoff > w.MaxBackoff {
+			backoff = w.MaxBackoff
+		}
+	}
+}
+
+// flushOutbox sends the queued records in batches, a sent batch is removed
+// from the outbox.
+func (db *TSEngine) flushOutbox(w *activeWebhook) error {
+	for {
+		var ids [][]byte
+		var batch []json.RawMessage
+		err := db.viewMeta(w.outbox, func(bkt *bolt.Bucket) error {
+			if bkt == nil {
+				return nil
+			}
+			c := bkt.Cursor()
+			for k, v := c.First(); k != nil && len(batch) < w.BatchSize; k, v = c.Next() {
+				ids = append(ids, append([]byte(nil), k...))
+				batch = append(batch, append(json.RawMessage(nil), v...))
+			}
+			return nil
+		})
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+
+		if err := w.post(batch); err != nil {
+			return err
+		}
+		err = db.updateMeta(w.outbox, func(bkt *bolt.Bucket) error {
+			for _, id := range ids {
+				if err := bkt.Delete(id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		w.mu.Lock()
+		if w.pending -= len(batch); w.pending < 0 {
+			w.pending = 0
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *activeWebhook) post(batch []json.RawMessage) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", w.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestWebhookAcrossRestart(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	down := true
+	received := make(chan []ItemTest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var records []struct {
+			Key   string
+			Value ItemTest
+		}
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			t.Errorf("Error decoding request: %s", err)
+		}
+		var items []ItemTest
+		for _, rec := range records {
+			items = append(items, rec.Value)
+		}
+		received <- items
+	}))
+	defer srv.Close()
+
+	hook := borm.Webhook{
+		Name:          "vehicles",
+		URL:           srv.URL,
+		Filter:        `Category = "vehicle"`,
+		FlushInterval: 10 * time.Millisecond,
+		MaxBackoff:    20 * time.Millisecond,
+		OnError:       func(err error) {},
+	}
+	options := &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode}
+
+	now := time.Now()
+	db, err := borm.OpenTSWithOptions(dir, options)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	if err := db.AddWebhook(hook); err != nil {
+		t.Fatalf("Error adding webhook: %s", err)
+	}
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		for i := range testData {
+			if err := bkt.Insert(borm.CreateID(now, uint32(i)), &testData[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error writing records: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing engine: %s", err)
+	}
+
+	// the outbox is delivered by the next run
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	db, err = borm.OpenTSWithOptions(dir, options)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	if err := db.AddWebhook(hook); err != nil {
+		t.Fatalf("Error adding webhook: %s", err)
+	}
+
+	expected := 0
+	for _, item := range testData {
+		if item.Category == "vehicle" {
+			expected++
+		}
+	}
+	var items []ItemTest
+	for len(items) < expected {
+		select {
+		case batch := <-received:
+			items = append(items, batch...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d forwarded records, got %d.", expected, len(items))
+		}
+	}
+	for _, item := range items {
+		if item.Category != "vehicle" {
+			t.Fatalf("Forwarded a record not matching the filter %v.", item)
+		}
+	}
+	if len(items) != expected {
+		t.Fatalf("Expected %d forwarded records, got %d.", expected, len(items))
+	}
+
+	if err := db.RemoveWebhook("vehicles"); err != nil {
+		t.Fatalf("Error removing webhook: %s", err)
+	}
+}
+
+func TestWebhookRollback(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var records []struct {
+			Key   string
+			Value ItemTest
+		}
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			t.Errorf("Error decoding request: %s", err)
+		}
+		for _, rec := range records {
+			received <- rec.Value.Name
+		}
+	}))
+	defer srv.Close()
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	err = db.AddWebhook(borm.Webhook{Name: "all", URL: srv.URL, FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error adding webhook: %s", err)
+	}
+
+	now := time.Now()
+	aborted := errors.New("aborted")
+	for _, name := range []string{"rolled back", "committed"} {
+		name := name
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Write(func(u borm.Updater) error {
+				if err := u.Insert(db.NewID(now), &ItemTest{Name: name}); err != nil {
+					return err
+				}
+				if name == "rolled back" {
+					return aborted
+				}
+				return nil
+			})
+		})
+		if name == "rolled back" && err != aborted {
+			t.Fatalf("Write got %v wanted %s.", err, aborted)
+		} else if name == "committed" && err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+
+	select {
+	case name := <-received:
+		if name != "committed" {
+			t.Fatalf("Forwarded %q wanted the committed record.", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the committed record to be forwarded.")
+	}
+	select {
+	case name := <-received:
+		t.Fatalf("Forwarded %q too.", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
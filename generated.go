@@ -0,0 +1,186 @@
+package borm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Marshaler is a record encoding itself in the format of the binary codec
+// without reflection, as generated by bormgen.
+type Marshaler interface {
+	MarshalBorm(dst []byte) ([]byte, error)
+}
+
+// Unmarshaler is a record decoding itself from the format of the binary
+// codec without reflection, as generated by bormgen.
+type Unmarshaler interface {
+	UnmarshalBorm(data []byte) error
+}
+
+// CodecGenerated is the codec of the records generated by bormgen, they
+// are compatible with the ones of CodecBinary.
+var CodecGenerated = Codec{GeneratedEncode, GeneratedDecode}
+
+// GeneratedEncode is the encoding func of the records implementing Marshaler
+func GeneratedEncode(value interface{}) ([]byte, error) {
+	return GeneratedAppendEncode(nil, value)
+}
+
+// GeneratedAppendEncode is the appender version of GeneratedEncode
+func GeneratedAppendEncode(dst []byte, value interface{}) ([]byte, error) {
+	m, ok := value.(Marshaler)
+	if !ok {
+		return dst, fmt.Errorf("%T doesn't implement borm.Marshaler, run bormgen", value)
+	}
+	return m.MarshalBorm(dst)
+}
+
+// GeneratedDecode is the decoding func of the records implementing Unmarshaler
+func GeneratedDecode(data []byte, value interface{}) error {
+	u, ok := value.(Unmarshaler)
+	if !ok {
+		return fmt.Errorf("%T doesn't implement borm.Unmarshaler, run bormgen", value)
+	}
+	return u.UnmarshalBorm(data)
+}
+
+// AppendBinaryInt appends a signed integer field of the binary format.
+func AppendBinaryInt(buf []byte, tag uint64, v int64) []byte {
+	return binary.AppendVarint(appendKey(buf, tag, wireVarint), v)
+}
+
+// AppendBinaryUint appends an unsigned integer field of the binary format.
+func AppendBinaryUint(buf []byte, tag uint64, v uint64) []byte {
+	return binary.AppendUvarint(appendKey(buf, tag, wireVarint), v)
+}
+
+// AppendBinaryBool appends a bool field of the binary format.
+func AppendBinaryBool(buf []byte, tag uint64, v bool) []byte {
+	if v {
+		return AppendBinaryUint(buf, tag, 1)
+	}
+	return AppendBinaryUint(buf, tag, 0)
+}
+
+// AppendBinaryFloat appends a float field of the binary format.
+func AppendBinaryFloat(buf []byte, tag uint64, v float64) []byte {
+	return binary.BigEndian.AppendUint64(appendKey(buf, tag, wireFixed64), math.Float64bits(v))
+}
+
+// AppendBinaryString appends a string field of the binary format.
+func AppendBinaryString(buf []byte, tag uint64, v string) []byte {
+	buf = binary.AppendUvarint(appendKey(buf, tag, wireBytes), uint64(len(v)))
+	return append(buf, v...)
+}
+
+// AppendBinaryBytes appends a bytes field of the binary format.
+func AppendBinaryBytes(buf []byte, tag uint64, v []byte) []byte {
+	buf = binary.AppendUvarint(appendKey(buf, tag, wireBytes), uint64(len(v)))
+	return append(buf, v...)
+}
+
+// AppendBinaryTime appends a time field of the binary format.
+func AppendBinaryTime(buf []byte, tag uint64, v time.Time) []byte {
+	return AppendBinaryInt(buf, tag, v.UnixNano())
+}
+
+// BinaryField is a field of a record of the binary format, see ScanBinary.
+type BinaryField struct {
+	Tag  uint64
+	wire int
+	num  uint64
+	raw  []byte
+}
+
+func (f BinaryField) mismatch(want string) error {
+	return fmt.Errorf("field %d of wire type %d doesn't decode into %s", f.Tag, f.wire, want)
+}
+
+// Int returns the value of a signed integer field.
+func (f BinaryField) Int() (int64, error) {
+	if f.wire != wireVarint {
+		return 0, f.mismatch("an int")
+	}
+	v, _ := binary.Varint(f.raw)
+	return v, nil
+}
+
+// Uint returns the value of an unsigned integer field.
+func (f BinaryField) Uint() (uint64, error) {
+	if f.wire != wireVarint {
+		return 0, f.mismatch("a uint")
+	}
+	return f.num, nil
+}
+
+// Bool returns the value of a bool field.
+func (f BinaryField) Bool() (bool, error) {
+	v, err := f.Uint()
+	return v != 0, err
+}
+
+// Float returns the value of a float field.
+func (f BinaryField) Float() (float64, error) {
+	if f.wire != wireFixed64 {
+		return 0, f.mismatch("a float")
+	}
+	return math.Float64frombits(f.num), nil
+}
+
+// Text returns the value of a string field. The interned strings of a
+// BinaryCodec with an intern table can't be decoded without it.
+func (f BinaryField) Text() (string, error) {
+	if f.wire == wireInterned {
+		return "", errors.New("interned string needs the intern table of its codec")
+	}
+	if f.wire != wireBytes {
+		return "", f.mismatch("a string")
+	}
+	return string(f.raw), nil
+}
+
+// Bytes returns a copy of the value of a bytes field.
+func (f BinaryField) Bytes() ([]byte, error) {
+	if f.wire != wireBytes {
+		return nil, f.mismatch("bytes")
+	}
+	return append([]byte(nil), f.raw...), nil
+}
+
+// Time returns the value of a time field.
+func (f BinaryField) Time() (time.Time, error) {
+	ns, err := f.Int()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns), nil
+}
+
+// ScanBinary calls fn with every field of a record of the binary format, in
+// the encoded order. A repeated field is passed once per element.
+func ScanBinary(data []byte, fn func(f BinaryField) error) error {
+	return scanBinary(data, func(tag uint64, wire int, num uint64, raw []byte) error {
+		return fn(BinaryField{Tag: tag, wire: wire, num: num, raw: raw})
+	})
+}
+
+var errFieldFound = errors.New("field found")
+
+// FindBinaryField returns the first field of the tag of a record of the
+// binary format, found is false when the record has none, e.g. a zero value.
+func FindBinaryField(data []byte, tag uint64) (f BinaryField, found bool, err error) {
+	err = ScanBinary(data, func(field BinaryField) error {
+		if field.Tag != tag {
+			return nil
+		}
+		f = field
+		return errFieldFound
+	})
+	if err == errFieldFound {
+		return f, true, nil
+	}
+	return f, false, err
+}
@@ -0,0 +1,97 @@
+package borm_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+//go:generate go run ./cmd/bormgen generated_test.go
+
+// GenItem is a record with the codec generated by bormgen.
+//
+//borm:generate
+type GenItem struct {
+	ID       int `borm:"1,index"`
+	Name     string
+	Category string `borm:",index"`
+	Score    float64
+	Ok       bool `borm:"9,index"`
+	Payload  []byte
+	Tags     []string
+	Created  time.Time
+	Skipped  string `borm:"-"`
+	internal int
+}
+
+func TestGeneratedCodec(t *testing.T) {
+	item := &GenItem{
+		ID:       7,
+		Name:     "car",
+		Category: "vehicles",
+		Score:    1.5,
+		Ok:       true,
+		Payload:  []byte{1, 2},
+		Tags:     []string{"a", "b"},
+		Created:  time.Unix(1600000000, 0),
+		Skipped:  "not written",
+	}
+	data, err := borm.GeneratedEncode(item)
+	if err != nil {
+		t.Fatalf("Error encoding: %s", err)
+	}
+	reflected, err := borm.BinaryEncode(item)
+	if err != nil {
+		t.Fatalf("Error encoding with the binary codec: %s", err)
+	}
+	if string(data) != string(reflected) {
+		t.Fatalf("Generated encoding %x differs from the binary codec %x.", data, reflected)
+	}
+
+	var result GenItem
+	if err := borm.GeneratedDecode(data, &result); err != nil {
+		t.Fatalf("Error decoding: %s", err)
+	}
+	item.Skipped = ""
+	if !reflect.DeepEqual(&result, item) {
+		t.Fatalf("Decoded %+v wanted %+v.", result, *item)
+	}
+
+	cols := GenItemColumns()
+	if len(cols) != 3 || cols[0].Name != "ID" || cols[1].Name != "Category" || cols[2].Name != "Ok" {
+		t.Fatalf("Generated columns are %v.", cols)
+	}
+	if v, ok := cols[0].Number(nil, data); !ok || v != 7 {
+		t.Fatalf("ID column got %v, %v wanted 7.", v, ok)
+	}
+	if v, ok := cols[1].String(nil, data); !ok || v != "vehicles" {
+		t.Fatalf("Category column got %v, %v wanted vehicles.", v, ok)
+	}
+	empty, _ := borm.GeneratedEncode(&GenItem{})
+	if v, ok := cols[2].Number(nil, empty); !ok || v != 0 {
+		t.Fatalf("Ok column of a zero record got %v, %v wanted 0.", v, ok)
+	}
+
+	if _, err := borm.GeneratedEncode(&ItemTest{}); err == nil {
+		t.Fatalf("Encoding a record without generated codec succeeded.")
+	}
+}
+
+func TestGeneratedBucket(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("generated", borm.GeneratedEncode, borm.GeneratedDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		bkt.SetAppendEncoder(borm.GeneratedAppendEncode)
+		if err := bkt.Insert("key1", &GenItem{ID: 1, Name: "car"}); err != nil {
+			t.Fatalf("Error inserting record: %s", err)
+		}
+		var result GenItem
+		if err := bkt.Get("key1", &result); err != nil || result.Name != "car" {
+			t.Fatalf("Error getting record: %v, got %+v", err, result)
+		}
+	})
+}
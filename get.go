@@ -7,6 +7,7 @@ package borm
 import (
 	"bytes"
 	"errors"
+	"fmt"
 
 	"github.com/boltdb/bolt"
 )
@@ -15,9 +16,22 @@ import (
 var ErrNotFound = errors.New("No data found for this key")
 var ErrBucketNotFound = errors.New("No data found for this key")
 
+// ErrStopIteration is returned by the callback of GetRange, ForEach or a
+// query to stop early, e.g. once the first match is found. It isn't an
+// error: the iteration returns nil and a query opens no further shard.
+var ErrStopIteration = errors.New("stop iteration")
+
+// stopped returns nil for an iteration stopped with ErrStopIteration.
+func stopped(err error) error {
+	if errors.Is(err, ErrStopIteration) {
+		return nil
+	}
+	return err
+}
+
 // Get retrieves a value from borm and puts it into result.  Result must be a pointer
 func (b *Bucket) Get(key string, result interface{}) error {
-	return b.store.db.View(func(tx *bolt.Tx) error {
+	return b.view(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket(b.name)
 		if bkt == nil {
 			return ErrBucketNotFound
@@ -28,13 +42,65 @@ func (b *Bucket) Get(key string, result interface{}) error {
 			return ErrNotFound
 		}
 
-		return b.decode(value, result)
+		return b.checksumError([]byte(key), b.decodeValue(value, result))
 	})
 }
 
+// IterOp is the step of a range read an IterError comes from.
+type IterOp string
+
+const (
+	// OpCursor is the walk of the bucket: the read transaction and the
+	// bucket lookup.
+	OpCursor IterOp = "cursor"
+	// OpDecode is the decoding of a record by Iterator.Read.
+	OpDecode IterOp = "decode"
+	// OpCallback is any other error returned by the callback.
+	OpCallback IterOp = "callback"
+)
+
+// IterError is the error of a GetRange, ForEach or query, with the key and
+// the shard file it failed at. Key is the last key read, "" when it failed
+// before the first one. errors.Is and errors.As see the wrapped error.
+type IterError struct {
+	Op    IterOp
+	Shard string
+	Key   string
+	Err   error
+}
+
+func (e *IterError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s error in shard %s: %s", e.Op, e.Shard, e.Err)
+	}
+	return fmt.Sprintf("%s error at key %q in shard %s: %s", e.Op, e.Key, e.Shard, e.Err)
+}
+
+// Unwrap returns the error of the read, the decoder or the callback.
+func (e *IterError) Unwrap() error {
+	return e.Err
+}
+
+// iterError wraps err into an IterError, unless it is nil, one already or
+// ErrStopIteration.
+func (b *Bucket) iterError(op IterOp, key []byte, err error) error {
+	var ie *IterError
+	if err == nil || errors.Is(err, ErrStopIteration) || errors.As(err, &ie) {
+		return err
+	}
+	return &IterError{Op: op, Shard: b.store.db.Path(), Key: string(key), Err: err}
+}
+
 // GetRange retrieves a set of values from the bolt that matches the key range.
+// The errors returned are IterErrors.
 func (b *Bucket) GetRange(start, end string, cb func(it *Iterator) error) error {
-	return b.store.db.View(func(tx *bolt.Tx) error {
+	return stopped(b.getRange(start, end, cb))
+}
+
+// getRange is GetRange returning ErrStopIteration, for the reads of several
+// ranges to stop too.
+func (b *Bucket) getRange(start, end string, cb func(it *Iterator) error) error {
+	err := b.view(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket(b.name)
 		if bkt == nil {
 			return ErrBucketNotFound
@@ -54,13 +120,15 @@ func (b *Bucket) GetRange(start, end string, cb func(it *Iterator) error) error
 			it.endKey = nil
 		}
 
-		return cb(&it)
+		return b.iterError(OpCallback, it.key, it.done(cb(&it)))
 	})
+	return b.iterError(OpCursor, nil, err)
 }
 
-// ForEach retrieves all values from the bolt.
+// ForEach retrieves all values from the bolt. The errors returned are
+// IterErrors.
 func (b *Bucket) ForEach(cb func(it *Iterator) error) error {
-	return b.store.db.View(func(tx *bolt.Tx) error {
+	err := b.view(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket(b.name)
 		if bkt == nil {
 			return ErrBucketNotFound
@@ -72,8 +140,9 @@ func (b *Bucket) ForEach(cb func(it *Iterator) error) error {
 			isFirst: true,
 		}
 
-		return cb(&it)
+		return b.iterError(OpCallback, it.key, it.done(cb(&it)))
 	})
+	return stopped(b.iterError(OpCursor, nil, err))
 }
 
 type Iterator struct {
@@ -85,9 +154,51 @@ type Iterator struct {
 
 	key   []byte
 	value []byte
+	// plainValue is the decompressed value, once Value is called.
+	plainValue []byte
+	// redacted is the plain value with the query redactions applied.
+	redacted []byte
+
+	// query is the state of the TSEngine query owning the iterator, or nil.
+	query *queryState
+
+	// err is the error of a value failing to be verified or decompressed,
+	// which ends the iteration.
+	err error
+}
+
+// done returns the error of the callback of the iterator, or the one
+// which ended the iteration.
+func (it *Iterator) done(err error) error {
+	if err == nil {
+		return it.err
+	}
+	return err
 }
 
 func (it *Iterator) Next() bool {
+	for {
+		if it.err != nil || !it.advance() {
+			return false
+		}
+		if it.query == nil {
+			return true
+		}
+		if it.query.err != nil {
+			return false
+		}
+		if it.query.filter != nil && !it.query.filter.Match(it.stored()) {
+			continue
+		}
+		it.query.next(it)
+		return true
+	}
+}
+
+// advance moves the cursor to the next record of the key range.
+func (it *Iterator) advance() bool {
+	it.plainValue = nil
+	it.redacted = nil
 	if !it.isFirst {
 		it.key, it.value = it.Cursor.Next()
 	} else {
@@ -101,25 +212,100 @@ func (it *Iterator) Next() bool {
 	if it.key == nil {
 		return false
 	}
-	if it.endKey == nil {
-		return true
+	if it.endKey != nil && bytes.Compare(it.key, it.endKey) > 0 {
+		return false
 	}
-
-	return bytes.Compare(it.key, it.endKey) <= 0
+	return true
 }
 
+// Read decodes the current value, the decoding errors are IterErrors of
+// OpDecode.
 func (it *Iterator) Read(value interface{}) error {
-	return it.B.decode(it.value, value)
+	if it.query != nil {
+		if err := it.query.decode(it.Value()); err != nil {
+			return err
+		}
+	}
+	if it.query != nil && (it.query.opts.Fields != nil || it.query.opts.Redact != nil) {
+		plain, err := it.visible()
+		if err != nil {
+			return it.B.iterError(OpDecode, it.key, it.B.checksumError(it.key, err))
+		}
+		if it.query.opts.Fields != nil {
+			plain, err = ProjectJSON(plain, it.query.opts.Fields)
+			if err != nil {
+				return it.B.iterError(OpDecode, it.key, err)
+			}
+		}
+		return it.B.iterError(OpDecode, it.key, it.B.decodeValue(plain, value))
+	}
+	return it.B.iterError(OpDecode, it.key, it.B.checksumError(it.key, it.B.decodeValue(it.value, value)))
 }
 
 func (it *Iterator) ReadWith(value interface{}, decoder DecodeFunc) error {
-	return decoder(it.value, value)
+	return it.B.iterError(OpDecode, it.key, decoder(it.Value(), value))
 }
 
 func (it *Iterator) Key() []byte {
 	return it.key
 }
 
+// Value returns the current value, decompressed if the shard is compressed
+// and without its checksum. The fields redacted by the query are dropped or
+// masked, a record that can't be redacted gives nil. A value failing its
+// checksum or decompression gives nil too, the iteration then ends with an
+// IterError of OpDecode.
 func (it *Iterator) Value() []byte {
-	return it.value
+	if it.query != nil && it.query.opts.Redact != nil {
+		if it.stored() == nil {
+			return nil
+		}
+		redacted, _ := it.visible()
+		return redacted
+	}
+	return it.stored()
+}
+
+// stored returns the current value as stored, only decompressed and
+// verified, or nil and ends the iteration if it fails to be.
+func (it *Iterator) stored() []byte {
+	plain, err := it.plain()
+	if err != nil {
+		if it.err == nil {
+			it.err = it.B.iterError(OpDecode, it.key, it.B.checksumError(it.key, err))
+		}
+		return nil
+	}
+	return plain
+}
+
+// visible returns the plain value with the query redactions applied, once.
+func (it *Iterator) visible() ([]byte, error) {
+	plain, err := it.plain()
+	if err != nil || plain == nil || it.query == nil || it.query.opts.Redact == nil {
+		return plain, err
+	}
+	if it.redacted == nil {
+		redacted, err := RedactJSON(plain, it.query.opts.Redact)
+		if err != nil {
+			return nil, err
+		}
+		it.redacted = redacted
+	}
+	return it.redacted, nil
+}
+
+// plain returns the current value decompressed and verified, once.
+func (it *Iterator) plain() ([]byte, error) {
+	if it.value == nil {
+		return nil, nil
+	}
+	if it.plainValue == nil {
+		plain, err := it.B.plain(it.value)
+		if err != nil {
+			return nil, err
+		}
+		it.plainValue = plain
+	}
+	return it.plainValue, nil
 }
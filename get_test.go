@@ -5,6 +5,7 @@
 package borm_test
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -40,3 +41,62 @@ func TestGet(t *testing.T) {
 		}
 	})
 }
+
+func TestGetRangeInto(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket for get range test: %s", err)
+		}
+		for i := 0; i < 5; i++ {
+			if err := bkt.Insert(fmt.Sprintf("key%d", i), &ItemTest{ID: i, Name: "Test Name"}); err != nil {
+				t.Fatalf("Error inserting data for test: %s", err)
+			}
+		}
+
+		var items []ItemTest
+		if err := bkt.GetRangeInto("key1", "key3", &items); err != nil {
+			t.Fatalf("Error getting range: %s", err)
+		}
+		if len(items) != 3 || items[0].ID != 1 || items[2].ID != 3 {
+			t.Fatalf("GetRangeInto returned %v.", items)
+		}
+
+		var ptrs []*ItemTest
+		if err := bkt.GetRangeInto("", "", &ptrs); err != nil {
+			t.Fatalf("Error getting range: %s", err)
+		}
+		if len(ptrs) != 5 || ptrs[4].ID != 4 {
+			t.Fatalf("GetRangeInto returned %d records.", len(ptrs))
+		}
+
+		if err := bkt.GetRangeInto("", "", items); err != borm.ErrNotSlice {
+			t.Fatalf("GetRangeInto didn't fail! Expected %s got %v", borm.ErrNotSlice, err)
+		}
+	})
+}
+
+func TestQueryInto(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		day := time.Now().AddDate(0, 0, -2)
+		var entries []borm.Entry
+		for i := 0; i < 4; i++ {
+			ts := day.Add(time.Duration(i) * time.Second)
+			entries = append(entries, borm.Entry{Time: ts, Value: &ItemTest{ID: i, Created: ts}})
+		}
+		if _, err := db.Backfill(entries, nil); err != nil {
+			t.Fatalf("Error backfilling: %s", err)
+		}
+		if err := db.Seal(day); err != nil {
+			t.Fatalf("Error sealing: %s", err)
+		}
+
+		var items []ItemTest
+		if err := db.QueryInto(day.AddDate(0, 0, -1), day.AddDate(0, 0, 1), &items); err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if len(items) != 4 || items[3].ID != 3 {
+			t.Fatalf("QueryInto returned %v.", items)
+		}
+	})
+}
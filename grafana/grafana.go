@@ -0,0 +1,298 @@
+// Package grafana serves a borm time series engine as a Grafana JSON
+// datasource (the SimpleJSON contract), so event counts, numeric series and
+// annotations can be charted without exporting the records to another
+// database.
+//
+// The handler answers the datasource endpoints:
+//
+//	GET  /             connection test
+//	POST /search       names of the series
+//	POST /query        data points of series
+//	POST /annotations  records matched by a filter as annotations
+//
+// The builtin series "count" is the number of records in every interval. A
+// target may restrict the records of its series with a borm filter
+// expression in its data, {"target": "count", "data": {"filter": "..."}}.
+package grafana
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// CountSeries is the name of the builtin series counting the records.
+const CountSeries = "count"
+
+// Series is a numeric series computed from the records.
+type Series struct {
+	Name string
+
+	// Value returns the sample of a record, records it returns false for
+	// are not part of the series.
+	Value func(key, value []byte) (float64, bool)
+
+	// Func is the range function evaluated at every interval over the
+	// samples of the interval.
+	Func borm.RangeFunc
+}
+
+// Options configures the handler.
+type Options struct {
+	Series []Series
+
+	// Annotation returns the title and text of the annotation of a record,
+	// nil uses the record value as the text.
+	Annotation func(key, value []byte) (title, text string)
+
+	// MaxAnnotations limits the annotations of a request, it defaults to 1000.
+	MaxAnnotations int
+}
+
+// Handler is the datasource http.Handler, mount it with http.StripPrefix
+// under a path other than the root.
+type Handler struct {
+	db   *borm.TSEngine
+	opts Options
+}
+
+// NewHandler returns the datasource handler for the engine.
+func NewHandler(db *borm.TSEngine, opts *Options) *Handler {
+	h := &Handler{db: db}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.MaxAnnotations <= 0 {
+		h.opts.MaxAnnotations = 1000
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result interface{}
+	var err error
+	switch path {
+	case "/search":
+		result = h.search()
+	case "/query":
+		var req queryRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			result, err = h.query(&req)
+		}
+	case "/annotations":
+		var req annotationRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			result, err = h.annotations(&req)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*requestError); ok {
+			status = http.StatusBadRequest
+		} else if _, ok := err.(*json.SyntaxError); ok {
+			status = http.StatusBadRequest
+		} else if _, ok := err.(*borm.FilterError); ok {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+type requestError struct {
+	msg string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+type timeRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type target struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	Data   struct {
+		Filter string `json:"filter"`
+	} `json:"data"`
+}
+
+type queryRequest struct {
+	Range         timeRange `json:"range"`
+	IntervalMs    int64     `json:"intervalMs"`
+	MaxDataPoints int64     `json:"maxDataPoints"`
+	Targets       []target  `json:"targets"`
+}
+
+// timeSeries is a series of the query response, a data point is the value
+// and the time in milliseconds.
+type timeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type annotationRequest struct {
+	Range      timeRange `json:"range"`
+	Annotation struct {
+		Name   string `json:"name"`
+		Query  string `json:"query"`
+		Enable bool   `json:"enable"`
+	} `json:"annotation"`
+}
+
+type annotation struct {
+	Annotation interface{} `json:"annotation"`
+	Time       int64       `json:"time"`
+	Title      string      `json:"title"`
+	Text       string      `json:"text"`
+}
+
+func (h *Handler) search() []string {
+	names := []string{CountSeries}
+	for _, s := range h.opts.Series {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func (h *Handler) series(name string) *Series {
+	for i := range h.opts.Series {
+		if h.opts.Series[i].Name == name {
+			return &h.opts.Series[i]
+		}
+	}
+	return nil
+}
+
+func (h *Handler) query(req *queryRequest) ([]timeSeries, error) {
+	if !req.Range.From.Before(req.Range.To) {
+		return nil, &requestError{"query range is empty"}
+	}
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if interval <= 0 && req.MaxDataPoints > 0 {
+		interval = req.Range.To.Sub(req.Range.From) / time.Duration(req.MaxDataPoints)
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	results := make([]timeSeries, 0, len(req.Targets))
+	for _, t := range req.Targets {
+		opts := &borm.QueryOptions{Filter: t.Data.Filter}
+		var points [][2]float64
+		var err error
+		if t.Target == CountSeries {
+			points, err = h.count(req.Range, interval, opts)
+		} else if s := h.series(t.Target); s != nil {
+			points, err = h.rangeQuery(req.Range, interval, opts, s)
+		} else {
+			return nil, &requestError{"unknown series " + t.Target}
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, timeSeries{Target: t.Target, Datapoints: points})
+	}
+	return results, nil
+}
+
+// count counts the records of every interval, a point is at the start of
+// its interval.
+func (h *Handler) count(r timeRange, interval time.Duration, opts *borm.QueryOptions) ([][2]float64, error) {
+	counts := make([]float64, int((r.To.Sub(r.From)+interval-1)/interval))
+	err := h.db.QueryWith(r.From, r.To, opts, func(it *borm.Iterator) error {
+		for it.Next() {
+			t := borm.TimeFromID(string(it.Key()))
+			if t.Before(r.From) || !t.Before(r.To) {
+				continue
+			}
+			counts[int(t.Sub(r.From)/interval)]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([][2]float64, len(counts))
+	for i, count := range counts {
+		points[i] = [2]float64{count, float64(millis(r.From.Add(time.Duration(i) * interval)))}
+	}
+	return points, nil
+}
+
+func (h *Handler) rangeQuery(r timeRange, interval time.Duration, opts *borm.QueryOptions, s *Series) ([][2]float64, error) {
+	results, err := h.db.QueryRange(r.From, r.To, opts, &borm.RangeQuery{
+		Value: s.Value,
+		Func:  s.Func,
+		Step:  interval,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([][2]float64, len(results))
+	for i, p := range results {
+		points[i] = [2]float64{p.Value, float64(millis(p.Time))}
+	}
+	return points, nil
+}
+
+func (h *Handler) annotations(req *annotationRequest) ([]annotation, error) {
+	if req.Annotation.Query == "" {
+		return nil, &requestError{"annotation has no query"}
+	}
+
+	var results []annotation
+	opts := &borm.QueryOptions{Filter: req.Annotation.Query}
+	err := h.db.QueryWith(req.Range.From, req.Range.To, opts, func(it *borm.Iterator) error {
+		for it.Next() && len(results) < h.opts.MaxAnnotations {
+			a := annotation{
+				Annotation: req.Annotation,
+				Time:       millis(borm.TimeFromID(string(it.Key()))),
+			}
+			if h.opts.Annotation != nil {
+				a.Title, a.Text = h.opts.Annotation(it.Key(), it.Value())
+			} else {
+				a.Text = string(it.Value())
+			}
+			results = append(results, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if results == nil {
+		results = []annotation{}
+	}
+	return results, nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,141 @@
+package grafana_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/grafana"
+)
+
+type event struct {
+	Action string
+	Bytes  float64
+}
+
+func post(t *testing.T, srv *httptest.Server, path, body string, result interface{}) int {
+	resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error posting %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("Error decoding %s response: %s", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grafana")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	var entries []borm.Entry
+	for i := 0; i < 30; i++ {
+		action := "allow"
+		if i%3 == 0 {
+			action = "block"
+		}
+		entries = append(entries, borm.Entry{Time: start.Add(time.Duration(i) * 10 * time.Second), Value: &event{Action: action, Bytes: float64(i)}})
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+
+	srv := httptest.NewServer(grafana.NewHandler(db, &grafana.Options{
+		Series: []grafana.Series{{
+			Name: "bytes",
+			Func: borm.AvgOverTime,
+			Value: func(key, value []byte) (float64, bool) {
+				var e event
+				return e.Bytes, json.Unmarshal(value, &e) == nil
+			},
+		}},
+		Annotation: func(key, value []byte) (string, string) {
+			var e event
+			json.Unmarshal(value, &e)
+			return e.Action, strconv.FormatFloat(e.Bytes, 'f', -1, 64)
+		},
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Error testing the connection: %v %v", resp, err)
+	}
+	resp.Body.Close()
+
+	var names []string
+	post(t, srv, "/search", `{"target": ""}`, &names)
+	if strings.Join(names, ",") != "count,bytes" {
+		t.Fatalf("Unexpected series %v.", names)
+	}
+
+	from := start.UTC().Format(time.RFC3339)
+	to := start.Add(5 * time.Minute).UTC().Format(time.RFC3339)
+	var series []struct {
+		Target     string
+		Datapoints [][2]float64
+	}
+	query := `{"range": {"from": "` + from + `", "to": "` + to + `"}, "intervalMs": 60000, "targets": [
+		{"target": "count", "refId": "A"},
+		{"target": "count", "refId": "B", "data": {"filter": "Action = \"block\""}},
+		{"target": "bytes", "refId": "C"}]}`
+	if status := post(t, srv, "/query", query, &series); status != http.StatusOK {
+		t.Fatalf("Error querying: %d", status)
+	}
+	if len(series) != 3 {
+		t.Fatalf("Expected 3 series, found %d.", len(series))
+	}
+	for _, s := range series[:2] {
+		if len(s.Datapoints) != 5 {
+			t.Fatalf("Expected 5 points, found %v.", s.Datapoints)
+		}
+	}
+	if series[0].Datapoints[0][0] != 6 || series[1].Datapoints[0][0] != 2 {
+		t.Fatalf("Unexpected counts %v %v.", series[0].Datapoints, series[1].Datapoints)
+	}
+	if series[0].Datapoints[1][1]-series[0].Datapoints[0][1] != 60000 {
+		t.Fatalf("Unexpected interval %v.", series[0].Datapoints)
+	}
+	if len(series[2].Datapoints) == 0 {
+		t.Fatalf("Series bytes has no points.")
+	}
+
+	if status := post(t, srv, "/query", `{"range": {"from": "`+from+`", "to": "`+to+`"}, "targets": [{"target": "missing"}]}`, nil); status != http.StatusBadRequest {
+		t.Fatalf("Expected a bad request for an unknown series, got %d.", status)
+	}
+
+	var annotations []struct {
+		Time  int64
+		Title string
+		Text  string
+	}
+	if status := post(t, srv, "/annotations", `{"range": {"from": "`+from+`", "to": "`+to+`"}, "annotation": {"name": "blocks", "query": "Action = \"block\""}}`, &annotations); status != http.StatusOK {
+		t.Fatalf("Error querying annotations: %d", status)
+	}
+	if len(annotations) != 10 || annotations[0].Title != "block" || annotations[1].Text != "3" {
+		t.Fatalf("Unexpected annotations %v.", annotations)
+	}
+}
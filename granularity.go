@@ -0,0 +1,49 @@
+package borm
+
+import (
+	"strconv"
+	"time"
+)
+
+// Granularity is the time span of a shard.
+type Granularity int
+
+const (
+	// Daily shards are named "<year>_<yearday>.ts".
+	Daily Granularity = iota
+	// Hourly shards are named "<year>_<yearday>_<hour>.ts".
+	Hourly
+)
+
+// Truncate returns the start of the shard containing t.
+func (g Granularity) Truncate(t time.Time) time.Time {
+	if g == Hourly {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Next returns the start of the shard following the one containing t.
+func (g Granularity) Next(t time.Time) time.Time {
+	if g == Hourly {
+		return g.Truncate(g.Truncate(t).Add(time.Hour + time.Minute))
+	}
+	start := g.Truncate(t)
+	return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, t.Location())
+}
+
+// Name returns the default file name of the shard containing t.
+func (g Granularity) Name(t time.Time) string {
+	name := strconv.Itoa(t.Year()) + "_" + strconv.Itoa(t.YearDay())
+	if g == Hourly {
+		name += "_" + strconv.Itoa(t.Hour())
+	}
+	return name + ".ts"
+}
+
+func (g Granularity) String() string {
+	if g == Hourly {
+		return "hourly"
+	}
+	return "daily"
+}
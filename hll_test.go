@@ -0,0 +1,36 @@
+package borm_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		sketch := borm.NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			sketch.Add([]byte(strconv.Itoa(i)))
+			sketch.Add([]byte(strconv.Itoa(i)))
+		}
+
+		count := sketch.Count()
+		if diff := float64(count) - float64(n); diff > 0.03*float64(n)+1 || -diff > 0.03*float64(n)+1 {
+			t.Fatalf("HyperLogLog counted %d distinct values wanted about %d.", count, n)
+		}
+
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Error marshaling sketch: %s", err)
+		}
+		other := borm.NewHyperLogLog()
+		if err := other.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Error unmarshaling sketch: %s", err)
+		}
+		other.Merge(sketch)
+		if other.Count() != count {
+			t.Fatalf("Merged sketch counted %d wanted %d.", other.Count(), count)
+		}
+	}
+}
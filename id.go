@@ -3,6 +3,8 @@ package borm
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -13,6 +15,62 @@ var (
 	idCounter uint32
 )
 
+// ErrInvalidID is returned for a key that is not an ObjectId
+var ErrInvalidID = errors.New("invalid ObjectId")
+
+// IDPrecision is the precision of the timestamp of an ObjectId.
+type IDPrecision int
+
+const (
+	// SecondPrecision ObjectIds are 16 hex digits, 4 bytes of unix seconds
+	// and 4 bytes of counter.
+	SecondPrecision IDPrecision = iota
+	// MillisecondPrecision ObjectIds are "m" and 20 hex digits, 6 bytes of
+	// unix milliseconds and 4 bytes of counter.
+	MillisecondPrecision
+	// NanosecondPrecision ObjectIds are "n" and 24 hex digits, 8 bytes of
+	// unix nanoseconds and 4 bytes of counter.
+	NanosecondPrecision
+)
+
+// idPrecisions are the precisions in the key order of their ObjectIds.
+var idPrecisions = []IDPrecision{SecondPrecision, MillisecondPrecision, NanosecondPrecision}
+
+const (
+	// idLen is the length of the second precision ObjectIds.
+	idLen = 16
+	// legacyIDLen is the length of the 12 bytes ObjectIds of older versions,
+	// which also start with the big endian timestamp.
+	legacyIDLen = 24
+	// milliIDLen and nanoIDLen are the lengths of the other precisions.
+	milliIDLen = 21
+	nanoIDLen  = 25
+)
+
+// prefix returns the prefix of the ObjectIds of the precision.
+func (p IDPrecision) prefix() string {
+	switch p {
+	case MillisecondPrecision:
+		return "m"
+	case NanosecondPrecision:
+		return "n"
+	default:
+		return ""
+	}
+}
+
+// lowKey and highKey bound the ObjectIds of the precision in key order.
+func (p IDPrecision) lowKey() string {
+	return p.prefix()
+}
+
+func (p IDPrecision) highKey() string {
+	if p == SecondPrecision {
+		return "f\xff"
+	}
+	return p.prefix() + "\xff"
+}
+
 // GenerateID returns a new unique ObjectId.
 func GenerateID() string {
 	return CreateID(time.Now(), atomic.AddUint32(&idCounter, 1))
@@ -28,14 +86,150 @@ func CreateID(t time.Time, count uint32) string {
 	return hex.EncodeToString(b[:])
 }
 
-// TimeFromID read time from id string.
+// CreateIDWithPrecision create a unique ObjectId with a timestamp of the
+// given precision. ObjectIds of a precision sort in time order among
+// themselves only.
+func CreateIDWithPrecision(t time.Time, count uint32, p IDPrecision) string {
+	var b [12]byte
+	switch p {
+	case MillisecondPrecision:
+		ms := uint64(t.UnixNano() / int64(time.Millisecond))
+		// Timestamp, 6 bytes, big endian
+		binary.BigEndian.PutUint16(b[:], uint16(ms>>32))
+		binary.BigEndian.PutUint32(b[2:], uint32(ms))
+		binary.BigEndian.PutUint32(b[6:], count)
+		return "m" + hex.EncodeToString(b[:10])
+	case NanosecondPrecision:
+		// Timestamp, 8 bytes, big endian
+		binary.BigEndian.PutUint64(b[:], uint64(t.UnixNano()))
+		binary.BigEndian.PutUint32(b[8:], count)
+		return "n" + hex.EncodeToString(b[:])
+	default:
+		return CreateID(t, count)
+	}
+}
+
+// suffixSeparator separates the timestamp of a composite key from its
+// suffix, it sorts after the hex digits of the counter of CreateID(t, 0) so
+// a range starting at a second includes the composite keys of the second.
+const suffixSeparator = ':'
+
+// CreateIDWithSuffix create a composite key of the timestamp of t, in
+// second precision, and a caller-defined discriminator such as a flow hash or
+// a sensor ID, which must make the key unique. Composite keys sort by time
+// with the ObjectIds.
+func CreateIDWithSuffix(t time.Time, suffix string) string {
+	var b [4]byte
+	// Timestamp, 4 bytes, big endian
+	binary.BigEndian.PutUint32(b[:], uint32(t.UTC().Unix()))
+	return hex.EncodeToString(b[:]) + string(suffixSeparator) + suffix
+}
+
+// nodeSeparator separates the node ID from the counter in the suffix of a
+// node key.
+const nodeSeparator = '.'
+
+// CreateNodeID create a composite key unique across nodes, its suffix is
+// the node ID and the counter.
+func CreateNodeID(t time.Time, node string, count uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], count)
+	return CreateIDWithSuffix(t, node+string(nodeSeparator)+hex.EncodeToString(b[:]))
+}
+
+// NodeFromID returns the node ID of a key created by CreateNodeID, empty
+// for the other keys.
+func NodeFromID(id string) string {
+	if !isComposite(id) || ValidateID(id) != nil {
+		return ""
+	}
+	suffix := id[9:]
+	i := strings.LastIndexByte(suffix, nodeSeparator)
+	if i <= 0 || len(suffix)-i-1 != 8 {
+		return ""
+	}
+	return suffix[:i]
+}
+
+// ParseID returns the time of an ObjectId or a composite key, and the
+// suffix of a composite key.
+func ParseID(id string) (time.Time, string, error) {
+	if err := ValidateID(id); err != nil {
+		return time.Time{}, "", err
+	}
+	suffix := ""
+	if isComposite(id) {
+		suffix = id[9:]
+	}
+	return TimeFromID(id), suffix, nil
+}
+
+// IDRange returns the keys bounding, both included, the second precision
+// ObjectIds and composite keys from the second of start to the second of end,
+// e.g. for Bucket.GetRange.
+func IDRange(start, end time.Time) (string, string) {
+	return CreateID(start, 0), CreateIDWithSuffix(end, "")[:8] + string(suffixSeparator+1)
+}
+
+func isComposite(id string) bool {
+	return len(id) > 8 && id[8] == suffixSeparator
+}
+
+// ValidateID checks that id is an ObjectId, as created by CreateID,
+// CreateIDWithPrecision or by older versions, or a composite key created by
+// CreateIDWithSuffix.
+func ValidateID(id string) error {
+	digits := id
+	switch {
+	case isComposite(id):
+		digits = id[:8]
+	case len(id) == idLen, len(id) == legacyIDLen:
+	case len(id) == milliIDLen && id[0] == 'm', len(id) == nanoIDLen && id[0] == 'n':
+		digits = id[1:]
+	default:
+		return ErrInvalidID
+	}
+	for i := 0; i < len(digits); i++ {
+		c := digits[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return ErrInvalidID
+		}
+	}
+	return nil
+}
+
+// TimeFromID read time from id string, whatever the precision of id. It
+// returns the zero time if id is not valid, see ValidateID.
 func TimeFromID(id string) time.Time {
-	bs, err := hex.DecodeString(id)
+	if ValidateID(id) != nil {
+		return time.Time{}
+	}
+
+	switch {
+	case isComposite(id):
+	case len(id) == milliIDLen:
+		bs, err := hex.DecodeString(id[1:13])
+		if err != nil {
+			return time.Time{}
+		}
+		// Timestamp, 6 bytes, big endian
+		ms := uint64(binary.BigEndian.Uint16(bs))<<32 | uint64(binary.BigEndian.Uint32(bs[2:]))
+		return time.Unix(0, int64(ms)*int64(time.Millisecond))
+	case len(id) == nanoIDLen:
+		bs, err := hex.DecodeString(id[1:17])
+		if err != nil {
+			return time.Time{}
+		}
+		// Timestamp, 8 bytes, big endian
+		return time.Unix(0, int64(binary.BigEndian.Uint64(bs)))
+	}
+
+	bs, err := hex.DecodeString(id[:8])
 	if err != nil {
 		return time.Time{}
 	}
 
 	// Timestamp, 4 bytes, big endian
-	unix := binary.BigEndian.Uint32(bs[:])
+	unix := binary.BigEndian.Uint32(bs)
 	return time.Unix(int64(unix), 0)
 }
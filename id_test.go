@@ -0,0 +1,194 @@
+package borm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestValidateID(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	for _, id := range []string{
+		borm.CreateID(now, 42),
+		"5a0b3c4d0000002a",
+		"5A0B3C4D0000002A",
+		// 12 bytes ObjectId of older versions
+		"5a0b3c4d1234567890abcdef",
+	} {
+		if err := borm.ValidateID(id); err != nil {
+			t.Fatalf("ID %q is rejected: %s", id, err)
+		}
+	}
+	if got := borm.TimeFromID("5a0b3c4d1234567890abcdef"); got.Unix() != 0x5a0b3c4d {
+		t.Fatalf("Time of a legacy ID is %s.", got)
+	}
+
+	for _, id := range []string{"", "5a0b", "5a0b3c4d0000002g", "5a0b3c4d0000002a0", "not an id at all"} {
+		if err := borm.ValidateID(id); err != borm.ErrInvalidID {
+			t.Fatalf("ID %q isn't rejected! Expected %s got %v", id, borm.ErrInvalidID, err)
+		}
+		if !borm.TimeFromID(id).IsZero() {
+			t.Fatalf("Time of invalid ID %q isn't zero.", id)
+		}
+	}
+}
+
+func TestTSGetInvalidID(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		var item ItemTest
+		if err := db.Get("bogus", &item); err != borm.ErrInvalidID {
+			t.Fatalf("Get with an invalid ID didn't fail! Expected %s got %v", borm.ErrInvalidID, err)
+		}
+	})
+}
+
+func FuzzTimeFromID(f *testing.F) {
+	f.Add("5a0b3c4d0000002a")
+	f.Add("5a0b3c4d1234567890abcdef")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, id string) {
+		// must not panic, and only valid ids have a time
+		tm := borm.TimeFromID(id)
+		if borm.ValidateID(id) != nil && !tm.IsZero() {
+			t.Fatalf("Invalid ID %q has time %s.", id, tm)
+		}
+	})
+}
+
+func FuzzIDRoundTrip(f *testing.F) {
+	f.Add(int64(0), uint32(0))
+	f.Add(time.Now().Unix(), uint32(1))
+	f.Fuzz(func(t *testing.T, unix int64, count uint32) {
+		if unix < 0 || unix > 1<<32-1 {
+			return
+		}
+		for _, p := range []borm.IDPrecision{borm.SecondPrecision, borm.MillisecondPrecision, borm.NanosecondPrecision} {
+			id := borm.CreateIDWithPrecision(time.Unix(unix, 0), count, p)
+			if err := borm.ValidateID(id); err != nil {
+				t.Fatalf("ID %q of %d is rejected: %s", id, unix, err)
+			}
+			if got := borm.TimeFromID(id).Unix(); got != unix {
+				t.Fatalf("ID %q round trips %d to %d.", id, unix, got)
+			}
+		}
+	})
+}
+
+func TestIDPrecision(t *testing.T) {
+	now := time.Now()
+	for _, p := range []borm.IDPrecision{borm.SecondPrecision, borm.MillisecondPrecision, borm.NanosecondPrecision} {
+		id := borm.CreateIDWithPrecision(now, 7, p)
+		if err := borm.ValidateID(id); err != nil {
+			t.Fatalf("ID %q of precision %d is rejected: %s", id, p, err)
+		}
+		got := borm.TimeFromID(id)
+		var want time.Time
+		switch p {
+		case borm.SecondPrecision:
+			want = time.Unix(now.Unix(), 0)
+		case borm.MillisecondPrecision:
+			want = now.Truncate(time.Millisecond)
+		default:
+			want = now
+		}
+		if !got.Equal(want) {
+			t.Fatalf("Time of ID %q of precision %d is %s, expected %s.", id, p, got, want)
+		}
+	}
+
+	a := borm.CreateIDWithPrecision(now, 9, borm.MillisecondPrecision)
+	b := borm.CreateIDWithPrecision(now.Add(time.Millisecond), 0, borm.MillisecondPrecision)
+	if a >= b {
+		t.Fatalf("Millisecond IDs %q and %q are not in time order.", a, b)
+	}
+}
+
+func TestTSMixedPrecision(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		precisions := []borm.IDPrecision{borm.SecondPrecision, borm.MillisecondPrecision, borm.NanosecondPrecision}
+		for i, p := range precisions {
+			id := borm.CreateIDWithPrecision(now, uint32(i), p)
+			err := db.Write(now, func(bkt *borm.Bucket) error {
+				return bkt.Insert(id, &ItemTest{ID: i, Created: now})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+
+			var item ItemTest
+			if err := db.Get(id, &item); err != nil || item.ID != i {
+				t.Fatalf("Error getting record %d: %v %v", i, item, err)
+			}
+		}
+
+		count := 0
+		err := db.Query(now.Add(-time.Minute), now.Add(time.Minute), func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != len(precisions) {
+			t.Fatalf("Query returned %d records, expected %d.", count, len(precisions))
+		}
+	})
+}
+
+func TestIDWithSuffix(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	for _, suffix := range []string{"", "sensor-1", "flow:1a2b3c4d5e6f"} {
+		id := borm.CreateIDWithSuffix(now, suffix)
+		got, gotSuffix, err := borm.ParseID(id)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %s", id, err)
+		}
+		if !got.Equal(now) || gotSuffix != suffix {
+			t.Fatalf("ID %q parses to %s %q.", id, got, gotSuffix)
+		}
+	}
+
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket for suffix test: %s", err)
+		}
+		for i := -1; i <= 1; i++ {
+			ts := now.Add(time.Duration(i) * time.Second)
+			for _, suffix := range []string{"a", "b"} {
+				if err := bkt.Insert(borm.CreateIDWithSuffix(ts, suffix), &ItemTest{ID: i}); err != nil {
+					t.Fatalf("Error inserting data for test: %s", err)
+				}
+			}
+		}
+
+		from, to := borm.IDRange(now, now)
+		var items []ItemTest
+		if err := bkt.GetRangeInto(from, to, &items); err != nil {
+			t.Fatalf("Error getting range: %s", err)
+		}
+		if len(items) != 2 || items[0].ID != 0 || items[1].ID != 0 {
+			t.Fatalf("IDRange of a second returned %v.", items)
+		}
+	})
+}
+
+func TestNodeID(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	id := borm.CreateNodeID(now, "edge.7", 42)
+	if got := borm.NodeFromID(id); got != "edge.7" {
+		t.Fatalf("Node of %q is %q.", id, got)
+	}
+	if got := borm.TimeFromID(id); !got.Equal(now) {
+		t.Fatalf("Time of %q is %s.", id, got)
+	}
+	for _, id := range []string{borm.GenerateID(), borm.CreateIDWithSuffix(now, "sensor-1")} {
+		if got := borm.NodeFromID(id); got != "" {
+			t.Fatalf("Expected no node of %q, got %q.", id, got)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package borm
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// DefaultDedupWindow is the default of TSOptions.DedupWindow.
+const DefaultDedupWindow = 24 * time.Hour
+
+// dedupBucket is the shard bucket of the request IDs of WriteIdempotent,
+// with the time they were written and the key of their record, dedupTimes
+// the one of the request IDs by time, to forget them past the window.
+var (
+	dedupBucket = []byte("_dedup")
+	dedupTimes  = []byte("_dedup_times")
+)
+
+// WriteIdempotent inserts the record at t unless the request ID was already
+// written within the dedup window, so the retries of an upstream delivery,
+// e.g. an HTTP ingestion or a Kafka redelivery, never insert it twice. It
+// returns the key of the record, the one of the first write for a retry,
+// and whether the request is a retry. The request IDs are remembered in the
+// shard of t in the transaction of the record, so retries must carry the
+// same time.
+func (db *TSEngine) WriteIdempotent(requestID string, t time.Time, record interface{}) (string, bool, error) {
+	if requestID == "" {
+		return "", false, errors.New("request ID is empty")
+	}
+	window := db.options.DedupWindow
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+
+	var key string
+	var retry, done bool
+	err := db.Write(t, func(bkt *Bucket) error {
+		value, err := bkt.encodeValue(record)
+		if err != nil {
+			return err
+		}
+		var k string
+		var r bool
+		err = bkt.store.db.Update(func(tx *bolt.Tx) error {
+			data := tx.Bucket(bkt.name)
+			if data == nil {
+				return ErrBucketNotFound
+			}
+			ids, err := tx.CreateBucketIfNotExists(dedupBucket)
+			if err != nil {
+				return err
+			}
+			times, err := tx.CreateBucketIfNotExists(dedupTimes)
+			if err != nil {
+				return err
+			}
+
+			now := db.now()
+			if err := forgetRequests(ids, times, now.Add(-window)); err != nil {
+				return err
+			}
+			if written := ids.Get([]byte(requestID)); written != nil {
+				k, r = string(written[8:]), true
+				return nil
+			}
+
+			k = db.NewID(t)
+			if err := bkt.put(tx, data, []byte(k), value); err != nil {
+				return err
+			}
+			var stamp [8]byte
+			binary.BigEndian.PutUint64(stamp[:], uint64(now.UnixNano()))
+			if err := ids.Put([]byte(requestID), append(stamp[:], k...)); err != nil {
+				return err
+			}
+			return times.Put(append(stamp[:], requestID...), nil)
+		})
+		// the callback is called again for the shadow engine, if any, which
+		// dedups the request on its own
+		if err == nil && !done {
+			key, retry, done = k, r, true
+		}
+		return err
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return key, retry, nil
+}
+
+// forgetRequests deletes the request IDs written before the time.
+func forgetRequests(ids, times *bolt.Bucket, before time.Time) error {
+	var limit [8]byte
+	binary.BigEndian.PutUint64(limit[:], uint64(before.UnixNano()))
+	c := times.Cursor()
+	for k, _ := c.First(); k != nil && string(k[:8]) < string(limit[:]); k, _ = c.First() {
+		if err := ids.Delete(k[8:]); err != nil {
+			return err
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSWriteIdempotent(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{DedupWindow: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	key, retry, err := db.WriteIdempotent("req-1", now, &ItemTest{Name: "car", Created: now})
+	if err != nil || retry {
+		t.Fatalf("Error writing request: %v, retry %v", err, retry)
+	}
+	again, retry, err := db.WriteIdempotent("req-1", now, &ItemTest{Name: "car", Created: now})
+	if err != nil || !retry || again != key {
+		t.Fatalf("Retry got %s, %v, %v wanted %s as a retry.", again, retry, err, key)
+	}
+	if _, retry, err := db.WriteIdempotent("req-2", now, &ItemTest{Name: "apple", Created: now}); err != nil || retry {
+		t.Fatalf("Error writing another request: %v, retry %v", err, retry)
+	}
+
+	count := func() int64 {
+		n, err := db.Count(now.Add(-time.Second), now.Add(time.Second), nil)
+		if err != nil {
+			t.Fatalf("Error counting: %s", err)
+		}
+		return n
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("Counted %d records wanted 2.", n)
+	}
+
+	var result ItemTest
+	if err := db.Get(key, &result); err != nil || result.Name != "car" {
+		t.Fatalf("Error reading %s: %v, got %v", key, err, result)
+	}
+
+	// past the window the request ID is forgotten
+	time.Sleep(100 * time.Millisecond)
+	if _, retry, err := db.WriteIdempotent("req-1", now, &ItemTest{Name: "car", Created: now}); err != nil || retry {
+		t.Fatalf("Write past the window got %v, retry %v", err, retry)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("Counted %d records wanted 3.", n)
+	}
+
+	if _, _, err := db.WriteIdempotent("", now, &ItemTest{}); err == nil {
+		t.Fatalf("Write without a request ID didn't fail!")
+	}
+}
@@ -0,0 +1,107 @@
+// Package influx ingests the InfluxDB line protocol into borm, so agents
+// speaking it, like telegraf with its influxdb output, write directly into a
+// time series engine or cluster.
+//
+// Every line is stored as a record of its time holding the Point, the series
+// of a point is its measurement and sorted tags, "cpu,host=a,region=eu",
+// which routes the points of a cluster.
+package influx
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// Point is a line of the protocol. Field values are float64, int64, uint64,
+// string or bool.
+type Point struct {
+	Measurement string
+	Tags        map[string]string `json:",omitempty"`
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// Series returns the series key of the point, the measurement followed by
+// the tags sorted by key.
+func (p *Point) Series() string {
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(escape(p.Measurement, measurementEscapes))
+	for _, k := range keys {
+		sb.WriteByte(',')
+		sb.WriteString(escape(k, tagEscapes))
+		sb.WriteByte('=')
+		sb.WriteString(escape(p.Tags[k], tagEscapes))
+	}
+	return sb.String()
+}
+
+// WriteEngine stores the points as records of the engine, with bulk
+// transactions per shard.
+func WriteEngine(db *borm.TSEngine, points []Point) error {
+	entries := make([]borm.Entry, len(points))
+	for i := range points {
+		entries[i] = borm.Entry{Time: points[i].Time, Value: &points[i]}
+	}
+	_, err := db.Backfill(entries, nil)
+	return err
+}
+
+// WriteCluster stores the points in the engines of their series.
+func WriteCluster(c *borm.Cluster, points []Point) error {
+	byEngine := map[*borm.TSEngine][]Point{}
+	var engines []*borm.TSEngine
+	for _, p := range points {
+		db := c.Engine(p.Series())
+		if _, ok := byEngine[db]; !ok {
+			engines = append(engines, db)
+		}
+		byEngine[db] = append(byEngine[db], p)
+	}
+	for _, db := range engines {
+		if err := WriteEngine(db, byEngine[db]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the /write endpoint of the InfluxDB HTTP API, the body is
+// parsed with the precision query parameter and written with write. It
+// answers 204 on success and 400 for a malformed body.
+func Handler(write func(points []Point) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		precision, err := ParsePrecision(r.URL.Query().Get("precision"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		points, err := Parse(r.Body, precision, time.Now())
+		if err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := err.(*ParseError); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if err := write(points); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
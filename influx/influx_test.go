@@ -0,0 +1,112 @@
+package influx_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/influx"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p, err := influx.ParseLine(`cpu\ load,host=a,region=eu\,west usage=0.5,count=3i,free=7u,up=true,msg="a \"b\", c" 1700000001000000000`, time.Nanosecond, now)
+	if err != nil {
+		t.Fatalf("Error parsing line: %s", err)
+	}
+	if p.Measurement != "cpu load" || p.Tags["host"] != "a" || p.Tags["region"] != "eu,west" {
+		t.Fatalf("Unexpected measurement or tags %v.", p)
+	}
+	if p.Fields["usage"] != 0.5 || p.Fields["count"] != int64(3) || p.Fields["free"] != uint64(7) ||
+		p.Fields["up"] != true || p.Fields["msg"] != `a "b", c` {
+		t.Fatalf("Unexpected fields %v.", p.Fields)
+	}
+	if !p.Time.Equal(time.Unix(1700000001, 0)) {
+		t.Fatalf("Unexpected time %s.", p.Time)
+	}
+	if series := p.Series(); series != `cpu\ load,host=a,region=eu\,west` {
+		t.Fatalf("Unexpected series %s.", series)
+	}
+
+	p, err = influx.ParseLine("mem used=1", time.Second, now)
+	if err != nil || !p.Time.Equal(now) || p.Tags != nil {
+		t.Fatalf("Unexpected point without timestamp %v %v.", p, err)
+	}
+
+	for _, line := range []string{"cpu", "cpu,host usage=1", "cpu usage=", `cpu msg="open`, "cpu usage=1 never", "cpu count=1.5i"} {
+		if _, err := influx.ParseLine(line, time.Second, now); err == nil {
+			t.Fatalf("Expected an error parsing %q.", line)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influx")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	srv := httptest.NewServer(influx.Handler(func(points []influx.Point) error {
+		return influx.WriteEngine(db, points)
+	}))
+	defer srv.Close()
+
+	now := time.Now().Truncate(time.Second)
+	body := "# telegraf\ncpu,host=a usage=1 " + formatSeconds(now.Add(-time.Minute)) + "\n\ncpu,host=b usage=2 " + formatSeconds(now) + "\n"
+	resp, err := http.Post(srv.URL+"/write?precision=s", "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error writing lines: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d.", resp.StatusCode)
+	}
+
+	var points []influx.Point
+	err = db.Query(now.Add(-time.Hour), now.Add(time.Second), func(it *borm.Iterator) error {
+		for it.Next() {
+			var p influx.Point
+			if err := json.Unmarshal(it.Value(), &p); err != nil {
+				return err
+			}
+			points = append(points, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying points: %s", err)
+	}
+	if len(points) != 2 || points[0].Tags["host"] != "a" || points[1].Fields["usage"] != 2.0 {
+		t.Fatalf("Unexpected points %v.", points)
+	}
+
+	resp, err = http.Post(srv.URL+"/write", "text/plain", strings.NewReader("cpu usage"))
+	if err != nil {
+		t.Fatalf("Error writing lines: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a malformed line, got %d.", resp.StatusCode)
+	}
+}
+
+func formatSeconds(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
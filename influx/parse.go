@@ -0,0 +1,246 @@
+package influx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseError is a malformed line.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// characters escaped by a backslash in the names
+const (
+	measurementEscapes = ", "
+	tagEscapes         = ",= "
+)
+
+func escape(s, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(chars, s[i]) >= 0 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// ParsePrecision returns the unit of the timestamps for the precision query
+// parameter of the write endpoint: n or ns, u or us, ms, s, m and h. The
+// empty precision is nanoseconds.
+func ParsePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	return 0, errors.New("invalid precision " + precision)
+}
+
+// Parse parses the lines of r, timestamps are in units of precision and
+// lines without one get the time now. Empty lines and comments are skipped.
+func Parse(r io.Reader, precision time.Duration, now time.Time) ([]Point, error) {
+	var points []Point
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := ParseLine(line, precision, now)
+		if err != nil {
+			return nil, &ParseError{Line: n, Msg: err.Error()}
+		}
+		points = append(points, p)
+	}
+	return points, scanner.Err()
+}
+
+// ParseLine parses a line, measurement[,tag=value...] field=value[,...] [timestamp].
+func ParseLine(line string, precision time.Duration, now time.Time) (Point, error) {
+	p := Point{Time: now}
+
+	key, rest := splitUnescaped(line, ' ')
+	name, tags := splitUnescaped(key, ',')
+	if name == "" {
+		return p, errors.New("missing measurement")
+	}
+	p.Measurement = unescape(name)
+	for tags != "" {
+		var tag string
+		tag, tags = splitUnescaped(tags, ',')
+		k, v := splitUnescaped(tag, '=')
+		if k == "" || v == "" {
+			return p, errors.New("invalid tag " + tag)
+		}
+		if p.Tags == nil {
+			p.Tags = map[string]string{}
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	fields, timestamp := splitFields(rest)
+	if fields == "" {
+		return p, errors.New("missing fields")
+	}
+	p.Fields = map[string]interface{}{}
+	for fields != "" {
+		var field string
+		field, fields = splitFieldValue(fields)
+		k, v := splitUnescaped(field, '=')
+		if k == "" || v == "" {
+			return p, errors.New("invalid field " + field)
+		}
+		value, err := parseValue(v)
+		if err != nil {
+			return p, err
+		}
+		p.Fields[unescape(k)] = value
+	}
+
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return p, errors.New("invalid timestamp " + timestamp)
+		}
+		p.Time = time.Unix(0, ts*int64(precision))
+	}
+	return p, nil
+}
+
+// splitUnescaped splits s at the first sep not escaped by a backslash.
+func splitUnescaped(s string, sep byte) (string, string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// splitFields splits the field set from the timestamp, spaces in quoted
+// string values don't separate them.
+func splitFields(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ' ':
+			if !quoted {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+// splitFieldValue splits the first field of the field set, commas in quoted
+// string values don't separate the fields.
+func splitFieldValue(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// unescapeString unescapes a string field value, only quotes and backslashes
+// are escaped in them.
+func unescapeString(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func parseValue(v string) (interface{}, error) {
+	switch {
+	case v[0] == '"':
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return nil, errors.New("unterminated string " + v)
+		}
+		return unescapeString(v[1 : len(v)-1]), nil
+	case v == "t" || v == "T" || v == "true" || v == "True" || v == "TRUE":
+		return true, nil
+	case v == "f" || v == "F" || v == "false" || v == "False" || v == "FALSE":
+		return false, nil
+	case strings.HasSuffix(v, "i"):
+		i, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid integer " + v)
+		}
+		return i, nil
+	case strings.HasSuffix(v, "u"):
+		u, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid unsigned integer " + v)
+		}
+		return u, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, errors.New("invalid float " + v)
+	}
+	return f, nil
+}
@@ -0,0 +1,112 @@
+package borm
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"time"
+)
+
+// ErrNotSlice is returned when the result of GetRangeInto or QueryInto is not
+// a pointer to a slice
+var ErrNotSlice = errors.New("result must be a pointer to a slice")
+
+// GetRangeInto decodes the values of the key range directly into new elements
+// appended to the slice result points to, e.g. a *[]T or a *[]*T. The slice
+// is grown once to the record count of the shard statistics when the whole
+// bucket is read from a sealed shard.
+func (b *Bucket) GetRangeInto(start, end string, result interface{}) error {
+	slice, err := sliceOf(result)
+	if err != nil {
+		return err
+	}
+	if start == "" && end == "" {
+		if stats, err := loadStats(b); err == nil && stats != nil {
+			growSlice(slice, stats.Count)
+		}
+	}
+	return b.GetRange(start, end, func(it *Iterator) error {
+		for it.Next() {
+			if err := appendDecoded(slice, b, it.value); err != nil {
+				return b.iterError(OpDecode, it.key, b.checksumError(it.key, err))
+			}
+		}
+		return nil
+	})
+}
+
+// QueryInto decodes the records in the time range into the slice result
+// points to, as GetRangeInto does, growing it with the statistics of the
+// sealed shards the range covers entirely.
+func (db *TSEngine) QueryInto(start, end time.Time, result interface{}) error {
+	slice, err := sliceOf(result)
+	if err != nil {
+		return err
+	}
+
+	return filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		return db.read(fileName, func(bkt *Bucket) error {
+			if position == positionMiddle {
+				if stats, err := loadStats(bkt); err == nil && stats != nil {
+					growSlice(slice, stats.Count)
+				}
+			}
+			return bkt.getRanges(keyRanges(position, start, end), func(it *Iterator) error {
+				for it.Next() {
+					if err := appendDecoded(slice, bkt, it.value); err != nil {
+						return bkt.iterError(OpDecode, it.key, bkt.checksumError(it.key, err))
+					}
+				}
+				return nil
+			})
+		})
+	})
+}
+
+func sliceOf(result interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return reflect.Value{}, ErrNotSlice
+	}
+	return v.Elem(), nil
+}
+
+// growSlice makes room for n more elements in the slice.
+func growSlice(slice reflect.Value, n int64) {
+	if n <= int64(slice.Cap()-slice.Len()) {
+		return
+	}
+	grown := reflect.MakeSlice(slice.Type(), slice.Len(), slice.Len()+int(n))
+	reflect.Copy(grown, slice)
+	slice.Set(grown)
+}
+
+// appendDecoded decodes value into a new last element of the slice.
+func appendDecoded(slice reflect.Value, b *Bucket, value []byte) error {
+	elemType := slice.Type().Elem()
+	n := slice.Len()
+	if n < slice.Cap() {
+		slice.SetLen(n + 1)
+		slice.Index(n).Set(reflect.Zero(elemType))
+	} else {
+		slice.Set(reflect.Append(slice, reflect.Zero(elemType)))
+	}
+
+	elem := slice.Index(n)
+	var target interface{}
+	if elemType.Kind() == reflect.Ptr {
+		ptr := reflect.New(elemType.Elem())
+		elem.Set(ptr)
+		target = ptr.Interface()
+	} else {
+		target = elem.Addr().Interface()
+	}
+	if err := b.decodeValue(value, target); err != nil {
+		slice.SetLen(n)
+		return err
+	}
+	return nil
+}
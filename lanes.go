@@ -0,0 +1,128 @@
+package borm
+
+import (
+	"sync"
+	"time"
+)
+
+// ReadPriority is the lane of a shard read.
+type ReadPriority int
+
+const (
+	// ForegroundRead is the lane of the queries and the lookups, the
+	// default. Foreground reads never wait for the background ones.
+	ForegroundRead ReadPriority = iota
+
+	// BackgroundRead is the lane of the maintenance: purge, rewrite, merge,
+	// backfill, seal, snapshot, view rebuild and verification. A background
+	// read of a shard waits for the foreground reads in progress to finish,
+	// at most TSOptions.BackgroundYield, so the maintenance time-slices
+	// around the queries shard by shard, batch by batch for a rewrite.
+	BackgroundRead
+)
+
+// DefaultBackgroundYield is the default of TSOptions.BackgroundYield.
+const DefaultBackgroundYield = 100 * time.Millisecond
+
+// LaneStats are the reads of a lane.
+type LaneStats struct {
+	// Active are the reads in progress, Waiting the ones waiting for the
+	// foreground ones to finish.
+	Active  int
+	Waiting int
+
+	// Reads are the reads started so far, Waited those which waited,
+	// WaitTime their total wait and MaxWait the longest one.
+	Reads    int64
+	Waited   int64
+	WaitTime time.Duration
+	MaxWait  time.Duration
+}
+
+// readLanes schedules the shard reads of the lanes.
+type readLanes struct {
+	mu    sync.Mutex
+	stats [2]LaneStats
+	// idle is closed once no foreground read is in progress.
+	idle chan struct{}
+}
+
+// enter starts a read in the lane, waiting for the foreground reads to
+// finish for a background one, and returns the function ending it.
+func (l *readLanes) enter(lane ReadPriority, yield time.Duration) func() {
+	l.mu.Lock()
+	s := &l.stats[lane]
+	if lane == BackgroundRead && l.stats[ForegroundRead].Active > 0 && yield > 0 {
+		idle := l.idle
+		s.Waiting++
+		l.mu.Unlock()
+
+		start := time.Now()
+		timer := time.NewTimer(yield)
+		select {
+		case <-idle:
+		case <-timer.C:
+		}
+		timer.Stop()
+		wait := time.Since(start)
+
+		l.mu.Lock()
+		s.Waiting--
+		s.Waited++
+		s.WaitTime += wait
+		if wait > s.MaxWait {
+			s.MaxWait = wait
+		}
+	}
+	if lane == ForegroundRead && s.Active == 0 {
+		l.idle = make(chan struct{})
+	}
+	s.Active++
+	s.Reads++
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		s.Active--
+		if lane == ForegroundRead && s.Active == 0 {
+			close(l.idle)
+		}
+	}
+}
+
+// laneStats returns the statistics of the foreground and background lanes.
+func (l *readLanes) laneStats() (foreground, background LaneStats) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats[ForegroundRead], l.stats[BackgroundRead]
+}
+
+// backgroundYield returns the longest wait of a background read.
+func (db *TSEngine) backgroundYield() time.Duration {
+	if db.options.BackgroundYield != 0 {
+		return db.options.BackgroundYield
+	}
+	return DefaultBackgroundYield
+}
+
+// readBackground reads the shard file in the background lane.
+func (db *TSEngine) readBackground(fileName string, cb func(bkt *Bucket) error) error {
+	return db.readIn(BackgroundRead, fileName, cb)
+}
+
+// lane returns the lane of the query.
+func (opts *QueryOptions) lane() ReadPriority {
+	if opts == nil {
+		return ForegroundRead
+	}
+	return opts.Priority
+}
+
+// reader returns the readFunc of the lane.
+func (db *TSEngine) reader(lane ReadPriority) readFunc {
+	if lane == BackgroundRead {
+		return db.readBackground
+	}
+	return db.read
+}
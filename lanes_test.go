@@ -0,0 +1,112 @@
+package borm_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func lanesEngine(t *testing.T, yield time.Duration) (*borm.TSEngine, func()) {
+	dir := tempdir(t)
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{BackgroundYield: yield})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	now := time.Now()
+	if err := db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), &ItemTest{Name: "car", Created: now})
+	}); err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// holdForeground runs a foreground query blocked until release is closed.
+func holdForeground(db *borm.TSEngine, release chan struct{}) (started, done chan struct{}) {
+	started, done = make(chan struct{}), make(chan struct{})
+	now := time.Now()
+	var once sync.Once
+	go func() {
+		defer close(done)
+		db.Query(now.Add(-time.Minute), now.Add(time.Second), func(it *borm.Iterator) error {
+			once.Do(func() { close(started) })
+			<-release
+			return nil
+		})
+	}()
+	return started, done
+}
+
+func backgroundCount(db *borm.TSEngine) chan int64 {
+	counted := make(chan int64, 1)
+	now := time.Now()
+	go func() {
+		n, err := db.Count(now.Add(-time.Minute), now.Add(time.Second), &borm.QueryOptions{Priority: borm.BackgroundRead})
+		if err != nil {
+			n = -1
+		}
+		counted <- n
+	}()
+	return counted
+}
+
+func TestBackgroundYields(t *testing.T) {
+	db, closeDB := lanesEngine(t, time.Hour)
+	defer closeDB()
+
+	release := make(chan struct{})
+	started, done := holdForeground(db, release)
+	<-started
+	counted := backgroundCount(db)
+	select {
+	case <-counted:
+		t.Fatalf("Background read ran during the foreground one.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s := db.Stats().Background; s.Waiting != 1 {
+		t.Fatalf("Background lane is %+v wanted a read waiting.", s)
+	}
+
+	close(release)
+	<-done
+	if n := <-counted; n != 1 {
+		t.Fatalf("Background count is %d wanted 1.", n)
+	}
+	stats := db.Stats()
+	if b := stats.Background; b.Waited != 1 || b.WaitTime < 50*time.Millisecond || b.Active != 0 || b.Reads != 1 {
+		t.Fatalf("Background lane is %+v.", b)
+	}
+	if f := stats.Foreground; f.Active != 0 || f.Reads == 0 || f.Waited != 0 {
+		t.Fatalf("Foreground lane is %+v.", f)
+	}
+}
+
+func TestBackgroundTimeSlices(t *testing.T) {
+	db, closeDB := lanesEngine(t, 20*time.Millisecond)
+	defer closeDB()
+
+	release := make(chan struct{})
+	started, done := holdForeground(db, release)
+	defer func() {
+		close(release)
+		<-done
+	}()
+	<-started
+	select {
+	case n := <-backgroundCount(db):
+		if n != 1 {
+			t.Fatalf("Background count is %d wanted 1.", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Background read starved past its yield.")
+	}
+	if b := db.Stats().Background; b.MaxWait < 20*time.Millisecond {
+		t.Fatalf("Background lane is %+v wanted a wait of the yield.", b)
+	}
+}
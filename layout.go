@@ -0,0 +1,116 @@
+package borm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ShardBucket is a bucket every shard hosts besides the records, e.g. the
+// indexes or the counters of a dataset, see TSOptions.Buckets.
+type ShardBucket struct {
+	Name string
+
+	// Encoder and Decoder are the codec of the bucket, the ones of the
+	// engine by default. RecordType, when set, is the type of its records.
+	Encoder    EncodeFunc
+	Decoder    DecodeFunc
+	RecordType interface{}
+}
+
+// checkBuckets validates the names of the shard buckets.
+func checkBuckets(options *TSOptions) error {
+	reserved := map[string]bool{"": true, dataBucket: true, string(versionsBucket): true}
+	for i := range options.Views {
+		reserved[string(options.Views[i].bucketName())] = true
+	}
+	for _, spec := range options.Buckets {
+		if reserved[spec.Name] {
+			return fmt.Errorf("shard bucket name %q is reserved or declared twice", spec.Name)
+		}
+		reserved[spec.Name] = true
+	}
+	return nil
+}
+
+// createBuckets creates the shard buckets missing in the store.
+func (db *TSEngine) createBuckets(store *Store) error {
+	if len(db.options.Buckets) == 0 {
+		return nil
+	}
+	for _, spec := range db.options.Buckets {
+		if spec.RecordType != nil {
+			store.RegisterType(spec.Name, spec.RecordType)
+		}
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		for _, spec := range db.options.Buckets {
+			if tx.Bucket([]byte(spec.Name)) != nil {
+				continue
+			}
+			if _, err := tx.CreateBucket([]byte(spec.Name)); err != nil {
+				return err
+			}
+			if err := store.describe(tx, db.shardBucketOf(store, spec), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// shardBucketOf returns the bucket of the spec in the store.
+func (db *TSEngine) shardBucketOf(store *Store, spec ShardBucket) *Bucket {
+	encoder, decoder := spec.Encoder, spec.Decoder
+	if encoder == nil {
+		encoder = db.options.Encoder
+	}
+	if decoder == nil {
+		decoder = db.options.Decoder
+	}
+	bkt := store.newBucket(spec.Name, encoder, decoder)
+	bkt.SetChecksums(db.options.Checksums)
+	bkt.AddWriteHook(db.countWrite(bkt))
+	return bkt
+}
+
+// shardBucket returns the named bucket of the shard of the records bucket,
+// the records bucket itself for "".
+func (db *TSEngine) shardBucket(records *Bucket, name string) (*Bucket, error) {
+	if name == "" || name == dataBucket {
+		return records, nil
+	}
+	for _, spec := range db.options.Buckets {
+		if spec.Name == name {
+			return db.shardBucketOf(records.store, spec), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s is not a shard bucket", ErrBucketNotFound, name)
+}
+
+// WriteBucket calls cb with the named bucket of TSOptions.Buckets in the
+// shard of t, as Write does with the records bucket. The writes of the
+// shard buckets don't go through the views, the rules, the webhooks nor the
+// change log of the records.
+func (db *TSEngine) WriteBucket(name string, t time.Time, cb func(bkt *Bucket) error) error {
+	return db.Write(t, func(records *Bucket) error {
+		bkt, err := db.shardBucket(records, name)
+		if err != nil {
+			return err
+		}
+		return cb(bkt)
+	})
+}
+
+// ReadBucket calls cb with the named bucket of TSOptions.Buckets in every
+// shard of the time range, as Read does with the records bucket.
+func (db *TSEngine) ReadBucket(name string, start, end time.Time, cb func(bkt *Bucket) error) error {
+	return db.Read(start, end, func(records *Bucket) error {
+		bkt, err := db.shardBucket(records, name)
+		if err != nil {
+			return err
+		}
+		return cb(bkt)
+	})
+}
@@ -0,0 +1,82 @@
+package borm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+type counter struct {
+	Requests int
+}
+
+func TestShardBuckets(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWith(dir, borm.WithBuckets(
+		borm.ShardBucket{Name: "alerts", Encoder: borm.JSONEncode, Decoder: borm.JSONDecode},
+		borm.ShardBucket{Name: "counters", RecordType: counter{}},
+	))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	if err := db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), &ItemTest{Name: "car", Created: now})
+	}); err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		at := now.Add(-time.Duration(i) * time.Second)
+		if err := db.WriteBucket("alerts", at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(db.NewID(at), &ItemTest{Name: "alert", Created: at})
+		}); err != nil {
+			t.Fatalf("Error writing alert: %s", err)
+		}
+	}
+	if err := db.WriteBucket("counters", now, func(bkt *borm.Bucket) error {
+		if err := bkt.Insert("0", &ItemTest{Name: "wrong"}); err == nil {
+			t.Fatalf("Inserting a record of the wrong type succeeded.")
+		}
+		return bkt.Insert("requests", &counter{Requests: 7})
+	}); err != nil {
+		t.Fatalf("Error writing counter: %s", err)
+	}
+
+	start, end := now.Add(-time.Minute), now.Add(time.Second)
+	count := func(bucket string) int64 {
+		n, err := db.Count(start, end, &borm.QueryOptions{Bucket: bucket})
+		if err != nil {
+			t.Fatalf("Error counting %q: %s", bucket, err)
+		}
+		return n
+	}
+	if records, alerts := count(""), count("alerts"); records != 1 || alerts != 3 {
+		t.Fatalf("Counts are %d records and %d alerts wanted 1 and 3.", records, alerts)
+	}
+	rec, err := db.FindFirst(start, end, "")
+	if err != nil || rec == nil {
+		t.Fatalf("Error finding record: %v, %s", rec, err)
+	}
+
+	var c counter
+	if err := db.ReadBucket("counters", now, now, func(bkt *borm.Bucket) error {
+		return bkt.Get("requests", &c)
+	}); err != nil || c.Requests != 7 {
+		t.Fatalf("Counter is %+v, %v wanted 7 requests.", c, err)
+	}
+
+	if _, err := db.Count(start, end, &borm.QueryOptions{Bucket: "missing"}); !errors.Is(err, borm.ErrBucketNotFound) {
+		t.Fatalf("Counting an undeclared bucket got %v wanted %s.", err, borm.ErrBucketNotFound)
+	}
+	if _, err := borm.OpenTSWith(filepath.Join(dir, "reserved"), borm.WithBuckets(borm.ShardBucket{Name: "attack"})); err == nil {
+		t.Fatalf("Declaring the records bucket succeeded.")
+	}
+}
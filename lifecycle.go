@@ -0,0 +1,60 @@
+package borm
+
+import "os"
+
+// ShardFunc is called with a shard on a lifecycle transition.
+type ShardFunc func(info ShardInfo)
+
+// lifecycle are the registered lifecycle callbacks.
+type lifecycle struct {
+	created, sealed, deleted []ShardFunc
+}
+
+// OnShardCreated registers fn to be called when a shard file is created, by
+// a write or a backfill.
+func (db *TSEngine) OnShardCreated(fn ShardFunc) {
+	db.rulesMu.Lock()
+	defer db.rulesMu.Unlock()
+	db.lifecycle.created = append(db.lifecycle.created, fn)
+}
+
+// OnShardSealed registers fn to be called when a shard is sealed, either by
+// Seal or after the engine rolled over to a newer shard.
+func (db *TSEngine) OnShardSealed(fn ShardFunc) {
+	db.rulesMu.Lock()
+	defer db.rulesMu.Unlock()
+	db.lifecycle.sealed = append(db.lifecycle.sealed, fn)
+}
+
+// OnShardDeleted registers fn to be called when a shard file is deleted by
+// retention, Size is 0 then.
+func (db *TSEngine) OnShardDeleted(fn ShardFunc) {
+	db.rulesMu.Lock()
+	defer db.rulesMu.Unlock()
+	db.lifecycle.deleted = append(db.lifecycle.deleted, fn)
+}
+
+// notifyShard calls the callbacks selected by which with the shard file. The
+// callbacks run synchronously, right after the transition.
+func (db *TSEngine) notifyShard(which func(l *lifecycle) []ShardFunc, fileName string) {
+	db.rulesMu.Lock()
+	fns := which(&db.lifecycle)
+	db.rulesMu.Unlock()
+	if len(fns) == 0 {
+		return
+	}
+
+	info := ShardInfo{Path: fileName}
+	if shard, err := db.openShard(fileName, db.location()); err == nil {
+		info = shard.Info()
+	} else if fi, err := os.Stat(fileName); err == nil {
+		info.Size = fi.Size()
+	}
+	for _, fn := range fns {
+		fn(info)
+	}
+}
+
+func shardCreated(l *lifecycle) []ShardFunc { return l.created }
+func shardSealed(l *lifecycle) []ShardFunc  { return l.sealed }
+func shardDeleted(l *lifecycle) []ShardFunc { return l.deleted }
@@ -0,0 +1,336 @@
+// Package limit protects the HTTP handlers and gRPC services of borm from
+// request storms: it limits the rate of the requests of every client and of
+// all the clients, and the heavy queries running at once. The requests past
+// a limit are answered 429 Too Many Requests with a Retry-After, the gRPC
+// calls fail with ResourceExhausted and a retry-after header, so e.g. the
+// refresh storm of a dashboard can't starve the ingestion.
+//
+//	l := limit.New(&limit.Options{ClientRate: 20, ClientBurst: 40, GlobalRate: 500, MaxHeavy: 4})
+//	http.Handle("/grafana/", a.Require(auth.Read, l.Limit(http.StripPrefix("/grafana", grafanaHandler))))
+//	s := grpc.NewServer(grpc.ChainStreamInterceptor(a.StreamInterceptor(auth.Read), l.StreamInterceptor()))
+//
+// Behind the auth package the clients are the subjects of the tokens, the
+// remote addresses otherwise.
+package limit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/borm/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxClients is the default of Options.MaxClients.
+const DefaultMaxClients = 10000
+
+// Options configures a Limiter, a zero rate or MaxHeavy is no limit.
+type Options struct {
+	// ClientRate is the requests per second of a client, ClientBurst the
+	// requests it may send at once, ClientRate rounded up by default.
+	ClientRate  float64
+	ClientBurst int
+
+	// GlobalRate and GlobalBurst are the same for all the clients.
+	GlobalRate  float64
+	GlobalBurst int
+
+	// MaxHeavy is the number of heavy requests served at once.
+	MaxHeavy int
+
+	// Heavy reports whether a request is a heavy query, DefaultHeavy by
+	// default.
+	Heavy func(r *http.Request) bool
+
+	// Client returns the client of a request, DefaultClient by default.
+	Client func(r *http.Request) string
+
+	// Exempt reports whether a request is never limited, e.g. the writes of
+	// the ingestion.
+	Exempt func(r *http.Request) bool
+
+	// HeavyMethod, ContextClient and ExemptMethod are Heavy, Client and
+	// Exempt for the gRPC calls, given their full method, e.g.
+	// "/borm.replica.Replica/Snapshot", or their context. They default to
+	// DefaultHeavyMethod and DefaultContextClient.
+	HeavyMethod   func(method string) bool
+	ContextClient func(ctx context.Context) string
+	ExemptMethod  func(method string) bool
+
+	// MaxClients bounds the clients whose rate is tracked, the idle ones
+	// are forgotten past it. It defaults to DefaultMaxClients.
+	MaxClients int
+}
+
+// DefaultHeavy reports the queries, annotations and exports as heavy, the
+// requests whose path ends with /query, /annotations or /export.
+func DefaultHeavy(r *http.Request) bool {
+	switch path.Base(r.URL.Path) {
+	case "query", "annotations", "export":
+		return true
+	}
+	return false
+}
+
+// DefaultClient returns the subject of the principal of the auth package,
+// or the host of the remote address.
+func DefaultClient(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// DefaultHeavyMethod reports the queries, snapshots and exports as heavy,
+// the gRPC methods named Query, Snapshot or Export.
+func DefaultHeavyMethod(method string) bool {
+	switch path.Base(method) {
+	case "Query", "Snapshot", "Export":
+		return true
+	}
+	return false
+}
+
+// DefaultContextClient returns the subject of the principal of the auth
+// package, or the host of the peer of the gRPC call.
+func DefaultContextClient(ctx context.Context) string {
+	if p := auth.FromContext(ctx); p != nil {
+		return p.Subject
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// Stats are the requests of a Limiter.
+type Stats struct {
+	Allowed int64
+	// RejectedClient, RejectedGlobal and RejectedHeavy are the requests
+	// rejected by the client rate, the global rate and MaxHeavy.
+	RejectedClient int64
+	RejectedGlobal int64
+	RejectedHeavy  int64
+
+	// Heavy are the heavy requests in progress, Clients the ones tracked.
+	Heavy   int
+	Clients int
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token of the bucket filled at rate up to burst, or returns
+// how long until one is available.
+func (b *bucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// Limiter limits the requests of the handlers it wraps.
+type Limiter struct {
+	opts Options
+
+	mu      sync.Mutex
+	global  bucket
+	clients map[string]*bucket
+	heavy   int
+	stats   Stats
+}
+
+// New returns a limiter with the options.
+func New(opts *Options) *Limiter {
+	l := &Limiter{clients: map[string]*bucket{}}
+	if opts != nil {
+		l.opts = *opts
+	}
+	if l.opts.ClientBurst <= 0 {
+		l.opts.ClientBurst = int(math.Ceil(l.opts.ClientRate))
+	}
+	if l.opts.GlobalBurst <= 0 {
+		l.opts.GlobalBurst = int(math.Ceil(l.opts.GlobalRate))
+	}
+	if l.opts.Heavy == nil {
+		l.opts.Heavy = DefaultHeavy
+	}
+	if l.opts.Client == nil {
+		l.opts.Client = DefaultClient
+	}
+	if l.opts.HeavyMethod == nil {
+		l.opts.HeavyMethod = DefaultHeavyMethod
+	}
+	if l.opts.ContextClient == nil {
+		l.opts.ContextClient = DefaultContextClient
+	}
+	if l.opts.MaxClients <= 0 {
+		l.opts.MaxClients = DefaultMaxClients
+	}
+	l.global = bucket{tokens: float64(l.opts.GlobalBurst), last: time.Now()}
+	return l
+}
+
+// Stats returns the requests of the limiter.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := l.stats
+	stats.Heavy = l.heavy
+	stats.Clients = len(l.clients)
+	return stats
+}
+
+// Limit serves the requests within the limits with next.
+func (l *Limiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.opts.Exempt != nil && l.opts.Exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		heavy := l.opts.MaxHeavy > 0 && l.opts.Heavy(r)
+		if retry, ok := l.admit(l.opts.Client(r), heavy); !ok {
+			w.Header().Set("Retry-After", retryAfter(retry))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		if heavy {
+			defer l.done()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryInterceptor limits the unary gRPC calls, the calls past a limit fail
+// with codes.ResourceExhausted.
+func (l *Limiter) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := l.call(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor limits the gRPC streams as UnaryInterceptor the calls,
+// a heavy stream counts until it ends.
+func (l *Limiter) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.call(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+// call admits the gRPC call of the method, it returns the release of the
+// call or the error of its rejection, with a retry-after header.
+func (l *Limiter) call(ctx context.Context, method string) (func(), error) {
+	if l.opts.ExemptMethod != nil && l.opts.ExemptMethod(method) {
+		return func() {}, nil
+	}
+	heavy := l.opts.MaxHeavy > 0 && l.opts.HeavyMethod(method)
+	if retry, ok := l.admit(l.opts.ContextClient(ctx), heavy); !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(retry)))
+		return nil, status.Error(codes.ResourceExhausted, http.StatusText(http.StatusTooManyRequests))
+	}
+	if heavy {
+		return l.done, nil
+	}
+	return func() {}, nil
+}
+
+// retryAfter returns the seconds of the wait, rounded up.
+func retryAfter(retry time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(retry.Seconds())))
+}
+
+// admit counts the request of the client against the limits, or returns
+// when to retry it. A request rejected by the global rate gives its token
+// back to the client.
+func (l *Limiter) admit(client string, heavy bool) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	if heavy && l.heavy >= l.opts.MaxHeavy {
+		l.stats.RejectedHeavy++
+		return time.Second, false
+	}
+	var b *bucket
+	if l.opts.ClientRate > 0 {
+		b = l.clients[client]
+		if b == nil {
+			l.forget(now)
+			b = &bucket{tokens: float64(l.opts.ClientBurst), last: now}
+			l.clients[client] = b
+		}
+		if ok, retry := b.take(now, l.opts.ClientRate, l.opts.ClientBurst); !ok {
+			l.stats.RejectedClient++
+			return retry, false
+		}
+	}
+	if l.opts.GlobalRate > 0 {
+		if ok, retry := l.global.take(now, l.opts.GlobalRate, l.opts.GlobalBurst); !ok {
+			if b != nil {
+				b.tokens++
+			}
+			l.stats.RejectedGlobal++
+			return retry, false
+		}
+	}
+	if heavy {
+		l.heavy++
+	}
+	l.stats.Allowed++
+	return 0, true
+}
+
+// forget removes the idle clients, whose buckets are full again, once
+// MaxClients are tracked. l.mu is held.
+func (l *Limiter) forget(now time.Time) {
+	if len(l.clients) < l.opts.MaxClients {
+		return
+	}
+	idle := time.Duration(float64(l.opts.ClientBurst) / l.opts.ClientRate * float64(time.Second))
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= idle {
+			delete(l.clients, client)
+		}
+	}
+}
+
+func (l *Limiter) done() {
+	l.mu.Lock()
+	l.heavy--
+	l.mu.Unlock()
+}
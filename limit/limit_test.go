@@ -0,0 +1,194 @@
+package limit_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm/limit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func get(h http.Handler, path, client string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = client + ":1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestClientRate(t *testing.T) {
+	l := limit.New(&limit.Options{
+		ClientRate:  1,
+		ClientBurst: 3,
+		Exempt:      func(r *http.Request) bool { return strings.HasSuffix(r.URL.Path, "/write") },
+	})
+	h := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 3; i++ {
+		if w := get(h, "/api/stats", "10.0.0.1"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d got %d.", i, w.Code)
+		}
+	}
+	w := get(h, "/api/stats", "10.0.0.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Request past the burst got %d, Retry-After %q.", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get(h, "/api/stats", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Fatalf("Request of another client got %d.", w.Code)
+	}
+	if w := get(h, "/influx/write", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("Exempt write got %d.", w.Code)
+	}
+	if s := l.Stats(); s.Allowed != 4 || s.RejectedClient != 1 || s.Clients != 2 {
+		t.Fatalf("Stats are %+v.", s)
+	}
+}
+
+func TestGlobalRate(t *testing.T) {
+	l := limit.New(&limit.Options{GlobalRate: 2})
+	h := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := []int{}
+	for _, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		codes = append(codes, get(h, "/api/stats", client).Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("Codes are %v wanted the third request rejected.", codes)
+	}
+	if s := l.Stats(); s.RejectedGlobal != 1 {
+		t.Fatalf("Stats are %+v.", s)
+	}
+}
+
+func TestMaxHeavy(t *testing.T) {
+	l := limit.New(&limit.Options{MaxHeavy: 1})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/query" {
+			close(started)
+			<-release
+		}
+	}))
+
+	done := make(chan int)
+	go func() { done <- get(h, "/api/query", "10.0.0.1").Code }()
+	<-started
+	if w := get(h, "/federation/query", "10.0.0.2"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Second heavy query got %d.", w.Code)
+	}
+	if w := get(h, "/api/shards", "10.0.0.2"); w.Code != http.StatusOK {
+		t.Fatalf("Light request got %d during the heavy query.", w.Code)
+	}
+	if s := l.Stats(); s.Heavy != 1 || s.RejectedHeavy != 1 {
+		t.Fatalf("Stats are %+v.", s)
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("Heavy query got %d.", code)
+	}
+	if s := l.Stats(); s.Heavy != 0 {
+		t.Fatalf("Heavy queries are %d after the query.", s.Heavy)
+	}
+}
+
+func TestClientRefill(t *testing.T) {
+	l := limit.New(&limit.Options{ClientRate: 50})
+	h := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 50; i++ {
+		get(h, "/", "10.0.0.1")
+	}
+	if w := get(h, "/", "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request past the burst got %d.", w.Code)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if w := get(h, "/", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("Request after the refill got %d.", w.Code)
+	}
+}
+
+func TestGlobalRefund(t *testing.T) {
+	l := limit.New(&limit.Options{ClientRate: 0.001, ClientBurst: 2, GlobalRate: 1000, GlobalBurst: 1})
+	h := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if w := get(h, "/", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("First request got %d.", w.Code)
+	}
+	if w := get(h, "/", "10.0.0.1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request past the global burst got %d.", w.Code)
+	}
+	// the rejected request didn't use the last token of the client
+	time.Sleep(5 * time.Millisecond)
+	if w := get(h, "/", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("Request after the global refill got %d.", w.Code)
+	}
+	if s := l.Stats(); s.RejectedGlobal != 1 || s.RejectedClient != 0 {
+		t.Fatalf("Stats are %+v.", s)
+	}
+}
+
+// stream is a server stream of a gRPC call.
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context { return s.ctx }
+
+func TestInterceptors(t *testing.T) {
+	l := limit.New(&limit.Options{ClientRate: 1, ClientBurst: 2, MaxHeavy: 1})
+	client := func(host string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(host), Port: 1234}})
+	}
+
+	unary := l.UnaryInterceptor()
+	call := func(host string) error {
+		_, err := unary(client(host), nil, &grpc.UnaryServerInfo{FullMethod: "/borm.Test/Stats"},
+			func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := call("10.0.0.1"); err != nil {
+			t.Fatalf("Call %d failed: %s", i, err)
+		}
+	}
+	if err := call("10.0.0.1"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Call past the burst got %v.", err)
+	}
+	if err := call("10.0.0.2"); err != nil {
+		t.Fatalf("Call of another client failed: %s", err)
+	}
+
+	// a heavy stream counts until it ends
+	intercept := l.StreamInterceptor()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- intercept(nil, &stream{ctx: client("10.0.0.3")}, &grpc.StreamServerInfo{FullMethod: "/borm.federation.Federation/Query"},
+			func(srv interface{}, ss grpc.ServerStream) error {
+				close(started)
+				<-release
+				return nil
+			})
+	}()
+	<-started
+	err := intercept(nil, &stream{ctx: client("10.0.0.4")}, &grpc.StreamServerInfo{FullMethod: "/borm.replica.Replica/Snapshot"},
+		func(srv interface{}, ss grpc.ServerStream) error { return nil })
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Second heavy stream got %v.", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Heavy stream failed: %s", err)
+	}
+	if s := l.Stats(); s.Heavy != 0 || s.RejectedHeavy != 1 || s.RejectedClient != 1 {
+		t.Fatalf("Stats are %+v.", s)
+	}
+}
@@ -0,0 +1,216 @@
+package borm
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrManagerClosed is returned by EngineManager.Open after Shutdown.
+var ErrManagerClosed = errors.New("engine manager is shut down")
+
+// ErrDatasetExists is returned by EngineManager.Open for a name already open.
+var ErrDatasetExists = errors.New("dataset is already open")
+
+// DefaultRetentionInterval is the default of ManagerOptions.RetentionInterval.
+const DefaultRetentionInterval = time.Hour
+
+// Dataset is an engine of an EngineManager, e.g. the events of a type.
+type Dataset struct {
+	Name    string
+	Path    string
+	Options *TSOptions
+
+	// Retention, when set, is applied by the scheduler of the manager.
+	Retention *RetentionPolicy
+}
+
+// ManagerOptions allows you set different options from the defaults for an
+// EngineManager.
+type ManagerOptions struct {
+	// MaxOpenFiles, when set, is the open file budget shared by the
+	// engines, see SetMaxOpenFiles.
+	MaxOpenFiles int
+
+	// RetentionInterval is how often the scheduler applies the retention of
+	// the datasets, DefaultRetentionInterval by default.
+	RetentionInterval time.Duration
+
+	// OnError is called with the errors of the scheduled jobs, which are
+	// logged to Logger without it.
+	OnError func(dataset string, err error)
+	Logger  *log.Logger
+}
+
+// EngineManager owns the engines of the independent datasets of a process.
+// The engines share the open file budget and the read lanes, so the
+// maintenance of one dataset yields to the queries of all of them, a single
+// scheduler applies their retention, and Shutdown closes them all.
+type EngineManager struct {
+	opts  ManagerOptions
+	lanes *readLanes
+
+	mu       sync.Mutex
+	engines  map[string]*TSEngine
+	policies map[string]RetentionPolicy
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewEngineManager returns a manager with no dataset and starts its
+// scheduler.
+func NewEngineManager(opts *ManagerOptions) *EngineManager {
+	m := &EngineManager{
+		lanes:    &readLanes{},
+		engines:  map[string]*TSEngine{},
+		policies: map[string]RetentionPolicy{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if opts != nil {
+		m.opts = *opts
+	}
+	if m.opts.RetentionInterval <= 0 {
+		m.opts.RetentionInterval = DefaultRetentionInterval
+	}
+	if m.opts.MaxOpenFiles > 0 {
+		SetMaxOpenFiles(m.opts.MaxOpenFiles)
+	}
+	go m.schedule()
+	return m
+}
+
+// Open opens the engine of the dataset.
+func (m *EngineManager) Open(ds Dataset) (*TSEngine, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrManagerClosed
+	}
+	if _, ok := m.engines[ds.Name]; ok {
+		return nil, ErrDatasetExists
+	}
+
+	var options TSOptions
+	if ds.Options != nil {
+		options = *ds.Options
+	}
+	options.lanes = m.lanes
+	db, err := OpenTSWithOptions(ds.Path, &options)
+	if err != nil {
+		return nil, err
+	}
+	m.engines[ds.Name] = db
+	if ds.Retention != nil {
+		m.policies[ds.Name] = *ds.Retention
+	}
+	return db, nil
+}
+
+// Engine returns the engine of the dataset, nil if it isn't open.
+func (m *EngineManager) Engine(name string) *TSEngine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.engines[name]
+}
+
+// Names returns the names of the open datasets, sorted.
+func (m *EngineManager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.namesLocked()
+}
+
+func (m *EngineManager) namesLocked() []string {
+	names := make([]string, 0, len(m.engines))
+	for name := range m.engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunRetention applies the retention of the datasets now, as the scheduler
+// does, and returns the first error.
+func (m *EngineManager) RunRetention() error {
+	m.mu.Lock()
+	type job struct {
+		name   string
+		db     *TSEngine
+		policy RetentionPolicy
+	}
+	var jobs []job
+	for name, policy := range m.policies {
+		jobs = append(jobs, job{name, m.engines[name], policy})
+	}
+	m.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].name < jobs[j].name })
+
+	var first error
+	for _, j := range jobs {
+		if _, err := j.db.ApplyRetention(j.policy, false); err != nil {
+			m.report(j.name, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// schedule applies the retention every interval until Shutdown.
+func (m *EngineManager) schedule() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.opts.RetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.RunRetention()
+		}
+	}
+}
+
+func (m *EngineManager) report(name string, err error) {
+	if m.opts.OnError != nil {
+		m.opts.OnError(name, err)
+		return
+	}
+	logger := m.opts.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("engine failed to apply the retention of %s: %s", name, err)
+}
+
+// Shutdown stops the scheduler, waiting for a job in progress, then closes
+// the engines, and returns the first error.
+func (m *EngineManager) Shutdown() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.mu.Unlock()
+
+	close(m.stop)
+	<-m.done
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var first error
+	for _, name := range m.namesLocked() {
+		if err := m.engines[name].Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(m.engines, name)
+	}
+	return first
+}
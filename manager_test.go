@@ -0,0 +1,71 @@
+package borm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestEngineManager(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	m := borm.NewEngineManager(&borm.ManagerOptions{RetentionInterval: 10 * time.Millisecond})
+	defer m.Shutdown()
+
+	old := time.Now().AddDate(0, 0, -10)
+	for _, name := range []string{"flows", "alerts"} {
+		ds := borm.Dataset{Name: name, Path: filepath.Join(dir, name)}
+		if name == "flows" {
+			ds.Retention = &borm.RetentionPolicy{MaxAge: 5 * 24 * time.Hour}
+		}
+		db, err := m.Open(ds)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", name, err)
+		}
+		if _, err := db.Backfill([]borm.Entry{{Time: old, Value: &ItemTest{Name: name}}}, nil); err != nil {
+			t.Fatalf("Error backfilling %s: %s", name, err)
+		}
+	}
+	if _, err := m.Open(borm.Dataset{Name: "flows", Path: filepath.Join(dir, "other")}); !errors.Is(err, borm.ErrDatasetExists) {
+		t.Fatalf("Opening flows again got %v wanted %s.", err, borm.ErrDatasetExists)
+	}
+	if names := m.Names(); len(names) != 2 || names[0] != "alerts" || names[1] != "flows" {
+		t.Fatalf("Names are %v.", names)
+	}
+
+	flows, alerts := m.Engine("flows"), m.Engine("alerts")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		shards, err := flows.Shards()
+		if err != nil {
+			t.Fatalf("Error listing shards: %s", err)
+		}
+		if len(shards) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The scheduler didn't apply the retention of flows, shards are %+v.", shards)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n, err := alerts.Count(old.Add(-time.Hour), old.Add(time.Hour), nil); err != nil || n != 1 {
+		t.Fatalf("Count of alerts is %d, %v wanted 1 kept without retention.", n, err)
+	}
+	if f, a := flows.Stats().Foreground, alerts.Stats().Foreground; f.Reads != a.Reads || f.Reads == 0 {
+		t.Fatalf("Foreground lanes are %+v and %+v wanted them shared.", f, a)
+	}
+
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("Error shutting down: %s", err)
+	}
+	if m.Engine("alerts") != nil {
+		t.Fatalf("Engine alerts is still open.")
+	}
+	if _, err := m.Open(borm.Dataset{Name: "late", Path: filepath.Join(dir, "late")}); !errors.Is(err, borm.ErrManagerClosed) {
+		t.Fatalf("Opening after shutdown got %v wanted %s.", err, borm.ErrManagerClosed)
+	}
+}
@@ -0,0 +1,248 @@
+package borm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifestFile describes the layout of the engine directory, it starts with
+// a dot so it is not listed as a shard.
+const manifestFile = ".borm.json"
+
+// FormatVersion is the version of the on-disk format of the engine. The
+// directories of older versions are migrated when opened, those of newer
+// versions are refused.
+const FormatVersion = 1
+
+// keyCodec is the format of the record keys, the ObjectIds.
+const keyCodec = "objectid"
+
+// Manifest is the description of an engine directory, written when it is
+// first opened.
+type Manifest struct {
+	Version       int
+	Created       time.Time
+	ShardInterval string
+	NamePattern   string `json:",omitempty"`
+	// ShardExtension is TSOptions.ShardExtension.
+	ShardExtension string `json:",omitempty"`
+	// KeyCodec is the format of the keys, ValueCodec the codec of the
+	// records, see BucketInfo.Codec.
+	KeyCodec   string
+	ValueCodec string
+}
+
+// ErrIncompatible is matched by errors.Is with the CompatErrors.
+var ErrIncompatible = errors.New("incompatible engine directory")
+
+// CompatError is returned by OpenTSWithOptions when the engine directory
+// was written by a newer version, with another codec or another layout of
+// the shards.
+type CompatError struct {
+	Path string
+	// Field is the field of the Manifest that doesn't match.
+	Field      string
+	Have, Want string
+}
+
+func (e *CompatError) Error() string {
+	return fmt.Sprintf("engine directory %s has the %s %s, wanted %s", e.Path, e.Field, e.Have, e.Want)
+}
+
+// Is makes errors.Is match the error with ErrIncompatible.
+func (e *CompatError) Is(err error) bool {
+	return err == ErrIncompatible
+}
+
+// migrations upgrade the directories of older versions, migrations[v]
+// migrates from the version v to v+1. The directories of version 0 predate
+// the manifest, they are only stamped.
+var migrations = []func(db *TSEngine, manifest *Manifest) error{
+	0: func(db *TSEngine, manifest *Manifest) error { return nil },
+}
+
+// newManifest returns the manifest of the options of the engine.
+func (db *TSEngine) newManifest() *Manifest {
+	return &Manifest{
+		Version:        FormatVersion,
+		Created:        db.now().UTC(),
+		ShardInterval:  db.options.ShardInterval.String(),
+		NamePattern:    db.options.NamePattern,
+		ShardExtension: db.options.ShardExtension,
+		KeyCodec:       keyCodec,
+		ValueCodec:     codecName(db.options.Encoder),
+	}
+}
+
+// checkManifest stamps a fresh directory, refuses the directories of newer
+// versions, of another codec or of another layout, and migrates those of
+// older versions.
+func (db *TSEngine) checkManifest() error {
+	manifest, err := db.Manifest()
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		fresh, err := isFresh(db.basePath)
+		if err != nil {
+			return err
+		}
+		if fresh {
+			if db.boltOptions().ReadOnly {
+				return nil
+			}
+			if err := os.MkdirAll(db.basePath, 0755); err != nil {
+				return err
+			}
+			return writeManifest(db.basePath, db.newManifest(), db.fileMode())
+		}
+		manifest = db.newManifest()
+		manifest.Version = 0
+	}
+
+	if manifest.Version > FormatVersion {
+		return &CompatError{Path: db.basePath, Field: "version", Have: strconv.Itoa(manifest.Version), Want: strconv.Itoa(FormatVersion)}
+	}
+	if manifest.KeyCodec != "" && manifest.KeyCodec != keyCodec {
+		return &CompatError{Path: db.basePath, Field: "key codec", Have: manifest.KeyCodec, Want: keyCodec}
+	}
+	want := codecName(db.options.Encoder)
+	if manifest.ValueCodec != "" && manifest.ValueCodec != "custom" && want != "custom" && manifest.ValueCodec != want {
+		return &CompatError{Path: db.basePath, Field: "value codec", Have: manifest.ValueCodec, Want: want}
+	}
+	if err := db.checkLayout(manifest); err != nil {
+		return err
+	}
+	if manifest.Version == FormatVersion {
+		return nil
+	}
+
+	for manifest.Version < FormatVersion {
+		if err := migrations[manifest.Version](db, manifest); err != nil {
+			return fmt.Errorf("migrating %s from version %d: %s", db.basePath, manifest.Version, err)
+		}
+		manifest.Version++
+	}
+	if manifest.KeyCodec == "" {
+		manifest.KeyCodec = keyCodec
+	}
+	if manifest.ValueCodec == "" {
+		manifest.ValueCodec = want
+	}
+	return writeManifest(db.basePath, manifest, db.fileMode())
+}
+
+// checkLayout refuses a directory whose shards the engine names otherwise,
+// it would misread and misname them.
+func (db *TSEngine) checkLayout(manifest *Manifest) error {
+	layout := db.newManifest()
+	// the manifests of version 0 have no interval
+	if manifest.ShardInterval != "" && manifest.ShardInterval != layout.ShardInterval {
+		return &CompatError{Path: db.basePath, Field: "shard interval", Have: manifest.ShardInterval, Want: layout.ShardInterval}
+	}
+	if manifest.NamePattern != layout.NamePattern {
+		return &CompatError{Path: db.basePath, Field: "name pattern", Have: strconv.Quote(manifest.NamePattern), Want: strconv.Quote(layout.NamePattern)}
+	}
+	// the extension of the names of a NameWith is unknown
+	if manifest.ShardExtension != "" && layout.ShardExtension != "" && manifest.ShardExtension != layout.ShardExtension {
+		return &CompatError{Path: db.basePath, Field: "shard extension", Have: manifest.ShardExtension, Want: layout.ShardExtension}
+	}
+	return nil
+}
+
+// Init prepares the engine directory of a fresh deployment up front: it
+// creates the directories of the engine and of the hot shard, checks they
+// are writable and writes the manifest, unless the directory has one, e.g.
+// the engine was opened read-only.
+func (db *TSEngine) Init() error {
+	hot := filepath.Dir(db.nameWith(db.now()))
+	for _, dir := range []string{db.basePath, hot} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := checkWritable(dir); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := db.Manifest()
+	if err != nil || manifest != nil {
+		return err
+	}
+	return writeManifest(db.basePath, db.newManifest(), db.fileMode())
+}
+
+// Manifest returns the manifest of the engine directory, nil if it has none.
+func (db *TSEngine) Manifest() (*Manifest, error) {
+	return readManifest(db.basePath)
+}
+
+func readManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", manifestFile, err)
+	}
+	return manifest, nil
+}
+
+func writeManifest(dir string, manifest *Manifest, mode os.FileMode) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, manifestFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// isFresh returns whether the directory is missing or has only dot-files.
+func isFresh(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkWritable creates and removes a file in dir.
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".init-")
+	if err == nil {
+		_, err = f.Write([]byte("borm"))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if rerr := os.Remove(f.Name()); err == nil {
+			err = rerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("engine directory %s is not writable: %s", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSInit(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fresh", "engine")
+	db, err := borm.OpenTSWithOptions(path, &borm.TSOptions{NamePattern: "%Y/%m/%d.ts", FileMode: 0600})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", path, err)
+	}
+	defer db.Close()
+
+	// the manifest is written on first open, with the mode of the engine
+	opened, err := db.Manifest()
+	if err != nil || opened == nil {
+		t.Fatalf("Expected the manifest written on open, got %v: %v", opened, err)
+	}
+	fi, err := os.Stat(filepath.Join(path, ".borm.json"))
+	if err != nil {
+		t.Fatalf("Error reading the manifest: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("Manifest has the mode %v wanted 0600.", fi.Mode().Perm())
+	}
+	if err := db.Init(); err != nil {
+		t.Fatalf("Error initializing %s: %s", path, err)
+	}
+	now := time.Now()
+	if fi, err := os.Stat(filepath.Join(path, now.Format("2006"), now.Format("01"))); err != nil || !fi.IsDir() {
+		t.Fatalf("Expected the directory of the hot shard created: %v", err)
+	}
+	manifest, err := db.Manifest()
+	if err != nil {
+		t.Fatalf("Error reading the manifest: %s", err)
+	}
+	if manifest == nil || manifest.Version != borm.FormatVersion || manifest.ShardInterval != "daily" || manifest.NamePattern != "%Y/%m/%d.ts" || !manifest.Created.Equal(opened.Created) {
+		t.Fatalf("Unexpected manifest %+v", manifest)
+	}
+
+	// Init again keeps the manifest, the manifest is not a shard
+	if err := db.Init(); err != nil {
+		t.Fatalf("Error initializing %s again: %s", path, err)
+	}
+	if again, err := db.Manifest(); err != nil || !again.Created.Equal(manifest.Created) {
+		t.Fatalf("Init rewrote the manifest %+v: %v", again, err)
+	}
+	if shards, err := db.Shards(); err != nil || len(shards) != 0 {
+		t.Fatalf("Expected no shards, got %v: %v", shards, err)
+	}
+}
+
+func TestTSCompatibility(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	// a directory of an older version, without a manifest
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	now := time.Now()
+	if err := db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), &ItemTest{Name: "old"})
+	}); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	db.Close()
+	if err := os.Remove(filepath.Join(dir, ".borm.json")); err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Error removing the manifest: %s", err)
+	}
+
+	db, err = borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening the old directory: %s", err)
+	}
+	manifest, err := db.Manifest()
+	db.Close()
+	if err != nil || manifest == nil || manifest.Version != borm.FormatVersion || manifest.ValueCodec != "gob" || manifest.KeyCodec != "objectid" {
+		t.Fatalf("Expected the old directory stamped, got %+v: %v", manifest, err)
+	}
+
+	if _, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode}); !errors.Is(err, borm.ErrIncompatible) {
+		t.Fatalf("Expected another codec refused, got %v", err)
+	}
+
+	var ce *borm.CompatError
+	for field, options := range map[string]*borm.TSOptions{
+		"shard interval": {ShardInterval: borm.Hourly},
+		"name pattern":   {NamePattern: "%Y/%m/%d.ts"},
+	} {
+		if _, err := borm.OpenTSWithOptions(dir, options); !errors.As(err, &ce) || ce.Field != field {
+			t.Fatalf("Expected another %s refused, got %v", field, err)
+		}
+	}
+
+	manifest.Version = borm.FormatVersion + 1
+	data, _ := json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(dir, ".borm.json"), data, 0666); err != nil {
+		t.Fatalf("Error writing the manifest: %s", err)
+	}
+	if _, err := borm.OpenTS(dir); !errors.As(err, &ce) || ce.Field != "version" {
+		t.Fatalf("Expected a newer version refused, got %v", err)
+	}
+}
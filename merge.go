@@ -0,0 +1,188 @@
+package borm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ConflictPolicy is what a merge does with a key existing on both sides.
+type ConflictPolicy int
+
+const (
+	// KeepExisting keeps the record of the destination.
+	KeepExisting ConflictPolicy = iota
+	// Overwrite replaces the record of the destination.
+	Overwrite
+	// FailOnConflict aborts the merge with ErrKeyExists.
+	FailOnConflict
+)
+
+// MergeOptions allows you set different options from the defaults for a merge
+type MergeOptions struct {
+	Conflict ConflictPolicy
+
+	// DryRun counts the records the merge would copy, skip and overwrite
+	// without writing them.
+	DryRun bool
+
+	// Progress is called after every merged shard.
+	Progress ProgressFunc
+}
+
+// MergeResult counts the records of a merge.
+type MergeResult struct {
+	Shards      int
+	Copied      int64
+	Skipped     int64
+	Overwritten int64
+}
+
+// MergeInto merges the shards of the src directory, e.g. data recovered
+// from a failed node, into the dst directory. Same-day shards are combined
+// key by key. No engine may have dst open.
+func MergeInto(src, dst string, opts *MergeOptions) (*MergeResult, error) {
+	db, err := OpenTS(dst)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.MergeFrom(src, opts)
+	if e := db.Close(); e != nil && err == nil {
+		err = e
+	}
+	return result, err
+}
+
+// MergeFrom merges the shards of the src directory into the engine, see
+// MergeInto. Every merge is recorded in the audit log.
+func (db *TSEngine) MergeFrom(src string, opts *MergeOptions) (*MergeResult, error) {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	result, err := db.mergeFrom(src, opts)
+	detail := fmt.Sprintf("merged %s: %d shards, %d copied, %d skipped, %d overwritten",
+		src, result.Shards, result.Copied, result.Skipped, result.Overwritten)
+	if e := db.audit("merge", opts.DryRun, detail, err); e != nil && err == nil {
+		err = e
+	}
+	return result, err
+}
+
+func (db *TSEngine) mergeFrom(src string, opts *MergeOptions) (*MergeResult, error) {
+	result := &MergeResult{}
+	shards, err := ListShards(src, time.Local)
+	if err != nil {
+		return result, err
+	}
+
+	var progress Progress
+	for i := len(shards) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(src, shards[i].path)
+		if err != nil {
+			return result, err
+		}
+		dstFile := filepath.Join(db.basePath, rel)
+		progress.Shard = dstFile
+
+		srcStore, err := Open(shards[i].path, 0666, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+		if err != nil {
+			return result, err
+		}
+		if _, statErr := os.Stat(dstFile); opts.DryRun && os.IsNotExist(statErr) {
+			err = mergeStore(srcStore, nil, opts, result, &progress)
+		} else {
+			err = db.readBackground(dstFile, func(bkt *Bucket) error {
+				if !opts.DryRun {
+					if err := unseal(bkt); err != nil {
+						return err
+					}
+				}
+				return mergeStore(srcStore, bkt, opts, result, &progress)
+			})
+		}
+		srcStore.Close()
+		if err != nil {
+			return result, err
+		}
+
+		result.Shards++
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+	return result, nil
+}
+
+// mergeStore copies all the buckets of src but the seal data into the store
+// of dst. The data bucket goes through the hooks of dst, so views are
+// maintained. A dry run only counts, dst is nil if its shard doesn't exist.
+func mergeStore(src *Store, dst *Bucket, opts *MergeOptions, result *MergeResult, progress *Progress) error {
+	conflict := opts.Conflict
+	return src.db.View(func(srcTx *bolt.Tx) error {
+		merge := func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, srcBkt *bolt.Bucket) error {
+				if bytes.Equal(name, statsBucket) || bytes.Equal(name, sketchBucket) || bytes.Equal(name, dictBucket) || bytes.Equal(name, schemaBucket) ||
+					bytes.Equal(name, changesBucket) {
+					return nil
+				}
+				dict := txDict(srcTx)
+				var dstBkt *bolt.Bucket
+				if opts.DryRun {
+					if dstTx != nil {
+						dstBkt = dstTx.Bucket(name)
+					}
+				} else {
+					var err error
+					if dstBkt, err = dstTx.CreateBucketIfNotExists(name); err != nil {
+						return err
+					}
+				}
+
+				return srcBkt.ForEach(func(k, v []byte) error {
+					if v == nil {
+						// nested buckets are not merged
+						return nil
+					}
+					if dstBkt != nil && dstBkt.Get(k) != nil {
+						switch conflict {
+						case KeepExisting:
+							result.Skipped++
+							return nil
+						case FailOnConflict:
+							return ErrKeyExists
+						}
+						result.Overwritten++
+					} else {
+						result.Copied++
+					}
+					progress.Items++
+					progress.Bytes += int64(len(v))
+					if opts.DryRun {
+						return nil
+					}
+
+					if bytes.Equal(name, dst.name) {
+						var err error
+						if v, err = plainWith(v, dict); err != nil {
+							return err
+						}
+						return dst.putRaw(dstTx, dstBkt, k, v)
+					}
+					return dstBkt.Put(k, v)
+				})
+			})
+		}
+
+		if !opts.DryRun {
+			return dst.store.db.Update(merge)
+		}
+		if dst == nil {
+			return merge(nil)
+		}
+		return dst.store.db.View(merge)
+	})
+}
@@ -0,0 +1,57 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestMergeInto(t *testing.T) {
+	src, dst := tempdir(t), tempdir(t)
+	defer os.RemoveAll(src)
+	defer os.RemoveAll(dst)
+
+	now := time.Now()
+	write := func(dir string, ids ...int) {
+		db, err := borm.OpenTS(dir)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", dir, err)
+		}
+		defer db.Close()
+		for _, i := range ids {
+			day := now.AddDate(0, 0, -i%2)
+			err := db.Write(day, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(day, uint32(i)), &ItemTest{ID: i, Name: dir, Created: day})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+	}
+	write(src, 0, 1, 2, 3)
+	write(dst, 2, 3, 4)
+
+	result, err := borm.MergeInto(src, dst, nil)
+	if err != nil {
+		t.Fatalf("Error merging: %s", err)
+	}
+	if result.Shards != 2 || result.Copied != 2 || result.Skipped != 2 {
+		t.Fatalf("Merge result is %+v.", result)
+	}
+
+	if _, err := borm.MergeInto(src, dst, &borm.MergeOptions{Conflict: borm.FailOnConflict}); err != borm.ErrKeyExists {
+		t.Fatalf("Merge with conflicts didn't fail! Expected %s got %s", borm.ErrKeyExists, err)
+	}
+
+	db, err := borm.OpenTS(dst)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dst, err)
+	}
+	defer db.Close()
+	var item ItemTest
+	if err := db.Get(borm.CreateID(now, 2), &item); err != nil || item.Name != dst {
+		t.Fatalf("Merge overwrote an existing record: %v %v", item, err)
+	}
+}
@@ -0,0 +1,233 @@
+package borm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaFile is the engine metadata store, relative to the engine path. It
+// starts with a dot so it is never taken as a shard.
+const metaFile = ".meta"
+
+// metaShards is the meta bucket of the shard metadata, keyed by shard path
+// relative to the engine path.
+var metaShards = []byte("shards")
+
+// metaCursors is the meta bucket of the consumer cursors, keyed by name.
+var metaCursors = []byte("cursors")
+
+// shardMeta is the metadata of a shard kept in the meta store.
+type shardMeta struct {
+	Tags map[string]string `json:"tags,omitempty"`
+
+	Pinned    bool      `json:"pinned,omitempty"`
+	PinReason string    `json:"pin_reason,omitempty"`
+	PinnedAt  time.Time `json:"pinned_at,omitempty"`
+
+	// ChangesLogged is the last change of the changes bucket of the shard
+	// in the change log, see TSOptions.ChangeLog.
+	ChangesLogged uint64 `json:"changes_logged,omitempty"`
+}
+
+// fill copies the metadata into the shard info.
+func (meta *shardMeta) fill(info *ShardInfo) {
+	info.Tags = meta.Tags
+	info.Pinned = meta.Pinned
+	info.PinReason = meta.PinReason
+}
+
+// errMetaClosed is returned by the meta store of a closed engine, e.g. to
+// the background goroutines still running.
+var errMetaClosed = errors.New("engine meta store is closed")
+
+func (db *TSEngine) metaStore() (*Store, error) {
+	db.metaMu.Lock()
+	defer db.metaMu.Unlock()
+	if db.metaClosed {
+		return nil, errMetaClosed
+	}
+	if db.meta != nil {
+		return db.meta, nil
+	}
+	if err := os.MkdirAll(db.basePath, 0755); err != nil {
+		return nil, err
+	}
+	store, err := Open(filepath.Join(db.basePath, metaFile), db.fileMode(), db.boltOptions())
+	if err != nil {
+		return nil, err
+	}
+	db.meta = store
+	return store, nil
+}
+
+// closeMeta closes the meta store, it is not reopened afterwards.
+func (db *TSEngine) closeMeta() error {
+	db.metaMu.Lock()
+	defer db.metaMu.Unlock()
+	db.metaClosed = true
+	if db.meta == nil {
+		return nil
+	}
+	err := db.meta.Close()
+	db.meta = nil
+	return err
+}
+
+// viewMeta runs fn with the meta bucket, the bucket is nil if it doesn't exist yet.
+func (db *TSEngine) viewMeta(bucket []byte, fn func(bkt *bolt.Bucket) error) error {
+	store, err := db.metaStore()
+	if err != nil {
+		return err
+	}
+	return store.db.View(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(bucket))
+	})
+}
+
+// updateMeta runs fn with the meta bucket, creating the bucket if needed.
+func (db *TSEngine) updateMeta(bucket []byte, fn func(bkt *bolt.Bucket) error) error {
+	store, err := db.metaStore()
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return fn(bkt)
+	})
+}
+
+// shardKey returns the key of the shard file in the meta store.
+func (db *TSEngine) shardKey(fileName string) []byte {
+	if rel, err := filepath.Rel(db.basePath, fileName); err == nil {
+		return []byte(filepath.ToSlash(rel))
+	}
+	return []byte(filepath.ToSlash(fileName))
+}
+
+func (db *TSEngine) readShardMeta(fileName string) (*shardMeta, error) {
+	meta := &shardMeta{}
+	err := db.viewMeta(metaShards, func(bkt *bolt.Bucket) error {
+		if bkt == nil {
+			return nil
+		}
+		if value := bkt.Get(db.shardKey(fileName)); value != nil {
+			return json.Unmarshal(value, meta)
+		}
+		return nil
+	})
+	return meta, err
+}
+
+func (db *TSEngine) updateShardMeta(fileName string, fn func(meta *shardMeta) error) error {
+	return db.updateMeta(metaShards, func(bkt *bolt.Bucket) error {
+		return putShardMeta(bkt, db.shardKey(fileName), fn)
+	})
+}
+
+// putShardMeta updates the metadata of the shard key in the meta bucket of
+// the shards.
+func putShardMeta(bkt *bolt.Bucket, key []byte, fn func(meta *shardMeta) error) error {
+	meta := &shardMeta{}
+	if value := bkt.Get(key); value != nil {
+		if err := json.Unmarshal(value, meta); err != nil {
+			return err
+		}
+	}
+	if err := fn(meta); err != nil {
+		return err
+	}
+	value, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(key, value)
+}
+
+func (db *TSEngine) deleteShardMeta(fileName string) error {
+	return db.updateMeta(metaShards, func(bkt *bolt.Bucket) error {
+		return bkt.Delete(db.shardKey(fileName))
+	})
+}
+
+// SetShardTag attaches the key/value tag to the shard of t, e.g. "source=sensor-7".
+func (db *TSEngine) SetShardTag(t time.Time, key, value string) error {
+	return db.updateShardMeta(db.nameWith(t), func(meta *shardMeta) error {
+		if meta.Tags == nil {
+			meta.Tags = map[string]string{}
+		}
+		meta.Tags[key] = value
+		return nil
+	})
+}
+
+// DeleteShardTag removes the tag from the shard of t.
+func (db *TSEngine) DeleteShardTag(t time.Time, key string) error {
+	return db.updateShardMeta(db.nameWith(t), func(meta *shardMeta) error {
+		delete(meta.Tags, key)
+		return nil
+	})
+}
+
+// ShardTags returns the tags of the shard of t.
+func (db *TSEngine) ShardTags(t time.Time) (map[string]string, error) {
+	meta, err := db.readShardMeta(db.nameWith(t))
+	if err != nil {
+		return nil, err
+	}
+	return meta.Tags, nil
+}
+
+// Shards returns the shards of the engine with their tags, latest first.
+func (db *TSEngine) Shards() ([]ShardInfo, error) {
+	shards, err := db.listShards(db.location())
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ShardInfo, 0, len(shards))
+	for _, shard := range shards {
+		info := shard.Info()
+		meta, err := db.readShardMeta(shard.path)
+		if err != nil {
+			return nil, err
+		}
+		meta.fill(&info)
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Cursor returns the position saved by SetCursor for the consumer name, e.g.
+// the sequence of the last message of a stream written into the engine. It
+// is 0 if nothing was saved.
+func (db *TSEngine) Cursor(name string) (uint64, error) {
+	var cursor uint64
+	err := db.viewMeta(metaCursors, func(bkt *bolt.Bucket) error {
+		if bkt == nil {
+			return nil
+		}
+		if value := bkt.Get([]byte(name)); len(value) == 8 {
+			cursor = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return cursor, err
+}
+
+// SetCursor saves the position of the consumer name.
+func (db *TSEngine) SetCursor(name string, cursor uint64) error {
+	return db.updateMeta(metaCursors, func(bkt *bolt.Bucket) error {
+		var value [8]byte
+		binary.BigEndian.PutUint64(value[:], cursor)
+		return bkt.Put([]byte(name), value[:])
+	})
+}
@@ -0,0 +1,270 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Codec is a pair of encoding and decoding funcs of the records.
+type Codec struct {
+	Encode EncodeFunc
+	Decode DecodeFunc
+}
+
+// The codecs of borm.
+var (
+	CodecGob    = Codec{DefaultEncode, DefaultDecode}
+	CodecJSON   = Codec{JSONEncode, JSONDecode}
+	CodecBinary = Codec{BinaryEncode, BinaryDecode}
+)
+
+// migrateDir is the directory, relative to the destination, where
+// MigrateCodec stages the shards and saves its progress.
+const migrateDir = ".migrate"
+
+// MigrateOptions allows you set different options from the defaults for MigrateCodec
+type MigrateOptions struct {
+	// RecordType is a prototype of the records, which are decoded into a
+	// new one and encoded again. It is required.
+	RecordType interface{}
+
+	// Dest, when set, is the engine directory the migrated shards are
+	// written to, leaving the source as it is. By default every shard is
+	// replaced, by renaming its migrated copy over it.
+	Dest string
+
+	// Progress is called after every migrated shard.
+	Progress ProgressFunc
+}
+
+// MigrateResult counts the shards and the records of a migration, Resumed
+// is the shards migrated by an interrupted run.
+type MigrateResult struct {
+	Shards  int
+	Resumed int
+	Records int64
+}
+
+// migrateProgress is the state of a migration, saved after every shard.
+type migrateProgress struct {
+	From, To string
+	Done     map[string]bool
+}
+
+// MigrateCodec rewrites the records of the engine directory at path from a
+// codec to another, oldest shard first, e.g. from JSON to the binary codec.
+// A migration interrupted is resumed by running it again. The compressed
+// shards are decompressed, and the views storing the records keep the old
+// codec until rebuilt with RebuildView. The manifest records the new codec
+// once done. A pinned shard fails the migration with ErrShardPinned before
+// any is rewritten. No engine may have path open. The migration is recorded
+// in the audit log.
+func MigrateCodec(path string, from, to Codec, opts *MigrateOptions) (*MigrateResult, error) {
+	if opts == nil || opts.RecordType == nil {
+		return nil, errors.New("MigrateCodec needs the record type")
+	}
+	options := &TSOptions{Encoder: from.Encode, Decoder: from.Decode}
+	manifest, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		options.NamePattern = manifest.NamePattern
+		options.ShardExtension = manifest.ShardExtension
+		if manifest.ShardInterval == Hourly.String() {
+			options.ShardInterval = Hourly
+		}
+	}
+	db, err := OpenTSWithOptions(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.migrateCodec(from, to, opts, manifest)
+	detail := fmt.Sprintf("migrated %s from %s to %s: %d shards, %d records",
+		path, codecName(from.Encode), codecName(to.Encode), result.Shards, result.Records)
+	if e := db.audit("migrate", false, detail, err); e != nil && err == nil {
+		err = e
+	}
+	if e := db.Close(); e != nil && err == nil {
+		err = e
+	}
+	return result, err
+}
+
+func (db *TSEngine) migrateCodec(from, to Codec, opts *MigrateOptions, manifest *Manifest) (*MigrateResult, error) {
+	result := &MigrateResult{}
+	shards, err := db.listShards(db.location())
+	if err != nil {
+		return result, err
+	}
+
+	dest := db.basePath
+	if opts.Dest != "" {
+		dest = opts.Dest
+	}
+	stage := filepath.Join(dest, migrateDir)
+	if err := os.MkdirAll(stage, 0755); err != nil {
+		return result, err
+	}
+	progressFile := filepath.Join(stage, "progress.json")
+	state := &migrateProgress{From: codecName(from.Encode), To: codecName(to.Encode), Done: map[string]bool{}}
+	if data, err := ioutil.ReadFile(progressFile); err == nil {
+		saved := &migrateProgress{}
+		if err := json.Unmarshal(data, saved); err != nil {
+			return result, err
+		}
+		if saved.From != state.From || saved.To != state.To {
+			return result, fmt.Errorf("%s has a migration from %s to %s in progress", dest, saved.From, saved.To)
+		}
+		if saved.Done != nil {
+			state.Done = saved.Done
+		}
+	} else if !os.IsNotExist(err) {
+		return result, err
+	}
+
+	for _, shard := range shards {
+		if err := db.checkPinned(shard.path); err != nil {
+			return result, err
+		}
+	}
+
+	typ := recordType(opts.RecordType)
+	tmp := filepath.Join(stage, "shard.tmp")
+	var progress Progress
+	for i := len(shards) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(db.basePath, shards[i].path)
+		if err != nil {
+			return result, err
+		}
+		key := filepath.ToSlash(rel)
+		if state.Done[key] {
+			result.Resumed++
+			continue
+		}
+
+		// a temporary file left over by an interrupted run is discarded
+		if err := removeFile(tmp); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+		n, err := migrateShard(shards[i].path, tmp, typ, from, to)
+		if err != nil {
+			return result, fmt.Errorf("migrating %s: %s", shards[i].path, err)
+		}
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return result, err
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			return result, err
+		}
+
+		state.Done[key] = true
+		data, err := json.Marshal(state)
+		if err != nil {
+			return result, err
+		}
+		if err := os.WriteFile(progressFile, data, 0666); err != nil {
+			return result, err
+		}
+		result.Shards++
+		result.Records += n
+		progress.Items += n
+		progress.Shard = target
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	if manifest == nil {
+		manifest = db.newManifest()
+	}
+	manifest.ValueCodec = codecName(to.Encode)
+	if err := writeManifest(dest, manifest, db.fileMode()); err != nil {
+		return result, err
+	}
+	return result, os.RemoveAll(stage)
+}
+
+// migrateShard copies the shard file src into dst, with the records
+// encoded with the new codec, and returns the number of records.
+func migrateShard(src, dst string, typ reflect.Type, from, to Codec) (int64, error) {
+	in, err := bolt.Open(src, 0666, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := bolt.Open(dst, 0666, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err = in.View(func(srcTx *bolt.Tx) error {
+		dict := txDict(srcTx)
+		return out.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, srcBkt *bolt.Bucket) error {
+				if bytes.Equal(name, dictBucket) {
+					// the records are written decompressed
+					return nil
+				}
+				dstBkt, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return srcBkt.ForEach(func(k, v []byte) error {
+					if v == nil {
+						// nested buckets are not copied
+						return nil
+					}
+					switch {
+					case string(name) == dataBucket:
+						checksummed := len(v) > 0 && v[0] == checksumMarker
+						plain, err := plainWith(v, dict)
+						if err == ErrChecksum {
+							return &ChecksumError{Shard: src, Key: string(k)}
+						}
+						if err != nil {
+							return err
+						}
+						record := reflect.New(typ).Interface()
+						if err := from.Decode(plain, record); err != nil {
+							return fmt.Errorf("decoding %q: %s", k, err)
+						}
+						if v, err = to.Encode(record); err != nil {
+							return fmt.Errorf("encoding %q: %s", k, err)
+						}
+						if checksummed {
+							v = addChecksum(v)
+						}
+						n++
+					case bytes.Equal(name, schemaBucket) && string(k) == dataBucket:
+						var info BucketInfo
+						if err := json.Unmarshal(v, &info); err != nil {
+							return err
+						}
+						info.Codec = codecName(to.Encode)
+						if v, err = json.Marshal(&info); err != nil {
+							return err
+						}
+					}
+					return dstBkt.Put(k, v)
+				})
+			})
+		})
+	})
+	if e := out.Close(); e != nil && err == nil {
+		err = e
+	}
+	return n, err
+}
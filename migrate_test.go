@@ -0,0 +1,80 @@
+package borm_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestMigrateCodec(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatalf("Error initializing %s: %s", dir, err)
+	}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		day := now.AddDate(0, 0, -i)
+		err := db.Write(day, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(day, uint32(i)), &ItemTest{ID: i, Name: "migrated", Created: day})
+		})
+		if err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+	db.Close()
+
+	// into another directory first, leaving the source as it is
+	copied := filepath.Join(dir, "copy")
+	result, err := borm.MigrateCodec(dir, borm.CodecJSON, borm.CodecGob, &borm.MigrateOptions{RecordType: ItemTest{}, Dest: copied})
+	if err != nil {
+		t.Fatalf("Error migrating into %s: %s", copied, err)
+	}
+	if result.Shards != 3 || result.Records != 3 {
+		t.Fatalf("Unexpected migration result %+v", result)
+	}
+
+	result, err = borm.MigrateCodec(dir, borm.CodecJSON, borm.CodecGob, &borm.MigrateOptions{RecordType: ItemTest{}})
+	if err != nil {
+		t.Fatalf("Error migrating %s: %s", dir, err)
+	}
+	if result.Shards != 3 || result.Records != 3 {
+		t.Fatalf("Unexpected migration result %+v", result)
+	}
+	if _, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode}); err == nil {
+		t.Fatalf("Expected the old codec refused after the migration.")
+	}
+
+	for _, path := range []string{dir, copied} {
+		db, err := borm.OpenTS(path)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", path, err)
+		}
+		n := 0
+		err = db.Query(now.AddDate(0, 0, -3), now.Add(time.Minute), func(it *borm.Iterator) error {
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					return err
+				}
+				if item.Name != "migrated" {
+					t.Fatalf("Read %+v from %s", item, path)
+				}
+				n++
+			}
+			return nil
+		})
+		db.Close()
+		if err != nil || n != 3 {
+			t.Fatalf("Read %d records from %s: %v", n, path, err)
+		}
+	}
+}
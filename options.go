@@ -0,0 +1,189 @@
+package borm
+
+import (
+	"log"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// Option configures a Store opened by OpenWith or a TSEngine opened by
+// OpenTSWith, so new settings are added without changing the signatures.
+// A Store only applies WithCodec, WithBoltOptions and WithFileMode, the
+// other options are ignored by OpenWith.
+type Option func(s *settings)
+
+// settings are the options of OpenWith and OpenTSWith, the Store ones are
+// taken from the TSOptions.
+type settings struct {
+	ts TSOptions
+}
+
+func applyOptions(opts []Option) *settings {
+	s := &settings{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithTSOptions starts from the options, for the settings without an
+// Option of their own. The options following it override theirs.
+func WithTSOptions(options *TSOptions) Option {
+	return func(s *settings) {
+		if options != nil {
+			s.ts = *options
+		}
+	}
+}
+
+// WithCodec sets the codec of the records, Gob by default. For a Store, it
+// is the codec of the buckets created without one.
+func WithCodec(codec Codec) Option {
+	return func(s *settings) {
+		s.ts.Encoder, s.ts.Decoder = codec.Encode, codec.Decode
+	}
+}
+
+// WithAppendEncoder sets the encoder of the records into reused buffers,
+// see TSOptions.AppendEncoder.
+func WithAppendEncoder(encoder AppendEncodeFunc) Option {
+	return func(s *settings) {
+		s.ts.AppendEncoder = encoder
+	}
+}
+
+// WithShardInterval sets the time span of the shards, Daily by default.
+func WithShardInterval(g Granularity) Option {
+	return func(s *settings) {
+		s.ts.ShardInterval = g
+	}
+}
+
+// WithShardPattern names the shards with the pattern, see
+// TSOptions.NamePattern.
+func WithShardPattern(pattern string) Option {
+	return func(s *settings) {
+		s.ts.NamePattern = pattern
+	}
+}
+
+// WithSyncPolicy sets when the shards are synced on commit, SyncAlways by
+// default.
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(s *settings) {
+		s.ts.SyncPolicy = policy
+	}
+}
+
+// WithChecksums stores a checksum with every record, see TSOptions.Checksums.
+func WithChecksums() Option {
+	return func(s *settings) {
+		s.ts.Checksums = true
+	}
+}
+
+// WithVersions keeps the versions of the records of an engine, see
+// TSOptions.Versioned.
+func WithVersions() Option {
+	return func(s *settings) {
+		s.ts.Versioned = true
+	}
+}
+
+// WithRecordType validates the type of the records, see
+// TSOptions.RecordType.
+func WithRecordType(prototype interface{}) Option {
+	return func(s *settings) {
+		s.ts.RecordType = prototype
+	}
+}
+
+// WithLogger sets the logger of the engine messages, the standard logger by
+// default.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *settings) {
+		s.ts.Logger = logger
+	}
+}
+
+// WithBoltOptions sets the options the files are opened with. The Timeout
+// defaults to 10 seconds for a TSEngine.
+func WithBoltOptions(options *bolt.Options) Option {
+	return func(s *settings) {
+		s.ts.BoltOptions = options
+	}
+}
+
+// WithFileMode sets the mode of the store, shard and meta files created,
+// 0666 by default.
+func WithFileMode(mode os.FileMode) Option {
+	return func(s *settings) {
+		s.ts.FileMode = mode
+	}
+}
+
+// WithSlowOps sets the thresholds of the slow operations of an engine,
+// reported to onSlow, or logged if it is nil.
+func WithSlowOps(thresholds SlowOpThresholds, onSlow func(op SlowOp)) Option {
+	return func(s *settings) {
+		s.ts.SlowOps = thresholds
+		s.ts.OnSlowOp = onSlow
+	}
+}
+
+// WithClock sets the clock of an engine, e.g. a ManualClock in tests.
+func WithClock(clock Clock) Option {
+	return func(s *settings) {
+		s.ts.Clock = clock
+	}
+}
+
+// WithBuckets declares the buckets every shard of an engine hosts besides
+// the records.
+func WithBuckets(buckets ...ShardBucket) Option {
+	return func(s *settings) {
+		s.ts.Buckets = append(s.ts.Buckets, buckets...)
+	}
+}
+
+// WithValidators adds validators checking every record an engine puts.
+func WithValidators(validators ...Validator) Option {
+	return func(s *settings) {
+		s.ts.Validators = append(s.ts.Validators, validators...)
+	}
+}
+
+// WithEnrichers adds enrichers completing every record an engine writes.
+func WithEnrichers(enrichers ...Enricher) Option {
+	return func(s *settings) {
+		s.ts.Enrichers = append(s.ts.Enrichers, enrichers...)
+	}
+}
+
+// OpenWith opens or creates a bolthold file as Open does, with the options.
+func OpenWith(filename string, opts ...Option) (*Store, error) {
+	s := applyOptions(opts)
+	mode := s.ts.FileMode
+	if mode == 0 {
+		mode = 0666
+	}
+	var options *bolt.Options
+	if s.ts.BoltOptions != nil {
+		copied := *s.ts.BoltOptions
+		options = &copied
+	}
+	store, err := Open(filename, mode, options)
+	if err != nil {
+		return nil, err
+	}
+	store.encode, store.decode = s.ts.Encoder, s.ts.Decoder
+	return store, nil
+}
+
+// OpenTSWith opens a TSEngine at path with the options, as
+// OpenTSWithOptions does.
+func OpenTSWith(path string, opts ...Option) (*TSEngine, error) {
+	s := applyOptions(opts)
+	return OpenTSWithOptions(path, &s.ts)
+}
@@ -0,0 +1,91 @@
+package borm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+)
+
+func TestOpenTSWith(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", dir, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".snapshot-1"), nil, 0644); err != nil {
+		t.Fatalf("Error writing leftover: %s", err)
+	}
+
+	var logged bytes.Buffer
+	db, err := borm.OpenTSWith(dir,
+		borm.WithCodec(borm.CodecJSON),
+		borm.WithShardInterval(borm.Hourly),
+		borm.WithLogger(log.New(&logged, "", 0)),
+		borm.WithFileMode(0600),
+		borm.WithBoltOptions(&bolt.Options{Timeout: time.Second}))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	if !strings.Contains(logged.String(), "engine repaired") {
+		t.Fatalf("Logger got %q wanted the repair of the leftover.", logged.String())
+	}
+
+	now := time.Now()
+	tx := db.Begin(now)
+	key, err := tx.Insert(&ItemTest{Name: "car", Created: now})
+	if err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, borm.Hourly.Name(now)))
+	if err != nil {
+		t.Fatalf("Error statting the hourly shard: %s", err)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		t.Fatalf("Shard mode is %s wanted 0600.", fi.Mode())
+	}
+	rec, err := db.FindFirst(now.Add(-time.Second), now.Add(time.Second), "")
+	if err != nil || string(rec.Key) != key || !bytes.HasPrefix(rec.Value, []byte("{")) {
+		t.Fatalf("Record got %v, %v wanted JSON of %s.", rec, err, key)
+	}
+}
+
+func TestOpenWith(t *testing.T) {
+	file := tempfile()
+	defer os.Remove(file)
+
+	store, err := borm.OpenWith(file, borm.WithCodec(borm.CodecJSON))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", file, err)
+	}
+	defer store.Close()
+
+	bkt, err := store.CreateBucket("bucktest", nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating bucket: %s", err)
+	}
+	if err := bkt.Insert("key1", &ItemTest{Name: "car"}); err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	err = store.Bolt().View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("bucktest")).Get([]byte("key1")); !bytes.HasPrefix(v, []byte("{")) {
+			t.Fatalf("Record is %q wanted JSON.", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading record: %s", err)
+	}
+}
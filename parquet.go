@@ -0,0 +1,310 @@
+package borm
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// parquetMagic starts and ends a Parquet file.
+var parquetMagic = []byte("PAR1")
+
+// Parquet physical types, repetitions, converted types and encodings, see
+// parquet.thrift of the format.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// ExportParquet writes the records of the shard of day as a Parquet file, one
+// row per record with the columns key, time and the columns of the schema,
+// which are nullable. The file has one row group, plain encoded and not
+// compressed, so any Parquet reader like Spark or DuckDB can load it.
+func (db *TSEngine) ExportParquet(day time.Time, schema []Column, w io.Writer) error {
+	fileName := db.nameWith(day)
+	if _, err := os.Stat(fileName); err != nil {
+		return err
+	}
+	batch, keys, err := db.extractColumns(fileName, keyRanges(positionMiddle, day, day), schema)
+	if err != nil {
+		return err
+	}
+
+	pw := &parquetWriter{w: w}
+	pw.write(parquetMagic)
+
+	var chunks []parquetChunk
+	rows := batch.Len()
+	var values []byte
+	for _, key := range keys {
+		values = binary.LittleEndian.AppendUint32(values, uint32(len(key)))
+		values = append(values, key...)
+	}
+	chunks = append(chunks, pw.chunk("key", parquetByteArray, rows, nil, values))
+
+	values = nil
+	for _, t := range batch.Times {
+		values = binary.LittleEndian.AppendUint64(values, uint64(t.UnixNano()/int64(time.Microsecond)))
+	}
+	chunks = append(chunks, pw.chunk("time", parquetInt64, rows, nil, values))
+
+	for _, col := range schema {
+		nulls := batch.Nulls[col.Name]
+		values = nil
+		typ := int32(parquetDouble)
+		switch col.Kind {
+		case NumberColumn:
+			for i, v := range batch.Numbers[col.Name] {
+				if nulls == nil || !nulls[i] {
+					values = binary.LittleEndian.AppendUint64(values, math.Float64bits(v))
+				}
+			}
+		case StringColumn:
+			typ = parquetByteArray
+			for i, v := range batch.Strings[col.Name] {
+				if nulls == nil || !nulls[i] {
+					values = binary.LittleEndian.AppendUint32(values, uint32(len(v)))
+					values = append(values, v...)
+				}
+			}
+		}
+		if nulls == nil {
+			nulls = make([]bool, rows)
+		}
+		chunks = append(chunks, pw.chunk(col.Name, typ, rows, nulls, values))
+	}
+	if pw.err != nil {
+		return pw.err
+	}
+
+	footer := parquetFooter(schema, chunks, rows)
+	pw.write(footer)
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	pw.write(parquetMagic)
+	return pw.err
+}
+
+// parquetChunk is the column chunk of a column in the row group.
+type parquetChunk struct {
+	name   string
+	typ    int32
+	offset int64
+	size   int64
+	values int64
+}
+
+type parquetWriter struct {
+	w      io.Writer
+	offset int64
+	err    error
+}
+
+func (pw *parquetWriter) write(data []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+// chunk writes a column chunk of a single data page. The definition levels
+// of an optional column mark its non null values, which are the only ones
+// in values.
+func (pw *parquetWriter) chunk(name string, typ int32, rows int, nulls []bool, values []byte) parquetChunk {
+	var page []byte
+	if nulls != nil {
+		levels := appendLevels(nil, nulls)
+		page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+		page = append(page, levels...)
+	}
+	page = append(page, values...)
+
+	var h thriftWriter
+	h.i32(1, 0) // DATA_PAGE
+	h.i32(2, int32(len(page)))
+	h.i32(3, int32(len(page)))
+	h.beginStruct(5)
+	h.i32(1, int32(rows))
+	h.i32(2, parquetPlain)
+	h.i32(3, parquetRLE)
+	h.i32(4, parquetRLE)
+	h.endStruct()
+	h.stop()
+
+	chunk := parquetChunk{name: name, typ: typ, offset: pw.offset, values: int64(rows)}
+	pw.write(h.buf)
+	pw.write(page)
+	chunk.size = pw.offset - chunk.offset
+	return chunk
+}
+
+// appendLevels appends the definition levels of an optional column with the
+// RLE hybrid encoding of bit width 1, as runs of equal levels.
+func appendLevels(data []byte, nulls []bool) []byte {
+	for i := 0; i < len(nulls); {
+		j := i + 1
+		for j < len(nulls) && nulls[j] == nulls[i] {
+			j++
+		}
+		data = binary.AppendUvarint(data, uint64(j-i)<<1)
+		if nulls[i] {
+			data = append(data, 0)
+		} else {
+			data = append(data, 1)
+		}
+		i = j
+	}
+	return data
+}
+
+// parquetFooter encodes the FileMetaData of the file.
+func parquetFooter(schema []Column, chunks []parquetChunk, rows int) []byte {
+	var m thriftWriter
+	m.i32(1, 1)
+
+	m.list(2, thriftStruct, len(chunks)+1)
+	m.beginElem()
+	m.str(4, "schema")
+	m.i32(5, int32(len(chunks)))
+	m.endStruct()
+	for i, chunk := range chunks {
+		m.beginElem()
+		m.i32(1, chunk.typ)
+		if i < 2 {
+			m.i32(3, parquetRequired)
+		} else {
+			m.i32(3, parquetOptional)
+		}
+		m.str(4, chunk.name)
+		switch {
+		case i == 1:
+			m.i32(6, parquetTimestampMicros)
+		case chunk.typ == parquetByteArray:
+			m.i32(6, parquetUTF8)
+		}
+		m.endStruct()
+	}
+
+	m.i64(3, int64(rows))
+
+	var total int64
+	for _, chunk := range chunks {
+		total += chunk.size
+	}
+	m.list(4, thriftStruct, 1)
+	m.beginElem()
+	m.list(1, thriftStruct, len(chunks))
+	for _, chunk := range chunks {
+		m.beginElem()
+		m.i64(2, chunk.offset)
+		m.beginStruct(3)
+		m.i32(1, chunk.typ)
+		m.list(2, thriftI32, 2)
+		m.buf = binary.AppendVarint(m.buf, parquetPlain)
+		m.buf = binary.AppendVarint(m.buf, parquetRLE)
+		m.list(3, thriftBinary, 1)
+		m.buf = binary.AppendUvarint(m.buf, uint64(len(chunk.name)))
+		m.buf = append(m.buf, chunk.name...)
+		m.i32(4, 0) // UNCOMPRESSED
+		m.i64(5, chunk.values)
+		m.i64(6, chunk.size)
+		m.i64(7, chunk.size)
+		m.i64(9, chunk.offset)
+		m.endStruct()
+		m.endStruct()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(rows))
+	m.endStruct()
+
+	m.str(6, "borm")
+	m.stop()
+	return m.buf
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, the field
+// ids of the enclosing structs are kept in stack.
+type thriftWriter struct {
+	buf   []byte
+	last  int16
+	stack []int16
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	w.last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *thriftWriter) list(id int16, elem byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elem)
+	} else {
+		w.buf = append(w.buf, 0xf0|elem)
+		w.buf = binary.AppendUvarint(w.buf, uint64(n))
+	}
+}
+
+// beginStruct starts a struct field, beginElem a struct element of a list.
+func (w *thriftWriter) beginStruct(id int16) {
+	w.field(id, thriftStruct)
+	w.beginElem()
+}
+
+func (w *thriftWriter) beginElem() {
+	w.stack = append(w.stack, w.last)
+	w.last = 0
+}
+
+func (w *thriftWriter) endStruct() {
+	w.stop()
+	w.last = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+}
+
+func (w *thriftWriter) stop() {
+	w.buf = append(w.buf, 0)
+}
@@ -0,0 +1,82 @@
+package borm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestExportParquet(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	day := startOfDay(time.Now().AddDate(0, 0, -1))
+	var entries []borm.Entry
+	for i, item := range testData {
+		item := item
+		entries = append(entries, borm.Entry{Time: day.Add(time.Duration(i) * time.Minute), Value: &item})
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if err := db.Seal(day); err != nil {
+		t.Fatalf("Error sealing shard: %s", err)
+	}
+
+	schema := []borm.Column{
+		{Name: "name", Kind: borm.StringColumn, String: func(key, value []byte) (string, bool) {
+			var item ItemTest
+			if json.Unmarshal(value, &item) != nil || item.Name == "" {
+				return "", false
+			}
+			return item.Name, true
+		}},
+		{Name: "id", Kind: borm.NumberColumn, Number: func(key, value []byte) (float64, bool) {
+			var item ItemTest
+			if json.Unmarshal(value, &item) != nil || item.ID%2 == 0 {
+				return 0, false
+			}
+			return float64(item.ID), true
+		}},
+	}
+	var buf bytes.Buffer
+	if err := db.ExportParquet(day, schema, &buf); err != nil {
+		t.Fatalf("Error exporting parquet: %s", err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatalf("Parquet file has no magic.")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen <= 0 || footerLen > len(data)-12 {
+		t.Fatalf("Invalid footer length %d.", footerLen)
+	}
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	for _, name := range []string{"schema", "key", "time", "name", "id"} {
+		if !bytes.Contains(footer, []byte(name)) {
+			t.Fatalf("Footer has no column %s.", name)
+		}
+	}
+	if !bytes.Contains(data, []byte(testData[3].Name)) {
+		t.Fatalf("Parquet file has no value %s.", testData[3].Name)
+	}
+
+	if err := db.ExportParquet(day.AddDate(0, 0, -5), schema, &buf); !os.IsNotExist(err) {
+		t.Fatalf("Expected a not exist error exporting a missing shard, got %v.", err)
+	}
+}
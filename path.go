@@ -0,0 +1,25 @@
+package borm
+
+import "path/filepath"
+
+// lockSuffix is the suffix of the lock files bolt creates next to the
+// databases on Windows.
+const lockSuffix = ".lock"
+
+// samePath returns whether the paths name the same file, comparing them as
+// the file system of the platform does: case-insensitively on Windows.
+func samePath(a, b string) bool {
+	if abs, err := filepath.Abs(a); err == nil {
+		a = abs
+	}
+	if abs, err := filepath.Abs(b); err == nil {
+		b = abs
+	}
+	return equalPath(filepath.Clean(a), filepath.Clean(b))
+}
+
+// hasFileSuffix is strings.HasSuffix for a file name, with the case
+// sensitivity of the file system of the platform.
+func hasFileSuffix(name, suffix string) bool {
+	return len(name) >= len(suffix) && equalPath(name[len(name)-len(suffix):], suffix)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package borm
+
+import "os"
+
+// equalPath compares paths exactly, as the case-sensitive file systems do.
+func equalPath(a, b string) bool {
+	return a == b
+}
+
+// removeFile removes the file, unix deletes a file still open or
+// memory-mapped once its last handle is closed.
+func removeFile(name string) error {
+	return os.Remove(name)
+}
@@ -0,0 +1,138 @@
+package borm_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSRemoveOpenShard(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	// the engine path is not clean, the handle is still found
+	db, err := borm.OpenTSWithOptions(dir+string(filepath.Separator)+".", &borm.TSOptions{HotShard: borm.DeleteHotShard})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	if err := db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), &ItemTest{Name: "hot"})
+	}); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if n := db.Stats().OpenWriters; n != 1 {
+		t.Fatalf("Expected the hot shard open, got %d writers.", n)
+	}
+
+	if err := db.EnforceRetention(now.AddDate(0, 0, 2)); err != nil {
+		t.Fatalf("Error removing the open shard: %s", err)
+	}
+	if n := db.Stats().OpenWriters; n != 0 {
+		t.Fatalf("Expected the handle of the removed shard closed, got %d writers.", n)
+	}
+	if shards, err := db.Shards(); err != nil || len(shards) != 0 {
+		t.Fatalf("Expected the shard removed, got %v: %v", shards, err)
+	}
+}
+
+func TestTSLongPath(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	// longer than MAX_PATH of Windows
+	long := dir
+	for len(long) < 300 {
+		long = filepath.Join(long, strings.Repeat("d", 50))
+	}
+	db, err := borm.OpenTS(long)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", long, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	if _, err := db.Backfill([]borm.Entry{{Time: now, Value: &ItemTest{Name: "far"}}}, nil); err != nil {
+		t.Fatalf("Error writing under a long path: %s", err)
+	}
+	n := 0
+	if err := db.Query(now.Add(-time.Minute), now.Add(time.Minute), func(it *borm.Iterator) error {
+		for it.Next() {
+			n++
+		}
+		return nil
+	}); err != nil || n != 1 {
+		t.Fatalf("Expected 1 record under a long path, got %d: %v", n, err)
+	}
+	if shards, err := db.Shards(); err != nil || len(shards) != 1 {
+		t.Fatalf("Expected 1 shard under a long path, got %v: %v", shards, err)
+	}
+}
+
+func TestTSCloseWaitsForReaders(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{HotShard: borm.DeleteHotShard})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(db.NewID(now), &ItemTest{Name: "hot"})
+		}); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+
+	started := make(chan struct{})
+	var once sync.Once
+	var finished int32
+	result := make(chan error, 1)
+	go func() {
+		n := 0
+		err := db.Query(now.Add(-time.Minute), now.Add(time.Minute), func(it *borm.Iterator) error {
+			once.Do(func() {
+				close(started)
+				time.Sleep(50 * time.Millisecond)
+			})
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					return err
+				}
+				n++
+			}
+			atomic.StoreInt32(&finished, 1)
+			return nil
+		})
+		if err == nil && n != 10 {
+			err = fmt.Errorf("read %d records", n)
+		}
+		result <- err
+	}()
+
+	// retention removes the shard being read once the reader is done
+	<-started
+	if err := db.EnforceRetention(now.AddDate(0, 0, 2)); err != nil {
+		t.Fatalf("Error enforcing retention: %s", err)
+	}
+	if atomic.LoadInt32(&finished) == 0 {
+		t.Fatalf("Expected the shard closed after the reader finished.")
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("Error reading while the shard is removed: %s", err)
+	}
+}
@@ -0,0 +1,63 @@
+package borm
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// equalPath compares paths case-insensitively, as NTFS does, with or
+// without their long path prefix.
+func equalPath(a, b string) bool {
+	return strings.EqualFold(plainPath(a), plainPath(b))
+}
+
+// plainPath returns the path without its long path prefix: \\?\C:\x is C:\x
+// and \\?\UNC\server\share\x is \\server\share\x.
+func plainPath(path string) string {
+	if len(path) >= 8 && strings.EqualFold(path[:8], `\\?\UNC\`) {
+		return `\\` + path[8:]
+	}
+	return strings.TrimPrefix(path, `\\?\`)
+}
+
+// Windows errors of a file open by another handle.
+const (
+	errorAccessDenied     syscall.Errno = 5
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// removeRetries bound the wait of removeFile for the other handles of the
+// file, e.g. a reader still iterating a shard, to be closed.
+const (
+	removeRetries = 10
+	removeBackoff = 10 * time.Millisecond
+)
+
+// removeFile removes the file. Windows doesn't delete a file still open or
+// memory-mapped, which bolt does for all its readers, so the removal is
+// retried while they finish.
+func removeFile(name string) error {
+	backoff := removeBackoff
+	for i := 0; ; i++ {
+		err := os.Remove(name)
+		if err == nil || i == removeRetries || !inUse(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func inUse(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	switch err {
+	case errorAccessDenied, errorSharingViolation, errorLockViolation:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package borm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSWindowsPaths(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory: %s", err)
+	}
+	volume := filepath.VolumeName(dir)
+	paths := map[string]string{
+		"long":  `\\?\` + filepath.Join(dir, "long"),
+		"upper": strings.ToUpper(filepath.Join(dir, "upper")),
+		// the administrative share of the volume, C:\x is \\localhost\C$\x
+		"unc": `\\localhost\` + strings.TrimSuffix(volume, ":") + `$` + filepath.Join(dir, "unc")[len(volume):],
+	}
+	if rel, err := filepath.Rel(cwd, filepath.Join(dir, "relative")); err == nil {
+		paths["relative"] = rel
+	}
+
+	for name, path := range paths {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			if name == "unc" {
+				t.Logf("Skipping the UNC path %s: %s", path, err)
+				continue
+			}
+			t.Fatalf("Error creating %s: %s", path, err)
+		}
+		db, err := borm.OpenTSWithOptions(path, &borm.TSOptions{HotShard: borm.DeleteHotShard})
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", path, err)
+		}
+
+		now := time.Now()
+		if err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(db.NewID(now), &ItemTest{Name: name})
+		}); err != nil {
+			t.Fatalf("Error writing under %s: %s", path, err)
+		}
+		if shards, err := db.Shards(); err != nil || len(shards) != 1 {
+			t.Fatalf("Expected 1 shard under %s, got %v: %v", path, shards, err)
+		}
+
+		// the listed shard is matched with its open handle, which is closed
+		// so the mapped file can be removed
+		if err := db.EnforceRetention(now.AddDate(0, 0, 2)); err != nil {
+			t.Fatalf("Error removing the open shard under %s: %s", path, err)
+		}
+		if n := db.Stats().OpenWriters; n != 0 {
+			t.Fatalf("Expected the handle of the removed shard under %s closed, got %d writers.", path, n)
+		}
+		if shards, err := db.Shards(); err != nil || len(shards) != 0 {
+			t.Fatalf("Expected the shard under %s removed, got %v: %v", path, shards, err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Error closing %s: %s", path, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package borm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ShardPattern is a strftime like pattern of the shard file names, e.g.
+// "%Y/%m/%d.ts", so the layout of the shards can be configured from a
+// string. The verbs are %Y (the year), %m (the month), %d (the day of the
+// month), %j (the day of the year), %H (the hour) and %% (a '%'), the
+// numbers are zero padded. '/' separates directories.
+type ShardPattern struct {
+	pattern string
+	hourly  bool
+}
+
+// widths are the digits of the verbs.
+var widths = map[byte]int{'Y': 4, 'm': 2, 'd': 2, 'j': 3, 'H': 2}
+
+// ParseShardPattern validates the pattern, which must name the day of the
+// shards: %Y with %j, or %Y with %m and %d.
+func ParseShardPattern(pattern string) (*ShardPattern, error) {
+	seen := map[byte]bool{}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(pattern) {
+			return nil, errors.New("shard pattern " + strconv.Quote(pattern) + " ends with %")
+		}
+		if _, ok := widths[pattern[i]]; !ok && pattern[i] != '%' {
+			return nil, fmt.Errorf("shard pattern %q has the unknown verb %%%c", pattern, pattern[i])
+		}
+		seen[pattern[i]] = true
+	}
+	if !seen['Y'] || !(seen['j'] || (seen['m'] && seen['d'])) {
+		return nil, errors.New("shard pattern " + strconv.Quote(pattern) + " doesn't name the day")
+	}
+	if strings.HasPrefix(pattern, "/") || strings.Contains(pattern, "..") {
+		return nil, errors.New("shard pattern " + strconv.Quote(pattern) + " is not relative to the engine path")
+	}
+	return &ShardPattern{pattern: pattern, hourly: seen['H']}, nil
+}
+
+// Granularity returns Hourly if the pattern names the hour, else Daily.
+func (p *ShardPattern) Granularity() Granularity {
+	if p.hourly {
+		return Hourly
+	}
+	return Daily
+}
+
+// Name returns the file name of the shard of t, relative to the engine path.
+func (p *ShardPattern) Name(t time.Time) string {
+	var sb strings.Builder
+	for i := 0; i < len(p.pattern); i++ {
+		c := p.pattern[i]
+		if c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		var n int
+		switch p.pattern[i] {
+		case 'Y':
+			n = t.Year()
+		case 'm':
+			n = int(t.Month())
+		case 'd':
+			n = t.Day()
+		case 'j':
+			n = t.YearDay()
+		case 'H':
+			n = t.Hour()
+		default:
+			sb.WriteByte('%')
+			continue
+		}
+		s := strconv.Itoa(n)
+		for j := len(s); j < widths[p.pattern[i]]; j++ {
+			sb.WriteByte('0')
+		}
+		sb.WriteString(s)
+	}
+	return filepath.FromSlash(sb.String())
+}
+
+// Parse returns the start time of the shard file name, relative to the
+// engine path, false if the name doesn't match the pattern.
+func (p *ShardPattern) Parse(name string, loc *time.Location) (time.Time, bool) {
+	name = filepath.ToSlash(name)
+	values := map[byte]int{'m': 1, 'd': 1}
+	j := 0
+	for i := 0; i < len(p.pattern); i++ {
+		c := p.pattern[i]
+		if c == '%' {
+			i++
+			c = p.pattern[i]
+			if width, ok := widths[c]; ok {
+				if j+width > len(name) {
+					return time.Time{}, false
+				}
+				n, err := strconv.Atoi(name[j : j+width])
+				if err != nil || n < 0 {
+					return time.Time{}, false
+				}
+				values[c] = n
+				j += width
+				continue
+			}
+		}
+		if j == len(name) || name[j] != c {
+			return time.Time{}, false
+		}
+		j++
+	}
+	if j != len(name) {
+		return time.Time{}, false
+	}
+
+	var t time.Time
+	if yearDay, ok := values['j']; ok {
+		t = time.Date(values['Y'], time.January, yearDay, values['H'], 0, 0, 0, loc)
+	} else {
+		t = time.Date(values['Y'], time.Month(values['m']), values['d'], values['H'], 0, 0, 0, loc)
+	}
+	// the name round-trips unless a field is out of range, e.g. a 13th month
+	if p.Name(t) != filepath.FromSlash(name) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// ListShardsWithPattern lists the shards of path named by the pattern, in
+// the directories the pattern nests them in. The other files are skipped.
+func ListShardsWithPattern(path string, pattern *ShardPattern, loc *time.Location) (Shards, error) {
+	return listShardsWithPattern(path, pattern, loc, nil)
+}
+
+func listShardsWithPattern(path string, pattern *ShardPattern, loc *time.Location, opts *ListOptions) (Shards, error) {
+	var shards Shards
+	err := filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && file == path {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(fi.Name(), ".") && file != path {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || opts.ignored(fi.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		start, ok := pattern.Parse(rel, loc)
+		if !ok {
+			return nil
+		}
+		g := pattern.Granularity()
+		shards = append(shards, &Shard{path: file, startTime: start, endTime: g.Next(start), granularity: g})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(shards)
+	return shards, nil
+}
+
+// listShards lists the shards of the engine, by its NamePattern if it has one.
+func (db *TSEngine) listShards(loc *time.Location) (Shards, error) {
+	if db.pattern != nil {
+		return listShardsWithPattern(db.basePath, db.pattern, loc, db.listOptions())
+	}
+	return ListShardsWith(db.basePath, loc, db.listOptions())
+}
+
+// listOptions returns the options of the discovery of the shards.
+func (db *TSEngine) listOptions() *ListOptions {
+	return &ListOptions{Extension: db.options.ShardExtension, Ignore: db.options.IgnoreShards}
+}
+
+// openShard returns the shard of the file name, by the NamePattern of the
+// engine if it has one.
+func (db *TSEngine) openShard(fileName string, loc *time.Location) (*Shard, error) {
+	if db.pattern == nil {
+		return openShard(fileName, loc)
+	}
+	rel, err := filepath.Rel(db.basePath, fileName)
+	if err != nil {
+		return nil, err
+	}
+	start, ok := db.pattern.Parse(rel, loc)
+	if !ok {
+		return nil, errors.New("invalid shard name - " + rel)
+	}
+	g := db.pattern.Granularity()
+	return &Shard{path: fileName, startTime: start, endTime: g.Next(start), granularity: g}, nil
+}
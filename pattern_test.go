@@ -0,0 +1,92 @@
+package borm_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestShardPattern(t *testing.T) {
+	for _, pattern := range []string{"%Y", "%Y/%m.ts", "%Y/%q.ts", "../%Y_%j.ts", "%Y_%j%"} {
+		if _, err := borm.ParseShardPattern(pattern); err == nil {
+			t.Fatalf("Expected the pattern %q rejected.", pattern)
+		}
+	}
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+	if _, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{NamePattern: "%Y/%m/%d.ts", ShardInterval: borm.Hourly}); err == nil {
+		t.Fatalf("Expected a daily pattern rejected for hourly shards.")
+	}
+
+	p, err := borm.ParseShardPattern("%Y/%m/%d_%H.ts")
+	if err != nil {
+		t.Fatalf("Error parsing the pattern: %s", err)
+	}
+	at := time.Date(2024, time.March, 7, 9, 30, 0, 0, time.UTC)
+	name := p.Name(at)
+	if name != filepath.FromSlash("2024/03/07_09.ts") {
+		t.Fatalf("Pattern named the shard %s", name)
+	}
+	if start, ok := p.Parse(name, time.UTC); !ok || !start.Equal(at.Truncate(time.Hour)) {
+		t.Fatalf("Pattern parsed %s as %s", name, start)
+	}
+	if _, ok := p.Parse("2024/13/07_09.ts", time.UTC); ok {
+		t.Fatalf("Pattern parsed the 13th month.")
+	}
+}
+
+func TestTSNamePattern(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{NamePattern: "%Y/%m/%d.ts"})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	for _, day := range []time.Time{now, yesterday} {
+		err := db.Write(day, func(bkt *borm.Bucket) error {
+			return bkt.Insert(db.NewID(day), &ItemTest{Name: "nested", Created: day})
+		})
+		if err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+
+	file := filepath.Join(dir, now.Format("2006"), now.Format("01"), now.Format("02")+".ts")
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("Expected the shard at %s: %s", file, err)
+	}
+
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 2 || !shards[0].StartTime.Equal(startOfDay(now)) || !shards[1].StartTime.Equal(startOfDay(yesterday)) {
+		t.Fatalf("Unexpected shards %v", shards)
+	}
+
+	count := 0
+	err = db.Query(yesterday.Add(-time.Minute), now.Add(time.Minute), func(it *borm.Iterator) error {
+		for it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil || count != 2 {
+		t.Fatalf("Query read %d records: %v", count, err)
+	}
+
+	if err := db.EnforceRetention(startOfDay(now)); err != nil {
+		t.Fatalf("Error enforcing retention: %s", err)
+	}
+	if shards, err := db.Shards(); err != nil || len(shards) != 1 {
+		t.Fatalf("Expected the shard of yesterday deleted, got %v: %v", shards, err)
+	}
+}
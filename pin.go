@@ -0,0 +1,71 @@
+package borm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrShardPinned is matched by the errors of the operations refusing to
+// rewrite a pinned shard, e.g. Reshard and MigrateCodec.
+var ErrShardPinned = errors.New("shard is pinned")
+
+// PinShard marks the shard of t as exempt from retention and compaction
+// until it is unpinned, e.g. to preserve the days of an incident
+// investigation. PurgeWhere and the rewrite jobs skip a pinned shard,
+// Reshard and MigrateCodec fail with ErrShardPinned.
+func (db *TSEngine) PinShard(t time.Time, reason string) error {
+	return db.updateShardMeta(db.nameWith(t), func(meta *shardMeta) error {
+		meta.Pinned = true
+		meta.PinReason = reason
+		meta.PinnedAt = db.now()
+		return nil
+	})
+}
+
+// UnpinShard removes the pin of the shard of t.
+func (db *TSEngine) UnpinShard(t time.Time) error {
+	return db.updateShardMeta(db.nameWith(t), func(meta *shardMeta) error {
+		meta.Pinned = false
+		meta.PinReason = ""
+		meta.PinnedAt = time.Time{}
+		return nil
+	})
+}
+
+// PinnedShards returns the pinned shards.
+func (db *TSEngine) PinnedShards() ([]ShardInfo, error) {
+	shards, err := db.Shards()
+	if err != nil {
+		return nil, err
+	}
+
+	var pinned []ShardInfo
+	for _, info := range shards {
+		if info.Pinned {
+			pinned = append(pinned, info)
+		}
+	}
+	return pinned, nil
+}
+
+// isPinned returns whether the shard file is pinned.
+func (db *TSEngine) isPinned(fileName string) (bool, error) {
+	meta, err := db.readShardMeta(fileName)
+	if err != nil {
+		return false, err
+	}
+	return meta.Pinned, nil
+}
+
+// checkPinned fails with ErrShardPinned if the shard file is pinned.
+func (db *TSEngine) checkPinned(fileName string) error {
+	pinned, err := db.isPinned(fileName)
+	if err != nil {
+		return err
+	}
+	if pinned {
+		return fmt.Errorf("%w: %s", ErrShardPinned, db.shardKey(fileName))
+	}
+	return nil
+}
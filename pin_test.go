@@ -0,0 +1,97 @@
+package borm_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestPinShard(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		day := now.AddDate(0, 0, -i)
+		err := db.Write(day, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(day, 1), &ItemTest{ID: i, Name: "pinned", Created: day})
+		})
+		if err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+	pinnedDay := now.AddDate(0, 0, -2)
+	if err := db.PinShard(pinnedDay, "incident-43"); err != nil {
+		t.Fatalf("Error pinning shard: %s", err)
+	}
+	pinned, err := db.PinnedShards()
+	if err != nil {
+		t.Fatalf("Error listing pinned shards: %s", err)
+	}
+	if len(pinned) != 1 || pinned[0].PinReason != "incident-43" {
+		t.Fatalf("PinnedShards returned %v.", pinned)
+	}
+
+	deleted, err := db.ApplyRetention(borm.RetentionPolicy{Before: startOfDay(now)}, false)
+	if err != nil {
+		t.Fatalf("Error applying retention: %s", err)
+	}
+	if len(deleted) != 1 || !deleted[0].StartTime.Equal(startOfDay(now.AddDate(0, 0, -1))) {
+		t.Fatalf("Retention deleted %v wanted the shard of yesterday.", deleted)
+	}
+
+	// the records of the pinned shard are neither purged nor compacted
+	report, err := db.PurgeWhere(pinnedDay, now.Add(time.Minute), func(key, value []byte) bool { return true })
+	if err != nil {
+		t.Fatalf("Error purging: %s", err)
+	}
+	if report.Total != 1 || len(report.Pinned) != 1 || report.Pinned[0] != pinned[0].Path {
+		t.Fatalf("Unexpected purge report %+v", report)
+	}
+	var item ItemTest
+	if err := db.Get(borm.CreateID(pinnedDay, 1), &item); err != nil {
+		t.Fatalf("Error getting the record of the pinned shard: %s", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+
+	if err := borm.Reshard(dir, borm.Daily, borm.Hourly); !errors.Is(err, borm.ErrShardPinned) {
+		t.Fatalf("Expected ErrShardPinned resharding, got %v.", err)
+	}
+	_, err = borm.MigrateCodec(dir, borm.CodecGob, borm.CodecJSON, &borm.MigrateOptions{RecordType: &ItemTest{}})
+	if !errors.Is(err, borm.ErrShardPinned) {
+		t.Fatalf("Expected ErrShardPinned migrating, got %v.", err)
+	}
+	shards, err := borm.ListShards(dir, time.Local)
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 2 {
+		t.Fatalf("Expected the shards left as they are, got %v.", shards)
+	}
+
+	db, err = borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	if err := db.UnpinShard(pinnedDay); err != nil {
+		t.Fatalf("Error unpinning shard: %s", err)
+	}
+	report, err = db.PurgeWhere(pinnedDay.Add(-time.Minute), pinnedDay.Add(time.Minute), func(key, value []byte) bool { return true })
+	if err != nil {
+		t.Fatalf("Error purging: %s", err)
+	}
+	if report.Total != 1 || len(report.Pinned) != 0 {
+		t.Fatalf("Unexpected purge report of the unpinned shard %+v", report)
+	}
+}
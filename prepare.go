@@ -0,0 +1,98 @@
+package borm
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+)
+
+// PreparedWriter writes the records of one type into a bucket, with the
+// type check and the choice of the encoder resolved once by Prepare instead
+// of on every write, for the ingest hot paths. The write hooks of the bucket,
+// e.g. its views, still run on every write. It is safe for concurrent use.
+type PreparedWriter struct {
+	b   *Bucket
+	typ reflect.Type
+	enc func(a *arena, data interface{}) ([]byte, error)
+}
+
+// Prepare returns a writer of the records of the prototype type, exactly:
+// a pointer prototype only writes pointers. The prototype must have the
+// declared type of the bucket, if any, and the bucket must exist.
+func (b *Bucket) Prepare(prototype interface{}) (*PreparedWriter, error) {
+	if prototype == nil {
+		return nil, errors.New("prototype of the prepared writer is nil")
+	}
+	if err := b.checkType(prototype); err != nil {
+		return nil, err
+	}
+	err := b.store.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(b.name) == nil {
+			return ErrBucketNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w := &PreparedWriter{b: b, typ: reflect.TypeOf(prototype)}
+	if b.appendEncode != nil {
+		w.enc = b.appendTo
+	} else {
+		encode := b.encode
+		w.enc = func(_ *arena, data interface{}) ([]byte, error) {
+			return encode(data)
+		}
+	}
+	return w, nil
+}
+
+func (w *PreparedWriter) encode(a *arena, data interface{}) ([]byte, error) {
+	if got := reflect.TypeOf(data); got != w.typ {
+		return nil, &ErrTypeMismatch{Bucket: w.b.Name, Want: w.typ, Got: got}
+	}
+	return w.enc(a, data)
+}
+
+// Insert inserts the record, or fails with ErrKeyExists.
+func (w *PreparedWriter) Insert(key string, data interface{}) error {
+	return w.Write(func(u Updater) error {
+		return u.Insert(key, data)
+	})
+}
+
+// Update updates an existing record, or fails with ErrNotFound.
+func (w *PreparedWriter) Update(key string, data interface{}) error {
+	return w.Write(func(u Updater) error {
+		return u.Update(key, data)
+	})
+}
+
+// Upsert inserts or updates the record.
+func (w *PreparedWriter) Upsert(key string, data interface{}) error {
+	return w.Write(func(u Updater) error {
+		return u.Upsert(key, data)
+	})
+}
+
+// Write runs the writes of cb in one transaction as Bucket.Write, encoding
+// the records with the prepared writer.
+func (w *PreparedWriter) Write(cb func(u Updater) error) error {
+	a := getArena()
+	defer a.release()
+	return w.b.store.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(w.b.name)
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+		return cb(&txUpdater{
+			b:        w.b,
+			tx:       tx,
+			bkt:      bkt,
+			arena:    a,
+			prepared: w,
+		})
+	})
+}
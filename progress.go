@@ -0,0 +1,18 @@
+package borm
+
+// Progress is the state of a long operation, reported to a ProgressFunc so
+// embedders can render progress bars and ETAs.
+type Progress struct {
+	// Items is the number of records done so far.
+	Items int64
+	// Bytes is the size of the records done so far.
+	Bytes int64
+	// Skipped is the number of records skipped, e.g. by
+	// QueryOptions.SkipUndecodable.
+	Skipped int64
+	// Shard is the shard file being processed.
+	Shard string
+}
+
+// ProgressFunc receives the progress of a long operation.
+type ProgressFunc func(p Progress)
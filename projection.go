@@ -0,0 +1,162 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+var errInvalidJSON = errors.New("invalid JSON object")
+
+// ProjectJSON returns a JSON object holding only the given top-level fields
+// of the JSON object data. The skipped values are scanned, not unmarshaled,
+// so decoding a projection of a wide record is cheap.
+func ProjectJSON(data []byte, fields []string) ([]byte, error) {
+	out := make([]byte, 0, 64)
+	out = append(out, '{')
+	err := scanJSONObject(data, func(key, rawKey, value []byte) {
+		for _, field := range fields {
+			if string(key) == field {
+				if len(out) > 1 {
+					out = append(out, ',')
+				}
+				out = append(out, rawKey...)
+				out = append(out, ':')
+				out = append(out, value...)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '}'), nil
+}
+
+// scanJSONObject calls fn with the unquoted key, the quoted key and the raw
+// value of every member of the JSON object data.
+func scanJSONObject(data []byte, fn func(key, rawKey, value []byte)) error {
+	i := skipSpaces(data, 0)
+	if i >= len(data) || data[i] != '{' {
+		return errInvalidJSON
+	}
+	i = skipSpaces(data, i+1)
+	if i < len(data) && data[i] == '}' {
+		return nil
+	}
+
+	for i < len(data) {
+		keyEnd, err := skipString(data, i)
+		if err != nil {
+			return err
+		}
+		rawKey := data[i:keyEnd]
+		key := rawKey[1 : len(rawKey)-1]
+		if bytes.IndexByte(key, '\\') >= 0 {
+			var s string
+			if err := json.Unmarshal(rawKey, &s); err != nil {
+				return err
+			}
+			key = []byte(s)
+		}
+
+		i = skipSpaces(data, keyEnd)
+		if i >= len(data) || data[i] != ':' {
+			return errInvalidJSON
+		}
+		i = skipSpaces(data, i+1)
+		valueEnd, err := skipValue(data, i)
+		if err != nil {
+			return err
+		}
+		fn(key, rawKey, data[i:valueEnd])
+
+		i = skipSpaces(data, valueEnd)
+		if i >= len(data) {
+			break
+		}
+		switch data[i] {
+		case ',':
+			i = skipSpaces(data, i+1)
+		case '}':
+			return nil
+		default:
+			return errInvalidJSON
+		}
+	}
+	return errInvalidJSON
+}
+
+func skipSpaces(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipString returns the offset after the JSON string starting at i.
+func skipString(data []byte, i int) (int, error) {
+	if i >= len(data) || data[i] != '"' {
+		return 0, errInvalidJSON
+	}
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, errInvalidJSON
+}
+
+// skipValue returns the offset after the JSON value starting at i.
+func skipValue(data []byte, i int) (int, error) {
+	if i >= len(data) {
+		return 0, errInvalidJSON
+	}
+	switch data[i] {
+	case '"':
+		return skipString(data, i)
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				end, err := skipString(data, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+			i++
+		}
+		return 0, errInvalidJSON
+	default:
+		start := i
+		for i < len(data) {
+			switch data[i] {
+			case ',', '}', ']', ' ', '\t', '\r', '\n':
+				if i == start {
+					return 0, errInvalidJSON
+				}
+				return i, nil
+			}
+			i++
+		}
+		return i, nil
+	}
+}
@@ -0,0 +1,80 @@
+package borm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestProjectJSON(t *testing.T) {
+	tests := []struct {
+		data   string
+		fields []string
+		result string
+	}{
+		{`{"a":1,"b":"x","c":[1,{"d":"}"}]}`, []string{"b"}, `{"b":"x"}`},
+		{`{ "a" : 1 , "b" : {"x":[1,2]} }`, []string{"a", "b"}, `{"a":1,"b":{"x":[1,2]}}`},
+		{`{"a\"b":true,"c":null}`, []string{`a"b`}, `{"a\"b":true}`},
+		{`{}`, []string{"a"}, `{}`},
+	}
+
+	for _, tst := range tests {
+		result, err := borm.ProjectJSON([]byte(tst.data), tst.fields)
+		if err != nil {
+			t.Fatalf("Error projecting %s: %s", tst.data, err)
+		}
+		if string(result) != tst.result {
+			t.Fatalf("Projecting %s got %s wanted %s.", tst.data, result, tst.result)
+		}
+	}
+
+	if _, err := borm.ProjectJSON([]byte(`{"a":1`), []string{"a"}); err == nil {
+		t.Fatalf("Projecting invalid JSON didn't fail!")
+	}
+}
+
+func TestQueryFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(borm.CreateID(now, 1), &ItemTest{Name: "car", Category: "vehicle", Created: now})
+	})
+	if err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+
+	var result ItemTest
+	err = db.QueryWith(now.Add(-time.Second), now.Add(time.Second), &borm.QueryOptions{
+		Fields: []string{"Name"},
+	}, func(it *borm.Iterator) error {
+		for it.Next() {
+			if err := it.Read(&result); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if result.Name != "car" || result.Category != "" {
+		t.Fatalf("Projection read %v wanted only the name.", result)
+	}
+}
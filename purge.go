@@ -0,0 +1,203 @@
+package borm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// PurgeReport is the number of records removed by PurgeWhere.
+type PurgeReport struct {
+	// Removed is the number of records removed from every shard file.
+	Removed map[string]int
+	Total   int64
+
+	// Pinned are the shard files of the range left as they are, as they
+	// are pinned.
+	Pinned []string
+}
+
+// PurgeWhere deletes the records of the time range the predicate returns
+// true for, e.g. all the records of a data subject, with their view
+// entries. The shards records were removed from are unsealed and compacted
+// into new files, so the bytes of the records are gone from the disk. The
+// values written before are kept by the change log until TruncateChanges.
+// The pinned shards are left as they are, see PurgeReport.Pinned. Every
+// purge is recorded in the audit log with the records removed per shard.
+func (db *TSEngine) PurgeWhere(start, end time.Time, predicate func(key, value []byte) bool) (*PurgeReport, error) {
+	report := &PurgeReport{Removed: map[string]int{}}
+	err := db.purgeWhere(start, end, predicate, report)
+
+	shards := make([]string, 0, len(report.Removed))
+	for file, n := range report.Removed {
+		shards = append(shards, fmt.Sprintf("%s=%d", db.shardKey(file), n))
+	}
+	sort.Strings(shards)
+	detail := fmt.Sprintf("purged %d records from %s to %s: %s",
+		report.Total, start.Format(time.RFC3339), end.Format(time.RFC3339), strings.Join(shards, ", "))
+	if len(report.Pinned) > 0 {
+		pinned := make([]string, len(report.Pinned))
+		for i, file := range report.Pinned {
+			pinned[i] = string(db.shardKey(file))
+		}
+		detail += ", pinned: " + strings.Join(pinned, ", ")
+	}
+	if e := db.audit("purge", false, detail, err); e != nil && err == nil {
+		err = e
+	}
+	return report, err
+}
+
+func (db *TSEngine) purgeWhere(start, end time.Time, predicate func(key, value []byte) bool, report *PurgeReport) error {
+	return filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		if pinned, err := db.isPinned(fileName); err != nil || pinned {
+			if pinned {
+				report.Pinned = append(report.Pinned, fileName)
+			}
+			return err
+		}
+		var removed int
+		err := db.readBackground(fileName, func(bkt *Bucket) error {
+			var err error
+			if removed, err = purgeShard(bkt, keyRanges(position, start, end), predicate); err != nil || removed == 0 {
+				return err
+			}
+			return unseal(bkt)
+		})
+		if err != nil || removed == 0 {
+			return err
+		}
+		report.Removed[fileName] = removed
+		report.Total += int64(removed)
+		return db.compactShard(fileName)
+	})
+}
+
+// purgeShard deletes the records of the key ranges matching the predicate
+// through the write hooks, and returns their number.
+func purgeShard(bkt *Bucket, spans []keySpan, predicate func(key, value []byte) bool) (int, error) {
+	removed := 0
+	err := bkt.store.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bkt.name)
+		if records == nil {
+			return nil
+		}
+		for _, span := range spans {
+			var keys [][]byte
+			it := Iterator{B: bkt, Cursor: records.Cursor(), isFirst: true}
+			if span.from != "" {
+				it.startKey = []byte(span.from)
+			}
+			if span.to != "" {
+				it.endKey = []byte(span.to)
+			}
+			for it.Next() {
+				value, err := it.plain()
+				if err != nil {
+					return bkt.checksumError(it.key, err)
+				}
+				if predicate(it.key, value) {
+					keys = append(keys, append([]byte(nil), it.key...))
+				}
+			}
+			for _, key := range keys {
+				if err := bkt.del(tx, records, key); err != nil {
+					return err
+				}
+			}
+			removed += len(keys)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// compactShard copies the shard into a new file renamed over it, leaving
+// out the free pages holding the deleted records. The values are
+// decompressed and the dictionary dropped, as it was trained on samples of
+// them, the shard is compressed again when sealed. A pinned shard fails
+// with ErrShardPinned.
+func (db *TSEngine) compactShard(file string) error {
+	if err := db.checkPinned(file); err != nil {
+		return err
+	}
+	if err := db.closeHandle(file); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(file), ".compact-"+filepath.Base(file))
+	if err := removeFile(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	src, err := bolt.Open(file, 0666, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	dst, err := bolt.Open(tmp, db.fileMode(), &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		src.Close()
+		return err
+	}
+	err = src.View(func(srcTx *bolt.Tx) error {
+		dict := txDict(srcTx)
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, srcBkt *bolt.Bucket) error {
+				if bytes.Equal(name, dictBucket) {
+					return nil
+				}
+				dstBkt, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				if string(name) != dataBucket {
+					return copyBucket(srcBkt, dstBkt, nil)
+				}
+				return copyBucket(srcBkt, dstBkt, func(v []byte) ([]byte, error) {
+					return decompressValue(v, dict)
+				})
+			})
+		})
+	})
+	src.Close()
+	if e := dst.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		removeFile(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// copyBucket copies the records and the nested buckets of src into dst,
+// the records through convert if it is not nil.
+func copyBucket(src, dst *bolt.Bucket, convert func(v []byte) ([]byte, error)) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), nested, nil)
+		}
+		if convert != nil {
+			var err error
+			if v, err = convert(v); err != nil {
+				return err
+			}
+		}
+		return dst.Put(k, v)
+	})
+}
@@ -0,0 +1,86 @@
+package borm_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestPurgeWhere(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		day := now.AddDate(0, 0, -i)
+		for j := 0; j < 10; j++ {
+			name := "bob"
+			if j%3 == 0 {
+				name = "alice-subject"
+			}
+			err := db.Write(day, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(day, uint32(j)), &ItemTest{ID: j, Name: name, Created: day})
+			})
+			if err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+	}
+
+	report, err := db.PurgeWhere(now.AddDate(0, 0, -2), now.Add(time.Minute), func(key, value []byte) bool {
+		return bytes.Contains(value, []byte("alice-subject"))
+	})
+	if err != nil {
+		t.Fatalf("Error purging: %s", err)
+	}
+	if report.Total != 8 || len(report.Removed) != 2 {
+		t.Fatalf("Unexpected purge report %+v", report)
+	}
+
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	for _, shard := range shards {
+		if report.Removed[shard.Path] != 4 {
+			t.Fatalf("Expected 4 records removed from %s, got %+v", shard.Path, report.Removed)
+		}
+		data, err := ioutil.ReadFile(shard.Path)
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", shard.Path, err)
+		}
+		if bytes.Contains(data, []byte("alice-subject")) {
+			t.Fatalf("The purged records are still in %s.", shard.Path)
+		}
+	}
+
+	n := 0
+	err = db.Query(now.AddDate(0, 0, -2), now.Add(time.Minute), func(it *borm.Iterator) error {
+		for it.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil || n != 12 {
+		t.Fatalf("Expected 12 records left, got %d: %v", n, err)
+	}
+
+	records, err := db.AuditLog(time.Time{})
+	if err != nil {
+		t.Fatalf("Error reading the audit log: %s", err)
+	}
+	if len(records) != 1 || records[0].Op != "purge" || !strings.HasPrefix(records[0].Detail, "purged 8 records") {
+		t.Fatalf("Unexpected audit log %+v", records)
+	}
+}
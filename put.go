@@ -20,9 +20,20 @@ type Updater interface {
 }
 
 type txUpdater struct {
-	b   *Bucket
-	tx  *bolt.Tx
-	bkt *bolt.Bucket
+	b     *Bucket
+	tx    *bolt.Tx
+	bkt   *bolt.Bucket
+	arena *arena
+
+	// prepared, when set, encodes the records instead of the bucket.
+	prepared *PreparedWriter
+}
+
+func (u *txUpdater) encode(data interface{}) ([]byte, error) {
+	if u.prepared != nil {
+		return u.prepared.encode(u.arena, data)
+	}
+	return u.b.encodeTo(u.arena, data)
 }
 
 // Insert inserts the passed in data into the the bolthold
@@ -33,12 +44,12 @@ func (u *txUpdater) Insert(key string, data interface{}) error {
 		return ErrKeyExists
 	}
 
-	bs, err := u.b.encode(data)
+	bs, err := u.encode(data)
 	if err != nil {
 		return err
 	}
 
-	return u.bkt.Put(gk, bs)
+	return u.b.put(u.tx, u.bkt, gk, bs)
 }
 
 // Update updates an existing record in the bolthold
@@ -49,28 +60,30 @@ func (u *txUpdater) Update(key string, data interface{}) error {
 		return ErrNotFound
 	}
 
-	bs, err := u.b.encode(data)
+	bs, err := u.encode(data)
 	if err != nil {
 		return err
 	}
 
-	return u.bkt.Put(gk, bs)
+	return u.b.put(u.tx, u.bkt, gk, bs)
 }
 
 // Upsert inserts the record into the bolthold if it doesn't exist.  If it does already exist, then it updates
 // the existing record
 func (u *txUpdater) Upsert(key string, data interface{}) error {
-	bs, err := u.b.encode(data)
+	bs, err := u.encode(data)
 	if err != nil {
 		return err
 	}
 
-	return u.bkt.Put([]byte(key), bs)
+	return u.b.put(u.tx, u.bkt, []byte(key), bs)
 }
 
 // Insert inserts the passed in data into the the bolthold
 // If the the key already exists in the bolthold, then an ErrKeyExists is returned
 func (b *Bucket) Insert(key string, data interface{}) error {
+	a := getArena()
+	defer a.release()
 	return b.store.db.Update(func(tx *bolt.Tx) error {
 		if !tx.Writable() {
 			return bolt.ErrTxNotWritable
@@ -85,18 +98,20 @@ func (b *Bucket) Insert(key string, data interface{}) error {
 			return ErrKeyExists
 		}
 
-		bs, err := b.encode(data)
+		bs, err := b.encodeTo(a, data)
 		if err != nil {
 			return err
 		}
 
-		return bkt.Put(gk, bs)
+		return b.put(tx, bkt, gk, bs)
 	})
 }
 
 // Update updates an existing record in the bolthold
 // if the Key doesn't already exist in the store, then it fails with ErrNotFound
 func (b *Bucket) Update(key string, data interface{}) error {
+	a := getArena()
+	defer a.release()
 	return b.store.db.Update(func(tx *bolt.Tx) error {
 		if !tx.Writable() {
 			return bolt.ErrTxNotWritable
@@ -111,18 +126,20 @@ func (b *Bucket) Update(key string, data interface{}) error {
 			return ErrNotFound
 		}
 
-		bs, err := b.encode(data)
+		bs, err := b.encodeTo(a, data)
 		if err != nil {
 			return err
 		}
 
-		return bkt.Put(gk, bs)
+		return b.put(tx, bkt, gk, bs)
 	})
 }
 
 // Upsert inserts the record into the bolthold if it doesn't exist.  If it does already exist, then it updates
 // the existing record
 func (b *Bucket) Upsert(key string, data interface{}) error {
+	a := getArena()
+	defer a.release()
 	return b.store.db.Update(func(tx *bolt.Tx) error {
 		if !tx.Writable() {
 			return bolt.ErrTxNotWritable
@@ -132,18 +149,20 @@ func (b *Bucket) Upsert(key string, data interface{}) error {
 			return ErrBucketNotFound
 		}
 
-		bs, err := b.encode(data)
+		bs, err := b.encodeTo(a, data)
 		if err != nil {
 			return err
 		}
 
-		return bkt.Put([]byte(key), bs)
+		return b.put(tx, bkt, []byte(key), bs)
 	})
 }
 
 // Upsert inserts the record into the bolthold if it doesn't exist.  If it does already exist, then it updates
 // the existing record
 func (b *Bucket) Write(cb func(store Updater) error) error {
+	a := getArena()
+	defer a.release()
 	return b.store.db.Update(func(tx *bolt.Tx) error {
 		if !tx.Writable() {
 			return bolt.ErrTxNotWritable
@@ -154,9 +173,10 @@ func (b *Bucket) Write(cb func(store Updater) error) error {
 		}
 
 		return cb(&txUpdater{
-			b:   b,
-			tx:  tx,
-			bkt: bkt,
+			b:     b,
+			tx:    tx,
+			bkt:   bkt,
+			arena: a,
 		})
 	})
 }
@@ -5,6 +5,9 @@
 package borm_test
 
 import (
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -299,3 +302,149 @@ func TestIssue14UpdateMatching(t *testing.T) {
 	})
 }
 */
+
+func TestAppendEncoder(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", borm.JSONEncode, borm.JSONDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket for append encoder test: %s", err)
+		}
+		bkt.SetAppendEncoder(borm.JSONAppendEncode)
+
+		// enough records to fill several arena chunks
+		for round := 0; round < 2; round++ {
+			err = bkt.Write(func(u borm.Updater) error {
+				for i := 0; i < 2000; i++ {
+					item := &ItemTest{ID: i, Name: strings.Repeat("x", i%100), Category: "append"}
+					if err := u.Upsert(fmt.Sprintf("key%04d", i), item); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error writing data for test: %s", err)
+			}
+		}
+
+		for i := 0; i < 2000; i++ {
+			result := &ItemTest{}
+			if err := bkt.Get(fmt.Sprintf("key%04d", i), result); err != nil {
+				t.Fatalf("Error getting record %d: %s", i, err)
+			}
+			if result.ID != i || result.Name != strings.Repeat("x", i%100) {
+				t.Fatalf("Record %d was corrupted: %v", i, result)
+			}
+		}
+	})
+}
+
+func TestPreparedWriter(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("bucktest", borm.JSONEncode, borm.JSONDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket for prepared writer test: %s", err)
+		}
+		w, err := bkt.Prepare(&ItemTest{})
+		if err != nil {
+			t.Fatalf("Error preparing writer: %s", err)
+		}
+
+		if err := w.Insert("key1", &ItemTest{ID: 1, Name: "car"}); err != nil {
+			t.Fatalf("Error inserting record: %s", err)
+		}
+		if err := w.Insert("key1", &ItemTest{ID: 1, Name: "car"}); err != borm.ErrKeyExists {
+			t.Fatalf("Insert of an existing key got %v wanted ErrKeyExists.", err)
+		}
+		if err := w.Update("key2", &ItemTest{ID: 2}); err != borm.ErrNotFound {
+			t.Fatalf("Update of a missing key got %v wanted ErrNotFound.", err)
+		}
+		if err := w.Upsert("key1", &ItemTest{ID: 1, Name: "truck"}); err != nil {
+			t.Fatalf("Error upserting record: %s", err)
+		}
+		if _, ok := w.Upsert("key3", ItemTest{ID: 3}).(*borm.ErrTypeMismatch); !ok {
+			t.Fatalf("Upsert of a record of another type didn't fail with ErrTypeMismatch.")
+		}
+
+		result := &ItemTest{}
+		if err := bkt.Get("key1", result); err != nil || result.Name != "truck" {
+			t.Fatalf("Error getting record: %v, got %v", err, result)
+		}
+
+		store.RegisterType("typed", &ItemTest{})
+		typed, err := store.CreateBucket("typed", borm.JSONEncode, borm.JSONDecode)
+		if err != nil {
+			t.Fatalf("Error creating typed bucket: %s", err)
+		}
+		if _, err := typed.Prepare(&struct{ Name string }{}); err == nil {
+			t.Fatalf("Prepare of another type than the declared one succeeded.")
+		}
+	})
+}
+
+func BenchmarkInsertPrepared(b *testing.B) {
+	file := tempfile()
+	defer os.Remove(file)
+	store, err := borm.Open(file, 0666, nil)
+	if err != nil {
+		b.Fatalf("Error opening %s: %s", file, err)
+	}
+	defer store.Close()
+
+	store.RegisterType("bench", &ItemTest{})
+	bkt, err := store.CreateBucket("bench", borm.JSONEncode, borm.JSONDecode)
+	if err != nil {
+		b.Fatalf("Error creating bucket: %s", err)
+	}
+	bkt.SetAppendEncoder(borm.JSONAppendEncode)
+	w, err := bkt.Prepare(&ItemTest{})
+	if err != nil {
+		b.Fatalf("Error preparing writer: %s", err)
+	}
+
+	item := &ItemTest{Name: "bench", Category: "append", Created: time.Now()}
+	b.ReportAllocs()
+	b.ResetTimer()
+	err = w.Write(func(u borm.Updater) error {
+		for i := 0; i < b.N; i++ {
+			if err := u.Upsert(fmt.Sprintf("key%09d", i), item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("Error writing: %s", err)
+	}
+}
+
+func BenchmarkInsertAppendEncoder(b *testing.B) {
+	file := tempfile()
+	defer os.Remove(file)
+	store, err := borm.Open(file, 0666, nil)
+	if err != nil {
+		b.Fatalf("Error opening %s: %s", file, err)
+	}
+	defer store.Close()
+
+	bkt, err := store.CreateBucket("bench", borm.JSONEncode, borm.JSONDecode)
+	if err != nil {
+		b.Fatalf("Error creating bucket: %s", err)
+	}
+	bkt.SetAppendEncoder(borm.JSONAppendEncode)
+
+	item := &ItemTest{Name: "bench", Category: "append", Created: time.Now()}
+	b.ReportAllocs()
+	b.ResetTimer()
+	err = bkt.Write(func(u borm.Updater) error {
+		for i := 0; i < b.N; i++ {
+			if err := u.Upsert(fmt.Sprintf("key%09d", i), item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("Error writing: %s", err)
+	}
+}
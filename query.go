@@ -0,0 +1,270 @@
+package borm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"time"
+)
+
+// QueryOptions allows you set different options from the defaults for a query
+type QueryOptions struct {
+	// ShardFilter is called with every shard of the time range before it is
+	// opened, shards it returns false for are skipped. The tags of the shard
+	// are read from the meta store, not from the shard.
+	ShardFilter func(info ShardInfo) bool
+
+	// Fields, when set, restricts Iterator.Read to decoding these top-level
+	// fields of the records, which must be JSON encoded.
+	Fields []string
+
+	// Redact drops or masks these top-level fields of the records, which
+	// must be JSON encoded, in what Iterator.Read and Iterator.Value return.
+	// Filter still matches the records as stored.
+	Redact []Redaction
+
+	// Progress is called after every shard and every ProgressInterval
+	// records read.
+	Progress ProgressFunc
+
+	// ResumeToken continues the query exactly after the record the token
+	// was taken from with Iterator.Token, e.g. after a restart. The query
+	// must have the same time range as the one the token comes from.
+	ResumeToken string
+
+	// DropCache evicts the historical shards from the page cache once they
+	// are read, so large scans don't evict the hot shard on machines short
+	// of memory. See also TSEngine.Prefetch.
+	DropCache bool
+
+	// MemoryBudget bounds the bytes of records decoded by Iterator.Read over
+	// the whole query, 0 means unlimited. Past it Read fails and the query
+	// aborts with ErrMemoryBudget.
+	MemoryBudget int64
+
+	// Filter restricts the records of the query to those matching the
+	// filter expression, the records must be JSON encoded. See ParseFilter.
+	Filter string
+
+	// SkipUndecodable skips the records Iterator.Read fails to decode: the
+	// callback returning the IterError is called again with an iterator
+	// after the record, instead of the query aborting.
+	SkipUndecodable bool
+
+	// OnSkip is called with the error of every record skipped.
+	OnSkip func(err *IterError)
+
+	// Bucket is the shard bucket of TSOptions.Buckets the query reads, the
+	// records by default. Its keys are the IDs of the time of their
+	// records, as NewID returns.
+	Bucket string
+
+	// Priority is the lane of the shard reads, e.g. BackgroundRead for an
+	// export yielding to the interactive queries.
+	Priority ReadPriority
+}
+
+// ErrMemoryBudget is returned when a query decodes more than its
+// QueryOptions.MemoryBudget.
+var ErrMemoryBudget = errors.New("query memory budget exceeded")
+
+// ProgressInterval is the number of records between two progress reports
+// within a shard.
+const ProgressInterval = 10000
+
+// queryState is the state of a query, shared by the iterators of its shards.
+type queryState struct {
+	opts     *QueryOptions
+	progress Progress
+	shardKey []byte
+	filter   *Filter
+
+	// decoded is the bytes of records decoded, err the error aborting the query.
+	decoded int64
+	err     error
+}
+
+// next is called by the iterator for every record read.
+func (q *queryState) next(it *Iterator) {
+	q.progress.Items++
+	q.progress.Bytes += int64(len(it.value))
+	if q.opts.Progress != nil && q.progress.Items%ProgressInterval == 0 {
+		q.opts.Progress(q.progress)
+	}
+}
+
+// decode accounts for a record about to be decoded.
+func (q *queryState) decode(value []byte) error {
+	if q.opts.MemoryBudget <= 0 {
+		return nil
+	}
+	q.decoded += int64(len(value))
+	if q.decoded > q.opts.MemoryBudget {
+		q.err = ErrMemoryBudget
+	}
+	return q.err
+}
+
+// readFunc opens a shard file for reading.
+type readFunc func(fileName string, cb func(bkt *Bucket) error) error
+
+// QueryWith retrieves the records in the time range as Query does, with the given options.
+func (db *TSEngine) QueryWith(start, end time.Time, opts *QueryOptions, cb func(it *Iterator) error) error {
+	return db.queryWith(db.reader(opts.lane()), start, end, opts, cb)
+}
+
+func (db *TSEngine) queryWith(read readFunc, start, end time.Time, opts *QueryOptions, cb func(it *Iterator) error) error {
+	if opts == nil {
+		opts = &QueryOptions{}
+	}
+
+	var resume *resumePoint
+	if opts.ResumeToken != "" {
+		var err error
+		if resume, err = decodeToken(opts.ResumeToken); err != nil {
+			return err
+		}
+	}
+
+	query := &queryState{opts: opts}
+	if opts.Filter != "" {
+		var err error
+		if query.filter, err = ParseFilter(opts.Filter); err != nil {
+			return err
+		}
+	}
+	handle := func(it *Iterator) error {
+		it.query = query
+		return cb(it)
+	}
+
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		shardKey := db.shardKey(fileName)
+		var after []byte
+		if resume != nil {
+			if !bytes.Equal(shardKey, resume.shard) {
+				// shards before the one of the token are done
+				return nil
+			}
+			after = resume.key
+			resume = nil
+		}
+
+		if opts.ShardFilter != nil {
+			info := shardInfo(fileName, day, db.options.ShardInterval)
+			meta, err := db.readShardMeta(fileName)
+			if err != nil {
+				return err
+			}
+			meta.fill(&info)
+			if !opts.ShardFilter(info) {
+				return nil
+			}
+		}
+
+		query.progress.Shard = fileName
+		query.shardKey = shardKey
+		segmentStart, items := time.Now(), query.progress.Items
+		err := read(fileName, func(bkt *Bucket) error {
+			spans := keyRanges(position, start, end)
+			for i := range spans {
+				if after != nil && string(after) >= spans[i].from {
+					// the smallest key after the last one read
+					spans[i].from = string(after) + "\x00"
+				}
+			}
+			bkt, err := db.shardBucket(bkt, opts.Bucket)
+			if err != nil {
+				return err
+			}
+			return query.getRanges(bkt, spans, handle)
+		})
+		db.slowOp(SlowQuery, fileName, db.options.SlowOps.Query, segmentStart, query.progress.Items-items, stopped(err))
+		if opts.DropCache {
+			db.dropCache(fileName, day)
+		}
+		if query.err != nil {
+			return query.err
+		}
+		if err == nil && opts.Progress != nil {
+			opts.Progress(query.progress)
+		}
+		return err
+	})
+	db.recordsRead.Add(query.progress.Items)
+	return stopped(err)
+}
+
+// getRanges calls cb with an iterator for every key range in turn, as
+// Bucket.getRanges does, resuming after the records failing to decode with
+// SkipUndecodable.
+func (q *queryState) getRanges(b *Bucket, spans []keySpan, cb func(it *Iterator) error) error {
+	for i := 0; i < len(spans); {
+		err := b.getRange(spans[i].from, spans[i].to, cb)
+		var ie *IterError
+		if err != nil && q.opts.SkipUndecodable && q.err == nil &&
+			errors.As(err, &ie) && ie.Op == OpDecode && ie.Key >= spans[i].from {
+			q.progress.Skipped++
+			if q.opts.OnSkip != nil {
+				q.opts.OnSkip(ie)
+			}
+			spans[i].from = ie.Key + "\x00"
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
+
+// keySpan is a key range of a shard, both ends included, "" is unbounded.
+type keySpan struct {
+	from, to string
+}
+
+// keyRanges returns the key ranges to read in a shard at the given position
+// of the queried time range. The IDs of every precision sort apart, a shard
+// cut by the time range is read with one range per precision.
+func keyRanges(position int, start, end time.Time) []keySpan {
+	if position == positionMiddle {
+		return []keySpan{{}}
+	}
+
+	spans := make([]keySpan, 0, len(idPrecisions))
+	for _, p := range idPrecisions {
+		span := keySpan{p.lowKey(), p.highKey()}
+		if position == positionStart || position == positionStartEnd {
+			span.from = CreateIDWithPrecision(start, 0, p)
+		}
+		if position == positionEnd || position == positionStartEnd {
+			span.to = CreateIDWithPrecision(end, 0, p)
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+// getRanges calls cb with an iterator for every key range in turn.
+func (b *Bucket) getRanges(spans []keySpan, cb func(it *Iterator) error) error {
+	for _, span := range spans {
+		if err := b.getRange(span.from, span.to, cb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardInfo returns the info of the shard file starting at start.
+func shardInfo(fileName string, start time.Time, g Granularity) ShardInfo {
+	info := ShardInfo{
+		Path:      fileName,
+		StartTime: start,
+		EndTime:   g.Next(start),
+	}
+	if fi, err := os.Stat(fileName); err == nil {
+		info.Size = fi.Size()
+	}
+	return info
+}
@@ -0,0 +1,84 @@
+package borm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Result is a record of QueryChan, copied out of its shard with the
+// redactions and the projection of the query applied.
+type Result struct {
+	RawRecord
+
+	// Token resumes the query right after the record, see
+	// QueryOptions.ResumeToken.
+	Token string
+}
+
+// QueryChan streams the records in the time range into the results channel,
+// for the consumers preferring channels to nested callbacks. The channel is
+// unbuffered, the query reads the next record once the previous one is
+// received, and the shard being read stays open meanwhile: a consumer
+// giving up before the end must cancel the context. The error channel gets
+// the error aborting the query, the one of the context if canceled, and
+// both channels are closed once the query is done.
+func (db *TSEngine) QueryChan(ctx context.Context, start, end time.Time, opts *QueryOptions) (<-chan Result, <-chan error) {
+	results := make(chan Result)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(results)
+
+		if err := ctx.Err(); err != nil {
+			errs <- err
+			return
+		}
+		err := db.QueryWith(start, end, opts, func(it *Iterator) error {
+			for it.Next() {
+				r, err := it.result()
+				if err != nil {
+					return err
+				}
+				select {
+				case results <- r:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if cerr := ctx.Err(); cerr != nil && errors.Is(err, cerr) {
+			// unwrapped from the IterError of the callback
+			err = cerr
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return results, errs
+}
+
+// result copies the current record out of the iterator.
+func (it *Iterator) result() (Result, error) {
+	value, err := it.visible()
+	if err != nil {
+		return Result{}, it.B.iterError(OpDecode, it.key, it.B.checksumError(it.key, err))
+	}
+	if opts := it.query.opts; opts.Fields != nil {
+		if value, err = ProjectJSON(value, opts.Fields); err != nil {
+			return Result{}, it.B.iterError(OpDecode, it.key, err)
+		}
+	}
+	if err := it.query.decode(value); err != nil {
+		return Result{}, err
+	}
+	return Result{
+		RawRecord: RawRecord{
+			Key:    append([]byte(nil), it.key...),
+			Value:  append([]byte(nil), value...),
+			decode: it.B.decodeValue,
+		},
+		Token: it.Token(),
+	}, nil
+}
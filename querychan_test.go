@@ -0,0 +1,64 @@
+package borm_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSQueryChan(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	tx := db.Begin(now)
+	for i := range testData {
+		item := testData[i]
+		item.Created = now
+		if _, err := tx.Insert(&item); err != nil {
+			t.Fatalf("Error inserting record: %s", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing records: %s", err)
+	}
+
+	results, errs := db.QueryChan(context.Background(), now.Add(-time.Second), now.Add(time.Second), nil)
+	count := 0
+	for r := range results {
+		var item ItemTest
+		if err := r.Read(&item); err != nil {
+			t.Fatalf("Error reading result %s: %s", r.Key, err)
+		}
+		if r.Token == "" {
+			t.Fatalf("Result %s has no resume token.", r.Key)
+		}
+		count++
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if count != len(testData) {
+		t.Fatalf("Received %d results wanted %d.", count, len(testData))
+	}
+
+	// a canceled consumer stops the query
+	ctx, cancel := context.WithCancel(context.Background())
+	results, errs = db.QueryChan(ctx, now.Add(-time.Second), now.Add(time.Second), nil)
+	<-results
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("Canceled query got %v wanted context.Canceled.", err)
+	}
+	for range results {
+	}
+}
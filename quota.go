@@ -0,0 +1,163 @@
+package borm
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrQuotaExceeded is matched by the errors of the writes rejected by a
+// quota, see QuotaError.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the records written per day, UTC, 0 is unlimited.
+type Quota struct {
+	Records int64
+	Bytes   int64
+}
+
+func (q Quota) zero() bool {
+	return q.Records == 0 && q.Bytes == 0
+}
+
+// QuotaError is the error of a write rejected by the quota of a namespace,
+// errors.Is matches it with ErrQuotaExceeded.
+type QuotaError struct {
+	// Namespace is empty for the global quota.
+	Namespace string
+	Global    bool
+
+	// Resource is "records" or "bytes".
+	Resource string
+	Limit    int64
+}
+
+func (e *QuotaError) Error() string {
+	scope := "namespace " + strconv.Quote(e.Namespace)
+	if e.Global {
+		scope = "global"
+	}
+	return scope + " quota of " + strconv.FormatInt(e.Limit, 10) + " " + e.Resource + "/day exceeded"
+}
+
+// Is makes errors.Is match the error with ErrQuotaExceeded.
+func (e *QuotaError) Is(err error) bool {
+	return err == ErrQuotaExceeded
+}
+
+// QuotaUsage counts the records of a namespace written today, and those
+// rejected.
+type QuotaUsage struct {
+	Records  int64
+	Bytes    int64
+	Rejected int64
+}
+
+// quotas are the counters of the quotas, kept in memory: they restart at
+// zero with the engine.
+type quotas struct {
+	mu     sync.Mutex
+	day    int64
+	used   map[string]*QuotaUsage
+	global QuotaUsage
+}
+
+// hasQuotas returns whether the options set a quota.
+func (options *TSOptions) hasQuotas() bool {
+	return len(options.Quotas) > 0 || !options.DefaultQuota.zero() || !options.GlobalQuota.zero()
+}
+
+// rollover resets the counters on a new day.
+func (q *quotas) rollover(now time.Time) {
+	day := now.UTC().Unix() / (24 * 60 * 60)
+	if q.day != day || q.used == nil {
+		q.day = day
+		q.used = map[string]*QuotaUsage{}
+		q.global = QuotaUsage{}
+	}
+}
+
+// checkQuota is the write hook rejecting the records past the quota of
+// their namespace or the global quota. The records are counted once their
+// transaction commits, so the records of a transaction may exceed a quota
+// by the size of the transaction.
+func (db *TSEngine) checkQuota(tx *bolt.Tx, key, value []byte) error {
+	if value == nil {
+		return nil
+	}
+	namespace := ""
+	if db.options.Namespace != nil {
+		namespace = db.options.Namespace(key, value)
+	}
+	quota, ok := db.options.Quotas[namespace]
+	if !ok {
+		quota = db.options.DefaultQuota
+	}
+	size := int64(len(key) + len(value))
+
+	q := &db.quotas
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover(db.now())
+	used := q.used[namespace]
+	if used == nil {
+		used = &QuotaUsage{}
+		q.used[namespace] = used
+	}
+
+	err := exceeds(quota, used, size, namespace, false)
+	if err == nil {
+		err = exceeds(db.options.GlobalQuota, &q.global, size, "", true)
+	}
+	if err != nil {
+		used.Rejected++
+		q.global.Rejected++
+		return err
+	}
+
+	day := q.day
+	tx.OnCommit(func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.rollover(db.now())
+		if q.day != day {
+			return
+		}
+		used := q.used[namespace]
+		if used == nil {
+			used = &QuotaUsage{}
+			q.used[namespace] = used
+		}
+		used.Records++
+		used.Bytes += size
+		q.global.Records++
+		q.global.Bytes += size
+	})
+	return nil
+}
+
+func exceeds(quota Quota, used *QuotaUsage, size int64, namespace string, global bool) error {
+	if quota.Records > 0 && used.Records+1 > quota.Records {
+		return &QuotaError{Namespace: namespace, Global: global, Resource: "records", Limit: quota.Records}
+	}
+	if quota.Bytes > 0 && used.Bytes+size > quota.Bytes {
+		return &QuotaError{Namespace: namespace, Global: global, Resource: "bytes", Limit: quota.Bytes}
+	}
+	return nil
+}
+
+// quotaStats returns a copy of the counters of today.
+func (db *TSEngine) quotaStats() (map[string]QuotaUsage, QuotaUsage) {
+	q := &db.quotas
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover(db.now())
+	usage := make(map[string]QuotaUsage, len(q.used))
+	for namespace, used := range q.used {
+		usage[namespace] = *used
+	}
+	return usage, q.global
+}
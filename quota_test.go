@@ -0,0 +1,69 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSQuota(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+		Namespace: func(key, value []byte) string {
+			var item ItemTest
+			json.Unmarshal(value, &item)
+			return item.Category
+		},
+		Quotas:       map[string]borm.Quota{"noisy": {Records: 2}},
+		DefaultQuota: borm.Quota{Records: 100},
+		GlobalQuota:  borm.Quota{Records: 5},
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	write := func(category string) error {
+		_, err := db.Backfill([]borm.Entry{{Time: time.Now(), Value: &ItemTest{Name: "x", Category: category}}}, nil)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := write("noisy"); err != nil {
+			t.Fatalf("Error writing under the quota: %s", err)
+		}
+	}
+	err = write("noisy")
+	if !errors.Is(err, borm.ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v.", err)
+	}
+	if qe, ok := err.(*borm.QuotaError); !ok || qe.Namespace != "noisy" || qe.Resource != "records" || qe.Limit != 2 {
+		t.Fatalf("Unexpected quota error %#v.", err)
+	}
+
+	// the other namespaces are not crowded out, up to the global quota
+	for i := 0; i < 3; i++ {
+		if err := write("quiet"); err != nil {
+			t.Fatalf("Error writing another namespace: %s", err)
+		}
+	}
+	if err := write("quiet"); err == nil || !err.(*borm.QuotaError).Global {
+		t.Fatalf("Expected the global quota to be exceeded, got %v.", err)
+	}
+
+	stats := db.Stats()
+	noisy, quiet := stats.Quotas["noisy"], stats.Quotas["quiet"]
+	if noisy.Records != 2 || noisy.Rejected != 1 || quiet.Records != 3 || quiet.Rejected != 1 {
+		t.Fatalf("Unexpected quota usage %+v %+v.", noisy, quiet)
+	}
+	if stats.GlobalQuota.Records != 5 || stats.GlobalQuota.Rejected != 2 || noisy.Bytes == 0 {
+		t.Fatalf("Unexpected global usage %+v.", stats.GlobalQuota)
+	}
+}
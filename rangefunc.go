@@ -0,0 +1,132 @@
+package borm
+
+import (
+	"errors"
+	"time"
+)
+
+// RangeFunc is a function evaluated over the samples of a numeric series in
+// a window.
+type RangeFunc int
+
+const (
+	// Rate is the per-second increase of a counter in the window.
+	Rate RangeFunc = iota
+	// Increase is the increase of a counter in the window, counter resets
+	// are accounted for.
+	Increase
+	// AvgOverTime is the moving average of the samples in the window.
+	AvgOverTime
+)
+
+// Point is a value of a range query at a step.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// RangeQuery evaluates a range function at every step of a time range, as
+// rate(x[5m]) or avg_over_time(x[5m]) would, so a graph doesn't need the raw
+// samples.
+type RangeQuery struct {
+	// Value returns the sample of the record, records it returns false for
+	// are not part of the series. The time of a sample is the time of the
+	// record key.
+	Value func(key, value []byte) (float64, bool)
+
+	Func RangeFunc
+
+	// Step is the interval between two points.
+	Step time.Duration
+
+	// Window is the span of samples a point is computed over, ending at the
+	// point. It defaults to Step.
+	Window time.Duration
+}
+
+type sample struct {
+	t time.Time
+	v float64
+}
+
+// QueryRange evaluates the range query from start to end, it returns a point
+// at every step with enough samples in its window: two for Rate and
+// Increase, one for AvgOverTime.
+func (db *TSEngine) QueryRange(start, end time.Time, opts *QueryOptions, q *RangeQuery) ([]Point, error) {
+	if q == nil || q.Value == nil {
+		return nil, errors.New("range query has no Value")
+	}
+	if q.Step <= 0 {
+		return nil, errors.New("range query step must be positive")
+	}
+	window := q.Window
+	if window <= 0 {
+		window = q.Step
+	}
+
+	// the key range of a query ends at the first ObjectId of its end second
+	var samples []sample
+	err := db.QueryWith(start.Add(-window), end.Add(time.Second), opts, func(it *Iterator) error {
+		for it.Next() {
+			t := TimeFromID(string(it.Key()))
+			if t.IsZero() {
+				continue
+			}
+			if v, ok := q.Value(it.Key(), it.Value()); ok {
+				samples = append(samples, sample{t, v})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var points []Point
+	first, last := 0, 0
+	for t := start; !t.After(end); t = t.Add(q.Step) {
+		// samples in (t - window, t]
+		for last < len(samples) && !samples[last].t.After(t) {
+			last++
+		}
+		for first < last && !samples[first].t.After(t.Add(-window)) {
+			first++
+		}
+		if v, ok := evalRange(q.Func, samples[first:last], window); ok {
+			points = append(points, Point{Time: t, Value: v})
+		}
+	}
+	return points, nil
+}
+
+func evalRange(fn RangeFunc, samples []sample, window time.Duration) (float64, bool) {
+	switch fn {
+	case AvgOverTime:
+		if len(samples) == 0 {
+			return 0, false
+		}
+		var sum float64
+		for _, s := range samples {
+			sum += s.v
+		}
+		return sum / float64(len(samples)), true
+	case Rate, Increase:
+		if len(samples) < 2 {
+			return 0, false
+		}
+		var increase float64
+		for i := 1; i < len(samples); i++ {
+			if delta := samples[i].v - samples[i-1].v; delta >= 0 {
+				increase += delta
+			} else {
+				// counter reset
+				increase += samples[i].v
+			}
+		}
+		if fn == Rate {
+			return increase / window.Seconds(), true
+		}
+		return increase, true
+	}
+	return 0, false
+}
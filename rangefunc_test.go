@@ -0,0 +1,75 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestQueryRange(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		start := time.Unix(time.Now().Add(-time.Hour).Unix(), 0)
+		// a counter sampled every 10s, increasing by 5, reset to 5 at 60s
+		for i := 0; i <= 12; i++ {
+			ts := start.Add(time.Duration(i) * 10 * time.Second)
+			value := i * 5
+			if i >= 6 {
+				value = (i-6)*5 + 5
+			}
+			err := db.Write(ts, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(ts, uint32(i)), &ItemTest{ID: value})
+			})
+			if err != nil {
+				t.Fatalf("Error writing sample %d: %s", i, err)
+			}
+		}
+
+		q := &borm.RangeQuery{
+			Value: func(key, value []byte) (float64, bool) {
+				var item ItemTest
+				if err := borm.DefaultDecode(value, &item); err != nil {
+					return 0, false
+				}
+				return float64(item.ID), true
+			},
+			Func:   borm.Increase,
+			Step:   30 * time.Second,
+			Window: 30 * time.Second,
+		}
+		points, err := db.QueryRange(start, start.Add(2*time.Minute), nil, q)
+		if err != nil {
+			t.Fatalf("Error querying range: %s", err)
+		}
+		// the window at 0s has one sample only
+		if len(points) != 4 {
+			t.Fatalf("Range query returned %d points, expected 4: %v", len(points), points)
+		}
+		for _, p := range points {
+			if p.Value != 10 {
+				t.Fatalf("Increase at %s is %v, expected 10.", p.Time, p.Value)
+			}
+		}
+
+		q.Func = borm.Rate
+		points, err = db.QueryRange(start, start.Add(2*time.Minute), nil, q)
+		if err != nil {
+			t.Fatalf("Error querying range: %s", err)
+		}
+		if math.Abs(points[0].Value-10.0/30) > 1e-9 {
+			t.Fatalf("Rate is %v, expected 1/3.", points[0].Value)
+		}
+
+		q.Func = borm.AvgOverTime
+		points, err = db.QueryRange(start, start.Add(30*time.Second), nil, q)
+		if err != nil {
+			t.Fatalf("Error querying range: %s", err)
+		}
+		if len(points) != 2 || points[0].Value != 0 || points[1].Value != 10 {
+			data, _ := json.Marshal(points)
+			t.Fatalf("Moving average is %s.", data)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package borm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repair is a leftover of a crash the engine repaired when opened.
+type Repair struct {
+	Path   string
+	Action string
+	Err    error
+}
+
+// Repairs returns the leftovers of a crash repaired when the engine was
+// opened, which are also logged.
+func (db *TSEngine) Repairs() []Repair {
+	return db.repairs
+}
+
+// recoverCrash removes the leftovers of an engine that crashed: the
+// temporary files of shard creations, snapshots, compactions, column
+// sidecars and manifests, the shards left empty and the lock files of
+// deleted shards. The stage of an interrupted MigrateCodec is kept for it to
+// resume, the one of an interrupted reshard is only reported.
+func (db *TSEngine) recoverCrash() ([]Repair, error) {
+	if _, err := os.Stat(db.basePath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var repairs []Repair
+	repair := func(file, action string, err error) {
+		r := Repair{Path: file, Action: action, Err: err}
+		if err != nil {
+			db.logger().Printf("engine failed to repair %s, %s: %s", file, action, err)
+		} else {
+			db.logger().Printf("engine repaired %s, %s", file, action)
+		}
+		repairs = append(repairs, r)
+	}
+
+	opts := db.listOptions()
+	err := filepath.Walk(db.basePath, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := fi.Name()
+		if fi.IsDir() {
+			switch {
+			case file == db.basePath:
+			case name == reshardDir:
+				db.logger().Printf("engine found the stage of an interrupted reshard at %s, reshard again to complete it", file)
+				return filepath.SkipDir
+			case strings.HasPrefix(name, "."):
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case strings.HasPrefix(name, ".") && strings.Contains(name, ".create-"),
+			strings.HasPrefix(name, ".snapshot-"),
+			strings.HasPrefix(name, ".compact-"),
+			strings.HasPrefix(name, ".init-"),
+			hasFileSuffix(name, columnsSuffix+".tmp"),
+			name == manifestFile+".tmp":
+			repair(file, "removed the temporary file", removeFile(file))
+		case hasFileSuffix(name, lockSuffix):
+			if _, err := os.Stat(file[:len(file)-len(lockSuffix)]); os.IsNotExist(err) {
+				repair(file, "removed the lock file of a deleted shard", removeFile(file))
+			}
+		case fi.Size() == 0 && !opts.ignored(name):
+			repair(file, "removed the empty shard", removeFile(file))
+		}
+		return nil
+	})
+	return repairs, err
+}
@@ -0,0 +1,82 @@
+package borm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTSCrashRecovery(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	old := time.Date(2020, time.January, 5, 12, 0, 0, 0, time.Local)
+	if _, err := db.Backfill([]borm.Entry{{Time: old, Value: &ItemTest{Created: old}}}, nil); err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+	db.Close()
+
+	leftovers := []string{
+		".2020_1.ts.create-1",
+		".snapshot-1",
+		".compact-2020_5.ts",
+		"2020_5.ts.cols.tmp",
+		".borm.json.tmp",
+		"2020_3.ts",
+		"2020_4.ts.lock",
+	}
+	kept := []string{
+		filepath.Join(".migrate", "progress.json"),
+		"2020_5.ts",
+		"2020_5.ts.lock",
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".migrate"), 0755); err != nil {
+		t.Fatalf("Error creating stage: %s", err)
+	}
+	for _, name := range append(leftovers, kept[:1]...) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", name, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "2020_5.ts.lock"), nil, 0644); err != nil {
+		t.Fatalf("Error writing lock file: %s", err)
+	}
+
+	db, err = borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error reopening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	repairs := db.Repairs()
+	if len(repairs) != len(leftovers) {
+		t.Fatalf("Repaired %v wanted %d leftovers.", repairs, len(leftovers))
+	}
+	for _, r := range repairs {
+		if r.Err != nil {
+			t.Fatalf("Error repairing %s: %s", r.Path, r.Err)
+		}
+	}
+	for _, name := range leftovers {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("Leftover %s wasn't removed: %v", name, err)
+		}
+	}
+	for _, name := range kept {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Error statting kept %s: %s", name, err)
+		}
+	}
+
+	if n, err := db.Count(old.Add(-time.Hour), old.Add(time.Hour), nil); err != nil || n != 1 {
+		t.Fatalf("Counted %d records of the repaired engine wanted 1: %v", n, err)
+	}
+}
@@ -0,0 +1,129 @@
+package borm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// DefaultMask replaces the value of a masked field when Redaction.Mask is
+// empty.
+const DefaultMask = "***"
+
+// Redaction hides a top-level field of JSON records from a query or an
+// export, e.g. the source IPs or the payload bodies of a support bundle.
+type Redaction struct {
+	Field string
+
+	// Drop removes the field, otherwise its value is replaced by the
+	// string Mask, DefaultMask if empty.
+	Drop bool
+	Mask string
+
+	// HashKey, when set, replaces the value by its Pseudonym under the key
+	// instead of a mask: equal values get equal pseudonyms, so shared
+	// datasets can still be joined on the field. Nulls are kept.
+	HashKey []byte
+}
+
+// Pseudonym returns the keyed hash, the hex of the first 16 bytes of the
+// HMAC-SHA256, of a value. A JSON string is hashed unquoted, any other value
+// as its JSON text.
+func Pseudonym(key, value []byte) string {
+	if len(value) > 0 && value[0] == '"' {
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			value = []byte(s)
+		}
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(value)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// RedactJSON returns the JSON object data with the redacted top-level
+// fields dropped, masked or pseudonymized, the other members are copied unchanged.
+func RedactJSON(data []byte, redactions []Redaction) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, '{')
+	err := scanJSONObject(data, func(key, rawKey, value []byte) {
+		for _, r := range redactions {
+			if string(key) != r.Field {
+				continue
+			}
+			if r.Drop {
+				return
+			}
+			if r.HashKey != nil {
+				if string(value) != "null" {
+					value, _ = json.Marshal(Pseudonym(r.HashKey, value))
+				}
+				break
+			}
+			mask := r.Mask
+			if mask == "" {
+				mask = DefaultMask
+			}
+			value, _ = json.Marshal(mask)
+			break
+		}
+		if len(out) > 1 {
+			out = append(out, ',')
+		}
+		out = append(out, rawKey...)
+		out = append(out, ':')
+		out = append(out, value...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '}'), nil
+}
+
+// RedactChange returns the change with the fields of its record redacted,
+// for shipping the change log to an anonymized replica. The record must be
+// JSON encoded, a delete is returned unchanged.
+func RedactChange(change Change, redactions []Redaction) (Change, error) {
+	if change.Value == nil {
+		return change, nil
+	}
+	value, err := stripChecksum(change.Value)
+	if err != nil {
+		return change, err
+	}
+	change.Value, err = RedactJSON(value, redactions)
+	return change, err
+}
+
+// RedactColumns returns the schema with its extractors called on the
+// redacted records, for QueryArrow and ExportParquet. A record that can't be
+// redacted, not being a JSON object, gives null in every column.
+func RedactColumns(schema []Column, redactions []Redaction) []Column {
+	cols := make([]Column, len(schema))
+	for i, col := range schema {
+		col := col
+		if col.Number != nil {
+			number := col.Number
+			col.Number = func(key, value []byte) (float64, bool) {
+				redacted, err := RedactJSON(value, redactions)
+				if err != nil {
+					return 0, false
+				}
+				return number(key, redacted)
+			}
+		}
+		if col.String != nil {
+			str := col.String
+			col.String = func(key, value []byte) (string, bool) {
+				redacted, err := RedactJSON(value, redactions)
+				if err != nil {
+					return "", false
+				}
+				return str(key, redacted)
+			}
+		}
+		cols[i] = col
+	}
+	return cols
+}
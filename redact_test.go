@@ -0,0 +1,112 @@
+package borm_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestRedactJSON(t *testing.T) {
+	redactions := []borm.Redaction{
+		{Field: "ip"},
+		{Field: "body", Drop: true},
+		{Field: "user", Mask: "anonymous"},
+	}
+	tests := []struct {
+		data   string
+		result string
+	}{
+		{`{"ip":"1.2.3.4","n":1}`, `{"ip":"***","n":1}`},
+		{`{ "body" : {"x":[1,2]}, "n":1 }`, `{"n":1}`},
+		{`{"n":1,"body":"x"}`, `{"n":1}`},
+		{`{"user":"bob","ip":null}`, `{"user":"anonymous","ip":"***"}`},
+		{`{}`, `{}`},
+	}
+
+	for _, tst := range tests {
+		result, err := borm.RedactJSON([]byte(tst.data), redactions)
+		if err != nil {
+			t.Fatalf("Error redacting %s: %s", tst.data, err)
+		}
+		if string(result) != tst.result {
+			t.Fatalf("Redacting %s got %s wanted %s.", tst.data, result, tst.result)
+		}
+	}
+
+	hashed, err := borm.RedactJSON([]byte(`{"ip":"1.2.3.4","port":80,"user":null}`), []borm.Redaction{
+		{Field: "ip", HashKey: []byte("k")},
+		{Field: "port", HashKey: []byte("k")},
+		{Field: "user", HashKey: []byte("k")},
+	})
+	if err != nil {
+		t.Fatalf("Error pseudonymizing: %s", err)
+	}
+	result := `{"ip":"` + borm.Pseudonym([]byte("k"), []byte("1.2.3.4")) + `","port":"` + borm.Pseudonym([]byte("k"), []byte("80")) + `","user":null}`
+	if string(hashed) != result {
+		t.Fatalf("Pseudonymizing got %s wanted %s.", hashed, result)
+	}
+	if borm.Pseudonym([]byte("k"), []byte("1.2.3.4")) == borm.Pseudonym([]byte("other"), []byte("1.2.3.4")) {
+		t.Fatalf("Pseudonyms under different keys are equal!")
+	}
+
+	if _, err := borm.RedactJSON([]byte(`[1]`), redactions); err == nil {
+		t.Fatalf("Redacting a JSON array didn't fail!")
+	}
+}
+
+func TestQueryRedact(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		if err := bkt.Insert(borm.CreateID(now, 1), &ItemTest{Name: "car", Category: "vehicle", Created: now}); err != nil {
+			return err
+		}
+		return bkt.Insert(borm.CreateID(now, 2), &ItemTest{Name: "apple", Category: "fruit", Created: now})
+	})
+	if err != nil {
+		t.Fatalf("Error writing records: %s", err)
+	}
+
+	var results []ItemTest
+	var values []string
+	err = db.QueryWith(now.Add(-time.Second), now.Add(time.Second), &borm.QueryOptions{
+		Filter: `Name = "car"`,
+		Redact: []borm.Redaction{{Field: "Name"}, {Field: "Category", Drop: true}},
+	}, func(it *borm.Iterator) error {
+		for it.Next() {
+			var result ItemTest
+			if err := it.Read(&result); err != nil {
+				return err
+			}
+			results = append(results, result)
+			values = append(values, string(it.Value()))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Query read %d records wanted 1.", len(results))
+	}
+	if results[0].Name != borm.DefaultMask || results[0].Category != "" {
+		t.Fatalf("Query read %v wanted the name masked and no category.", results[0])
+	}
+	if len(values) != 1 || strings.Contains(values[0], "car") || strings.Contains(values[0], "vehicle") {
+		t.Fatalf("Query values %v aren't redacted.", values)
+	}
+}
@@ -0,0 +1,464 @@
+// Package replica replicates a borm time series engine to followers over
+// gRPC, giving read replicas for query offloading and a standby to fail
+// over to. The leader engine keeps a change log (TSOptions.ChangeLog) and
+// serves it with the borm.replica.Replica service of a Server, which has two
+// server streams:
+//
+//	Snapshot    every record, after the sequence of the change log it starts at
+//	Changes     the changes after a sequence, as they are logged
+//
+// A Follower bootstraps from the snapshot then applies the changes as they
+// are logged, its position is saved with the engine cursors. The messages
+// are JSON encoded by the codec the package registers, there is no
+// generated code.
+//
+//	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+//	replica.NewServer(leader).Register(s)
+//	go s.Serve(lis)
+//
+// Protect the server with the interceptors of the auth package, the
+// follower sends its Options.Headers, e.g. the authorization:
+//
+//	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)),
+//		grpc.StreamInterceptor(a.StreamInterceptor(auth.Read)))
+//	f, err := replica.NewFollower(db, "leader:7070", &replica.Options{
+//		TLS:     tlsConfig,
+//		Headers: map[string]string{"authorization": "Bearer " + readToken},
+//	})
+//
+// Shards dropped whole by retention are not replicated, every engine runs
+// its own retention. A server with redactions, see NewServerWithOptions,
+// feeds an anonymized replica, e.g. to share datasets with researchers.
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the name of the gRPC service of the leader.
+const ServiceName = "borm.replica.Replica"
+
+// codecName is the content subtype of the messages, see jsonCodec.
+const codecName = "borm-json"
+
+// pollInterval bounds the wait of the changes stream for a change, so a
+// cancelled stream ends.
+const pollInterval = time.Second
+
+// jsonCodec encodes the messages of the service in JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return codecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// request is the request of a stream.
+type request struct {
+	// After is the sequence the changes follow.
+	After uint64 `json:"after,omitempty"`
+	// Limit is the largest number of changes of a message.
+	Limit int `json:"limit,omitempty"`
+}
+
+// message is a message of a stream, the first one of a snapshot has only
+// the sequence of the change log the follower continues from.
+type message struct {
+	Seq     uint64        `json:"seq,omitempty"`
+	Changes []borm.Change `json:"changes,omitempty"`
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Snapshot", ServerStreams: true, Handler: serve((*Server).snapshot)},
+		{StreamName: "Changes", ServerStreams: true, Handler: serve((*Server).changes)},
+	},
+}
+
+// snapshotStream and changesStream are the streams of serviceDesc.
+var (
+	snapshotStream = &serviceDesc.Streams[0]
+	changesStream  = &serviceDesc.Streams[1]
+)
+
+// serve returns the handler of a stream receiving its request first.
+func serve(fn func(s *Server, req *request, stream grpc.ServerStream) error) grpc.StreamHandler {
+	return func(srv interface{}, stream grpc.ServerStream) error {
+		var req request
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		if req.Limit <= 0 || req.Limit > 10000 {
+			req.Limit = 1000
+		}
+		return fn(srv.(*Server), &req, stream)
+	}
+}
+
+// Server serves the change log of the leader engine.
+type Server struct {
+	db     *borm.TSEngine
+	redact []borm.Redaction
+}
+
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// Redact drops, masks or pseudonymizes these fields of the records,
+	// which must be JSON encoded, before they are served. Pseudonyms keep
+	// the records of the replica joinable on the field.
+	Redact []borm.Redaction
+}
+
+// NewServer returns the server of the leader engine, which needs a change
+// log.
+func NewServer(db *borm.TSEngine) *Server {
+	return NewServerWithOptions(db, nil)
+}
+
+// NewServerWithOptions returns the server of the leader engine with the
+// options.
+func NewServerWithOptions(db *borm.TSEngine, opts *ServerOptions) *Server {
+	s := &Server{db: db}
+	if opts != nil {
+		s.redact = opts.Redact
+	}
+	return s
+}
+
+// Register registers the service on the gRPC server.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	r.RegisterService(&serviceDesc, s)
+}
+
+// redacted applies the redactions to the changes.
+func (s *Server) redacted(changes []borm.Change) error {
+	if s.redact == nil {
+		return nil
+	}
+	for i := range changes {
+		var err error
+		if changes[i], err = borm.RedactChange(changes[i], s.redact); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the changes in a message.
+func (s *Server) send(stream grpc.ServerStream, changes []borm.Change) error {
+	if err := s.redacted(changes); err != nil {
+		return err
+	}
+	return stream.SendMsg(&message{Changes: changes})
+}
+
+func (s *Server) snapshot(req *request, stream grpc.ServerStream) error {
+	seq, err := s.db.LastChange()
+	if err != nil {
+		return err
+	}
+	// the sequence is taken before SnapshotChanges takes its own, the older
+	// one only makes the follower apply some changes twice
+	if err := stream.SendMsg(&message{Seq: seq}); err != nil {
+		return err
+	}
+	batch := make([]borm.Change, 0, req.Limit)
+	if _, err := s.db.SnapshotChanges(func(change borm.Change) error {
+		if change.Value != nil {
+			// the value is only valid during the callback
+			change.Value = append([]byte{}, change.Value...)
+		}
+		if batch = append(batch, change); len(batch) < req.Limit {
+			return nil
+		}
+		err := s.send(stream, batch)
+		batch = batch[:0]
+		return err
+	}); err != nil {
+		return err
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.send(stream, batch)
+}
+
+func (s *Server) changes(req *request, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	for after := req.After; ctx.Err() == nil; {
+		changes, err := s.db.Changes(after, req.Limit)
+		if err == borm.ErrChangesTruncated {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			if _, err := s.db.WaitChanges(after, pollInterval); err != nil {
+				return err
+			}
+			continue
+		}
+		after = changes[len(changes)-1].Seq
+		if err := s.send(stream, changes); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// Options configures a Follower.
+type Options struct {
+	// Name is the name of the cursor of the follower, it defaults to
+	// "replica".
+	Name string
+
+	// TLS connects to the leader with TLS, nil connects without.
+	TLS *borm.TLSConfig
+
+	// DialOptions are added to the options of the connection.
+	DialOptions []grpc.DialOption
+
+	// Headers are sent with the requests, e.g. the authorization.
+	Headers map[string]string
+
+	// BatchSize is the number of changes applied together, it defaults to
+	// 1000.
+	BatchSize int
+
+	// RetryInterval is the wait after a failure, it defaults to a second.
+	RetryInterval time.Duration
+
+	// OnError is called with the failures, which are retried, nil logs them.
+	OnError func(err error)
+}
+
+// Follower applies the changes of a leader to its engine, which must not
+// be written otherwise until the follower is promoted.
+type Follower struct {
+	db   *borm.TSEngine
+	conn *grpc.ClientConn
+	opts Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	running chan struct{}
+}
+
+// errGone is the truncation of the changes the follower needs.
+var errGone = errors.New("replica: changes truncated on the leader")
+
+// NewFollower returns the follower of the leader server at the target, e.g.
+// "leader:7070", for the engine.
+func NewFollower(db *borm.TSEngine, target string, opts *Options) (*Follower, error) {
+	f := &Follower{db: db}
+	if opts != nil {
+		f.opts = *opts
+	}
+	if f.opts.Name == "" {
+		f.opts.Name = "replica"
+	}
+	if f.opts.BatchSize <= 0 {
+		f.opts.BatchSize = 1000
+	}
+	if f.opts.RetryInterval <= 0 {
+		f.opts.RetryInterval = time.Second
+	}
+
+	creds := insecure.NewCredentials()
+	if f.opts.TLS != nil {
+		config, err := f.opts.TLS.Client()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(config)
+	}
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codecName)),
+	}, f.opts.DialOptions...)
+	conn, err := grpc.Dial(target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	f.conn = conn
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	return f, nil
+}
+
+// Seq returns the sequence of the last change of the leader applied.
+func (f *Follower) Seq() (uint64, error) {
+	return f.db.Cursor(f.opts.Name)
+}
+
+// snapshotCursor is the cursor marking a completed bootstrap.
+func (f *Follower) snapshotCursor() string {
+	return f.opts.Name + ".snapshot"
+}
+
+// Run follows the leader until Promote, bootstrapping from a snapshot
+// first if the engine never followed it or the leader truncated the changes
+// it needs. Failures are reported and retried.
+func (f *Follower) Run() error {
+	f.mu.Lock()
+	if f.running != nil || f.ctx.Err() != nil {
+		f.mu.Unlock()
+		return errors.New("replica: follower is running or promoted")
+	}
+	running := make(chan struct{})
+	f.running = running
+	f.mu.Unlock()
+	defer close(running)
+
+	for f.ctx.Err() == nil {
+		err := f.follow()
+		if err == errGone {
+			err = f.db.SetCursor(f.snapshotCursor(), 0)
+		}
+		if err == nil || f.ctx.Err() != nil {
+			continue
+		}
+		if f.opts.OnError != nil {
+			f.opts.OnError(err)
+		} else {
+			log.Printf("replica follower failed to follow the leader: %s", err)
+		}
+		select {
+		case <-f.ctx.Done():
+		case <-time.After(f.opts.RetryInterval):
+		}
+	}
+	return nil
+}
+
+// Promote stops following the leader, waiting for the changes being
+// applied, so the engine can take writes, e.g. on failover.
+func (f *Follower) Promote() {
+	f.cancel()
+	f.mu.Lock()
+	running := f.running
+	f.mu.Unlock()
+	if running != nil {
+		<-running
+	}
+	f.conn.Close()
+}
+
+func (f *Follower) follow() error {
+	bootstrapped, err := f.db.Cursor(f.snapshotCursor())
+	if err != nil {
+		return err
+	}
+	if bootstrapped == 0 {
+		return f.bootstrap()
+	}
+
+	seq, err := f.db.Cursor(f.opts.Name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(f.ctx)
+	defer cancel()
+	stream, err := f.stream(ctx, changesStream, &request{After: seq, Limit: f.opts.BatchSize})
+	if err != nil {
+		return err
+	}
+	for {
+		var msg message
+		err := stream.RecvMsg(&msg)
+		if status.Code(err) == codes.OutOfRange {
+			return errGone
+		}
+		if err != nil {
+			return err
+		}
+		if len(msg.Changes) == 0 {
+			continue
+		}
+		if err := f.db.ApplyChanges(msg.Changes); err != nil {
+			return err
+		}
+		if err := f.db.SetCursor(f.opts.Name, msg.Changes[len(msg.Changes)-1].Seq); err != nil {
+			return err
+		}
+	}
+}
+
+// bootstrap applies the snapshot of the leader and continues from its
+// sequence.
+func (f *Follower) bootstrap() error {
+	ctx, cancel := context.WithCancel(f.ctx)
+	defer cancel()
+	stream, err := f.stream(ctx, snapshotStream, &request{Limit: f.opts.BatchSize})
+	if err != nil {
+		return err
+	}
+	var first message
+	if err := stream.RecvMsg(&first); err != nil {
+		return err
+	}
+
+	// the records the leader deleted since the last bootstrap are deleted
+	applier := f.db.ApplySnapshot()
+	for {
+		var msg message
+		err := stream.RecvMsg(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := applier.Apply(msg.Changes); err != nil {
+			return err
+		}
+	}
+	if err := applier.Finish(); err != nil {
+		return err
+	}
+
+	if err := f.db.SetCursor(f.opts.Name, first.Seq); err != nil {
+		return err
+	}
+	return f.db.SetCursor(f.snapshotCursor(), 1)
+}
+
+// stream opens the stream of the leader with the request.
+func (f *Follower) stream(ctx context.Context, desc *grpc.StreamDesc, req *request) (grpc.ClientStream, error) {
+	for k, v := range f.opts.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+	stream, err := f.conn.NewStream(ctx, desc, "/"+ServiceName+"/"+desc.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
@@ -0,0 +1,377 @@
+package replica_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/auth"
+	"github.com/runner-mei/borm/replica"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type event struct {
+	Name string
+}
+
+func open(t *testing.T, dir string, changeLog bool) *borm.TSEngine {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Error creating %s: %s", dir, err)
+	}
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder:   borm.JSONEncode,
+		Decoder:   borm.JSONDecode,
+		ChangeLog: changeLog,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	return db
+}
+
+// serve serves the replica server on a local address, until stopped.
+func serve(t *testing.T, srv *replica.Server, opts ...grpc.ServerOption) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	s := grpc.NewServer(opts...)
+	srv.Register(s)
+	go s.Serve(lis)
+	return lis.Addr().String(), s.Stop
+}
+
+// waitFor waits until the follower applied the last change of the leader.
+func waitFor(t *testing.T, f *replica.Follower, leader *borm.TSEngine) {
+	last, err := leader.LastChange()
+	if err != nil {
+		t.Fatalf("Error reading last change: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		seq, err := f.Seq()
+		if err != nil {
+			t.Fatalf("Error reading follower sequence: %s", err)
+		}
+		if seq >= last {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Follower at %d, expected %d.", seq, last)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func count(t *testing.T, db *borm.TSEngine, start, end time.Time) []string {
+	var names []string
+	err := db.Query(start, end, func(it *borm.Iterator) error {
+		for it.Next() {
+			var e event
+			if err := it.Read(&e); err != nil {
+				return err
+			}
+			names = append(names, e.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	return names
+}
+
+func TestFollower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	leader := open(t, filepath.Join(dir, "leader"), true)
+	defer leader.Close()
+
+	start := time.Now().Add(-48 * time.Hour).Truncate(time.Hour)
+	end := time.Now().Add(time.Hour)
+	var entries []borm.Entry
+	for i := 0; i < 10; i++ {
+		entries = append(entries, borm.Entry{Time: start.Add(time.Duration(i) * 5 * time.Hour), Value: &event{Name: "old"}})
+	}
+	if _, err := leader.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	// the snapshot starts after the changes, which are truncated
+	last, _ := leader.LastChange()
+	if err := leader.TruncateChanges(last); err != nil {
+		t.Fatalf("Error truncating changes: %s", err)
+	}
+
+	addr, stop := serve(t, replica.NewServer(leader))
+	defer stop()
+
+	follower := open(t, filepath.Join(dir, "follower"), false)
+	defer follower.Close()
+	f, err := replica.NewFollower(follower, addr, &replica.Options{RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error creating follower: %s", err)
+	}
+	done := make(chan error)
+	go func() { done <- f.Run() }()
+
+	waitFor(t, f, leader)
+	if names := count(t, follower, start, end); len(names) != 10 {
+		t.Fatalf("Expected 10 records of the snapshot, got %d.", len(names))
+	}
+
+	// changes are streamed
+	if _, err := leader.Backfill([]borm.Entry{{Time: time.Now(), Value: &event{Name: "new"}}}, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if _, err := leader.DeleteRange(start, start.Add(time.Second), false); err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+	waitFor(t, f, leader)
+	names := count(t, follower, start, end)
+	if len(names) != 10 || names[len(names)-1] != "new" {
+		t.Fatalf("Expected 10 records ending with the new one, got %v.", names)
+	}
+
+	// a follower behind truncated changes bootstraps again, without the
+	// records deleted meanwhile
+	f.Promote()
+	if err := <-done; err != nil {
+		t.Fatalf("Error running follower: %s", err)
+	}
+	if _, err := leader.Backfill([]borm.Entry{{Time: time.Now(), Value: &event{Name: "newer"}}}, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if _, err := leader.DeleteRange(start.Add(5*time.Hour), start.Add(10*time.Hour+time.Second), false); err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+	last, _ = leader.LastChange()
+	if err := leader.TruncateChanges(last); err != nil {
+		t.Fatalf("Error truncating changes: %s", err)
+	}
+
+	f, err = replica.NewFollower(follower, addr, &replica.Options{RetryInterval: 10 * time.Millisecond, OnError: func(error) {}})
+	if err != nil {
+		t.Fatalf("Error creating follower: %s", err)
+	}
+	go func() { done <- f.Run() }()
+	waitFor(t, f, leader)
+	f.Promote()
+	<-done
+	if names := count(t, follower, start, end); len(names) != 9 {
+		t.Fatalf("Expected 9 records after the new snapshot, got %d.", len(names))
+	}
+}
+
+func TestAnonymizedFollower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	leader := open(t, filepath.Join(dir, "leader"), true)
+	defer leader.Close()
+
+	now := time.Now()
+	if _, err := leader.Backfill([]borm.Entry{{Time: now, Value: &event{Name: "alice"}}}, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+
+	key := []byte("secret")
+	addr, stop := serve(t, replica.NewServerWithOptions(leader, &replica.ServerOptions{
+		Redact: []borm.Redaction{{Field: "Name", HashKey: key}},
+	}))
+	defer stop()
+
+	follower := open(t, filepath.Join(dir, "follower"), false)
+	defer follower.Close()
+	f, err := replica.NewFollower(follower, addr, &replica.Options{RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error creating follower: %s", err)
+	}
+	done := make(chan error)
+	go func() { done <- f.Run() }()
+	defer func() {
+		f.Promote()
+		<-done
+	}()
+	waitFor(t, f, leader)
+
+	// the changes streamed after the snapshot are pseudonymized too
+	if _, err := leader.Backfill([]borm.Entry{{Time: now, Value: &event{Name: "alice"}}}, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	waitFor(t, f, leader)
+
+	pseudonym := borm.Pseudonym(key, []byte("alice"))
+	names := count(t, follower, now.Add(-time.Second), now.Add(time.Second))
+	if len(names) != 2 || names[0] != pseudonym || names[1] != pseudonym {
+		t.Fatalf("Expected 2 records named %s, got %v.", pseudonym, names)
+	}
+}
+
+func TestChangeLogRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	leader := open(t, filepath.Join(dir, "leader"), true)
+	defer leader.Close()
+
+	now := time.Now()
+	aborted := errors.New("aborted")
+	err = leader.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Write(func(u borm.Updater) error {
+			if err := u.Insert(leader.NewID(now), &event{Name: "rolled back"}); err != nil {
+				return err
+			}
+			return aborted
+		})
+	})
+	if err != aborted {
+		t.Fatalf("Write got %v wanted %s.", err, aborted)
+	}
+	if last, err := leader.LastChange(); err != nil || last != 0 {
+		t.Fatalf("Last change is %d, %v wanted none.", last, err)
+	}
+
+	err = leader.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Write(func(u borm.Updater) error {
+			for _, name := range []string{"alice", "bob"} {
+				if err := u.Insert(leader.NewID(now), &event{Name: name}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	changes, err := leader.Changes(0, 10)
+	if err != nil || len(changes) != 2 || changes[0].Seq != 1 || changes[1].Seq != 2 {
+		t.Fatalf("Changes got %+v, %v wanted the 2 committed records.", changes, err)
+	}
+}
+
+func TestChangeLogCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leader")
+	leader := open(t, path, true)
+
+	now := time.Now()
+	entries := []borm.Entry{{Time: now, Value: &event{Name: "alice"}}, {Time: now, Value: &event{Name: "bob"}}}
+	if _, err := leader.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if err := leader.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+
+	// a crash after the writes committed, before their changes were logged
+	meta, err := bolt.Open(filepath.Join(path, ".meta"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening meta store: %s", err)
+	}
+	err = meta.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"changes", "shards"} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	meta.Close()
+	if err != nil {
+		t.Fatalf("Error dropping the change log: %s", err)
+	}
+
+	leader = open(t, path, true)
+	defer leader.Close()
+	if repairs := leader.Repairs(); len(repairs) != 1 || repairs[0].Action != "logged 2 changes" {
+		t.Fatalf("Repairs got %+v wanted the 2 changes logged.", repairs)
+	}
+	if _, err := leader.Backfill([]borm.Entry{{Time: now, Value: &event{Name: "carol"}}}, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	changes, err := leader.Changes(0, 10)
+	if err != nil || len(changes) != 3 || changes[2].Seq != 3 {
+		t.Fatalf("Changes got %+v, %v wanted the 3 records once.", changes, err)
+	}
+}
+
+func TestAuthorizedFollower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	leader := open(t, filepath.Join(dir, "leader"), true)
+	defer leader.Close()
+	now := time.Now()
+	if _, err := leader.Backfill([]borm.Entry{{Time: now, Value: &event{Name: "a"}}}, nil); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+
+	a, err := auth.New([]auth.Token{{Subject: "replica", Secret: "r", Role: auth.Read}})
+	if err != nil {
+		t.Fatalf("Error creating authorizer: %s", err)
+	}
+	addr, stop := serve(t, replica.NewServer(leader), grpc.StreamInterceptor(a.StreamInterceptor(auth.Read)))
+	defer stop()
+
+	follower := open(t, filepath.Join(dir, "follower"), false)
+	defer follower.Close()
+
+	// a follower without a token is refused
+	refused := make(chan error, 1)
+	f, err := replica.NewFollower(follower, addr, &replica.Options{RetryInterval: time.Hour, OnError: func(err error) {
+		select {
+		case refused <- err:
+		default:
+		}
+	}})
+	if err != nil {
+		t.Fatalf("Error creating follower: %s", err)
+	}
+	done := make(chan error)
+	go func() { done <- f.Run() }()
+	if err := <-refused; status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected the follower without token unauthenticated, got %v.", err)
+	}
+	f.Promote()
+	<-done
+
+	f, err = replica.NewFollower(follower, addr, &replica.Options{
+		RetryInterval: 10 * time.Millisecond,
+		Headers:       map[string]string{"authorization": "Bearer r"},
+	})
+	if err != nil {
+		t.Fatalf("Error creating follower: %s", err)
+	}
+	go func() { done <- f.Run() }()
+	waitFor(t, f, leader)
+	f.Promote()
+	<-done
+	if names := count(t, follower, now.Add(-time.Hour), now.Add(time.Hour)); len(names) != 1 {
+		t.Fatalf("Expected the record of the leader, got %v.", names)
+	}
+}
@@ -0,0 +1,257 @@
+package borm
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ReprocessInput is an input of a ReprocessFunc, a raw record or a dead
+// letter.
+type ReprocessInput struct {
+	// Key and Time are those of the record, of the rejected record of a
+	// dead letter, empty for a dead letter of an input never decoded.
+	Key  string
+	Time time.Time
+	Raw  []byte
+
+	// DeadLetter is the dead letter of the input, nil for a record.
+	DeadLetter *DeadLetter
+}
+
+// ReprocessFunc parses or transforms an input again, e.g. with a parser
+// bug fixed, into the corrected entries. No entries drop the input.
+type ReprocessFunc func(in ReprocessInput) ([]Entry, error)
+
+// ReprocessOptions configures ReprocessRange and ReprocessDeadLetters.
+type ReprocessOptions struct {
+	// Source is the engine of the raw records of ReprocessRange, the engine
+	// itself by default, e.g. an engine storing the input as received.
+	Source *TSEngine
+
+	// Origin selects the dead letters of ReprocessDeadLetters, all of them
+	// by default.
+	Origin string
+
+	// KeepDeadLetters keeps the dead letters reprocessed, by default they
+	// are deleted once their entries are written.
+	KeepDeadLetters bool
+
+	// DeadLetter, when set, is the origin of the dead letters of the records
+	// the transform fails on and of the entries rejected by a validator,
+	// which fail the reprocessing otherwise. A dead letter the transform
+	// fails on is kept as it is.
+	DeadLetter string
+
+	// BatchSize is the number of inputs read, transformed and written at
+	// a time, it defaults to 500.
+	BatchSize int
+
+	// Progress is called after every batch, Items are the inputs done and
+	// Skipped the ones dead-lettered.
+	Progress ProgressFunc
+}
+
+// ReprocessResult counts the inputs of a reprocessing.
+type ReprocessResult struct {
+	Inputs int64
+	// Written are the entries written, Dropped the inputs without entries
+	// and DeadLettered the records and the entries dead-lettered.
+	Written      int64
+	Dropped      int64
+	DeadLettered int64
+}
+
+// errBatchFull stops the read of a batch.
+var errBatchFull = errors.New("batch full")
+
+// reprocessor writes the entries of the inputs batch by batch.
+type reprocessor struct {
+	db        *TSEngine
+	transform ReprocessFunc
+	opts      ReprocessOptions
+	result    ReprocessResult
+	progress  Progress
+}
+
+func (db *TSEngine) newReprocessor(transform ReprocessFunc, opts *ReprocessOptions) *reprocessor {
+	p := &reprocessor{db: db, transform: transform}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.Source == nil {
+		p.opts.Source = db
+	}
+	if p.opts.BatchSize <= 0 {
+		p.opts.BatchSize = 500
+	}
+	return p
+}
+
+// ReprocessRange runs the transform over the raw records of the source
+// engine from start to end and writes the entries into the engine, e.g. to
+// re-ingest the last week once a parser bug is fixed. The entries are
+// written with Backfill, overwriting: those without a key get the key of
+// their input if they have its time, a composite key of it otherwise, so
+// reprocessing the same records again overwrites the same entries. The
+// records of the source are left as they are.
+//
+// The records are read in batches, the reads and writes of a batch don't
+// overlap, so the source may be the engine itself to correct the records in
+// place. The entries written past the batch are then read as inputs too, so
+// the transform must keep a single entry of the time of its input.
+func (db *TSEngine) ReprocessRange(start, end time.Time, transform ReprocessFunc, opts *ReprocessOptions) (ReprocessResult, error) {
+	p := db.newReprocessor(transform, opts)
+	token := ""
+	for {
+		var inputs []ReprocessInput
+		qopts := &QueryOptions{ResumeToken: token, Priority: BackgroundRead}
+		err := p.opts.Source.QueryWith(start, end, qopts, func(it *Iterator) error {
+			for it.Next() {
+				if len(inputs) == p.opts.BatchSize {
+					return errBatchFull
+				}
+				key := string(it.Key())
+				inputs = append(inputs, ReprocessInput{
+					Key:  key,
+					Time: TimeFromID(key),
+					Raw:  append([]byte(nil), it.Value()...),
+				})
+				token = it.Token()
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBatchFull) {
+			return p.result, err
+		}
+		if err := p.batch(inputs); err != nil {
+			return p.result, err
+		}
+		if err == nil {
+			return p.result, nil
+		}
+	}
+}
+
+// ReprocessDeadLetters runs the transform over the dead letters of the
+// engine, oldest first, and writes the entries into the engine as
+// ReprocessRange does. The dead letters are deleted once their entries are
+// written, unless KeepDeadLetters is set.
+func (db *TSEngine) ReprocessDeadLetters(transform ReprocessFunc, opts *ReprocessOptions) (ReprocessResult, error) {
+	p := db.newReprocessor(transform, opts)
+	var after uint64
+	for {
+		var inputs []ReprocessInput
+		err := db.deadLettersAfter(p.opts.Origin, after, func(letter DeadLetter) error {
+			if len(inputs) == p.opts.BatchSize {
+				return errBatchFull
+			}
+			inputs = append(inputs, ReprocessInput{
+				Key:        letter.Key,
+				Time:       letter.RecordTime,
+				Raw:        letter.Raw,
+				DeadLetter: &letter,
+			})
+			after = letter.Seq
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBatchFull) {
+			return p.result, err
+		}
+		if err := p.batch(inputs); err != nil {
+			return p.result, err
+		}
+		if err == nil {
+			return p.result, nil
+		}
+	}
+}
+
+// batch transforms and writes a batch of inputs.
+func (p *reprocessor) batch(inputs []ReprocessInput) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	var entries []Entry
+	var letters []DeadLetter
+	var done []uint64
+	dropped := 0
+	for i := range inputs {
+		in := &inputs[i]
+		out, err := p.transform(*in)
+		if err != nil {
+			if p.opts.DeadLetter == "" {
+				return err
+			}
+			if in.DeadLetter == nil {
+				letters = append(letters, DeadLetter{
+					Origin:     p.opts.DeadLetter,
+					Reason:     err.Error(),
+					Key:        in.Key,
+					RecordTime: in.Time,
+					Raw:        in.Raw,
+				})
+			}
+			continue
+		}
+		if len(out) == 0 {
+			dropped++
+		}
+		for j, entry := range out {
+			entry.Key = reprocessKey(in, entry, j)
+			entries = append(entries, entry)
+		}
+		if in.DeadLetter != nil {
+			done = append(done, in.DeadLetter.Seq)
+		}
+	}
+
+	var rejected int64
+	if len(entries) > 0 {
+		bopts := &BackfillOptions{
+			Overwrite:  true,
+			DeadLetter: p.opts.DeadLetter,
+			Progress:   func(progress Progress) { rejected = progress.Skipped },
+		}
+		if _, err := p.db.Backfill(entries, bopts); err != nil {
+			return err
+		}
+	}
+	if len(letters) > 0 {
+		if err := p.db.addDeadLetters(letters); err != nil {
+			return err
+		}
+	}
+	if len(done) > 0 && !p.opts.KeepDeadLetters {
+		if err := p.db.DeleteDeadLetters(done...); err != nil {
+			return err
+		}
+	}
+
+	p.result.Inputs += int64(len(inputs))
+	p.result.Written += int64(len(entries)) - rejected
+	p.result.Dropped += int64(dropped)
+	p.result.DeadLettered += int64(len(letters)) + rejected
+	p.progress.Items = p.result.Inputs
+	p.progress.Skipped = p.result.DeadLettered
+	if p.opts.Progress != nil {
+		p.opts.Progress(p.progress)
+	}
+	return nil
+}
+
+// reprocessKey returns the key of the i-th entry of an input, its own or one
+// derived from the input, the same every time the input is reprocessed.
+func reprocessKey(in *ReprocessInput, entry Entry, i int) string {
+	if entry.Key != "" {
+		return entry.Key
+	}
+	source := in.Key
+	if i == 0 && source != "" && TimeFromID(source).Unix() == entry.Time.Unix() {
+		return source
+	}
+	if source == "" {
+		source = "dead." + strconv.FormatUint(in.DeadLetter.Seq, 10)
+	}
+	return CreateIDWithSuffix(entry.Time, source+"."+strconv.Itoa(i))
+}
@@ -0,0 +1,116 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestReprocess(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	raw, err := borm.OpenTSWith(dir+"-raw", borm.WithCodec(borm.CodecJSON))
+	if err != nil {
+		t.Fatalf("Error opening %s-raw: %s", dir, err)
+	}
+	defer os.RemoveAll(dir + "-raw")
+	defer raw.Close()
+	db, err := borm.OpenTSWith(dir,
+		borm.WithCodec(borm.CodecJSON),
+		borm.WithValidators(borm.RequireFields("Category")))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var entries []borm.Entry
+	for i, line := range []string{"car,vehicle", "bike", "", "skip", "truck,vehicle+trailer,vehicle"} {
+		entries = append(entries, borm.Entry{Time: start.Add(time.Duration(i) * time.Minute), Value: map[string]string{"Line": line}})
+	}
+	if _, err := raw.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+
+	parse := func(unknown string) borm.ReprocessFunc {
+		return func(in borm.ReprocessInput) ([]borm.Entry, error) {
+			var record map[string]string
+			if err := json.Unmarshal(in.Raw, &record); err != nil {
+				return nil, err
+			}
+			if _, ok := record["Line"]; !ok {
+				// a parsed record of a dead letter
+				record["Category"] = unknown
+				return []borm.Entry{{Time: in.Time, Value: record}}, nil
+			}
+			if record["Line"] == "" {
+				return nil, errors.New("empty line")
+			}
+			var out []borm.Entry
+			for _, item := range strings.Split(record["Line"], "+") {
+				if item == "skip" {
+					continue
+				}
+				fields := strings.Split(item, ",")
+				value := map[string]string{"Name": fields[0]}
+				if len(fields) > 1 {
+					value["Category"] = fields[1]
+				}
+				out = append(out, borm.Entry{Time: in.Time, Value: value})
+			}
+			return out, nil
+		}
+	}
+
+	end := time.Now().Add(time.Second)
+	if _, err := db.ReprocessRange(start, end, parse(""), &borm.ReprocessOptions{Source: raw}); err == nil || err.Error() != "empty line" {
+		t.Fatalf("Reprocessing got %v wanted the empty line.", err)
+	}
+	if _, err := db.ReprocessRange(start, start.Add(time.Second), parse(""), &borm.ReprocessOptions{Source: raw}); err != nil {
+		t.Fatalf("Error reprocessing the car: %s", err)
+	}
+	if _, err := db.ReprocessRange(start, end, parse(""), &borm.ReprocessOptions{Source: raw, BatchSize: 2}); !errors.Is(err, borm.ErrInvalidRecord) {
+		t.Fatalf("Reprocessing got %v wanted %s.", err, borm.ErrInvalidRecord)
+	}
+
+	opts := &borm.ReprocessOptions{Source: raw, DeadLetter: "reprocess", BatchSize: 2}
+	for i := 0; i < 2; i++ {
+		result, err := db.ReprocessRange(start, end, parse(""), opts)
+		if err != nil {
+			t.Fatalf("Error reprocessing: %s", err)
+		}
+		want := borm.ReprocessResult{Inputs: 5, Written: 3, Dropped: 1, DeadLettered: 2}
+		if result != want {
+			t.Fatalf("Reprocessing got %+v wanted %+v.", result, want)
+		}
+		if count, err := db.Count(start, end, nil); err != nil || count != 3 {
+			t.Fatalf("Count got %d, %v wanted 3 after %d runs.", count, err, i+1)
+		}
+	}
+
+	// the empty lines fail again and are kept, the bikes are written once
+	result, err := db.ReprocessDeadLetters(parse("unknown"), &borm.ReprocessOptions{Origin: "reprocess", DeadLetter: "reprocess"})
+	if err != nil {
+		t.Fatalf("Error reprocessing dead letters: %s", err)
+	}
+	if want := (borm.ReprocessResult{Inputs: 4, Written: 2}); result != want {
+		t.Fatalf("Reprocessing dead letters got %+v wanted %+v.", result, want)
+	}
+	if count, err := db.Count(start, end, nil); err != nil || count != 4 {
+		t.Fatalf("Count got %d, %v wanted 4.", count, err)
+	}
+	var reasons []string
+	err = db.DeadLetters("", func(letter borm.DeadLetter) error {
+		reasons = append(reasons, letter.Reason)
+		return nil
+	})
+	if err != nil || len(reasons) != 2 || reasons[0] != "empty line" {
+		t.Fatalf("Dead letters got %v, %v wanted the empty lines.", reasons, err)
+	}
+}
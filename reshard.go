@@ -0,0 +1,244 @@
+package borm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// reshardDir is the directory, relative to the engine path, where Reshard
+// stages the new shards.
+const reshardDir = ".reshard"
+
+// Reshard rewrites the src granularity shards of the engine directory at path
+// into dst granularity shards, e.g. daily shards into hourly ones, so
+// TSOptions.ShardInterval can be changed on an existing dataset. Records are
+// routed by the time of their key, records whose key is not an ObjectId stay
+// in the first new shard of their old shard. Seal data and tags of the old
+// shards are dropped, a pinned shard fails the reshard with ErrShardPinned
+// before any is rewritten. The manifest records the new granularity once
+// done. No engine may have path open. The reshard is recorded in the audit
+// log.
+func Reshard(path string, src, dst Granularity) error {
+	if src == dst {
+		return errors.New("source and destination granularity are the same")
+	}
+	db, err := OpenTSWithOptions(path, &TSOptions{ShardInterval: src})
+	if err != nil {
+		return err
+	}
+	err = db.reshard(src, dst)
+	if err == nil {
+		err = db.setShardInterval(dst)
+	}
+	if e := db.audit("reshard", false, "resharded "+src.String()+" shards into "+dst.String()+" shards", err); e != nil && err == nil {
+		err = e
+	}
+	if e := db.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (db *TSEngine) reshard(src, dst Granularity) error {
+	shards, err := ListShardsWith(db.basePath, db.location(), db.listOptions())
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if shard.granularity != src {
+			continue
+		}
+		if err := db.checkPinned(shard.path); err != nil {
+			return err
+		}
+	}
+
+	stage := filepath.Join(db.basePath, reshardDir)
+	if err := os.RemoveAll(stage); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stage, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	staged := map[string]*bolt.DB{}
+	closeStaged := func() error {
+		var err error
+		for _, s := range staged {
+			if e := s.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+
+	var old Shards
+	for _, shard := range shards {
+		if shard.granularity != src {
+			continue
+		}
+		old = append(old, shard)
+		if err := reshardStore(shard, stage, dst, staged); err != nil {
+			closeStaged()
+			return err
+		}
+	}
+	if err := closeStaged(); err != nil {
+		return err
+	}
+
+	for _, shard := range old {
+		if err := removeFile(shard.path); err != nil {
+			return err
+		}
+		if err := removeFile(shard.path + columnsSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := db.deleteShardMeta(shard.path); err != nil {
+			return err
+		}
+	}
+	for name := range staged {
+		if err := os.Rename(filepath.Join(stage, name), filepath.Join(db.basePath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setShardInterval records the granularity of the shards in the manifest.
+func (db *TSEngine) setShardInterval(g Granularity) error {
+	manifest, err := db.Manifest()
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		manifest = db.newManifest()
+	}
+	manifest.ShardInterval = g.String()
+	return writeManifest(db.basePath, manifest, db.fileMode())
+}
+
+// reshardStore copies all the buckets of the shard but the seal data into
+// the staged shards.
+func reshardStore(shard *Shard, stage string, dst Granularity, staged map[string]*bolt.DB) error {
+	store, err := bolt.Open(shard.path, 0666, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	type record struct{ bucket, key, value []byte }
+	routed := map[string][]record{}
+	err = store.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bkt *bolt.Bucket) error {
+			if bytes.Equal(name, statsBucket) || bytes.Equal(name, sketchBucket) || bytes.Equal(name, dictBucket) || bytes.Equal(name, schemaBucket) ||
+				bytes.Equal(name, changesBucket) {
+				return nil
+			}
+			dict := txDict(tx)
+			return bkt.ForEach(func(k, v []byte) error {
+				if v == nil {
+					// nested buckets are not copied
+					return nil
+				}
+				if string(name) == dataBucket {
+					var err error
+					if v, err = plainWith(v, dict); err != nil {
+						return err
+					}
+				}
+				t := TimeFromID(string(k))
+				if t.IsZero() {
+					t = shard.startTime
+				}
+				file := dst.Name(t)
+				routed[file] = append(routed[file], record{name,
+					append([]byte(nil), k...), append([]byte(nil), v...)})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for file, records := range routed {
+		s, err := openStaged(filepath.Dir(shard.path), stage, file, staged)
+		if err != nil {
+			return err
+		}
+		err = s.Update(func(tx *bolt.Tx) error {
+			for _, r := range records {
+				bkt, err := tx.CreateBucketIfNotExists(r.bucket)
+				if err != nil {
+					return err
+				}
+				if err := bkt.Put(r.key, r.value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openStaged returns the staged shard of file, it starts as a copy of the
+// shard of the same name if one exists already.
+func openStaged(base, stage, file string, staged map[string]*bolt.DB) (*bolt.DB, error) {
+	if s, ok := staged[file]; ok {
+		return s, nil
+	}
+
+	stagedFile := filepath.Join(stage, file)
+	if err := copyFile(filepath.Join(base, file), stagedFile); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	s, err := bolt.Open(stagedFile, 0666, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = s.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{statsBucket, sketchBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	staged[file] = s
+	return s, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,64 @@
+package borm_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestReshard(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	day := startOfDay(time.Now().AddDate(0, 0, -2))
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	for i := 0; i < 6; i++ {
+		ts := day.Add(time.Duration(i%3)*time.Hour + time.Minute)
+		err := db.Write(ts, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(ts, uint32(i)), &ItemTest{ID: i, Created: ts})
+		})
+		if err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+	db.Close()
+
+	if err := borm.Reshard(dir, borm.Daily, borm.Hourly); err != nil {
+		t.Fatalf("Error resharding: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, borm.Daily.Name(day))); !os.IsNotExist(err) {
+		t.Fatalf("Daily shard wasn't removed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		name := borm.Hourly.Name(day.Add(time.Duration(i) * time.Hour))
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Hourly shard %s is missing: %s", name, err)
+		}
+	}
+
+	db, err = borm.OpenTSWithOptions(dir, &borm.TSOptions{ShardInterval: borm.Hourly})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	count := 0
+	err = db.Query(day, day.Add(3*time.Hour), func(it *borm.Iterator) error {
+		for it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if count != 6 {
+		t.Fatalf("Query after reshard returned %d records, expected 6.", count)
+	}
+}
@@ -0,0 +1,213 @@
+package borm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaRetention is the meta bucket of the retention horizon.
+var metaRetention = []byte("retention")
+
+var horizonKey = []byte("horizon")
+
+// ErrRetention is returned by Backfill for the records older than the
+// retention horizon whose shard was deleted, see RetentionHorizon.
+var ErrRetention = errors.New("record is older than the retention horizon")
+
+// RetentionPolicy selects the shards deleted by ApplyRetention. A shard is
+// deleted when any of Before, MaxAge or MaxSize selects it, unless it is
+// kept by KeepAtLeast or ProtectTags, it is pinned, or it is the hot shard
+// and TSOptions.HotShard keeps it.
+type RetentionPolicy struct {
+	// Before deletes the shards starting before it.
+	Before time.Time
+
+	// MaxAge deletes the shards ending more than MaxAge ago.
+	MaxAge time.Duration
+
+	// MaxSize deletes the oldest shards until the shards total at most
+	// MaxSize bytes.
+	MaxSize int64
+
+	// KeepAtLeast never deletes the KeepAtLeast latest shards.
+	KeepAtLeast int
+
+	// ProtectTags never deletes the shards having one of these tags.
+	ProtectTags []string
+}
+
+// ApplyRetention deletes the shards selected by the policy and returns them.
+// When dryRun is true nothing is deleted, so operators can verify what would
+// be before destructive runs. Every run is recorded in the audit log.
+func (db *TSEngine) ApplyRetention(policy RetentionPolicy, dryRun bool) ([]ShardInfo, error) {
+	infos, err := db.applyRetention(policy, dryRun)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = filepath.Base(info.Path)
+	}
+	detail := fmt.Sprintf("deleted %d shards %v", len(infos), names)
+	if e := db.audit("retention", dryRun, detail, err); e != nil && err == nil {
+		err = e
+	}
+	return infos, err
+}
+
+// RetentionCandidates returns the shards the policy would delete, as a dry
+// run of ApplyRetention without an audit record, e.g. for a dashboard.
+func (db *TSEngine) RetentionCandidates(policy RetentionPolicy) ([]ShardInfo, error) {
+	return db.applyRetention(policy, true)
+}
+
+func (db *TSEngine) applyRetention(policy RetentionPolicy, dryRun bool) ([]ShardInfo, error) {
+	loc := db.location()
+	if !policy.Before.IsZero() {
+		loc = policy.Before.Location()
+	}
+	shards, err := db.listShards(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	now := db.now()
+	hot := db.nameWith(now)
+	var selected []*Shard
+	var infos []ShardInfo
+	var total int64
+	for i, shard := range shards {
+		info := shard.Info()
+		total += info.Size
+		if i < policy.KeepAtLeast {
+			continue
+		}
+
+		meta, err := db.readShardMeta(shard.path)
+		if err != nil {
+			return nil, err
+		}
+		if meta.Pinned || isProtected(meta, policy.ProtectTags) {
+			continue
+		}
+		if db.options.HotShard == KeepHotShard && samePath(shard.path, hot) {
+			continue
+		}
+
+		if (!policy.Before.IsZero() && shard.startTime.Before(policy.Before)) ||
+			(policy.MaxAge > 0 && now.Sub(shard.endTime) > policy.MaxAge) ||
+			(policy.MaxSize > 0 && total > policy.MaxSize) {
+			meta.fill(&info)
+			selected = append(selected, shard)
+			infos = append(infos, info)
+		}
+	}
+
+	if dryRun {
+		return infos, nil
+	}
+	for i, shard := range selected {
+		if err := db.removeShard(shard); err != nil {
+			return infos[:i], err
+		}
+		if err := db.advanceHorizon(shard.endTime); err != nil {
+			return infos[:i+1], err
+		}
+	}
+	return infos, nil
+}
+
+// RetentionHorizon returns the end of the latest shard deleted by
+// retention, zero if none was. Backfill rejects the older records whose
+// shard is gone, retention would delete it again.
+func (db *TSEngine) RetentionHorizon() (time.Time, error) {
+	var horizon time.Time
+	err := db.viewMeta(metaRetention, func(bkt *bolt.Bucket) error {
+		if bkt == nil {
+			return nil
+		}
+		if value := bkt.Get(horizonKey); len(value) == 8 {
+			horizon = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		}
+		return nil
+	})
+	return horizon, err
+}
+
+// advanceHorizon moves the retention horizon to t if it is later.
+func (db *TSEngine) advanceHorizon(t time.Time) error {
+	return db.updateMeta(metaRetention, func(bkt *bolt.Bucket) error {
+		if value := bkt.Get(horizonKey); len(value) == 8 && int64(binary.BigEndian.Uint64(value)) >= t.UnixNano() {
+			return nil
+		}
+		var value [8]byte
+		binary.BigEndian.PutUint64(value[:], uint64(t.UnixNano()))
+		return bkt.Put(horizonKey, value[:])
+	})
+}
+
+func isProtected(meta *shardMeta, tags []string) bool {
+	for _, tag := range tags {
+		if _, ok := meta.Tags[tag]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteRange deletes the records in the time range and returns how many it
+// deleted, or would delete when dryRun is true. The statistics of the sealed
+// shards it deletes from are dropped. Every run is recorded in the audit log.
+func (db *TSEngine) DeleteRange(start, end time.Time, dryRun bool) (int64, error) {
+	var deleted int64
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		return db.read(fileName, func(bkt *Bucket) error {
+			var keys [][]byte
+			err := bkt.getRanges(keyRanges(position, start, end), func(it *Iterator) error {
+				for it.Next() {
+					keys = append(keys, append([]byte(nil), it.Key()...))
+				}
+				return nil
+			})
+			if err != nil || len(keys) == 0 {
+				return err
+			}
+			if dryRun {
+				deleted += int64(len(keys))
+				return nil
+			}
+
+			if err := unseal(bkt); err != nil {
+				return err
+			}
+			err = bkt.store.db.Update(func(tx *bolt.Tx) error {
+				records := tx.Bucket(bkt.name)
+				if records == nil {
+					return ErrBucketNotFound
+				}
+				for _, key := range keys {
+					if err := bkt.del(tx, records, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err == nil {
+				deleted += int64(len(keys))
+			}
+			return err
+		})
+	})
+
+	detail := fmt.Sprintf("deleted %d records from %s to %s", deleted, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if e := db.audit("delete-range", dryRun, detail, err); e != nil && err == nil {
+		err = e
+	}
+	return deleted, err
+}
@@ -0,0 +1,113 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestApplyRetention(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		for i := 0; i < 5; i++ {
+			day := now.AddDate(0, 0, -i)
+			err := db.Write(day, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(day, 1), &ItemTest{ID: i, Created: day})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+		if err := db.SetShardTag(now.AddDate(0, 0, -4), "keep", "incident-42"); err != nil {
+			t.Fatalf("Error tagging shard: %s", err)
+		}
+
+		policy := borm.RetentionPolicy{
+			MaxAge:      48 * time.Hour,
+			KeepAtLeast: 1,
+			ProtectTags: []string{"keep"},
+		}
+		deleted, err := db.ApplyRetention(policy, true)
+		if err != nil {
+			t.Fatalf("Error applying retention: %s", err)
+		}
+		if len(deleted) != 1 || !deleted[0].StartTime.Equal(startOfDay(now.AddDate(0, 0, -3))) {
+			t.Fatalf("Dry run selected %v wanted the shard of 3 days ago.", deleted)
+		}
+
+		shards, err := db.Shards()
+		if err != nil {
+			t.Fatalf("Error listing shards: %s", err)
+		}
+		if len(shards) != 5 {
+			t.Fatalf("Dry run deleted shards, %d left.", len(shards))
+		}
+
+		if _, err := db.ApplyRetention(policy, false); err != nil {
+			t.Fatalf("Error applying retention: %s", err)
+		}
+		shards, err = db.Shards()
+		if err != nil {
+			t.Fatalf("Error listing shards: %s", err)
+		}
+		if len(shards) != 4 {
+			t.Fatalf("Retention left %d shards wanted %d.", len(shards), 4)
+		}
+	})
+}
+
+func TestRetentionHotShard(t *testing.T) {
+	for _, policy := range []borm.HotShardPolicy{borm.KeepHotShard, borm.DeleteHotShard} {
+		dir := tempdir(t)
+		defer os.RemoveAll(dir)
+
+		db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{HotShard: policy})
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", dir, err)
+		}
+		defer db.Close()
+
+		write := func() error {
+			now := time.Now()
+			return db.Write(now, func(bkt *borm.Bucket) error {
+				return bkt.Insert(db.NewID(now), &ItemTest{Name: "hot"})
+			})
+		}
+		if err := write(); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+		if err := db.EnforceRetention(time.Now().AddDate(0, 0, 2)); err != nil {
+			t.Fatalf("Error enforcing retention: %s", err)
+		}
+
+		shards, err := db.Shards()
+		if err != nil {
+			t.Fatalf("Error listing shards: %s", err)
+		}
+		if policy == borm.KeepHotShard {
+			if len(shards) != 1 {
+				t.Fatalf("Expected the hot shard kept, got %v.", shards)
+			}
+			if err := write(); err != nil {
+				t.Fatalf("Error writing after retention: %s", err)
+			}
+			continue
+		}
+
+		if len(shards) != 0 {
+			t.Fatalf("Expected the hot shard deleted, got %v.", shards)
+		}
+		if err := write(); err != borm.ErrShardDeleted {
+			t.Fatalf("Expected ErrShardDeleted writing the deleted shard, got %v.", err)
+		}
+		if shards, _ := db.Shards(); len(shards) != 0 {
+			t.Fatalf("Expected the deleted shard not recreated, got %v.", shards)
+		}
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
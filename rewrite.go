@@ -0,0 +1,342 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaRewrites is the meta bucket of the checkpoints of the rewrite jobs,
+// keyed by name.
+var metaRewrites = []byte("rewrites")
+
+// RewriteFunc returns the new value of a record, e.g. with a field renamed
+// or a value redacted. The value is decompressed and the one returned is
+// stored as is, nil or an equal value leaves the record as it is.
+type RewriteFunc func(key, value []byte) ([]byte, error)
+
+// RewriteJob applies a transform to every record of the shards of a time
+// range, in the background. It writes in small transactions with a pause
+// between them so the foreground writes and queries are never blocked long,
+// and saves a checkpoint after every transaction so a job started again
+// after a restart resumes where it stopped. A transaction may be applied
+// twice after a crash, the transform must be idempotent.
+type RewriteJob struct {
+	// Name identifies the checkpoint of the job.
+	Name string
+
+	// Start and End select the shards rewritten entirely, but the pinned
+	// ones, ShardFilter skips those it returns false for.
+	Start, End  time.Time
+	ShardFilter func(info ShardInfo) bool
+
+	Transform RewriteFunc
+
+	// BatchSize is the number of records of a transaction, it defaults to
+	// 500. Pause is the wait between two transactions, it defaults to 10ms.
+	BatchSize int
+	Pause     time.Duration
+
+	// Progress is called after every transaction.
+	Progress ProgressFunc
+}
+
+// rewriteCheckpoint is the position of a rewrite job, the last key done in
+// the shard starting at Shard.
+type rewriteCheckpoint struct {
+	Shard time.Time `json:"shard"`
+	Key   string    `json:"key,omitempty"`
+	Done  bool      `json:"done,omitempty"`
+}
+
+// Rewrite is a running rewrite job.
+type Rewrite struct {
+	job  RewriteJob
+	stop chan struct{}
+	done chan struct{}
+	err  error
+
+	mu       sync.Mutex
+	progress Progress
+}
+
+// ErrRewriteRunning is returned when a rewrite job of the same name runs.
+var ErrRewriteRunning = errors.New("rewrite job is running")
+
+// errRewriteStopped ends a stopped job at a checkpoint.
+var errRewriteStopped = errors.New("rewrite job stopped")
+
+// StartRewrite starts the rewrite job, from its checkpoint if it ran
+// before. A job already done returns a done Rewrite, ResetRewrite runs it
+// again.
+func (db *TSEngine) StartRewrite(job RewriteJob) (*Rewrite, error) {
+	if job.Name == "" || job.Transform == nil {
+		return nil, errors.New("rewrite job needs a name and a transform")
+	}
+	if job.Start.After(job.End) {
+		return nil, errors.New("time range is invalid")
+	}
+	if job.BatchSize <= 0 {
+		job.BatchSize = 500
+	}
+	if job.Pause <= 0 {
+		job.Pause = 10 * time.Millisecond
+	}
+
+	db.rewritesMu.Lock()
+	defer db.rewritesMu.Unlock()
+	if db.rewrites == nil {
+		db.rewrites = map[string]*Rewrite{}
+	}
+	if _, ok := db.rewrites[job.Name]; ok {
+		return nil, ErrRewriteRunning
+	}
+	r := &Rewrite{job: job, stop: make(chan struct{}), done: make(chan struct{})}
+	db.rewrites[job.Name] = r
+	go func() {
+		defer close(r.done)
+		if r.err = db.runRewrite(r); r.err == errRewriteStopped {
+			r.err = nil
+		}
+		db.rewritesMu.Lock()
+		delete(db.rewrites, job.Name)
+		db.rewritesMu.Unlock()
+	}()
+	return r, nil
+}
+
+// Stop stops the job at its next checkpoint and returns its error.
+func (r *Rewrite) Stop() error {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	return r.Wait()
+}
+
+// Wait waits for the job to be done or stopped and returns its error.
+func (r *Rewrite) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Progress returns the records rewritten so far and the shard being
+// rewritten.
+func (r *Rewrite) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+// ResetRewrite deletes the checkpoint of the job, which then starts over.
+func (db *TSEngine) ResetRewrite(name string) error {
+	return db.updateMeta(metaRewrites, func(bkt *bolt.Bucket) error {
+		return bkt.Delete([]byte(name))
+	})
+}
+
+// stopRewrites stops the running rewrite jobs, at their checkpoints.
+func (db *TSEngine) stopRewrites() {
+	db.rewritesMu.Lock()
+	running := make([]*Rewrite, 0, len(db.rewrites))
+	for _, r := range db.rewrites {
+		running = append(running, r)
+	}
+	db.rewritesMu.Unlock()
+	for _, r := range running {
+		r.Stop()
+	}
+}
+
+func (db *TSEngine) rewriteCheckpoint(name string) (*rewriteCheckpoint, error) {
+	var cp *rewriteCheckpoint
+	err := db.viewMeta(metaRewrites, func(bkt *bolt.Bucket) error {
+		if bkt == nil {
+			return nil
+		}
+		if data := bkt.Get([]byte(name)); data != nil {
+			cp = &rewriteCheckpoint{}
+			return json.Unmarshal(data, cp)
+		}
+		return nil
+	})
+	return cp, err
+}
+
+func (db *TSEngine) saveRewriteCheckpoint(name string, cp *rewriteCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return db.updateMeta(metaRewrites, func(bkt *bolt.Bucket) error {
+		return bkt.Put([]byte(name), data)
+	})
+}
+
+func (db *TSEngine) runRewrite(r *Rewrite) error {
+	job := &r.job
+	cp, err := db.rewriteCheckpoint(job.Name)
+	if err != nil || (cp != nil && cp.Done) {
+		return err
+	}
+
+	err = filesRead(db.nameWith, db.options.ShardInterval, job.Start, job.End, func(position int, day time.Time, fileName string) error {
+		var after []byte
+		if cp != nil {
+			if day.Before(cp.Shard) {
+				return nil
+			}
+			if day.Equal(cp.Shard) && cp.Key != "" {
+				after = []byte(cp.Key)
+			}
+		}
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		meta, err := db.readShardMeta(fileName)
+		if err != nil || meta.Pinned {
+			return err
+		}
+		if job.ShardFilter != nil {
+			info := shardInfo(fileName, day, db.options.ShardInterval)
+			meta.fill(&info)
+			if !job.ShardFilter(info) {
+				return nil
+			}
+		}
+
+		r.mu.Lock()
+		r.progress.Shard = fileName
+		r.mu.Unlock()
+		for {
+			var read int
+			var done bool
+			err := db.readBackground(fileName, func(bkt *Bucket) error {
+				var err error
+				after, read, done, err = db.rewriteBatch(bkt, job, after)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			r.mu.Lock()
+			r.progress.Items += int64(read)
+			r.mu.Unlock()
+			if err := db.saveRewriteCheckpoint(job.Name, &rewriteCheckpoint{Shard: day, Key: string(after)}); err != nil {
+				return err
+			}
+			if job.Progress != nil {
+				job.Progress(r.Progress())
+			}
+			if done {
+				return nil
+			}
+			select {
+			case <-r.stop:
+				return errRewriteStopped
+			case <-time.After(job.Pause):
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return db.saveRewriteCheckpoint(job.Name, &rewriteCheckpoint{Shard: job.End, Done: true})
+}
+
+// rewriteBatch rewrites the records after the key in one transaction, and
+// returns the last key read, the number of records read and whether the
+// shard is done.
+func (db *TSEngine) rewriteBatch(bkt *Bucket, job *RewriteJob, after []byte) ([]byte, int, bool, error) {
+	type update struct{ key, value []byte }
+	var updates []update
+	done := true
+	read := 0
+	err := bkt.store.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bkt.name)
+		if records == nil {
+			return nil
+		}
+		c := records.Cursor()
+		k, v := c.First()
+		if after != nil {
+			k, v = c.Seek(after)
+			if k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil; k, v = c.Next() {
+			if read == job.BatchSize {
+				done = false
+				break
+			}
+			read++
+			after = append(after[:0:0], k...)
+			if v == nil {
+				continue
+			}
+			plain, err := bkt.plain(v)
+			if err != nil {
+				return bkt.checksumError(k, err)
+			}
+			value, err := job.Transform(k, plain)
+			if err != nil {
+				return bkt.iterError(OpCallback, k, err)
+			}
+			if value != nil && !bytes.Equal(value, plain) {
+				updates = append(updates, update{after, append([]byte(nil), value...)})
+			}
+		}
+		return nil
+	})
+	if err != nil || len(updates) == 0 {
+		return after, read, done, err
+	}
+
+	if err := unseal(bkt); err != nil {
+		return after, read, done, err
+	}
+	err = bkt.store.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bkt.name)
+		if records == nil {
+			return ErrBucketNotFound
+		}
+		for _, u := range updates {
+			if records.Get(u.key) == nil {
+				// deleted since it was read
+				continue
+			}
+			if err := db.rewriteRecord(tx, bkt, records, u.key, u.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return after, read, done, err
+}
+
+// rewriteRecord stores the new value of a record, updating the views and
+// the change log but not going through the rules, the webhooks and the
+// quotas: the record is not a new one.
+func (db *TSEngine) rewriteRecord(tx *bolt.Tx, bkt *Bucket, records *bolt.Bucket, key, value []byte) error {
+	for i := range db.options.Views {
+		if err := db.options.Views[i].apply(tx, key, value); err != nil {
+			return err
+		}
+	}
+	if db.options.ChangeLog {
+		if err := db.logChange(bkt, tx, key, value); err != nil {
+			return err
+		}
+	}
+	if bkt.checksums {
+		value = addChecksum(value)
+	}
+	return records.Put(key, value)
+}
@@ -0,0 +1,102 @@
+package borm_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestRewriteJob(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	options := &borm.TSOptions{Encoder: borm.JSONEncode, Decoder: borm.JSONDecode}
+	db, err := borm.OpenTSWithOptions(dir, options)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		day := now.AddDate(0, 0, -i)
+		for j := 0; j < 5; j++ {
+			err := db.Write(day, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(day, uint32(j)), &ItemTest{ID: j, Name: "secret", Created: day})
+			})
+			if err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+	}
+
+	// stopped after the first transaction by the progress callback
+	var r *borm.Rewrite
+	started := make(chan struct{})
+	job := borm.RewriteJob{
+		Name:      "redact",
+		Start:     now.AddDate(0, 0, -1),
+		End:       now,
+		BatchSize: 3,
+		Pause:     time.Hour,
+		Transform: func(key, value []byte) ([]byte, error) {
+			return bytes.Replace(value, []byte(`"secret"`), []byte(`"redacted"`), 1), nil
+		},
+		Progress: func(p borm.Progress) {
+			select {
+			case <-started:
+			default:
+				close(started)
+			}
+		},
+	}
+	if r, err = db.StartRewrite(job); err != nil {
+		t.Fatalf("Error starting the rewrite: %s", err)
+	}
+	if _, err := db.StartRewrite(job); err != borm.ErrRewriteRunning {
+		t.Fatalf("Expected ErrRewriteRunning, got %v", err)
+	}
+	<-started
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Error stopping the rewrite: %s", err)
+	}
+	if p := r.Progress(); p.Items != 3 {
+		t.Fatalf("Expected the job stopped after a transaction, got %+v", p)
+	}
+	db.Close()
+
+	// the job resumes from its checkpoint after a restart
+	db, err = borm.OpenTSWithOptions(dir, options)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	job.Pause = time.Millisecond
+	job.Progress = nil
+	if r, err = db.StartRewrite(job); err != nil {
+		t.Fatalf("Error starting the rewrite: %s", err)
+	}
+	if err := r.Wait(); err != nil {
+		t.Fatalf("Error rewriting: %s", err)
+	}
+	if p := r.Progress(); p.Items != 7 {
+		t.Fatalf("Expected the job resumed after 3 records, got %+v", p)
+	}
+
+	err = db.Query(now.AddDate(0, 0, -2), now.Add(time.Minute), func(it *borm.Iterator) error {
+		for it.Next() {
+			var item ItemTest
+			if err := it.Read(&item); err != nil {
+				return err
+			}
+			if item.Name != "redacted" {
+				t.Fatalf("Record %s was not rewritten: %+v", it.Key(), item)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+}
@@ -0,0 +1,198 @@
+package borm
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaRules is the meta bucket of the rule states, keyed by rule name.
+var metaRules = []byte("rules")
+
+// Rule is an alert evaluated against the written records, it fires when at
+// least Threshold records matched it within Window, e.g. more than 100
+// records matching X in 5 minutes.
+type Rule struct {
+	Name      string
+	Match     func(key, value []byte) bool
+	Threshold int
+	Window    time.Duration
+
+	// Fire is called in its own goroutine when the rule fires, a rule fires
+	// at most once per window.
+	Fire func(event RuleEvent)
+}
+
+// RuleEvent is the firing of a rule.
+type RuleEvent struct {
+	Rule       string
+	Count      int
+	Start, End time.Time
+}
+
+// ruleState is the persisted state of a rule.
+type ruleState struct {
+	// Matches are the latest Threshold times of the matches, oldest first,
+	// whatever the order the records were written in.
+	Matches   []time.Time `json:"matches"`
+	LastFired time.Time   `json:"last_fired"`
+}
+
+type activeRule struct {
+	Rule
+	state ruleState
+}
+
+// AddRule registers the rule, its state persisted by a previous run is
+// restored.
+func (db *TSEngine) AddRule(rule Rule) error {
+	if rule.Name == "" || rule.Match == nil || rule.Threshold <= 0 || rule.Window <= 0 {
+		return errors.New("rule needs a name, a match, a threshold and a window")
+	}
+
+	r := &activeRule{Rule: rule}
+	err := db.viewMeta(metaRules, func(bkt *bolt.Bucket) error {
+		if bkt == nil {
+			return nil
+		}
+		if data := bkt.Get([]byte(rule.Name)); data != nil {
+			return json.Unmarshal(data, &r.state)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	db.rulesMu.Lock()
+	defer db.rulesMu.Unlock()
+	for i, existing := range db.rules {
+		if existing.Name == rule.Name {
+			db.rules[i] = r
+			return nil
+		}
+	}
+	db.rules = append(db.rules, r)
+	return nil
+}
+
+// RemoveRule unregisters the rule and drops its persisted state.
+func (db *TSEngine) RemoveRule(name string) error {
+	db.rulesMu.Lock()
+	for i, r := range db.rules {
+		if r.Name == name {
+			db.rules = append(db.rules[:i], db.rules[i+1:]...)
+			break
+		}
+	}
+	db.rulesMu.Unlock()
+
+	return db.updateMeta(metaRules, func(bkt *bolt.Bucket) error {
+		return bkt.Delete([]byte(name))
+	})
+}
+
+// evalRules returns the write hook of the records bucket matching the rules
+// against a written record, the state of the rules is applied once the
+// write commits.
+func (db *TSEngine) evalRules(bkt *Bucket) WriteHook {
+	return func(tx *bolt.Tx, key, value []byte) error {
+		if value == nil {
+			return nil
+		}
+
+		db.rulesMu.Lock()
+		defer db.rulesMu.Unlock()
+		if len(db.rules) == 0 {
+			return nil
+		}
+
+		t := TimeFromID(string(key))
+		if t.IsZero() {
+			t = db.now()
+		}
+		for _, r := range db.rules {
+			if r.Match(key, value) {
+				bkt.matches.add(tx, ruleMatch{rule: r, t: t}, db.applyMatches)
+			}
+		}
+		return nil
+	}
+}
+
+// ruleMatch is a record of a write transaction matching a rule at t.
+type ruleMatch struct {
+	rule *activeRule
+	t    time.Time
+}
+
+// applyMatches applies the matches of a write transaction to the state of
+// their rules and fires the rules. The write is committed already, a state
+// failing to be saved is logged to the Logger.
+func (db *TSEngine) applyMatches(items []interface{}) {
+	db.rulesMu.Lock()
+	defer db.rulesMu.Unlock()
+	active := map[*activeRule]bool{}
+	for _, r := range db.rules {
+		active[r] = true
+	}
+	for _, item := range items {
+		m := item.(ruleMatch)
+		r, t := m.rule, m.t
+		if !active[r] {
+			// removed since
+			continue
+		}
+
+		// a backfilled or late record is inserted in time order
+		matches := r.state.Matches
+		i := sort.Search(len(matches), func(i int) bool { return matches[i].After(t) })
+		matches = append(matches, time.Time{})
+		copy(matches[i+1:], matches[i:])
+		matches[i] = t
+		if len(matches) > r.Threshold {
+			matches = matches[len(matches)-r.Threshold:]
+		}
+		r.state.Matches = matches
+		if len(matches) < r.Threshold {
+			continue
+		}
+
+		first, last := matches[0], matches[len(matches)-1]
+		if last.Sub(first) > r.Window || last.Sub(r.state.LastFired) < r.Window {
+			continue
+		}
+		r.state.LastFired = last
+		if err := db.saveRuleState(r); err != nil {
+			db.logger().Printf("engine failed to save the state of the rule %s: %s", r.Name, err)
+		}
+		if r.Fire != nil {
+			go r.Fire(RuleEvent{Rule: r.Name, Count: len(matches), Start: first, End: last})
+		}
+	}
+}
+
+func (db *TSEngine) saveRuleState(r *activeRule) error {
+	data, err := json.Marshal(&r.state)
+	if err != nil {
+		return err
+	}
+	return db.updateMeta(metaRules, func(bkt *bolt.Bucket) error {
+		return bkt.Put([]byte(r.Name), data)
+	})
+}
+
+// saveRules persists the state of all the rules.
+func (db *TSEngine) saveRules() error {
+	db.rulesMu.Lock()
+	defer db.rulesMu.Unlock()
+	for _, r := range db.rules {
+		if err := db.saveRuleState(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
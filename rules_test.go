@@ -0,0 +1,181 @@
+package borm_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestRuleFiresAcrossRestart(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	fired := make(chan borm.RuleEvent, 1)
+	rule := borm.Rule{
+		Name:      "vehicles",
+		Threshold: 3,
+		Window:    time.Minute,
+		Match: func(key, value []byte) bool {
+			var item ItemTest
+			return borm.DefaultDecode(value, &item) == nil && item.Category == "vehicle"
+		},
+		Fire: func(event borm.RuleEvent) { fired <- event },
+	}
+
+	now := time.Now()
+	write := func(db *borm.TSEngine, i int) {
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(now, uint32(i)), &testData[i])
+		})
+		if err != nil {
+			t.Fatalf("Error writing record %d: %s", i, err)
+		}
+	}
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	if err := db.AddRule(rule); err != nil {
+		t.Fatalf("Error adding rule: %s", err)
+	}
+	// car, truck and a seal
+	for _, i := range []int{0, 1, 2} {
+		write(db, i)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing engine: %s", err)
+	}
+
+	db, err = borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	if err := db.AddRule(rule); err != nil {
+		t.Fatalf("Error adding rule: %s", err)
+	}
+	// a van
+	write(db, 3)
+
+	select {
+	case event := <-fired:
+		if event.Rule != "vehicles" || event.Count != 3 {
+			t.Fatalf("Rule fired with %v.", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Rule didn't fire!")
+	}
+}
+
+func TestRuleRollback(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	fired := make(chan borm.RuleEvent, 1)
+	err = db.AddRule(borm.Rule{
+		Name:      "any",
+		Threshold: 1,
+		Window:    time.Minute,
+		Match:     func(key, value []byte) bool { return true },
+		Fire:      func(event borm.RuleEvent) { fired <- event },
+	})
+	if err != nil {
+		t.Fatalf("Error adding rule: %s", err)
+	}
+
+	now := time.Now()
+	aborted := errors.New("aborted")
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Write(func(u borm.Updater) error {
+			if err := u.Insert(db.NewID(now), &testData[0]); err != nil {
+				return err
+			}
+			return aborted
+		})
+	})
+	if err != aborted {
+		t.Fatalf("Write got %v wanted %s.", err, aborted)
+	}
+	select {
+	case event := <-fired:
+		t.Fatalf("Rule fired on a rolled back write with %v.", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), &testData[0])
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	select {
+	case event := <-fired:
+		if event.Count != 1 {
+			t.Fatalf("Rule fired with %v.", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Rule didn't fire!")
+	}
+}
+
+func TestRuleOutOfOrder(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	fired := make(chan borm.RuleEvent, 1)
+	err = db.AddRule(borm.Rule{
+		Name:      "any",
+		Threshold: 3,
+		Window:    10 * time.Minute,
+		Match:     func(key, value []byte) bool { return true },
+		Fire:      func(event borm.RuleEvent) { fired <- event },
+	})
+	if err != nil {
+		t.Fatalf("Error adding rule: %s", err)
+	}
+
+	start := time.Now().Add(-2 * time.Hour)
+	write := func(offset time.Duration) {
+		at := start.Add(offset)
+		err := db.Write(at, func(bkt *borm.Bucket) error {
+			return bkt.Insert(db.NewID(at), &testData[0])
+		})
+		if err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+
+	// the three matches span 51 minutes, whatever their order
+	write(50 * time.Minute)
+	write(51 * time.Minute)
+	write(0)
+	select {
+	case event := <-fired:
+		t.Fatalf("Rule fired on matches out of its window with %v.", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	write(45 * time.Minute)
+	select {
+	case event := <-fired:
+		if event.Count != 3 || event.End.Sub(event.Start) != 6*time.Minute {
+			t.Fatalf("Rule fired with %v.", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Rule didn't fire!")
+	}
+}
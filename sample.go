@@ -0,0 +1,142 @@
+package borm
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// RawRecord is a record copied out of a shard.
+type RawRecord struct {
+	Key   []byte
+	Value []byte
+
+	decode DecodeFunc
+}
+
+// Read decodes the record value into value.
+func (r *RawRecord) Read(value interface{}) error {
+	return r.decode(r.Value, value)
+}
+
+// Sample returns about n records uniformly sampled in the time range, in key
+// order. Every shard gets a share of n weighted by its record count, which
+// is read from the shard statistics when sealed, and is sampled with a
+// reservoir.
+func (db *TSEngine) Sample(start, end time.Time, n int) ([]RawRecord, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	type shardCount struct {
+		fileName string
+		position int
+		count    int64
+	}
+	var counts []shardCount
+	var total int64
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		return db.read(fileName, func(bkt *Bucket) error {
+			if position == positionMiddle {
+				stats, err := loadStats(bkt)
+				if err != nil {
+					return err
+				}
+				if stats != nil {
+					counts = append(counts, shardCount{fileName, position, stats.Count})
+					total += stats.Count
+					return nil
+				}
+			}
+
+			var count int64
+			err := bkt.getRanges(keyRanges(position, start, end), func(it *Iterator) error {
+				for it.Next() {
+					count++
+				}
+				return nil
+			})
+			counts = append(counts, shardCount{fileName, position, count})
+			total += count
+			return err
+		})
+	})
+	if err != nil || total == 0 {
+		return nil, err
+	}
+
+	// the shares are rounded down, the records left go to the largest
+	// remainders, and no shard gets more than its records
+	quotas := make([]int, len(counts))
+	order := make([]int, len(counts))
+	assigned := 0
+	for i, sc := range counts {
+		if quotas[i] = int(int64(n) * sc.count / total); int64(quotas[i]) > sc.count {
+			quotas[i] = int(sc.count)
+		}
+		assigned += quotas[i]
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return int64(n)*counts[order[a]].count%total > int64(n)*counts[order[b]].count%total
+	})
+	for _, i := range order {
+		if assigned >= n {
+			break
+		}
+		if int64(quotas[i]) < counts[i].count {
+			quotas[i]++
+			assigned++
+		}
+	}
+
+	var samples []RawRecord
+	for i, sc := range counts {
+		quota := quotas[i]
+		if quota == 0 {
+			continue
+		}
+
+		err := db.read(sc.fileName, func(bkt *Bucket) error {
+			reservoir := make([]RawRecord, 0, quota)
+			seen := 0
+			err := bkt.getRanges(keyRanges(sc.position, start, end), func(it *Iterator) error {
+				for it.Next() {
+					seen++
+					idx := len(reservoir)
+					if idx >= quota {
+						idx = rand.Intn(seen)
+						if idx >= quota {
+							continue
+						}
+					}
+					record := RawRecord{
+						Key:    append([]byte(nil), it.Key()...),
+						Value:  append([]byte(nil), it.Value()...),
+						decode: bkt.decode,
+					}
+					if idx == len(reservoir) {
+						reservoir = append(reservoir, record)
+					} else {
+						reservoir[idx] = record
+					}
+				}
+				return nil
+			})
+			sort.Slice(reservoir, func(i, j int) bool {
+				return bytes.Compare(reservoir[i].Key, reservoir[j].Key) < 0
+			})
+			samples = append(samples, reservoir...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,104 @@
+package borm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ErrTypeMismatch is the error returned when a record doesn't have the type
+// declared for its bucket
+type ErrTypeMismatch struct {
+	Bucket string
+	Want   reflect.Type
+	Got    reflect.Type
+}
+
+func (e *ErrTypeMismatch) Error() string {
+	return fmt.Sprintf("bucket %s stores %s, got %s", e.Bucket, e.Want, e.Got)
+}
+
+// recordType returns the type of the record, without pointers.
+func recordType(value interface{}) reflect.Type {
+	if value == nil {
+		return nil
+	}
+	typ := reflect.TypeOf(value)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// SetType declares the type of the records stored in the bucket, nil removes
+// the declaration.
+func (b *Bucket) SetType(prototype interface{}) {
+	b.typ = recordType(prototype)
+}
+
+// Type returns the declared type of the records, or nil.
+func (b *Bucket) Type() reflect.Type {
+	return b.typ
+}
+
+func (b *Bucket) checkType(value interface{}) error {
+	if b.typ == nil {
+		return nil
+	}
+	if got := recordType(value); got != b.typ {
+		return &ErrTypeMismatch{Bucket: b.Name, Want: b.typ, Got: got}
+	}
+	return nil
+}
+
+// encodeValue validates and encodes a record to be put in the bucket.
+func (b *Bucket) encodeValue(data interface{}) ([]byte, error) {
+	if err := b.checkType(data); err != nil {
+		return nil, err
+	}
+	return b.encode(data)
+}
+
+// decodeValue decodes a value of the bucket into result. result must be a
+// pointer to the declared type.
+func (b *Bucket) decodeValue(value []byte, result interface{}) error {
+	if b.typ != nil && reflect.TypeOf(result) != reflect.PtrTo(b.typ) {
+		return &ErrTypeMismatch{Bucket: b.Name, Want: reflect.PtrTo(b.typ), Got: reflect.TypeOf(result)}
+	}
+	value, err := b.plain(value)
+	if err != nil {
+		return err
+	}
+	return b.decode(value, result)
+}
+
+// New allocates a record of the declared type and returns a pointer to it.
+func (b *Bucket) New() (interface{}, error) {
+	if b.typ == nil {
+		return nil, fmt.Errorf("bucket %s has no declared type", b.Name)
+	}
+	return reflect.New(b.typ).Interface(), nil
+}
+
+// GetValue retrieves a value from borm into a new record of the declared type.
+func (b *Bucket) GetValue(key string) (interface{}, error) {
+	result, err := b.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Get(key, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReadValue decodes the current value into a new record of the declared type.
+func (it *Iterator) ReadValue() (interface{}, error) {
+	result, err := it.B.New()
+	if err != nil {
+		return nil, err
+	}
+	if err := it.Read(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
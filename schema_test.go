@@ -0,0 +1,111 @@
+package borm_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestRegisterType(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		store.RegisterType("bucktest", ItemTest{})
+		bkt, err := store.CreateBucket("bucktest", nil, nil)
+		if err != nil {
+			t.Fatalf("Error creating bucket for schema test: %s", err)
+		}
+
+		data := &ItemTest{Name: "Test Name", Created: time.Now()}
+		if err := bkt.Insert("testKey", data); err != nil {
+			t.Fatalf("Error inserting data for schema test: %s", err)
+		}
+
+		err = bkt.Insert("otherKey", &OtherType{})
+		if _, ok := err.(*borm.ErrTypeMismatch); !ok {
+			t.Fatalf("Inserting the wrong type did NOT return the correct error.  Got %v", err)
+		}
+
+		var other OtherType
+		err = bkt.Get("testKey", &other)
+		if _, ok := err.(*borm.ErrTypeMismatch); !ok {
+			t.Fatalf("Getting into the wrong type did NOT return the correct error.  Got %v", err)
+		}
+
+		result, err := bkt.GetValue("testKey")
+		if err != nil {
+			t.Fatalf("Error getting data from borm: %s", err)
+		}
+		item, ok := result.(*ItemTest)
+		if !ok {
+			t.Fatalf("GetValue returned %T wanted *ItemTest", result)
+		}
+		if !data.equal(item) {
+			t.Fatalf("Got %v wanted %v.", item, data)
+		}
+	})
+}
+
+func TestRegisterTypeConcurrent(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		if _, err := store.CreateBucket("bucktest", nil, nil); err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+
+		// registered while the buckets are got, run with -race
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				store.RegisterType(fmt.Sprintf("bucket%d", i), ItemTest{})
+			}(i)
+			go func() {
+				defer wg.Done()
+				if _, err := store.GetBucket("bucktest", nil, nil); err != nil {
+					t.Errorf("Error getting bucket: %s", err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+type OtherType struct {
+	OtherName string
+}
+
+func TestStoreBuckets(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		store.RegisterType("typed", ItemTest{})
+		if _, err := store.CreateBucket("typed", borm.JSONEncode, borm.JSONDecode); err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		if _, err := store.CreateBucketIfNotExists("plain", nil, nil); err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+
+		infos, err := store.Buckets()
+		if err != nil {
+			t.Fatalf("Error listing buckets: %s", err)
+		}
+		byName := map[string]borm.BucketInfo{}
+		for _, info := range infos {
+			byName[info.Name] = info
+		}
+		if info := byName["typed"]; info.Type != "borm_test.ItemTest" || info.Codec != "json" {
+			t.Fatalf("Unexpected metadata of the typed bucket %+v", info)
+		}
+		if info := byName["plain"]; info.Type != "" || info.Codec != "gob" || info.Internal {
+			t.Fatalf("Unexpected metadata of the plain bucket %+v", info)
+		}
+		if info, ok := byName["_schema"]; !ok || !info.Internal {
+			t.Fatalf("Expected the schema bucket listed as internal, got %+v", infos)
+		}
+
+		if _, err := store.BucketInfo("missing"); err != borm.ErrBucketNotFound {
+			t.Fatalf("Expected ErrBucketNotFound, got %v", err)
+		}
+	})
+}
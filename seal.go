@@ -0,0 +1,231 @@
+package borm
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// statsBucket is the shard bucket of the statistics computed at seal time.
+var statsBucket = []byte("_stats")
+
+var statsKey = []byte("stats")
+
+// DefaultStatsTopN is the default of TSOptions.StatsTopN.
+const DefaultStatsTopN = 10
+
+// ShardStats are the statistics of a shard, computed when it is sealed.
+type ShardStats struct {
+	Path     string
+	SealedAt time.Time
+
+	Count          int64
+	MinKey, MaxKey string
+	// Bytes is the file size, ValueBytes the sum of the value sizes.
+	Bytes      int64
+	ValueBytes int64
+
+	// Top are the most frequent groups of each of TSOptions.StatDimensions.
+	Top map[string][]Group `json:",omitempty"`
+}
+
+// Seal computes and stores the statistics and the registered sketches of the
+// shard of t. Shards are sealed automatically when their write handle is
+// released after the engine rolled over to a newer shard.
+func (db *TSEngine) Seal(t time.Time) error {
+	return db.readBackground(db.nameWith(t), func(bkt *Bucket) error {
+		return db.seal(bkt)
+	})
+}
+
+func (db *TSEngine) seal(bkt *Bucket) error {
+	stats := &ShardStats{Path: bkt.store.db.Path(), SealedAt: db.now()}
+	if fi, err := os.Stat(stats.Path); err == nil {
+		stats.Bytes = fi.Size()
+	}
+
+	topN := db.options.StatsTopN
+	if topN <= 0 {
+		topN = DefaultStatsTopN
+	}
+	dimensions := map[string]map[string]int64{}
+	for name := range db.options.StatDimensions {
+		dimensions[name] = map[string]int64{}
+	}
+	sketches := map[string]*HyperLogLog{}
+	for name := range db.options.Sketches {
+		sketches[name] = NewHyperLogLog()
+	}
+
+	err := bkt.store.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(bkt.name)
+		if records == nil {
+			return ErrBucketNotFound
+		}
+		err := records.ForEach(func(k, v []byte) error {
+			v, err := bkt.plain(v)
+			if err != nil {
+				return bkt.checksumError(k, err)
+			}
+			if stats.Count == 0 {
+				stats.MinKey = string(k)
+			}
+			stats.MaxKey = string(k)
+			stats.Count++
+			stats.ValueBytes += int64(len(v))
+
+			for name, groupBy := range db.options.StatDimensions {
+				if group, ok := groupBy(k, v); ok {
+					dimensions[name][group]++
+				}
+			}
+			for name, extract := range db.options.Sketches {
+				if value := extract(v); value != nil {
+					sketches[name].Add(value)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for name, counts := range dimensions {
+			if stats.Top == nil {
+				stats.Top = map[string][]Group{}
+			}
+			stats.Top[name] = topGroups(counts, topN)
+		}
+
+		data, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		statsBkt, err := tx.CreateBucketIfNotExists(statsBucket)
+		if err != nil {
+			return err
+		}
+		if err := statsBkt.Put(statsKey, data); err != nil {
+			return err
+		}
+
+		if len(sketches) == 0 {
+			return nil
+		}
+		sketchBkt, err := tx.CreateBucketIfNotExists(sketchBucket)
+		if err != nil {
+			return err
+		}
+		for name, sketch := range sketches {
+			data, err := sketch.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := sketchBkt.Put([]byte(name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil && len(db.options.Columns) > 0 {
+		err = bkt.store.db.View(func(tx *bolt.Tx) error {
+			return db.writeColumns(tx, bkt)
+		})
+	}
+	if err == nil && db.options.DictCompression {
+		err = compressShard(bkt)
+	}
+	if err != nil {
+		return err
+	}
+	db.notifyShard(shardSealed, bkt.store.db.Path())
+	return nil
+}
+
+func topGroups(counts map[string]int64, n int) []Group {
+	groups := make([]Group, 0, len(counts))
+	for key, count := range counts {
+		groups = append(groups, Group{Key: key, Count: count})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	if len(groups) > n {
+		groups = groups[:n]
+	}
+	return groups
+}
+
+// isSealed reports whether the shard of the bucket has statistics.
+func isSealed(bkt *Bucket) (bool, error) {
+	var sealed bool
+	err := bkt.store.db.View(func(tx *bolt.Tx) error {
+		statsBkt := tx.Bucket(statsBucket)
+		sealed = statsBkt != nil && statsBkt.Get(statsKey) != nil
+		return nil
+	})
+	return sealed, err
+}
+
+// loadStats returns the statistics of the shard of the bucket, nil if the
+// shard isn't sealed.
+func loadStats(bkt *Bucket) (*ShardStats, error) {
+	var stats *ShardStats
+	err := bkt.store.db.View(func(tx *bolt.Tx) error {
+		statsBkt := tx.Bucket(statsBucket)
+		if statsBkt == nil {
+			return nil
+		}
+		data := statsBkt.Get(statsKey)
+		if data == nil {
+			return nil
+		}
+		stats = &ShardStats{}
+		return json.Unmarshal(data, stats)
+	})
+	return stats, err
+}
+
+// unseal drops the statistics and sketches of the shard of the bucket, which
+// don't cover records written after the seal.
+func unseal(bkt *Bucket) error {
+	if err := removeFile(bkt.store.db.Path() + columnsSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return bkt.store.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{statsBucket, sketchBucket} {
+			if tx.Bucket(name) == nil {
+				continue
+			}
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ShardStats returns the statistics of the sealed shards in the time range,
+// shards without statistics are skipped. No record is read.
+func (db *TSEngine) ShardStats(start, end time.Time) ([]*ShardStats, error) {
+	var results []*ShardStats
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		return db.read(fileName, func(bkt *Bucket) error {
+			stats, err := loadStats(bkt)
+			if stats != nil {
+				results = append(results, stats)
+			}
+			return err
+		})
+	})
+	return results, err
+}
@@ -0,0 +1,192 @@
+package borm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestShardStats(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		yesterday := time.Now().AddDate(0, 0, -1)
+		err := db.Write(yesterday, func(bkt *borm.Bucket) error {
+			for i := range testData {
+				if err := bkt.Insert(borm.CreateID(yesterday, uint32(i)), &testData[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error writing test data: %s", err)
+		}
+
+		if err := db.Seal(yesterday); err != nil {
+			t.Fatalf("Error sealing shard: %s", err)
+		}
+
+		stats, err := db.ShardStats(yesterday, yesterday)
+		if err != nil {
+			t.Fatalf("Error reading shard stats: %s", err)
+		}
+		if len(stats) != 1 {
+			t.Fatalf("ShardStats returned %d shards wanted %d.", len(stats), 1)
+		}
+		if stats[0].Count != int64(len(testData)) {
+			t.Fatalf("Shard count is %d wanted %d.", stats[0].Count, len(testData))
+		}
+		if stats[0].MinKey != borm.CreateID(yesterday, 0) ||
+			stats[0].MaxKey != borm.CreateID(yesterday, uint32(len(testData)-1)) {
+			t.Fatalf("Shard key range is %s-%s.", stats[0].MinKey, stats[0].MaxKey)
+		}
+	})
+}
+
+func TestSample(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		yesterday := now.AddDate(0, 0, -1)
+		for _, day := range []time.Time{yesterday, now} {
+			err := db.Write(day, func(bkt *borm.Bucket) error {
+				for i := range testData {
+					if err := bkt.Insert(borm.CreateID(day, uint32(i)), &testData[i]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error writing test data: %s", err)
+			}
+		}
+		if err := db.Seal(yesterday); err != nil {
+			t.Fatalf("Error sealing shard: %s", err)
+		}
+
+		samples, err := db.Sample(yesterday.AddDate(0, 0, -1), now.Add(time.Second), 10)
+		if err != nil {
+			t.Fatalf("Error sampling: %s", err)
+		}
+		if len(samples) != 10 {
+			t.Fatalf("Sample returned %d records wanted %d.", len(samples), 10)
+		}
+
+		var item ItemTest
+		if err := samples[0].Read(&item); err != nil {
+			t.Fatalf("Error reading sample: %s", err)
+		}
+	})
+}
+
+func TestSampleRemainder(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		// 5, 5 and 1 records, 6 samples are 2.7, 2.7 and 0.5 of them
+		now := time.Now()
+		days := []time.Time{now.AddDate(0, 0, -3), now.AddDate(0, 0, -2), now.AddDate(0, 0, -1)}
+		for d, n := range []int{5, 5, 1} {
+			err := db.Write(days[d], func(bkt *borm.Bucket) error {
+				for i := 0; i < n; i++ {
+					if err := bkt.Insert(borm.CreateID(days[d], uint32(i)), &testData[i]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error writing test data: %s", err)
+			}
+		}
+
+		samples, err := db.Sample(days[0].AddDate(0, 0, -1), now, 6)
+		if err != nil {
+			t.Fatalf("Error sampling: %s", err)
+		}
+		if len(samples) != 6 {
+			t.Fatalf("Sample returned %d records wanted %d.", len(samples), 6)
+		}
+		for _, sample := range samples {
+			if string(sample.Key) == borm.CreateID(days[2], 0) {
+				t.Fatalf("Sample returned the record of the smallest shard, wanted 3 of each other.")
+			}
+		}
+	})
+}
+
+func TestDictCompression(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder:         borm.JSONEncode,
+		Decoder:         borm.JSONDecode,
+		DictCompression: true,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	day := time.Now().AddDate(0, 0, -2)
+	var entries []borm.Entry
+	var plainBytes int
+	for i := 0; i < 500; i++ {
+		item := &ItemTest{ID: i, Name: "attack from 10.0.0." + strconv.Itoa(i%50), Category: testData[i%len(testData)].Category, Created: day}
+		data, _ := borm.JSONEncode(item)
+		plainBytes += len(data)
+		entries = append(entries, borm.Entry{Time: day, Key: borm.CreateID(day, uint32(i)), Value: item})
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+	if err := db.Seal(day); err != nil {
+		t.Fatalf("Error sealing shard: %s", err)
+	}
+
+	var item ItemTest
+	if err := db.Get(borm.CreateID(day, 7), &item); err != nil || item.ID != 7 {
+		t.Fatalf("Error getting a compressed record: %v %v", item, err)
+	}
+	count := 0
+	opts := &borm.QueryOptions{Filter: `Category = "food"`}
+	err = db.QueryWith(day.Add(-time.Hour), day.Add(time.Hour), opts, func(it *borm.Iterator) error {
+		for it.Next() {
+			var item ItemTest
+			if err := it.Read(&item); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying compressed shard: %s", err)
+	}
+	if count == 0 {
+		t.Fatalf("Filter found no record in the compressed shard.")
+	}
+
+	// the raw values in the shard file
+	store, err := borm.Open(filepath.Join(dir, borm.Daily.Name(day)), 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening shard: %s", err)
+	}
+	defer store.Close()
+	bkt, err := store.GetBucket("attack", nil, nil)
+	if err != nil {
+		t.Fatalf("Error opening shard bucket: %s", err)
+	}
+	var rawBytes int
+	bkt.ForEach(func(it *borm.Iterator) error {
+		for it.Next() {
+			rawBytes += len(it.Value())
+		}
+		return nil
+	})
+	if rawBytes*2 > plainBytes {
+		t.Fatalf("Compressed values are %d bytes, plain %d.", rawBytes, plainBytes)
+	}
+}
@@ -16,6 +16,29 @@ import (
 type Shard struct {
 	path               string
 	startTime, endTime time.Time
+	granularity        Granularity
+}
+
+// ShardInfo describes a shard file.
+type ShardInfo struct {
+	Path               string
+	StartTime, EndTime time.Time
+	// Size is the file size in bytes, 0 if the file doesn't exist.
+	Size int64
+	// Tags are the key/value metadata attached to the shard.
+	Tags map[string]string
+	// Pinned shards are exempt from retention, see TSEngine.PinShard.
+	Pinned    bool
+	PinReason string
+}
+
+// Info returns the description of the shard.
+func (s *Shard) Info() ShardInfo {
+	info := ShardInfo{Path: s.path, StartTime: s.startTime, EndTime: s.endTime}
+	if fi, err := os.Stat(s.path); err == nil {
+		info.Size = fi.Size()
+	}
+	return info
 }
 
 // Shards is a slice of Shards.
@@ -41,7 +64,7 @@ func openShard(path string, loc *time.Location) (*Shard, error) {
 	}
 
 	ss := strings.Split(name, "_")
-	if len(ss) != 2 {
+	if len(ss) != 2 && len(ss) != 3 {
 		return nil, errors.New("invalid shard name - " + name)
 	}
 
@@ -54,12 +77,67 @@ func openShard(path string, loc *time.Location) (*Shard, error) {
 		return nil, errors.New("invalid shard name - " + name)
 	}
 	start := time.Date(year, time.January, 0, 0, 0, 0, 0, loc).AddDate(0, 0, yearDay)
+	g := Daily
+	if len(ss) == 3 {
+		hour, err := strconv.Atoi(ss[2])
+		if err != nil || hour < 0 || hour > 23 {
+			return nil, errors.New("invalid shard name - " + name)
+		}
+		start = time.Date(start.Year(), start.Month(), start.Day(), hour, 0, 0, 0, loc)
+		g = Hourly
+	}
 	return &Shard{path: path,
-		startTime: start,
-		endTime:   start.AddDate(0, 0, 1)}, nil
+		startTime:   start,
+		endTime:     g.Next(start),
+		granularity: g}, nil
+}
+
+// DefaultShardExtension is the extension of the shard files named by a
+// Granularity.
+const DefaultShardExtension = ".ts"
+
+// DefaultIgnoreShards are the patterns of the files of an engine directory
+// which aren't shards: temporary files, partial copies and editor backups.
+var DefaultIgnoreShards = []string{"*.tmp", "*.part", "*.swp", "*~", "#*#"}
+
+// ListOptions allows you set different options from the defaults for the
+// listing of the shards of a directory.
+type ListOptions struct {
+	// Extension, when set, restricts the shards to the files with it, e.g.
+	// ".ts".
+	Extension string
+
+	// Ignore are the patterns, as of filepath.Match, of the file names which
+	// aren't shards. It defaults to DefaultIgnoreShards.
+	Ignore []string
+}
+
+// ignored returns whether the file name isn't a shard.
+func (opts *ListOptions) ignored(name string) bool {
+	if strings.HasPrefix(name, ".") ||
+		hasFileSuffix(name, lockSuffix) ||
+		hasFileSuffix(name, columnsSuffix) {
+		return true
+	}
+	ignore := DefaultIgnoreShards
+	if opts != nil && opts.Ignore != nil {
+		ignore = opts.Ignore
+	}
+	for _, pattern := range ignore {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return opts != nil && opts.Extension != "" && !hasFileSuffix(name, opts.Extension)
 }
 
 func ListShards(path string, loc *time.Location) (Shards, error) {
+	return ListShardsWith(path, loc, nil)
+}
+
+// ListShardsWith lists the shards of path as ListShards does, with the given
+// options.
+func ListShardsWith(path string, loc *time.Location, opts *ListOptions) (Shards, error) {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		if !os.IsExist(err) {
 			return nil, err
@@ -79,9 +157,7 @@ func ListShards(path string, loc *time.Location) (Shards, error) {
 	var shards Shards
 	// Open all indexes.
 	for _, fi := range files {
-		if fi.IsDir() ||
-			strings.HasPrefix(fi.Name(), ".") ||
-			strings.HasSuffix(fi.Name(), ".lock") {
+		if fi.IsDir() || opts.ignored(fi.Name()) {
 			continue
 		}
 		shardPath := filepath.Join(path, fi.Name())
@@ -100,7 +176,7 @@ func ListShards(path string, loc *time.Location) (Shards, error) {
 func removeShardsBefore(shards Shards, t time.Time) error {
 	for _, shard := range shards {
 		if shard.startTime.Before(t) {
-			if err := os.Remove(shard.path); err != nil {
+			if err := removeFile(shard.path); err != nil {
 				return err
 			}
 		}
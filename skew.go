@@ -0,0 +1,70 @@
+package borm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrClockSkew is returned when a Write timestamp is too far from the wall clock
+var ErrClockSkew = errors.New("timestamp is too far from the current time")
+
+// SkewAction is what TSEngine does with a write whose timestamp is outside
+// of the skew bounds.
+type SkewAction int
+
+const (
+	// SkewReject fails the write with ErrClockSkew.
+	SkewReject SkewAction = iota
+
+	// SkewQuarantine redirects the write into the quarantine shard, which is
+	// out of the shard directory and so never seen by queries and retention.
+	SkewQuarantine
+)
+
+// QuarantineDir is the directory of the quarantine shard, relative to the engine path.
+const QuarantineDir = "quarantine"
+
+// QuarantineFile returns the path of the quarantine shard.
+func (db *TSEngine) QuarantineFile() string {
+	return filepath.Join(db.basePath, QuarantineDir, "quarantine.ts")
+}
+
+// route returns the shard file a write at t goes to.
+func (db *TSEngine) route(t time.Time) (string, error) {
+	if !db.isSkewed(t) {
+		return db.nameWith(t), nil
+	}
+	if db.options.SkewAction == SkewQuarantine {
+		file := db.QuarantineFile()
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return "", err
+		}
+		return file, nil
+	}
+	return "", ErrClockSkew
+}
+
+// routesTo returns whether a write at t goes to the shard file, as route
+// decides without reporting the skew.
+func (db *TSEngine) routesTo(t time.Time, file string) bool {
+	if db.skewed(t, db.now()) {
+		return db.options.SkewAction == SkewQuarantine && file == db.QuarantineFile()
+	}
+	return db.nameWith(t) == file
+}
+
+func (db *TSEngine) isSkewed(t time.Time) bool {
+	now := db.now()
+	skewed := db.skewed(t, now)
+	if skewed && db.options.OnSkew != nil {
+		db.options.OnSkew(t, now)
+	}
+	return skewed
+}
+
+func (db *TSEngine) skewed(t, now time.Time) bool {
+	return (db.options.MaxFutureSkew > 0 && t.Sub(now) > db.options.MaxFutureSkew) ||
+		(db.options.MaxPastSkew > 0 && now.Sub(t) > db.options.MaxPastSkew)
+}
@@ -0,0 +1,79 @@
+package borm
+
+import (
+	"fmt"
+	"time"
+)
+
+// SlowOpThresholds are the durations past which an operation is reported as
+// slow, see TSOptions.OnSlowOp. A zero threshold never reports the
+// operation.
+type SlowOpThresholds struct {
+	// Commit is the threshold of a Write, the encoding of the records and
+	// the commit of the Bolt transaction.
+	Commit time.Duration
+
+	// Open is the threshold of the opening of a shard file, its creation
+	// included.
+	Open time.Duration
+
+	// Query is the threshold of the segment of a query in a shard, the
+	// callbacks reading the records included.
+	Query time.Duration
+}
+
+// Slow operations of a SlowOp.
+const (
+	SlowCommit = "commit"
+	SlowOpen   = "open"
+	SlowQuery  = "query"
+)
+
+// SlowOp is an operation slower than its threshold.
+type SlowOp struct {
+	// Op is SlowCommit, SlowOpen or SlowQuery.
+	Op    string
+	Shard string
+
+	Start     time.Time
+	Duration  time.Duration
+	Threshold time.Duration
+
+	// Records are the records read by a query segment.
+	Records int64
+
+	// Err is the error the operation failed with, if any.
+	Err error
+}
+
+// String returns the operation as key=value pairs, the line the engine logs
+// without a TSOptions.OnSlowOp.
+func (op SlowOp) String() string {
+	s := fmt.Sprintf("op=%s shard=%q start=%s duration=%s threshold=%s",
+		op.Op, op.Shard, op.Start.Format(time.RFC3339Nano), op.Duration, op.Threshold)
+	if op.Op == SlowQuery {
+		s += fmt.Sprintf(" records=%d", op.Records)
+	}
+	if op.Err != nil {
+		s += fmt.Sprintf(" error=%q", op.Err.Error())
+	}
+	return s
+}
+
+// slowOp reports the operation on the shard started at start if it took
+// longer than the threshold.
+func (db *TSEngine) slowOp(op, shard string, threshold time.Duration, start time.Time, records int64, err error) {
+	if threshold <= 0 {
+		return
+	}
+	elapsed := time.Since(start)
+	if elapsed < threshold {
+		return
+	}
+	slow := SlowOp{Op: op, Shard: shard, Start: start, Duration: elapsed, Threshold: threshold, Records: records, Err: err}
+	if db.options.OnSlowOp != nil {
+		db.options.OnSlowOp(slow)
+	} else {
+		db.logger().Printf("engine found a slow operation, %s", slow)
+	}
+}
@@ -0,0 +1,92 @@
+package borm_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestSlowOps(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	var ops []borm.SlowOp
+	db, err := borm.OpenTSWith(dir, borm.WithSlowOps(borm.SlowOpThresholds{
+		Commit: time.Nanosecond,
+		Open:   time.Nanosecond,
+		Query:  time.Hour,
+	}, func(op borm.SlowOp) {
+		mu.Lock()
+		ops = append(ops, op)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	tx := db.Begin(now)
+	if _, err := tx.Insert(&ItemTest{Name: "car", Created: now}); err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+	if _, err := db.FindFirst(now.Add(-time.Second), now.Add(time.Second), ""); err != nil {
+		t.Fatalf("Error finding record: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	seen := map[string]bool{}
+	for _, op := range ops {
+		seen[op.Op] = true
+		if op.Shard == "" || op.Duration < op.Threshold || op.Err != nil {
+			t.Fatalf("Slow op is %+v.", op)
+		}
+	}
+	if !seen[borm.SlowOpen] || !seen[borm.SlowCommit] || seen[borm.SlowQuery] {
+		t.Fatalf("Slow ops are %+v wanted an open and a commit.", ops)
+	}
+}
+
+func TestSlowOpsLogged(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	var logged bytes.Buffer
+	db, err := borm.OpenTSWith(dir,
+		borm.WithLogger(log.New(&logged, "", 0)),
+		borm.WithSlowOps(borm.SlowOpThresholds{Query: time.Nanosecond}, nil))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	if err := db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), &ItemTest{Name: "car", Created: now})
+	}); err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+	err = db.Query(now.Add(-time.Second), now.Add(time.Second), func(it *borm.Iterator) error {
+		for it.Next() {
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if line := logged.String(); !strings.Contains(line, "engine found a slow operation, op=query shard=") || !strings.Contains(line, "records=1") ||
+		strings.Contains(line, "op=commit") {
+		t.Fatalf("Logged %q wanted the slow query segment.", line)
+	}
+}
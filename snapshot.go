@@ -0,0 +1,215 @@
+package borm
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrSnapshotStale is returned when a read of a snapshot taken by
+// FreezeRange needs a sealed shard written to since, which unsealed it.
+var ErrSnapshotStale = errors.New("shard changed since the range was frozen")
+
+// Snapshot serves consistent reads as of the time it was taken: the hot
+// shard is read from a copy made by Snapshot, so records written afterwards
+// don't appear mid-iteration. The other shards are read as usual, but for
+// the ones frozen by FreezeRange.
+type Snapshot struct {
+	db *TSEngine
+
+	// copies are the shards read from a copy, nil for the ones which didn't
+	// exist when the snapshot was taken.
+	copies map[string]*shardCopy
+
+	// frozen are the statistics of the sealed shards of the range frozen by
+	// FreezeRange, as stored when the shard was sealed.
+	frozen map[string][]byte
+}
+
+// shardCopy is the copy of a shard taken by a snapshot.
+type shardCopy struct {
+	path  string
+	store *Store
+	bkt   *Bucket
+}
+
+// Snapshot returns a snapshot of the engine, it must be closed to remove
+// the copy of the hot shard.
+func (db *TSEngine) Snapshot() (*Snapshot, error) {
+	s := &Snapshot{db: db, copies: map[string]*shardCopy{}}
+	if err := s.copyShard(db.nameWith(db.now())); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FreezeRange returns a snapshot under which the reads of the time range,
+// Query, Count and Aggregate, observe the same records, e.g. for the pages
+// of a report. The hot shard and the unsealed shards of the range are
+// copied as by Snapshot, the sealed ones are read as usual, the reads of a
+// sealed shard written to since the freeze fail with ErrSnapshotStale.
+func (db *TSEngine) FreezeRange(start, end time.Time) (*Snapshot, error) {
+	s := &Snapshot{db: db, copies: map[string]*shardCopy{}, frozen: map[string][]byte{}}
+	hotFile := db.nameWith(db.now())
+	err := filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); fileName == hotFile || os.IsNotExist(err) {
+			return s.copyShard(fileName)
+		}
+		err := db.read(fileName, func(bkt *Bucket) error {
+			return bkt.store.db.View(func(tx *bolt.Tx) error {
+				if stats := sealStats(tx); stats != nil {
+					s.frozen[fileName] = append([]byte(nil), stats...)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if _, ok := s.frozen[fileName]; ok {
+			return nil
+		}
+		return s.copyShard(fileName)
+	})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// copyShard copies the shard file, if it exists, to read it as of now.
+func (s *Snapshot) copyShard(fileName string) error {
+	db := s.db
+	s.copies[fileName] = nil
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := os.MkdirAll(db.basePath, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(db.basePath, ".snapshot-")
+	if err != nil {
+		return err
+	}
+	c := &shardCopy{path: f.Name()}
+	f.Close()
+
+	err = db.read(fileName, func(bkt *Bucket) error {
+		return bkt.store.db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(c.path, 0600)
+		})
+	})
+	if err == nil {
+		c.store, c.bkt, err = db.open(c.path)
+	}
+	if err != nil {
+		os.Remove(c.path)
+		return err
+	}
+	s.copies[fileName] = c
+	return nil
+}
+
+// read is the readFunc of the snapshot.
+func (s *Snapshot) read(fileName string, cb func(bkt *Bucket) error) error {
+	if c, ok := s.copies[fileName]; ok {
+		if c == nil {
+			// the shard didn't exist when the snapshot was taken
+			return nil
+		}
+		return cb(c.bkt)
+	}
+	stats, ok := s.frozen[fileName]
+	if !ok {
+		return s.db.read(fileName, cb)
+	}
+
+	return s.db.read(fileName, func(bkt *Bucket) error {
+		tx, err := bkt.store.db.Begin(false)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if !bytes.Equal(sealStats(tx), stats) {
+			return ErrSnapshotStale
+		}
+		// the reads of the callback go through the checked transaction
+		pinned := *bkt
+		pinned.tx = tx
+		return cb(&pinned)
+	})
+}
+
+// sealStats returns the statistics stored by the seal of the shard, nil if
+// it isn't sealed. Every write unseals the shard first.
+func sealStats(tx *bolt.Tx) []byte {
+	statsBkt := tx.Bucket(statsBucket)
+	if statsBkt == nil {
+		return nil
+	}
+	return statsBkt.Get(statsKey)
+}
+
+// Get retrieves a record as of the snapshot.
+func (s *Snapshot) Get(id string, record interface{}) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	t := TimeFromID(id)
+
+	err := ErrNotFound
+	if e := s.read(s.db.nameWith(t), func(bkt *Bucket) error {
+		err = bkt.Get(id, record)
+		return nil
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+// Query retrieves the records in the time range as of the snapshot.
+func (s *Snapshot) Query(start, end time.Time, cb func(it *Iterator) error) error {
+	return s.db.queryWith(s.read, start, end, nil, cb)
+}
+
+// QueryWith retrieves the records in the time range as of the snapshot, with
+// the given options.
+func (s *Snapshot) QueryWith(start, end time.Time, opts *QueryOptions, cb func(it *Iterator) error) error {
+	return s.db.queryWith(s.read, start, end, opts, cb)
+}
+
+// Count returns the number of records in the time range matching the
+// options, as of the snapshot.
+func (s *Snapshot) Count(start, end time.Time, opts *QueryOptions) (int64, error) {
+	return s.db.count(s.read, start, end, opts)
+}
+
+// Aggregate runs the aggregation over the records in the time range, as of
+// the snapshot.
+func (s *Snapshot) Aggregate(start, end time.Time, opts *QueryOptions, agg *Aggregation) ([]Group, error) {
+	return s.db.aggregate(s.read, start, end, opts, agg)
+}
+
+// Close releases the snapshot.
+func (s *Snapshot) Close() error {
+	var err error
+	for fileName, c := range s.copies {
+		delete(s.copies, fileName)
+		if c == nil {
+			continue
+		}
+		if e := c.store.Close(); e != nil && err == nil {
+			err = e
+		}
+		if e := os.Remove(c.path); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package borm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestSnapshot(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		write := func(i int) {
+			err := db.Write(now, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(now, uint32(i)), &testData[i])
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+		count := func(query func(start, end time.Time, cb func(it *borm.Iterator) error) error) int {
+			count := 0
+			err := query(now.Add(-time.Second), now.Add(time.Second), func(it *borm.Iterator) error {
+				for it.Next() {
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error querying: %s", err)
+			}
+			return count
+		}
+
+		write(0)
+		snapshot, err := db.Snapshot()
+		if err != nil {
+			t.Fatalf("Error taking snapshot: %s", err)
+		}
+		defer snapshot.Close()
+		write(1)
+
+		if n := count(snapshot.Query); n != 1 {
+			t.Fatalf("Snapshot query found %d records wanted %d.", n, 1)
+		}
+		if n := count(db.Query); n != 2 {
+			t.Fatalf("Query found %d records wanted %d.", n, 2)
+		}
+
+		var result ItemTest
+		if err := snapshot.Get(borm.CreateID(now, 1), &result); err != borm.ErrNotFound {
+			t.Fatalf("Snapshot returned a record written after it, got %v", err)
+		}
+	})
+}
+
+func TestFreezeRange(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		sealedDay := startOfDay(now).Add(-48 * time.Hour)
+		openDay := startOfDay(now).Add(-24 * time.Hour)
+		write := func(at time.Time, i int) {
+			if _, err := db.Backfill([]borm.Entry{{Time: at, Value: &testData[i]}}, nil); err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+		write(sealedDay.Add(time.Hour), 0)
+		write(openDay.Add(time.Hour), 1)
+		write(now, 2)
+		if err := db.Seal(sealedDay); err != nil {
+			t.Fatalf("Error sealing: %s", err)
+		}
+
+		start, end := sealedDay, now.Add(time.Second)
+		frozen, err := db.FreezeRange(start, end)
+		if err != nil {
+			t.Fatalf("Error freezing range: %s", err)
+		}
+		defer frozen.Close()
+
+		write(openDay.Add(2*time.Hour), 3)
+		write(now, 4)
+		if n, err := db.Count(start, end, nil); err != nil || n != 5 {
+			t.Fatalf("Count found %d records wanted 5: %v", n, err)
+		}
+		if n, err := frozen.Count(start, end, nil); err != nil || n != 3 {
+			t.Fatalf("Frozen count found %d records wanted 3: %v", n, err)
+		}
+		groups, err := frozen.Aggregate(start, end, nil, &borm.Aggregation{
+			GroupBy: func(key, value []byte) (string, bool) { return "all", true },
+		})
+		if err != nil {
+			t.Fatalf("Error aggregating: %s", err)
+		}
+		if len(groups) != 1 || groups[0].Count != 3 {
+			t.Fatalf("Frozen aggregate got %v wanted 3 records.", groups)
+		}
+
+		// a sealed shard written to since can't be read as of the freeze
+		write(sealedDay.Add(2*time.Hour), 5)
+		if _, err := frozen.Count(start, end, nil); err != borm.ErrSnapshotStale {
+			t.Fatalf("Frozen count of a changed shard got %v wanted ErrSnapshotStale.", err)
+		}
+	})
+}
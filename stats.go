@@ -0,0 +1,257 @@
+package borm
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// EngineStats are the runtime statistics of an engine.
+type EngineStats struct {
+	// OpenWriters is the number of shards kept open for writing.
+	OpenWriters int
+
+	// RecordsWritten are the records put since the engine was opened,
+	// RecordsRead the ones read by Get and the queries, for the rates of a
+	// dashboard.
+	RecordsWritten int64
+	RecordsRead    int64
+
+	// Foreground and Background are the shard reads of the lanes, see
+	// ReadPriority, those of all the engines of an EngineManager.
+	Foreground LaneStats
+	Background LaneStats
+
+	// Files is the usage of the open file budget of the process, see
+	// SetMaxOpenFiles.
+	Files FileStats
+
+	// Quotas are the usage of today per namespace and of all namespaces,
+	// counted if the engine has quotas.
+	Quotas      map[string]QuotaUsage
+	GlobalQuota QuotaUsage
+
+	// Rejected are the records rejected by the validators by rule, see
+	// ValidationError.
+	Rejected map[string]int64
+
+	// EnrichFailures are the records the enrichers failed on by enricher,
+	// whatever their policy.
+	EnrichFailures map[string]int64
+}
+
+// Stats returns the runtime statistics of the engine.
+func (db *TSEngine) Stats() EngineStats {
+	db.handlesMu.Lock()
+	stats := EngineStats{OpenWriters: len(db.handles), Files: OpenFiles()}
+	db.handlesMu.Unlock()
+	stats.RecordsWritten = db.recordsWritten.Load()
+	stats.RecordsRead = db.recordsRead.Load()
+	stats.Foreground, stats.Background = db.lanes.laneStats()
+	if db.options.hasQuotas() {
+		stats.Quotas, stats.GlobalQuota = db.quotaStats()
+	}
+	if len(db.options.Validators) > 0 {
+		stats.Rejected = db.rejected.stats()
+	}
+	if len(db.options.Enrichers) > 0 {
+		stats.EnrichFailures = db.enrichFailures.stats()
+	}
+	return stats
+}
+
+// countWrite returns the write hook of a bucket counting the records put
+// once the write commits.
+func (db *TSEngine) countWrite(bkt *Bucket) WriteHook {
+	return func(tx *bolt.Tx, key, value []byte) error {
+		if value != nil {
+			bkt.written.add(tx, nil, func(items []interface{}) {
+				db.recordsWritten.Add(int64(len(items)))
+			})
+		}
+		return nil
+	}
+}
+
+// ShardBoltStats are the Bolt internals of a shard file, to find out why a
+// shard grew: the free and pending pages of the freelist, the transactions
+// and the pages of the buckets.
+type ShardBoltStats struct {
+	Path               string
+	StartTime, EndTime time.Time
+	// Size is the file size, DataSize the pages up to the high water mark
+	// of the file, the rest is preallocated.
+	Size     int64
+	DataSize int64
+	PageSize int
+
+	// Open is true if the shard is open for writing, Bolt then counts the
+	// transactions since the engine opened it, since the shard was opened
+	// for the statistics otherwise.
+	Open bool
+	Bolt bolt.Stats
+
+	// Buckets are the page statistics of the buckets by name.
+	Buckets map[string]bolt.BucketStats
+}
+
+// BoltStats returns the Bolt statistics of the shards overlapping the time
+// range, latest first. The shards not open for writing are opened to read
+// them.
+func (db *TSEngine) BoltStats(start, end time.Time) ([]ShardBoltStats, error) {
+	return db.boltStats(func(shard *Shard) bool {
+		return shard.endTime.After(start) && !shard.startTime.After(end)
+	})
+}
+
+// OpenBoltStats returns the Bolt statistics of the shards open for
+// writing, latest first, without opening any file.
+func (db *TSEngine) OpenBoltStats() ([]ShardBoltStats, error) {
+	return db.boltStats(func(shard *Shard) bool {
+		return db.isOpen(shard.path)
+	})
+}
+
+func (db *TSEngine) boltStats(match func(shard *Shard) bool) ([]ShardBoltStats, error) {
+	shards, err := db.listShards(db.location())
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []ShardBoltStats
+	for _, shard := range shards {
+		if !match(shard) {
+			continue
+		}
+		info := shard.Info()
+		s := ShardBoltStats{Path: info.Path, StartTime: info.StartTime, EndTime: info.EndTime, Size: info.Size, Open: db.isOpen(shard.path)}
+		err := db.read(shard.path, func(bkt *Bucket) error {
+			s.Bolt = bkt.store.db.Stats()
+			s.PageSize = bkt.store.db.Info().PageSize
+			return bkt.store.db.View(func(tx *bolt.Tx) error {
+				s.DataSize = tx.Size()
+				s.Buckets = map[string]bolt.BucketStats{}
+				return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+					s.Buckets[string(name)] = b.Stats()
+					return nil
+				})
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// BucketStats are the statistics of the records of a bucket.
+type BucketStats struct {
+	Keys int64
+	// ValueBytes is the sum of the stored value sizes, compressed if the
+	// shard is, AvgValueSize the average of them.
+	ValueBytes   int64
+	AvgValueSize float64
+
+	// Prefixes is the key prefix histogram, the prefixes of the most keys
+	// first.
+	Prefixes []PrefixStats
+}
+
+// PrefixStats are the keys and the value bytes of a key prefix.
+type PrefixStats struct {
+	Prefix     string
+	Keys       int64
+	ValueBytes int64
+}
+
+// KeyPrefixFunc returns the prefix a key is counted under in the histogram
+// of Bucket.Stats, e.g. the series or the tenant of the key.
+type KeyPrefixFunc func(key []byte) string
+
+// DefaultKeyPrefix is the key up to its first ':' or '/', the whole key if
+// it has none.
+func DefaultKeyPrefix(key []byte) string {
+	if i := bytes.IndexAny(key, ":/"); i >= 0 {
+		return string(key[:i])
+	}
+	return string(key)
+}
+
+// BucketStatsOptions allows you set different options from the defaults for Bucket.StatsWith
+type BucketStatsOptions struct {
+	// Prefix defaults to DefaultKeyPrefix.
+	Prefix KeyPrefixFunc
+	// TopN is the size of the histogram, it defaults to DefaultStatsTopN.
+	TopN int
+}
+
+// Stats returns the statistics of the bucket, with the default options.
+func (b *Bucket) Stats() (*BucketStats, error) {
+	return b.StatsWith(nil)
+}
+
+// StatsWith scans the bucket and returns its statistics.
+func (b *Bucket) StatsWith(opts *BucketStatsOptions) (*BucketStats, error) {
+	if opts == nil {
+		opts = &BucketStatsOptions{}
+	}
+	prefixOf := opts.Prefix
+	if prefixOf == nil {
+		prefixOf = DefaultKeyPrefix
+	}
+	topN := opts.TopN
+	if topN <= 0 {
+		topN = DefaultStatsTopN
+	}
+
+	stats := &BucketStats{}
+	prefixes := map[string]*PrefixStats{}
+	err := b.view(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(b.name)
+		if bkt == nil {
+			return ErrBucketNotFound
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				// nested buckets are not records
+				return nil
+			}
+			stats.Keys++
+			stats.ValueBytes += int64(len(v))
+
+			prefix := prefixOf(k)
+			p := prefixes[prefix]
+			if p == nil {
+				p = &PrefixStats{Prefix: prefix}
+				prefixes[prefix] = p
+			}
+			p.Keys++
+			p.ValueBytes += int64(len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stats.Keys > 0 {
+		stats.AvgValueSize = float64(stats.ValueBytes) / float64(stats.Keys)
+	}
+
+	stats.Prefixes = make([]PrefixStats, 0, len(prefixes))
+	for _, p := range prefixes {
+		stats.Prefixes = append(stats.Prefixes, *p)
+	}
+	sort.Slice(stats.Prefixes, func(i, j int) bool {
+		if stats.Prefixes[i].Keys != stats.Prefixes[j].Keys {
+			return stats.Prefixes[i].Keys > stats.Prefixes[j].Keys
+		}
+		return stats.Prefixes[i].Prefix < stats.Prefixes[j].Prefix
+	})
+	if len(stats.Prefixes) > topN {
+		stats.Prefixes = stats.Prefixes[:topN]
+	}
+	return stats, nil
+}
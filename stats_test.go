@@ -0,0 +1,125 @@
+package borm_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestBucketStats(t *testing.T) {
+	testWrap(t, func(store *borm.Store, t *testing.T) {
+		bkt, err := store.CreateBucket("stats", borm.JSONEncode, borm.JSONDecode)
+		if err != nil {
+			t.Fatalf("Error creating bucket: %s", err)
+		}
+		for i := 0; i < 6; i++ {
+			tenant := "small"
+			if i%3 != 0 {
+				tenant = "big"
+			}
+			if err := bkt.Insert(fmt.Sprintf("%s:%d", tenant, i), strings.Repeat("x", i)); err != nil {
+				t.Fatalf("Error inserting record %d: %s", i, err)
+			}
+		}
+
+		stats, err := bkt.Stats()
+		if err != nil {
+			t.Fatalf("Error getting the bucket stats: %s", err)
+		}
+		// the values are the JSON strings, quotes and newline included
+		if stats.Keys != 6 || stats.ValueBytes != 33 || stats.AvgValueSize != 5.5 {
+			t.Fatalf("Unexpected bucket stats %+v", stats)
+		}
+		if len(stats.Prefixes) != 2 || stats.Prefixes[0] != (borm.PrefixStats{Prefix: "big", Keys: 4, ValueBytes: 24}) ||
+			stats.Prefixes[1].Prefix != "small" {
+			t.Fatalf("Unexpected key prefix histogram %+v", stats.Prefixes)
+		}
+
+		stats, err = bkt.StatsWith(&borm.BucketStatsOptions{TopN: 1})
+		if err != nil || len(stats.Prefixes) != 1 {
+			t.Fatalf("Expected the histogram cut to 1 prefix, got %+v: %v", stats, err)
+		}
+	})
+}
+
+func TestBoltStats(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		old := now.AddDate(0, 0, -3)
+		var entries []borm.Entry
+		for i := 0; i < 10; i++ {
+			entries = append(entries, borm.Entry{Time: old.Add(time.Duration(i) * time.Second), Value: &ItemTest{Name: "old"}})
+		}
+		if _, err := db.Backfill(entries, nil); err != nil {
+			t.Fatalf("Error backfilling: %s", err)
+		}
+		tx := db.Begin(now)
+		if _, err := tx.Insert(&ItemTest{Name: "new", Created: now}); err != nil {
+			t.Fatalf("Error inserting record: %s", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Error committing: %s", err)
+		}
+
+		open, err := db.OpenBoltStats()
+		if err != nil {
+			t.Fatalf("Error getting the open shard stats: %s", err)
+		}
+		if len(open) != 1 || !open[0].Open || open[0].StartTime.After(now) || open[0].Bolt.TxN == 0 {
+			t.Fatalf("Open shard stats are %+v wanted the shard of today.", open)
+		}
+
+		stats, err := db.BoltStats(old.Add(-time.Hour), now)
+		if err != nil {
+			t.Fatalf("Error getting the shard stats: %s", err)
+		}
+		if len(stats) != 2 || !stats[0].Open || stats[1].Open {
+			t.Fatalf("Shard stats are %+v wanted today open and the old one closed.", stats)
+		}
+		for i, want := range []int{1, 10} {
+			s := stats[i]
+			keys := 0
+			for _, b := range s.Buckets {
+				keys += b.KeyN
+			}
+			if keys < want || s.PageSize == 0 || s.DataSize == 0 || s.DataSize > s.Size {
+				t.Fatalf("Shard stats %d are %+v wanted %d keys.", i, s, want)
+			}
+		}
+	})
+}
+
+func TestRecordsWritten(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		aborted := errors.New("aborted")
+		for _, commit := range []bool{false, true} {
+			commit := commit
+			err := db.Write(now, func(bkt *borm.Bucket) error {
+				return bkt.Write(func(u borm.Updater) error {
+					for i := 0; i < 2; i++ {
+						if err := u.Insert(db.NewID(now), &testData[i]); err != nil {
+							return err
+						}
+					}
+					if !commit {
+						return aborted
+					}
+					return nil
+				})
+			})
+			if commit && err != nil {
+				t.Fatalf("Error writing: %s", err)
+			} else if !commit && err != aborted {
+				t.Fatalf("Write got %v wanted %s.", err, aborted)
+			}
+		}
+		if written := db.Stats().RecordsWritten; written != 2 {
+			t.Fatalf("Records written are %d wanted the 2 committed.", written)
+		}
+	})
+}
@@ -6,6 +6,8 @@ package borm
 
 import (
 	"os"
+	"reflect"
+	"sync"
 
 	"github.com/boltdb/bolt"
 )
@@ -13,6 +15,18 @@ import (
 // Store is a bolthold wrapper around a bolt DB
 type Store struct {
 	db *bolt.DB
+
+	// types are the registered record types by bucket, guarded by typesMu.
+	typesMu sync.RWMutex
+	types   map[string]reflect.Type
+
+	// encode and decode are the codec of the buckets created without one,
+	// see WithCodec.
+	encode EncodeFunc
+	decode DecodeFunc
+
+	// release gives the file back to the open file budget once.
+	release sync.Once
 }
 
 // Options allows you set different options from the defaults
@@ -22,11 +36,16 @@ type Options struct {
 	Decoder DecodeFunc
 }
 
-// Open opens or creates a bolthold file.
+// Open opens or creates a bolthold file. It waits for a file of the budget
+// set by SetMaxOpenFiles, at most for the timeout of the options.
 func Open(filename string, mode os.FileMode, options *bolt.Options) (*Store, error) {
 	options = fillOptions(options)
+	if err := fileBudget.acquire(options.Timeout); err != nil {
+		return nil, err
+	}
 	db, err := bolt.Open(filename, mode, options)
 	if err != nil {
+		fileBudget.release()
 		return nil, err
 	}
 
@@ -48,9 +67,17 @@ func (s *Store) Bolt() *bolt.DB {
 	return s.db
 }
 
+// Sync executes fdatasync() against the bolt db file handle, it is only
+// needed when the db skips fsync on commit.
+func (s *Store) Sync() error {
+	return s.db.Sync()
+}
+
 // Close closes the bolt db
 func (s *Store) Close() error {
-	return s.db.Close()
+	err := s.db.Close()
+	s.release.Do(fileBudget.release)
+	return err
 }
 
 // CreateBucket create a bucket
@@ -70,82 +97,84 @@ func (s *Store) GetBucket(name string, encoder EncodeFunc, decoder DecodeFunc) (
 		return nil, err
 	}
 
-	if encoder == nil {
-		encoder = DefaultEncode
-	}
-	if decoder == nil {
-		decoder = DefaultDecode
-	}
-
-	return &Bucket{
-		store:  s,
-		Name:   name,
-		name:   []byte(name),
-		encode: encoder,
-		decode: decoder,
-	}, nil
+	return s.newBucket(name, encoder, decoder), nil
 }
 
 // CreateBucket create a bucket
 func (s *Store) CreateBucket(name string, encoder EncodeFunc, decoder DecodeFunc) (*Bucket, error) {
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		if !tx.Writable() {
-			return bolt.ErrTxNotWritable
-		}
-
-		_, err := tx.CreateBucket([]byte(name))
-		return err
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	if encoder == nil {
-		encoder = DefaultEncode
-	}
-	if decoder == nil {
-		decoder = DefaultDecode
-	}
-
-	return &Bucket{
-		store:  s,
-		Name:   name,
-		name:   []byte(name),
-		encode: encoder,
-		decode: decoder,
-	}, nil
+	return s.createBucket(name, encoder, decoder, false, nil)
 }
 
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist.
 // Returns an error if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (s *Store) CreateBucketIfNotExists(name string, encoder EncodeFunc, decoder DecodeFunc) (*Bucket, error) {
+	return s.createBucket(name, encoder, decoder, true, nil)
+}
+
+// createBucket creates the bucket and records its metadata with the views
+// derived from it.
+func (s *Store) createBucket(name string, encoder EncodeFunc, decoder DecodeFunc, ifNotExists bool, views []string) (*Bucket, error) {
+	b := s.newBucket(name, encoder, decoder)
 	err := s.db.Update(func(tx *bolt.Tx) error {
 		if !tx.Writable() {
 			return bolt.ErrTxNotWritable
 		}
 
-		_, err := tx.CreateBucketIfNotExists([]byte(name))
-		return err
+		var err error
+		if ifNotExists {
+			_, err = tx.CreateBucketIfNotExists(b.name)
+		} else {
+			_, err = tx.CreateBucket(b.name)
+		}
+		if err != nil {
+			return err
+		}
+		return s.describe(tx, b, views)
 	})
 	if err != nil {
 		return nil, err
 	}
+	return b, nil
+}
 
+func (s *Store) newBucket(name string, encoder EncodeFunc, decoder DecodeFunc) *Bucket {
+	if encoder == nil {
+		encoder = s.encode
+	}
 	if encoder == nil {
 		encoder = DefaultEncode
 	}
+	if decoder == nil {
+		decoder = s.decode
+	}
 	if decoder == nil {
 		decoder = DefaultDecode
 	}
 
+	s.typesMu.RLock()
+	typ := s.types[name]
+	s.typesMu.RUnlock()
 	return &Bucket{
 		store:  s,
 		Name:   name,
 		name:   []byte(name),
 		encode: encoder,
 		decode: decoder,
-	}, nil
+		typ:    typ,
+	}
+}
+
+// RegisterType declares the type of the records stored in the bucket, the
+// buckets returned afterwards validate it on every put and get.
+func (s *Store) RegisterType(name string, prototype interface{}) {
+	typ := recordType(prototype)
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
+	if s.types == nil {
+		s.types = map[string]reflect.Type{}
+	}
+	s.types[name] = typ
 }
 
 // DeleteBucket deletes a bucket.
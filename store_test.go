@@ -53,6 +53,30 @@ func testWrap(t *testing.T, tests func(store *borm.Store, t *testing.T)) {
 	tests(store, t)
 }
 
+// testTSWrap creates a temporary time series engine for testing and closes and
+// cleans it up when completed.
+func testTSWrap(t *testing.T, tests func(db *borm.TSEngine, t *testing.T)) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	tests(db, t)
+}
+
+// tempdir creates a temporary directory.
+func tempdir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "borm-ts-")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	return dir
+}
+
 // tempfile returns a temporary file path.
 func tempfile() string {
 	f, err := ioutil.TempFile("", "borm-")
@@ -0,0 +1,271 @@
+package subscribe
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// JetStream is a Source consuming a NATS JetStream stream with an ephemeral
+// push consumer, created at the sequence after the cursor with explicit
+// acknowledgements. It speaks the NATS protocol directly.
+type JetStream struct {
+	Addr   string
+	Stream string
+
+	// Subject filters the subjects of the stream, empty consumes all.
+	Subject string
+
+	// BatchSize limits the messages handled together, it defaults to 100.
+	BatchSize int
+
+	// TLS connects with TLS, started after the INFO of the server.
+	TLS *borm.TLSConfig
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// NewJetStream returns the source of the stream of the NATS server at addr.
+func NewJetStream(addr, stream, subject string) *JetStream {
+	return &JetStream{Addr: addr, Stream: stream, Subject: subject}
+}
+
+// consumer subscription ids
+const (
+	deliverSid = "1"
+	apiSid     = "2"
+)
+
+func (js *JetStream) Subscribe(after uint64, handle func(msgs []*Message) error) error {
+	conn, err := net.Dial("tcp", js.Addr)
+	if err != nil {
+		return err
+	}
+	js.mu.Lock()
+	if js.closed {
+		js.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	js.conn = conn
+	js.mu.Unlock()
+	defer conn.Close()
+
+	err = js.consume(conn, after, handle)
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.closed {
+		return nil
+	}
+	return err
+}
+
+func (js *JetStream) consume(conn net.Conn, after uint64, handle func(msgs []*Message) error) error {
+	r := bufio.NewReader(conn)
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return errors.New("nats: unexpected greeting " + line)
+	}
+	if js.TLS != nil {
+		if conn, err = js.TLS.Upgrade(conn, js.Addr); err != nil {
+			return err
+		}
+		r = bufio.NewReader(conn)
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	inbox := "_INBOX." + hex.EncodeToString(id[:])
+
+	config := map[string]interface{}{
+		"deliver_subject": inbox + ".deliver",
+		"ack_policy":      "explicit",
+		"deliver_policy":  "all",
+	}
+	if after > 0 {
+		config["deliver_policy"] = "by_start_sequence"
+		config["opt_start_seq"] = after + 1
+	}
+	if js.Subject != "" {
+		config["filter_subject"] = js.Subject
+	}
+	req, err := json.Marshal(map[string]interface{}{"stream_name": js.Stream, "config": config})
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(conn)
+	w.WriteString(`CONNECT {"verbose":false,"pedantic":false}` + "\r\n")
+	w.WriteString("SUB " + inbox + ".deliver " + deliverSid + "\r\n")
+	w.WriteString("SUB " + inbox + ".api " + apiSid + "\r\n")
+	writePub(w, "$JS.API.CONSUMER.CREATE."+js.Stream, inbox+".api", req)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	batchSize := js.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	var batch []*Message
+	for {
+		// handle the messages received before waiting for more
+		if len(batch) > 0 && (len(batch) >= batchSize || r.Buffered() == 0) {
+			if err := handle(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PING":
+			w.WriteString("PONG\r\n")
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			continue
+		case line == "PONG" || line == "+OK":
+			continue
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(line[4:]))
+		case !strings.HasPrefix(line, "MSG ") && !strings.HasPrefix(line, "HMSG "):
+			return errors.New("nats: unexpected " + line)
+		}
+
+		subject, sid, reply, data, err := readMsg(r, line)
+		if err != nil {
+			return err
+		}
+		if sid == apiSid {
+			var resp struct {
+				Error *struct {
+					Description string `json:"description"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return err
+			}
+			if resp.Error != nil {
+				return errors.New("nats: " + resp.Error.Description)
+			}
+			continue
+		}
+
+		msg := &Message{Subject: subject, Data: data}
+		if !parseAckReply(reply, msg) {
+			// flow control and heartbeats have no sequence
+			continue
+		}
+		msg.ack = func() error {
+			writePub(w, reply, "", nil)
+			return w.Flush()
+		}
+		batch = append(batch, msg)
+	}
+}
+
+// Close stops the subscription.
+func (js *JetStream) Close() error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.closed = true
+	if js.conn != nil {
+		return js.conn.Close()
+	}
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readMsg reads the payload of MSG subject sid [reply] size, or of
+// HMSG subject sid [reply] header-size size without its headers.
+func readMsg(r *bufio.Reader, line string) (subject, sid, reply string, data []byte, err error) {
+	args := strings.Fields(line)
+	headers := args[0] == "HMSG"
+	args = args[1:]
+	n := 3
+	if headers {
+		n = 4
+	}
+	if len(args) != n && len(args) != n+1 {
+		return "", "", "", nil, errors.New("nats: invalid " + line)
+	}
+	subject, sid = args[0], args[1]
+	if len(args) == n+1 {
+		reply = args[2]
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil {
+		return "", "", "", nil, errors.New("nats: invalid " + line)
+	}
+	hdr := 0
+	if headers {
+		if hdr, err = strconv.Atoi(args[len(args)-2]); err != nil || hdr > size {
+			return "", "", "", nil, errors.New("nats: invalid " + line)
+		}
+	}
+
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", "", "", nil, err
+	}
+	return subject, sid, reply, payload[hdr:size], nil
+}
+
+// parseAckReply fills the sequence and time of the message from its ack
+// subject, $JS.ACK.<stream>.<consumer>.<delivered>.<stream seq>.<consumer seq>.<time>.<pending>,
+// or the same prefixed by a domain and an account hash.
+func parseAckReply(reply string, msg *Message) bool {
+	tokens := strings.Split(reply, ".")
+	if len(tokens) < 9 || tokens[0] != "$JS" || tokens[1] != "ACK" {
+		return false
+	}
+	if len(tokens) >= 11 {
+		tokens = tokens[2:]
+	}
+	seq, err := strconv.ParseUint(tokens[5], 10, 64)
+	if err != nil {
+		return false
+	}
+	msg.Seq = seq
+	if ns, err := strconv.ParseInt(tokens[7], 10, 64); err == nil {
+		msg.Time = time.Unix(0, ns)
+	}
+	return true
+}
+
+func writePub(w *bufio.Writer, subject, reply string, data []byte) {
+	w.WriteString("PUB " + subject + " ")
+	if reply != "" {
+		w.WriteString(reply + " ")
+	}
+	w.WriteString(strconv.Itoa(len(data)) + "\r\n")
+	w.Write(data)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,141 @@
+// Package subscribe consumes message streams into a borm time series engine
+// with at-least-once semantics. The position of a Subscriber in its stream
+// is saved with the engine cursors after every written batch, so a
+// restarted subscriber resumes where it stopped instead of relying on the
+// broker to remember it.
+package subscribe
+
+import (
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// Message is a message of a stream.
+type Message struct {
+	Subject string
+	Data    []byte
+
+	// Seq is the position of the message in its stream, it increases from
+	// message to message.
+	Seq uint64
+
+	// Time is the time the message was published, zero if unknown.
+	Time time.Time
+
+	ack func() error
+}
+
+// Ack acknowledges the message to the broker.
+func (m *Message) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack()
+}
+
+// Source is a message stream, see JetStream.
+type Source interface {
+	// Subscribe delivers the messages after the sequence to handle, in
+	// order and in batches, until Close or an error. Messages which are
+	// not acknowledged are delivered again.
+	Subscribe(after uint64, handle func(msgs []*Message) error) error
+
+	Close() error
+}
+
+// Record is the record written for a message by default.
+type Record struct {
+	Subject string
+	Data    []byte
+}
+
+// Options configures a Subscriber.
+type Options struct {
+	// Name is the name of the cursor of the subscriber, it defaults to
+	// "subscribe". Subscribers of different streams need different names.
+	Name string
+
+	// Decode returns the time and the record value of a message, the
+	// default writes a Record at the message time, or at the current time
+	// if the message has none. A decode error stops the subscriber.
+	Decode func(msg *Message) (time.Time, interface{}, error)
+}
+
+// Subscriber writes the messages of a source into the engine.
+type Subscriber struct {
+	db     *borm.TSEngine
+	src    Source
+	opts   Options
+	cursor uint64
+}
+
+// New returns a subscriber of the source writing into the engine.
+func New(db *borm.TSEngine, src Source, opts *Options) *Subscriber {
+	s := &Subscriber{db: db, src: src}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Name == "" {
+		s.opts.Name = "subscribe"
+	}
+	if s.opts.Decode == nil {
+		s.opts.Decode = decodeRecord
+	}
+	return s
+}
+
+func decodeRecord(msg *Message) (time.Time, interface{}, error) {
+	t := msg.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t, &Record{Subject: msg.Subject, Data: msg.Data}, nil
+}
+
+// Run consumes the source from the saved cursor until the source is closed.
+// A batch is written before the cursor is saved and its messages are
+// acknowledged, a batch interrupted in between is delivered again and the
+// messages up to the saved cursor are skipped.
+func (s *Subscriber) Run() error {
+	cursor, err := s.db.Cursor(s.opts.Name)
+	if err != nil {
+		return err
+	}
+	s.cursor = cursor
+	return s.src.Subscribe(cursor, s.handle)
+}
+
+func (s *Subscriber) handle(msgs []*Message) error {
+	entries := make([]borm.Entry, 0, len(msgs))
+	last := s.cursor
+	for _, msg := range msgs {
+		if msg.Seq <= s.cursor {
+			continue
+		}
+		t, value, err := s.opts.Decode(msg)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, borm.Entry{Time: t, Value: value})
+		if msg.Seq > last {
+			last = msg.Seq
+		}
+	}
+
+	if len(entries) > 0 {
+		if _, err := s.db.Backfill(entries, nil); err != nil {
+			return err
+		}
+		if err := s.db.SetCursor(s.opts.Name, last); err != nil {
+			return err
+		}
+		s.cursor = last
+	}
+	for _, msg := range msgs {
+		if err := msg.Ack(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
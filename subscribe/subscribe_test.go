@@ -0,0 +1,197 @@
+package subscribe_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/subscribe"
+)
+
+// memSource delivers its messages after the sequence in batches of two.
+type memSource struct {
+	msgs  []string
+	acked []uint64
+	fail  uint64
+}
+
+func (s *memSource) Subscribe(after uint64, handle func(msgs []*subscribe.Message) error) error {
+	var batch []*subscribe.Message
+	for i := after; i < uint64(len(s.msgs)); i++ {
+		seq := i + 1
+		msg := &subscribe.Message{Subject: "events", Data: []byte(s.msgs[i]), Seq: seq, Time: time.Now()}
+		batch = append(batch, msg)
+		if len(batch) == 2 || seq == uint64(len(s.msgs)) {
+			if seq >= s.fail && s.fail != 0 {
+				s.fail = 0
+				return errors.New("connection lost")
+			}
+			if err := handle(batch); err != nil {
+				return err
+			}
+			for _, msg := range batch {
+				s.acked = append(s.acked, msg.Seq)
+			}
+			batch = nil
+		}
+	}
+	return nil
+}
+
+func (s *memSource) Close() error {
+	return nil
+}
+
+func openEngine(t *testing.T) (*borm.TSEngine, string) {
+	dir, err := ioutil.TempDir("", "subscribe")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	return db, dir
+}
+
+func count(t *testing.T, db *borm.TSEngine) int {
+	n := 0
+	err := db.Query(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), func(it *borm.Iterator) error {
+		for it.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	return n
+}
+
+func TestSubscriber(t *testing.T) {
+	db, dir := openEngine(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	src := &memSource{msgs: []string{"a", "b", "c", "d", "e"}, fail: 3}
+	if err := subscribe.New(db, src, nil).Run(); err == nil {
+		t.Fatalf("Expected the lost connection.")
+	}
+	if cursor, err := db.Cursor("subscribe"); err != nil || cursor != 2 {
+		t.Fatalf("Expected cursor 2, got %d %v.", cursor, err)
+	}
+
+	// the restarted subscriber resumes after the cursor
+	if err := subscribe.New(db, src, nil).Run(); err != nil {
+		t.Fatalf("Error running subscriber: %s", err)
+	}
+	if cursor, err := db.Cursor("subscribe"); err != nil || cursor != 5 {
+		t.Fatalf("Expected cursor 5, got %d %v.", cursor, err)
+	}
+	if n := count(t, db); n != 5 {
+		t.Fatalf("Expected 5 records, found %d.", n)
+	}
+	if len(src.acked) != 5 {
+		t.Fatalf("Expected 5 acks, got %v.", src.acked)
+	}
+}
+
+// fakeJetStream answers the consumer creation and delivers two messages.
+func fakeJetStream(t *testing.T, ln net.Listener, acks chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if args[0] == "PUB" {
+			size, _ := strconv.Atoi(args[len(args)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			if strings.HasPrefix(args[1], "$JS.API.CONSUMER.CREATE.") {
+				if !strings.Contains(string(payload), `"opt_start_seq":8`) {
+					t.Errorf("Unexpected consumer request %s.", payload)
+				}
+				resp := `{"name":"c1"}`
+				conn.Write([]byte("MSG " + args[2] + " 2 " + strconv.Itoa(len(resp)) + "\r\n" + resp + "\r\n"))
+				for seq := 8; seq <= 9; seq++ {
+					data := "event " + strconv.Itoa(seq)
+					reply := "$JS.ACK.events.c1.1." + strconv.Itoa(seq) + "." + strconv.Itoa(seq-7) + "." + strconv.FormatInt(time.Now().UnixNano(), 10) + ".0"
+					conn.Write([]byte("MSG events.login 1 " + reply + " " + strconv.Itoa(len(data)) + "\r\n" + data + "\r\n"))
+				}
+				conn.Write([]byte("PING\r\n"))
+			} else {
+				acks <- args[1]
+			}
+		}
+	}
+}
+
+func TestJetStream(t *testing.T) {
+	db, dir := openEngine(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	if err := db.SetCursor("events", 7); err != nil {
+		t.Fatalf("Error setting cursor: %s", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer ln.Close()
+	acks := make(chan string, 10)
+	go fakeJetStream(t, ln, acks)
+
+	src := subscribe.NewJetStream(ln.Addr().String(), "events", "events.>")
+	done := make(chan error, 1)
+	go func() {
+		done <- subscribe.New(db, src, &subscribe.Options{Name: "events"}).Run()
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case ack := <-acks:
+			if !strings.HasPrefix(ack, "$JS.ACK.events.c1.") {
+				t.Fatalf("Unexpected ack %s.", ack)
+			}
+		case err := <-done:
+			t.Fatalf("Subscriber stopped: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for the acks.")
+		}
+	}
+	src.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Error running subscriber: %s", err)
+	}
+
+	if cursor, err := db.Cursor("events"); err != nil || cursor != 9 {
+		t.Fatalf("Expected cursor 9, got %d %v.", cursor, err)
+	}
+	if n := count(t, db); n != 2 {
+		t.Fatalf("Expected 2 records, found %d.", n)
+	}
+}
@@ -0,0 +1,148 @@
+package syslog
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CEF is an event in the ArcSight Common Event Format,
+// CEF:Version|Device Vendor|Device Product|Device Version|Signature ID|Name|Severity|Extension.
+type CEF struct {
+	Version       int
+	DeviceVendor  string
+	DeviceProduct string
+	DeviceVersion string
+	SignatureID   string
+	Name          string
+	Severity      string
+	Extensions    map[string]string `json:",omitempty"`
+}
+
+// cefTimeLayouts are the formats of the rt extension besides milliseconds.
+var cefTimeLayouts = []string{
+	"Jan 02 2006 15:04:05.000 MST",
+	"Jan 02 2006 15:04:05 MST",
+	"Jan 02 2006 15:04:05.000",
+	"Jan 02 2006 15:04:05",
+}
+
+// Time returns the time of the event from its rt extension.
+func (e *CEF) Time() (time.Time, bool) {
+	rt, ok := e.Extensions["rt"]
+	if !ok {
+		return time.Time{}, false
+	}
+	if ms, err := strconv.ParseInt(rt, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+	for _, layout := range cefTimeLayouts {
+		if t, err := time.Parse(layout, rt); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseCEF parses a CEF event, pipes and backslashes are escaped in the
+// header fields, equal signs, backslashes and newlines in the extension
+// values.
+func ParseCEF(s string) (*CEF, error) {
+	if !strings.HasPrefix(s, "CEF:") {
+		return nil, errors.New("not a CEF event")
+	}
+	s = s[4:]
+
+	var header [7]string
+	for i := range header {
+		var sb strings.Builder
+		j := 0
+		for ; j < len(s) && s[j] != '|'; j++ {
+			if s[j] == '\\' && j+1 < len(s) && (s[j+1] == '|' || s[j+1] == '\\') {
+				j++
+			}
+			sb.WriteByte(s[j])
+		}
+		if j == len(s) {
+			return nil, errors.New("truncated CEF header")
+		}
+		header[i], s = sb.String(), s[j+1:]
+	}
+
+	version, err := strconv.Atoi(header[0])
+	if err != nil {
+		return nil, errors.New("invalid CEF version " + header[0])
+	}
+	e := &CEF{
+		Version:       version,
+		DeviceVendor:  header[1],
+		DeviceProduct: header[2],
+		DeviceVersion: header[3],
+		SignatureID:   header[4],
+		Name:          header[5],
+		Severity:      header[6],
+	}
+	e.Extensions = parseExtensions(s)
+	return e, nil
+}
+
+// parseExtensions parses the key=value pairs of the extension, a value runs
+// up to the space before the next key.
+func parseExtensions(s string) map[string]string {
+	var keys []string
+	var starts, ends []int
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] != '=' {
+			continue
+		}
+		k := i
+		for k > 0 && s[k-1] != ' ' {
+			k--
+		}
+		if k == i {
+			continue
+		}
+		if len(keys) > 0 {
+			ends = append(ends, k)
+		}
+		keys = append(keys, s[k:i])
+		starts = append(starts, i+1)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	ends = append(ends, len(s))
+
+	extensions := make(map[string]string, len(keys))
+	for i, key := range keys {
+		extensions[key] = unescapeExtension(strings.TrimRight(s[starts[i]:ends[i]], " "))
+	}
+	return extensions
+}
+
+func unescapeExtension(v string) string {
+	if strings.IndexByte(v, '\\') < 0 {
+		return v
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+			switch v[i] {
+			case 'n':
+				sb.WriteByte('\n')
+				continue
+			case 'r':
+				sb.WriteByte('\r')
+				continue
+			}
+		}
+		sb.WriteByte(v[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,285 @@
+package syslog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+// maxMessageSize limits a message, larger datagrams are truncated and larger
+// stream frames are dropped.
+const maxMessageSize = 64 * 1024
+
+// Options configures a Server.
+type Options struct {
+	// BatchSize is the number of messages written in one batch, it
+	// defaults to 1000.
+	BatchSize int
+
+	// FlushInterval is the longest time a message waits for its batch, it
+	// defaults to a second.
+	FlushInterval time.Duration
+
+	// OnError is called with the malformed messages and write errors, nil
+	// logs them.
+	OnError func(err error)
+
+	// DeadLetter preserves the malformed messages and the messages rejected
+	// by the validators of the engine as dead letters of the origin
+	// DeadLetterOrigin, instead of reporting them to OnError.
+	DeadLetter bool
+}
+
+// DeadLetterOrigin is the origin of the dead letters of a Server.
+const DeadLetterOrigin = "syslog"
+
+// Server receives syslog messages and writes them into the engine with
+// Backfill, batched by size and time.
+type Server struct {
+	db   *borm.TSEngine
+	opts Options
+
+	mu        sync.Mutex
+	batch     []borm.Entry
+	listeners map[io.Closer]struct{}
+	closed    bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewServer returns a server writing into the engine, Close flushes the last
+// batch.
+func NewServer(db *borm.TSEngine, opts *Options) *Server {
+	s := &Server{db: db, listeners: map[io.Closer]struct{}{}, done: make(chan struct{})}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.BatchSize <= 0 {
+		s.opts.BatchSize = 1000
+	}
+	if s.opts.FlushInterval <= 0 {
+		s.opts.FlushInterval = time.Second
+	}
+
+	s.wg.Add(1)
+	go s.flushLoop()
+	return s
+}
+
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Server) onError(err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(err)
+	} else {
+		log.Printf("syslog server failed to ingest messages: %s", err)
+	}
+}
+
+// Handle parses a message and adds it to the current batch.
+func (s *Server) Handle(data []byte) {
+	msg, err := Parse(data, time.Now())
+	if err != nil {
+		if s.opts.DeadLetter {
+			letter := borm.DeadLetter{Origin: DeadLetterOrigin, Reason: err.Error(), Raw: data}
+			if _, err = s.db.AddDeadLetter(letter); err == nil {
+				return
+			}
+		}
+		s.onError(err)
+		return
+	}
+
+	s.mu.Lock()
+	s.batch = append(s.batch, borm.Entry{Time: msg.Time, Value: msg})
+	full := len(s.batch) >= s.opts.BatchSize
+	s.mu.Unlock()
+	if full {
+		s.Flush()
+	}
+}
+
+// Flush writes the current batch.
+func (s *Server) Flush() error {
+	s.mu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var opts *borm.BackfillOptions
+	if s.opts.DeadLetter {
+		opts = &borm.BackfillOptions{DeadLetter: DeadLetterOrigin}
+	}
+	_, err := s.db.Backfill(batch, opts)
+	if err != nil {
+		s.onError(err)
+	}
+	return err
+}
+
+func (s *Server) track(l io.Closer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		l.Close()
+		return errors.New("syslog server is closed")
+	}
+	s.listeners[l] = struct{}{}
+	return nil
+}
+
+func (s *Server) untrack(l io.Closer) {
+	s.mu.Lock()
+	delete(s.listeners, l)
+	s.mu.Unlock()
+	l.Close()
+}
+
+// ListenAndServe listens on the network address and serves it until Close,
+// network is "udp" or "tcp", tcp is served with TLS if config is not nil.
+func (s *Server) ListenAndServe(network, addr string, config *borm.TLSConfig) error {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		return s.ServePacket(conn)
+	}
+
+	ln, err := config.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// ServePacket serves a datagram connection, a datagram is a message.
+func (s *Server) ServePacket(conn net.PacketConn) error {
+	if err := s.track(conn); err != nil {
+		return err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		s.Handle(buf[:n])
+	}
+}
+
+// Serve serves the stream connections of the listener. The messages of a
+// stream are framed by octet counting or separated by newlines, RFC 6587.
+func (s *Server) Serve(ln net.Listener) error {
+	if err := s.track(ln); err != nil {
+		return err
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if err := s.track(conn); err != nil {
+			return nil
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.untrack(conn)
+	r := bufio.NewReaderSize(conn, maxMessageSize)
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+
+		var msg []byte
+		if first[0] >= '1' && first[0] <= '9' {
+			// octet counting: MSG-LEN SP SYSLOG-MSG
+			size, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(size[:len(size)-1])
+			if err != nil || n > maxMessageSize {
+				s.onError(errors.New("invalid frame length " + size))
+				return
+			}
+			msg = make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+		} else {
+			line, err := r.ReadSlice('\n')
+			if err == bufio.ErrBufferFull {
+				s.onError(errors.New("message is too long"))
+				return
+			}
+			if len(line) == 0 && err != nil {
+				return
+			}
+			msg = line
+		}
+		if len(msg) > 0 && msg[0] != '\n' {
+			s.Handle(msg)
+		}
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close stops the listeners and the connections and writes the last batch.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	listeners := s.listeners
+	s.listeners = nil
+	s.mu.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+	close(s.done)
+	s.wg.Wait()
+	return s.Flush()
+}
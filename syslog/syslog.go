@@ -0,0 +1,184 @@
+// Package syslog ingests syslog messages, RFC 5424 and the BSD format of RFC
+// 3164, and the CEF events they carry into a borm time series engine. A
+// Server listens on UDP, TCP or TLS and writes the parsed messages in
+// batches, a record of the message time per message.
+package syslog
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a parsed syslog message.
+type Message struct {
+	Facility int
+	Severity int
+	Time     time.Time
+	Hostname string `json:",omitempty"`
+	AppName  string `json:",omitempty"`
+	ProcID   string `json:",omitempty"`
+	MsgID    string `json:",omitempty"`
+
+	// StructuredData is the raw structured data of a RFC 5424 message.
+	StructuredData string `json:",omitempty"`
+
+	Message string
+
+	// CEF is the event of a message in the Common Event Format.
+	CEF *CEF `json:",omitempty"`
+}
+
+var errNoPriority = errors.New("message has no priority")
+
+// Parse parses a message. Messages without a timestamp, or with a RFC 3164
+// one which has no year, are completed from now. A message without a
+// priority is kept as the text of a user notice.
+func Parse(data []byte, now time.Time) (*Message, error) {
+	line := strings.TrimRight(string(data), "\r\n\x00")
+	msg := &Message{Time: now, Facility: 1, Severity: 5}
+
+	rest, err := msg.parsePriority(line)
+	if err == errNoPriority {
+		msg.Message, err = line, nil
+	} else if err != nil {
+		return nil, err
+	} else if strings.HasPrefix(rest, "1 ") {
+		err = msg.parse5424(rest[2:])
+	} else {
+		msg.parse3164(rest, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(msg.Message, "CEF:") {
+		if msg.CEF, err = ParseCEF(msg.Message); err != nil {
+			return nil, err
+		}
+		if t, ok := msg.CEF.Time(); ok {
+			msg.Time = t
+		}
+	}
+	return msg, nil
+}
+
+func (msg *Message) parsePriority(line string) (string, error) {
+	if !strings.HasPrefix(line, "<") {
+		return line, errNoPriority
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return "", errors.New("invalid priority in " + line)
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return "", errors.New("invalid priority in " + line)
+	}
+	msg.Facility, msg.Severity = pri/8, pri%8
+	return line[end+1:], nil
+}
+
+// parse5424 parses TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG].
+func (msg *Message) parse5424(rest string) error {
+	var fields [5]string
+	for i := range fields {
+		sp := strings.IndexByte(rest, ' ')
+		if sp < 0 {
+			if i < len(fields)-1 {
+				return errors.New("truncated RFC 5424 header")
+			}
+			sp = len(rest)
+		}
+		fields[i], rest = rest[:sp], strings.TrimPrefix(rest[sp:], " ")
+	}
+	if fields[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return errors.New("invalid timestamp " + fields[0])
+		}
+		msg.Time = t
+	}
+	msg.Hostname = nilValue(fields[1])
+	msg.AppName = nilValue(fields[2])
+	msg.ProcID = nilValue(fields[3])
+	msg.MsgID = nilValue(fields[4])
+
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else if strings.HasPrefix(rest, "[") {
+		end := structuredDataEnd(rest)
+		if end < 0 {
+			return errors.New("unterminated structured data")
+		}
+		msg.StructuredData, rest = rest[:end], rest[end:]
+	}
+	// a UTF-8 message may start with a BOM
+	msg.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return nil
+}
+
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// structuredDataEnd returns the end of the structured data elements at the
+// start of s, or -1. Quotes, brackets and backslashes are escaped in the
+// parameter values.
+func structuredDataEnd(s string) int {
+	for i := 0; i < len(s) && s[i] == '['; {
+		quoted := false
+		j := i + 1
+		for ; j < len(s); j++ {
+			if s[j] == '\\' && quoted {
+				j++
+			} else if s[j] == '"' {
+				quoted = !quoted
+			} else if s[j] == ']' && !quoted {
+				break
+			}
+		}
+		if j >= len(s) {
+			return -1
+		}
+		i = j + 1
+		if i == len(s) || s[i] != '[' {
+			return i
+		}
+	}
+	return -1
+}
+
+// parse3164 parses Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG, the header is
+// optional.
+func (msg *Message) parse3164(rest string, now time.Time) {
+	if len(rest) >= 16 && rest[15] == ' ' {
+		t, err := time.ParseInLocation(time.Stamp, rest[:15], now.Location())
+		if err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			// the message of the last days of the previous year
+			if t.After(now.AddDate(0, 0, 1)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			msg.Time = t
+			rest = rest[16:]
+			if sp := strings.IndexByte(rest, ' '); sp > 0 {
+				msg.Hostname, rest = rest[:sp], rest[sp+1:]
+			}
+		}
+	}
+
+	if colon := strings.Index(rest, ": "); colon > 0 && !strings.ContainsAny(rest[:colon], " ") {
+		tag := rest[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			msg.ProcID = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		msg.AppName, rest = tag, rest[colon+2:]
+	}
+	msg.Message = rest
+}
@@ -0,0 +1,210 @@
+package syslog_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+	"github.com/runner-mei/borm/syslog"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	msg, err := syslog.Parse([]byte(`<165>1 2023-10-11T22:14:15.003Z fw1 sshd 8710 ID47 [exampleSDID@32473 iut="3" eventSource="App\]"] Failed password for root`+"\n"), now)
+	if err != nil {
+		t.Fatalf("Error parsing RFC 5424 message: %s", err)
+	}
+	if msg.Facility != 20 || msg.Severity != 5 || msg.Hostname != "fw1" || msg.AppName != "sshd" ||
+		msg.ProcID != "8710" || msg.MsgID != "ID47" || msg.Message != "Failed password for root" ||
+		!msg.Time.Equal(time.Date(2023, 10, 11, 22, 14, 15, 3000000, time.UTC)) {
+		t.Fatalf("Unexpected RFC 5424 message %+v.", msg)
+	}
+	if msg.StructuredData != `[exampleSDID@32473 iut="3" eventSource="App\]"]` {
+		t.Fatalf("Unexpected structured data %s.", msg.StructuredData)
+	}
+
+	msg, err = syslog.Parse([]byte("<34>Dec 31 23:59:00 mymachine su[42]: 'su root' failed"), now)
+	if err != nil {
+		t.Fatalf("Error parsing RFC 3164 message: %s", err)
+	}
+	if msg.Facility != 4 || msg.Severity != 2 || msg.Hostname != "mymachine" || msg.AppName != "su" ||
+		msg.ProcID != "42" || msg.Message != "'su root' failed" ||
+		!msg.Time.Equal(time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected RFC 3164 message %+v.", msg)
+	}
+
+	msg, err = syslog.Parse([]byte(`<134>1 - ids - - - - CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 msg=detected a \= sign rt=1700000000000`), now)
+	if err != nil {
+		t.Fatalf("Error parsing CEF message: %s", err)
+	}
+	cef := msg.CEF
+	if cef == nil || cef.DeviceVendor != "Security" || cef.Name != "worm successfully stopped" || cef.Severity != "10" {
+		t.Fatalf("Unexpected CEF event %+v.", cef)
+	}
+	if cef.Extensions["src"] != "10.0.0.1" || cef.Extensions["msg"] != "detected a = sign" {
+		t.Fatalf("Unexpected CEF extensions %v.", cef.Extensions)
+	}
+	if !msg.Time.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("Unexpected CEF time %s.", msg.Time)
+	}
+
+	msg, err = syslog.Parse([]byte("no priority"), now)
+	if err != nil || msg.Message != "no priority" || !msg.Time.Equal(now) {
+		t.Fatalf("Unexpected message without priority %+v %v.", msg, err)
+	}
+
+	for _, line := range []string{"<999>x", "<13>1 2023", "<13>1 yesterday h a p m -", "<13>CEF:0|a|b"} {
+		if _, err := syslog.Parse([]byte(line), now); err == nil {
+			t.Fatalf("Expected an error parsing %q.", line)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder: borm.JSONEncode,
+		Decoder: borm.JSONDecode,
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	srv := syslog.NewServer(db, &syslog.Options{BatchSize: 2, FlushInterval: time.Hour})
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on udp: %s", err)
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening on tcp: %s", err)
+	}
+	go srv.ServePacket(udp)
+	go srv.Serve(tcp)
+
+	now := time.Now().UTC().Truncate(time.Second).Format(time.RFC3339)
+	conn, err := net.Dial("udp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Error dialing udp: %s", err)
+	}
+	conn.Write([]byte("<13>1 " + now + " host app - - - over udp"))
+	conn.Close()
+
+	conn, err = net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing tcp: %s", err)
+	}
+	framed := "<13>1 " + now + " host app - - - octet counted"
+	conn.Write([]byte("<13>1 " + now + " host app - - - newline\n" + strconv.Itoa(len(framed)) + " " + framed))
+	conn.Close()
+
+	// wait for the messages before closing
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count := 0
+		db.Query(time.Now().Add(-time.Minute), time.Now().Add(time.Minute), func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if count >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Error closing server: %s", err)
+	}
+
+	texts := map[string]bool{}
+	err = db.Query(time.Now().Add(-time.Minute), time.Now().Add(time.Minute), func(it *borm.Iterator) error {
+		for it.Next() {
+			var msg syslog.Message
+			if err := json.Unmarshal(it.Value(), &msg); err != nil {
+				return err
+			}
+			texts[msg.Message] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying messages: %s", err)
+	}
+	for _, text := range []string{"over udp", "newline", "octet counted"} {
+		if !texts[text] {
+			t.Fatalf("Message %q was not written, found %v.", text, texts)
+		}
+	}
+}
+
+func TestServerDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Encoder:    borm.JSONEncode,
+		Decoder:    borm.JSONDecode,
+		Validators: []borm.Validator{borm.RequireFields("AppName")},
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	var reported []error
+	srv := syslog.NewServer(db, &syslog.Options{
+		FlushInterval: time.Hour,
+		DeadLetter:    true,
+		OnError:       func(err error) { reported = append(reported, err) },
+	})
+	now := time.Now().UTC().Truncate(time.Second).Format(time.RFC3339)
+	srv.Handle([]byte("<13>1 " + now + " host app - - - valid"))
+	srv.Handle([]byte("<13>1 " + now + " host - - - - no app"))
+	srv.Handle([]byte("<999>garbage"))
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Error closing server: %s", err)
+	}
+	if len(reported) != 0 {
+		t.Fatalf("Server reported %v wanted dead letters.", reported)
+	}
+
+	count, err := db.Count(time.Now().Add(-time.Minute), time.Now().Add(time.Minute), nil)
+	if err != nil || count != 1 {
+		t.Fatalf("Count got %d, %v wanted the valid message only.", count, err)
+	}
+	var letters []borm.DeadLetter
+	err = db.DeadLetters(syslog.DeadLetterOrigin, func(letter borm.DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading dead letters: %s", err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("Dead letters are %+v wanted 2.", letters)
+	}
+	if string(letters[0].Raw) != "<999>garbage" || letters[0].Rule != "" {
+		t.Fatalf("Dead letter of the malformed message is %+v.", letters[0])
+	}
+	var msg syslog.Message
+	if err := json.Unmarshal(letters[1].Raw, &msg); err != nil || msg.Message != "no app" || letters[1].Rule != borm.RuleRequired {
+		t.Fatalf("Dead letter of the rejected message is %+v, %v.", letters[1], err)
+	}
+}
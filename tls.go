@@ -0,0 +1,186 @@
+package borm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+// TLSConfig configures TLS for the network components, the syslog server,
+// the HTTP handlers, webhooks, subscriptions and replication, as server or
+// as client of the other side.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM certificate and key presented to the
+	// other side, required for a server and the client certificate of mTLS
+	// for a client.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is the PEM bundle verifying the other side. A server verifies
+	// the client certificates with it, a client verifies the server
+	// certificate with it instead of the system roots.
+	CAFile string
+
+	// RequireClientCert makes a server reject the clients without a
+	// certificate verified by CAFile.
+	RequireClientCert bool
+
+	// ServerName is the name a client verifies the certificate of the
+	// server against, it defaults to the host dialed.
+	ServerName string
+
+	// InsecureSkipVerify makes a client accept any certificate of the
+	// server, for tests only.
+	InsecureSkipVerify bool
+}
+
+func (c *TLSConfig) certificates() ([]tls.Certificate, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return []tls.Certificate{cert}, nil
+}
+
+func (c *TLSConfig) pool() (*x509.CertPool, error) {
+	if c.CAFile == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate in " + c.CAFile)
+	}
+	return pool, nil
+}
+
+// Server returns the tls.Config of a server.
+func (c *TLSConfig) Server() (*tls.Config, error) {
+	certs, err := c.certificates()
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("tls server needs a certificate")
+	}
+	pool, err := c.pool()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{Certificates: certs, MinVersion: tls.VersionTLS12}
+	switch {
+	case c.RequireClientCert:
+		if pool == nil {
+			return nil, errors.New("tls client certificates need a CA file")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case pool != nil:
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// Client returns the tls.Config of a client.
+func (c *TLSConfig) Client() (*tls.Config, error) {
+	certs, err := c.certificates()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := c.pool()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:       certs,
+		RootCAs:            pool,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}, nil
+}
+
+// Listen listens on the network address, with TLS if c is not nil. Serve
+// HTTP handlers with http.Serve on it.
+func (c *TLSConfig) Listen(network, addr string) (net.Listener, error) {
+	var config *tls.Config
+	if c != nil {
+		var err error
+		if config, err = c.Server(); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+	return ln, nil
+}
+
+// Dial connects to the network address, with TLS if c is not nil.
+func (c *TLSConfig) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil || c == nil {
+		return conn, err
+	}
+	return c.upgrade(conn, addr)
+}
+
+// Upgrade starts TLS as client on an established connection, for the
+// protocols negotiating it first, with TLS if c is not nil.
+func (c *TLSConfig) Upgrade(conn net.Conn, addr string) (net.Conn, error) {
+	if c == nil {
+		return conn, nil
+	}
+	return c.upgrade(conn, addr)
+}
+
+func (c *TLSConfig) upgrade(conn net.Conn, addr string) (net.Conn, error) {
+	config, err := c.Client()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	tconn := tls.Client(conn, config)
+	if err := tconn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tconn, nil
+}
+
+// HTTPClient returns a client connecting with TLS, http.DefaultClient if c
+// is nil.
+func (c *TLSConfig) HTTPClient() (*http.Client, error) {
+	if c == nil {
+		return http.DefaultClient, nil
+	}
+	config, err := c.Client()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,46 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrInvalidToken is returned when a resume token can't be decoded
+var ErrInvalidToken = errors.New("invalid resume token")
+
+// resumePoint is the decoded form of a resume token.
+type resumePoint struct {
+	shard []byte
+	key   []byte
+}
+
+func encodeToken(shard, key []byte) string {
+	data := make([]byte, 0, len(shard)+1+len(key))
+	data = append(data, shard...)
+	data = append(data, 0)
+	data = append(data, key...)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeToken(token string) (*resumePoint, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	idx := bytes.IndexByte(data, 0)
+	if idx <= 0 || idx == len(data)-1 {
+		return nil, ErrInvalidToken
+	}
+	return &resumePoint{shard: data[:idx], key: data[idx+1:]}, nil
+}
+
+// Token returns an opaque token resuming a query right after the current
+// record, see QueryOptions.ResumeToken. It is empty for an iterator not
+// created by a TSEngine query.
+func (it *Iterator) Token() string {
+	if it.query == nil || it.key == nil {
+		return ""
+	}
+	return encodeToken(it.query.shardKey, it.key)
+}
@@ -2,128 +2,883 @@ package borm
 
 import (
 	"errors"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boltdb/bolt"
 )
 
+// SyncPolicy controls when commits to a shard are flushed to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs every commit, whatever shard it goes to.
+	SyncAlways SyncPolicy = iota
+
+	// SyncHotOnly fsyncs commits to the hot shard (the shard of the
+	// current time) only. Writes into historical shards, as done by
+	// backfill or reprocessing jobs, skip the fsync and the shard is
+	// synced once when its handle is closed.
+	SyncHotOnly
+)
+
+// HotShardPolicy is what retention does with the hot shard, the shard of
+// the current time which the writes go to.
+type HotShardPolicy int
+
+const (
+	// KeepHotShard never deletes the hot shard, whatever the retention
+	// policy selects.
+	KeepHotShard HotShardPolicy = iota
+	// DeleteHotShard deletes it like the other shards, the Writes to it
+	// then fail with ErrShardDeleted instead of recreating it empty.
+	DeleteHotShard
+)
+
+// ErrShardDeleted is returned by the Writes to the hot shard once retention
+// deleted it, see DeleteHotShard.
+var ErrShardDeleted = errors.New("shard was deleted by retention")
+
+// TSOptions allows you set different options from the defaults for a TSEngine
+type TSOptions struct {
+	// NameWith returns the shard file name of t, relative to the engine path.
+	// It defaults to ShardInterval.Name.
+	NameWith func(t time.Time) string
+
+	// NamePattern, when set, names the shards instead of NameWith, e.g.
+	// "%Y/%m/%d.ts". The directories are created on write, see
+	// ShardPattern. It must name the hour with Hourly shards, and only then.
+	NamePattern string
+
+	// ShardInterval is the time span of a shard, Daily by default.
+	ShardInterval Granularity
+
+	// ShardExtension is the extension of the shard files, the other files
+	// of the engine directory are ignored. It defaults to
+	// DefaultShardExtension with the default names, and to any extension
+	// with NameWith or NamePattern.
+	ShardExtension string
+
+	// IgnoreShards are the patterns of the file names in the engine
+	// directory which aren't shards, see ListOptions.Ignore.
+	IgnoreShards []string
+
+	// SyncPolicy defaults to SyncAlways.
+	SyncPolicy SyncPolicy
+
+	// BoltOptions are the options the shard files are opened with, the
+	// Timeout defaults to 10 seconds. FileMode is the mode of the shard and
+	// meta files created, 0666 by default.
+	BoltOptions *bolt.Options
+	FileMode    os.FileMode
+
+	// Logger gets the messages of the engine, e.g. the crash repairs and the
+	// failed shadow writes, the standard logger by default.
+	Logger *log.Logger
+
+	// NoRecovery skips the removal of the leftovers of a crash when the
+	// engine is opened, see TSEngine.Repairs. Set it when several processes
+	// open the directory, the temporary files of the others are removed
+	// otherwise.
+	NoRecovery bool
+
+	// MaxOpenWriters is the number of recently written shards kept open, so
+	// interleaved writes for today and yesterday don't reopen shards on every
+	// call. It defaults to DefaultMaxOpenWriters.
+	MaxOpenWriters int
+
+	// MaxFutureSkew and MaxPastSkew bound how far the timestamp of a Write,
+	// a WriteTx, a Backfill or an AsyncWriter entry may be from the wall
+	// clock, 0 disables the bound.
+	MaxFutureSkew time.Duration
+	MaxPastSkew   time.Duration
+
+	// SkewAction selects what happens to a skewed write, SkewReject by default.
+	SkewAction SkewAction
+
+	// OnSkew is called for every skewed write, e.g. for logging.
+	OnSkew func(t, now time.Time)
+
+	// Encoder and Decoder are the codec of the records, Gob by default.
+	Encoder EncodeFunc
+	Decoder DecodeFunc
+
+	// AppendEncoder, when set, replaces Encoder to encode the records into
+	// reused buffers, see Bucket.SetAppendEncoder.
+	AppendEncoder AppendEncodeFunc
+
+	// Sketches are the named extractors of CountDistinctSketch, their
+	// HyperLogLog sketches are persisted per sealed shard.
+	Sketches map[string]func(raw []byte) []byte
+
+	// StatDimensions are the group by of the top-N statistics computed when a
+	// shard is sealed, see ShardStats.
+	StatDimensions map[string]func(key, value []byte) (string, bool)
+
+	// StatsTopN is the size of the top-N statistics, it defaults to
+	// DefaultStatsTopN.
+	StatsTopN int
+
+	// DictCompression compresses the records of a shard when it is sealed,
+	// with a deflate dictionary trained on a sample of them, which works far
+	// better than compressing every short record alone. Iterator.Value and
+	// the decoders return the decompressed records, values of the codec must
+	// not start with a 0x00 byte.
+	DictCompression bool
+
+	// Checksums stores a CRC-32C with every record, verified on read: the
+	// records corrupted by the storage fail with a ChecksumError instead of
+	// decoding into garbage. See Bucket.SetChecksums.
+	Checksums bool
+
+	// DedupWindow is how long WriteIdempotent remembers a request ID, it
+	// defaults to DefaultDedupWindow.
+	DedupWindow time.Duration
+
+	// Columns are the fields extracted into a columnar sidecar file of every
+	// sealed shard, so ScanColumns and AggregateColumns only read the
+	// columns they need.
+	Columns []Column
+
+	// Views are the materialized views maintained on write.
+	Views []View
+
+	// IDPrecision is the precision of the ObjectIds created by NewID and
+	// Backfill, SecondPrecision by default. Stores mixing precisions still
+	// work, a query returns the records of a shard grouped by precision.
+	IDPrecision IDPrecision
+
+	// Namespace returns the namespace of a record for the quotas, e.g. the
+	// tenant or the sensor, nil puts all records in the namespace "".
+	Namespace func(key, value []byte) string
+
+	// Quotas are the daily quotas of the namespaces, DefaultQuota applies to
+	// the namespaces without one. GlobalQuota applies to all records. A
+	// write past a quota fails with a QuotaError, see EngineStats for the
+	// usage.
+	Quotas       map[string]Quota
+	DefaultQuota Quota
+	GlobalQuota  Quota
+
+	// NodeID, when set, makes NewID and Backfill create composite keys
+	// ending with the node ID and a counter, see CreateNodeID, so the
+	// records of engines of different nodes, e.g. edge sensors, never
+	// collide when merged centrally. The IDPrecision is then seconds.
+	NodeID string
+
+	// RecordType is a prototype of the records stored, when set the buckets
+	// of the shards validate it, see Bucket.SetType.
+	RecordType interface{}
+
+	// Shadow is a second engine every Write is replayed on, e.g. with a
+	// different granularity or codec, to validate a migration with real
+	// traffic. The shadow is not closed with the engine.
+	Shadow *TSEngine
+
+	// OnShadowError is called with the errors of the shadow writes, which
+	// never fail the write to the engine.
+	OnShadowError func(t time.Time, err error)
+
+	// ChangeLog records every written and deleted record in a change log of
+	// the meta store, which followers replicate, see Changes. A change is
+	// kept in its shard by the write until it is in the change log, the
+	// changes a crash left out are logged when the engine is opened again.
+	ChangeLog bool
+
+	// HotShard is what retention does with the hot shard, KeepHotShard by
+	// default.
+	HotShard HotShardPolicy
+
+	// Actor is who the audit log records for the destructive operations,
+	// e.g. the operator or the service, it defaults to the OS user.
+	Actor string
+
+	// SlowOps are the thresholds of the slow operations, reported to
+	// OnSlowOp, or logged to the Logger without one.
+	SlowOps  SlowOpThresholds
+	OnSlowOp func(op SlowOp)
+
+	// Clock is the time of the engine, SystemClock by default.
+	Clock Clock
+
+	// BackgroundYield is the longest a background read waits for the
+	// foreground reads, DefaultBackgroundYield by default, negative never
+	// waits. See BackgroundRead.
+	BackgroundYield time.Duration
+
+	// Buckets are the buckets every shard hosts besides the records,
+	// created when the shard is opened, see WriteBucket, ReadBucket and
+	// QueryOptions.Bucket.
+	Buckets []ShardBucket
+
+	// Validators check every record put, in order, a rejected write fails
+	// with a ValidationError, see EngineStats.Rejected.
+	Validators []Validator
+
+	// Enrichers complete every record written, in order, before the
+	// validators check it, e.g. with the location or the ASN of its
+	// address. The records replicated or merged are stored as they are,
+	// enriched already. See EngineStats.EnrichFailures.
+	Enrichers []Enricher
+
+	// Versioned keeps the versions of the records, every update saves the
+	// value it replaces with the time it was superseded, see History and
+	// GetAt. A delete deletes the versions of the record.
+	Versioned bool
+
+	// lanes are the read lanes of an EngineManager, new ones by default.
+	lanes *readLanes
+}
+
+// dataBucket is the shard bucket of the records.
+const dataBucket = "attack"
+
+// DefaultMaxOpenWriters is the default of TSOptions.MaxOpenWriters.
+const DefaultMaxOpenWriters = 2
+
+// shardHandle is a shard kept open for writing. The readers and writers
+// using it hold a reference, a handle evicted or closed meanwhile is retired:
+// it is closed by the last of them, so a store is never closed under a
+// running callback.
+type shardHandle struct {
+	file  string
+	day   time.Time
+	store *Store
+	bkt   *Bucket
+
+	// refs and retired are guarded by TSEngine.handlesMu.
+	refs    int
+	retired bool
+	seal    bool
+
+	// done is closed once the retired handle is closed, with err.
+	done chan struct{}
+	err  error
+}
+
 type TSEngine struct {
-	basePath    string
-	nameWith    func(t time.Time) string
-	currentFile string
-	store       *Store
-	bkt         *Bucket
+	basePath string
+	nameWith func(t time.Time) string
+	options  TSOptions
+	pattern  *ShardPattern
+
+	// repairs are the leftovers of a crash repaired at open.
+	repairs []Repair
+
+	// handles are the shards open for writing, most recently used first.
+	// retired are the handles removed from them not closed yet.
+	handlesMu sync.Mutex
+	handles   []*shardHandle
+	retired   []*shardHandle
+
+	// lanes schedule the foreground and background reads, shared by the
+	// engines of an EngineManager.
+	lanes *readLanes
+
+	// meta is the engine metadata store, opened on first use and not
+	// reopened once metaClosed.
+	metaMu     sync.Mutex
+	meta       *Store
+	metaClosed bool
+
+	// rulesMu guards the rules, the webhooks and the lifecycle callbacks.
+	rulesMu   sync.Mutex
+	rules     []*activeRule
+	webhooks  []*activeWebhook
+	lifecycle lifecycle
+
+	quotas   quotas
+	rejected rejections
+
+	// enrichFailures count the failures of the enrichers by name.
+	enrichFailures rejections
+
+	// deleted are the hot shards deleted by retention, guarded by
+	// handlesMu.
+	deleted map[string]bool
+
+	// rewrites are the running rewrite jobs by name.
+	rewritesMu sync.Mutex
+	rewrites   map[string]*Rewrite
+
+	// changed is closed and replaced on every change logged.
+	changesMu sync.Mutex
+	changed   chan struct{}
+
+	// recordsWritten and recordsRead count the records since the engine
+	// was opened, see EngineStats.
+	recordsWritten atomic.Int64
+	recordsRead    atomic.Int64
 }
 
 func (db *TSEngine) Close() error {
-	var err error
-	if db.store != nil {
-		err = db.store.Close()
+	db.stopRewrites()
+	db.stopWebhooks()
+	err := db.saveRules()
 
-		db.store = nil
-		db.bkt = nil
+	// the handles in use are closed once their callbacks return
+	db.handlesMu.Lock()
+	handles := db.handles
+	db.handles = nil
+	var closeNow []*shardHandle
+	for _, h := range handles {
+		if db.retire(h, false) {
+			closeNow = append(closeNow, h)
+		}
+	}
+	db.handlesMu.Unlock()
+	for _, h := range closeNow {
+		db.closeRetired(h)
+	}
+	for _, h := range handles {
+		<-h.done
+		if h.err != nil && err == nil {
+			err = h.err
+		}
+	}
+
+	if e := db.closeMeta(); e != nil && err == nil {
+		err = e
 	}
 	return err
 }
 
 func (db *TSEngine) EnforceRetention(t time.Time) error {
-	shards, err := ListShards(db.basePath, t.Location())
-	if err != nil {
+	_, err := db.ApplyRetention(RetentionPolicy{Before: t}, false)
+	return err
+}
+
+// removeShard deletes the shard file, closing its write handle first. It
+// waits for the callbacks using the handle, so it must not be called from
+// one of them.
+func (db *TSEngine) removeShard(shard *Shard) error {
+	file := ""
+	db.handlesMu.Lock()
+	for _, h := range db.handles {
+		if samePath(shard.path, h.file) {
+			file = h.file
+			break
+		}
+	}
+	db.handlesMu.Unlock()
+	if file != "" {
+		if err := db.closeHandle(file); err != nil {
+			return err
+		}
+	}
+	if hot := db.nameWith(db.now()); samePath(shard.path, hot) {
+		db.handlesMu.Lock()
+		if db.deleted == nil {
+			db.deleted = map[string]bool{}
+		}
+		db.deleted[hot] = true
+		db.handlesMu.Unlock()
+	}
+	if err := removeFile(shard.path); err != nil {
+		return err
+	}
+	if err := removeFile(shard.path + columnsSuffix); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return db.removeShardsBefore(shards, t)
+	// the lock file bolt uses on Windows, left over by a crash
+	if err := removeFile(shard.path + lockSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := db.deleteShardMeta(shard.path); err != nil {
+		return err
+	}
+	db.notifyShard(shardDeleted, shard.path)
+	return nil
 }
 
-func (db *TSEngine) removeShardsBefore(shards Shards, t time.Time) error {
-	for _, shard := range shards {
-		if shard.startTime.Before(t) {
-			if strings.ToLower(filepath.Base(shard.path)) ==
-				strings.ToLower(db.currentFile) {
-				if err := db.Close(); err != nil {
-					return err
-				}
-			}
-			if err := os.Remove(shard.path); err != nil {
-				return err
-			}
+// Sync flushes the open shards to disk, it is the checkpoint of a backfill
+// running with SyncHotOnly.
+func (db *TSEngine) Sync() error {
+	db.handlesMu.Lock()
+	handles := append([]*shardHandle(nil), db.handles...)
+	for _, h := range handles {
+		h.refs++
+	}
+	db.handlesMu.Unlock()
+
+	var err error
+	for _, h := range handles {
+		if err == nil {
+			err = h.store.Sync()
+		}
+		db.unref(h)
+	}
+	return err
+}
+
+// isHistorical reports whether the shard of t is older than the hot shard.
+func (db *TSEngine) isHistorical(t time.Time) bool {
+	now := db.now()
+	return t.Before(now) && db.nameWith(t) != db.nameWith(now)
+}
+
+// closeStore closes the store, syncing it first if its commits skipped fsync.
+func closeStore(store *Store) error {
+	if store.db.NoSync {
+		if err := store.Sync(); err != nil {
+			store.Close()
+			return err
+		}
+	}
+	return store.Close()
+}
+
+func (db *TSEngine) open(file string) (store *Store, bkt *Bucket, err error) {
+	if threshold := db.options.SlowOps.Open; threshold > 0 {
+		defer func(start time.Time) {
+			db.slowOp(SlowOpen, file, threshold, start, 0, err)
+		}(time.Now())
+	}
+	_, statErr := os.Stat(file)
+	if os.IsNotExist(statErr) {
+		if err := db.createShard(file); err != nil {
+			return nil, nil, err
+		}
+	}
+	store, bkt, err = db.openStore(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if os.IsNotExist(statErr) {
+		db.notifyShard(shardCreated, file)
+	}
+	return store, bkt, nil
+}
+
+// createShard creates the shard file with its buckets under a temporary
+// name linked into place once initialized, so a crash never leaves a shard
+// half created. A shard created meanwhile by another opener is kept.
+func (db *TSEngine) createShard(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".create-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, db.fileMode())
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(tmp)
+
+	store, _, err := db.openStore(tmp)
+	if err != nil {
+		return err
+	}
+	if err := store.Close(); err != nil {
+		return err
+	}
+	err = os.Link(tmp, file)
+	if err != nil && !os.IsExist(err) {
+		// without hard links, e.g. on FAT, the shard is renamed instead
+		if _, statErr := os.Stat(file); os.IsNotExist(statErr) {
+			return os.Rename(tmp, file)
 		}
 	}
 	return nil
 }
 
-func (db *TSEngine) open(file string) (*Store, *Bucket, error) {
-	store, err := Open(file, 0666, &bolt.Options{Timeout: 10 * time.Second})
+// logger returns the logger of the engine messages.
+func (db *TSEngine) logger() *log.Logger {
+	if db.options.Logger != nil {
+		return db.options.Logger
+	}
+	return log.Default()
+}
+
+// boltOptions returns the options the engine opens its files with.
+func (db *TSEngine) boltOptions() *bolt.Options {
+	options := &bolt.Options{}
+	if db.options.BoltOptions != nil {
+		*options = *db.options.BoltOptions
+	}
+	if options.Timeout == 0 {
+		options.Timeout = 10 * time.Second
+	}
+	return options
+}
+
+// fileMode returns the mode of the files created by the engine.
+func (db *TSEngine) fileMode() os.FileMode {
+	if db.options.FileMode != 0 {
+		return db.options.FileMode
+	}
+	return 0666
+}
+
+// openStore opens the shard file and its bucket with the hooks of the
+// engine.
+func (db *TSEngine) openStore(file string) (*Store, *Bucket, error) {
+	store, err := Open(file, db.fileMode(), db.boltOptions())
 	if err != nil {
 		return nil, nil, err
 	}
-	bkt, err := store.CreateBucketIfNotExists("attack", nil, nil)
+	if db.options.RecordType != nil {
+		store.RegisterType(dataBucket, db.options.RecordType)
+	}
+	var views []string
+	for i := range db.options.Views {
+		views = append(views, string(db.options.Views[i].bucketName()))
+	}
+	bkt, err := store.createBucket(dataBucket, db.options.Encoder, db.options.Decoder, true, views)
 	if err != nil {
 		store.Close()
 		return nil, nil, err
 	}
+	bkt.SetAppendEncoder(db.options.AppendEncoder)
+	bkt.SetChecksums(db.options.Checksums)
+	if bkt.dict, err = loadDict(bkt); err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	if err := db.createBuckets(store); err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	if len(db.options.Enrichers) > 0 {
+		bkt.enrich = db.enrich
+	}
+	if len(db.options.Validators) > 0 {
+		bkt.AddWriteHook(db.validate)
+	}
+	if db.options.hasQuotas() {
+		bkt.AddWriteHook(db.checkQuota)
+	}
+	if db.options.Versioned {
+		bkt.AddWriteHook(db.keepVersions(bkt))
+	}
+	db.addViewHooks(bkt)
+	bkt.AddWriteHook(db.countWrite(bkt))
+	bkt.AddWriteHook(db.evalRules(bkt))
+	bkt.AddWriteHook(db.forwardRecords(bkt))
+	if db.options.ChangeLog {
+		bkt.logged = &loggedChanges{}
+		bkt.AddWriteHook(db.changeLog(bkt))
+	}
 	return store, bkt, nil
 }
 
-func (db *TSEngine) ensureOpen(t time.Time) error {
-	newFile := db.nameWith(t)
-	if db.currentFile != newFile {
-		db.Close()
-		db.currentFile = newFile
+// isOpen returns whether the shard file has an open handle.
+func (db *TSEngine) isOpen(file string) bool {
+	db.handlesMu.Lock()
+	defer db.handlesMu.Unlock()
+	for _, h := range db.handles {
+		if h.file == file {
+			return true
+		}
 	}
+	return false
+}
 
-	if db.store == nil {
-		var err error
-		db.store, db.bkt, err = db.open(db.currentFile)
-		if err != nil {
-			return err
+// acquireHandle returns the open handle of the shard file with a
+// reference, released with unref, or nil. A retired handle still in use is
+// returned too, so a record written through it just before a rollover is
+// read through it as well.
+func (db *TSEngine) acquireHandle(file string) *shardHandle {
+	db.handlesMu.Lock()
+	defer db.handlesMu.Unlock()
+	for _, h := range db.handles {
+		if h.file == file {
+			h.refs++
+			return h
+		}
+	}
+	for _, h := range db.retired {
+		if h.file == file && h.refs > 0 {
+			h.refs++
+			return h
+		}
+	}
+	return nil
+}
+
+// waitClosed waits for the retired handles of the shard file being closed,
+// sealed first maybe, so the shard can be opened again without waiting for
+// the file lock. The ones still in use are not waited for.
+func (db *TSEngine) waitClosed(file string) {
+	for {
+		var closing *shardHandle
+		db.handlesMu.Lock()
+		for _, h := range db.retired {
+			if h.file == file && h.refs == 0 {
+				closing = h
+				break
+			}
+		}
+		db.handlesMu.Unlock()
+		if closing == nil {
+			return
+		}
+		<-closing.done
+	}
+}
+
+// unref releases a reference of the handle, closing it if it is retired
+// and this was the last one.
+func (db *TSEngine) unref(h *shardHandle) {
+	db.handlesMu.Lock()
+	h.refs--
+	last := h.retired && h.refs == 0
+	db.handlesMu.Unlock()
+	if last {
+		db.closeRetired(h)
+	}
+}
+
+// retire marks the handle, removed from the handles, to be closed, sealed
+// first if seal, and returns whether it is unused and must be closed now.
+// db.handlesMu is held.
+func (db *TSEngine) retire(h *shardHandle, seal bool) bool {
+	h.retired = true
+	h.seal = seal
+	db.retired = append(db.retired, h)
+	return h.refs == 0
+}
+
+func (db *TSEngine) closeRetired(h *shardHandle) {
+	if h.seal {
+		h.err = db.release(h)
+	} else {
+		h.err = closeStore(h.store)
+	}
+
+	db.handlesMu.Lock()
+	for i, retired := range db.retired {
+		if retired == h {
+			db.retired = append(db.retired[:i], db.retired[i+1:]...)
+			break
+		}
+	}
+	db.handlesMu.Unlock()
+	close(h.done)
+}
+
+// closeHandle closes the open handle of the shard file, if any, once the
+// callbacks using it return.
+func (db *TSEngine) closeHandle(file string) error {
+	db.handlesMu.Lock()
+	var h *shardHandle
+	for i, handle := range db.handles {
+		if handle.file == file {
+			h = handle
+			db.handles = append(db.handles[:i], db.handles[i+1:]...)
+			break
+		}
+	}
+	if h == nil {
+		db.handlesMu.Unlock()
+		return nil
+	}
+	closeNow := db.retire(h, false)
+	db.handlesMu.Unlock()
+
+	if closeNow {
+		db.closeRetired(h)
+	}
+	<-h.done
+	return h.err
+}
+
+// ensureOpenRouted returns the handle of the shard file a write at t is
+// routed to with a reference, unless retention deleted it.
+func (db *TSEngine) ensureOpenRouted(newFile string, t time.Time) (*shardHandle, error) {
+	db.handlesMu.Lock()
+	deleted := db.deleted[newFile]
+	db.handlesMu.Unlock()
+	if deleted {
+		return nil, ErrShardDeleted
+	}
+	return db.ensureOpenFile(newFile, t, db.options.SyncPolicy == SyncHotOnly && db.isHistorical(t))
+}
+
+// ensureOpenFile returns the handle of the shard file with a reference,
+// opening it if needed.
+func (db *TSEngine) ensureOpenFile(newFile string, t time.Time, noSync bool) (*shardHandle, error) {
+	if h := db.frontHandle(newFile); h != nil {
+		return h, nil
+	}
+
+	db.waitClosed(newFile)
+	store, bkt, err := db.open(newFile)
+	if err != nil {
+		return nil, err
+	}
+	store.db.NoSync = noSync
+	if db.isHistorical(t) {
+		// statistics of a sealed shard don't cover the records to come
+		if err := unseal(bkt); err != nil {
+			closeStore(store)
+			return nil, err
+		}
+	}
+
+	db.handlesMu.Lock()
+	for _, existing := range db.handles {
+		if existing.file == newFile {
+			// opened by another writer meanwhile
+			db.handlesMu.Unlock()
+			closeStore(store)
+			return db.ensureOpenFile(newFile, t, noSync)
+		}
+	}
+	h := &shardHandle{file: newFile, day: t, store: store, bkt: bkt, refs: 1, done: make(chan struct{})}
+	db.handles = append([]*shardHandle{h}, db.handles...)
+
+	maxOpen := db.options.MaxOpenWriters
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenWriters
+	}
+	var closeNow []*shardHandle
+	for len(db.handles) > maxOpen {
+		last := db.handles[len(db.handles)-1]
+		db.handles = db.handles[:len(db.handles)-1]
+		if db.retire(last, true) {
+			closeNow = append(closeNow, last)
+		}
+	}
+	db.handlesMu.Unlock()
+	for _, last := range closeNow {
+		db.closeRetired(last)
+	}
+	return h, nil
+}
+
+// frontHandle returns the open handle of the shard file with a reference,
+// moving it first as the most recently used, or nil.
+func (db *TSEngine) frontHandle(file string) *shardHandle {
+	db.handlesMu.Lock()
+	defer db.handlesMu.Unlock()
+	for i, h := range db.handles {
+		if h.file == file {
+			copy(db.handles[1:i+1], db.handles[:i])
+			db.handles[0] = h
+			h.refs++
+			return h
 		}
 	}
 	return nil
 }
 
+// release closes a write handle evicted after the engine rolled over, the
+// shard is sealed first when it is older than the hot shard.
+func (db *TSEngine) release(h *shardHandle) error {
+	if db.isHistorical(h.day) && h.file != db.QuarantineFile() {
+		if sealed, err := isSealed(h.bkt); err == nil && !sealed {
+			if err := db.seal(h.bkt); err != nil {
+				db.logger().Printf("engine failed to seal shard %s: %s", h.file, err)
+			}
+		}
+	}
+	return closeStore(h.store)
+}
+
 func (db *TSEngine) Write(t time.Time, cb func(bkt *Bucket) error) error {
-	err := db.ensureOpen(t)
+	file, err := db.route(t)
+	if err != nil {
+		return err
+	}
+	return db.writeTo(file, t, cb)
+}
+
+// writeTo is Write into the shard file a write at t is routed to.
+func (db *TSEngine) writeTo(file string, t time.Time, cb func(bkt *Bucket) error) error {
+	if err := db.writeRouted(file, t, cb); err != nil {
+		return err
+	}
+	if db.options.Shadow != nil {
+		db.shadowWrite(t, cb)
+	}
+	return nil
+}
+
+// writeRouted writes into the shard file a write at t is routed to, without
+// the shadow write.
+func (db *TSEngine) writeRouted(file string, t time.Time, cb func(bkt *Bucket) error) error {
+	h, err := db.ensureOpenRouted(file, t)
 	if err != nil {
 		return err
 	}
-	return cb(db.bkt)
+	start := time.Now()
+	err = cb(h.bkt)
+	db.slowOp(SlowCommit, h.file, db.options.SlowOps.Commit, start, 0, err)
+	db.unref(h)
+	return err
+}
+
+// shadowWrite replays a successful write on the shadow engine.
+func (db *TSEngine) shadowWrite(t time.Time, cb func(bkt *Bucket) error) {
+	if err := db.options.Shadow.Write(t, cb); err != nil {
+		db.shadowError(t, err)
+	}
+}
+
+// shadowError reports an error of the shadow write at t.
+func (db *TSEngine) shadowError(t time.Time, err error) {
+	if db.options.OnShadowError != nil {
+		db.options.OnShadowError(t, err)
+	} else {
+		db.logger().Printf("engine failed to write shadow at %s: %s", t, err)
+	}
+}
+
+// NewID returns a new unique ObjectId of t, with the IDPrecision of the
+// engine, or the key of its node ID.
+func (db *TSEngine) NewID(t time.Time) string {
+	if db.options.NodeID != "" {
+		return CreateNodeID(t, db.options.NodeID, atomic.AddUint32(&idCounter, 1))
+	}
+	return CreateIDWithPrecision(t, atomic.AddUint32(&idCounter, 1), db.options.IDPrecision)
+}
+
+// NodeID returns the node ID of the engine, see TSOptions.NodeID.
+func (db *TSEngine) NodeID() string {
+	return db.options.NodeID
 }
 
 func (db *TSEngine) Read(start, end time.Time, cb func(bkt *Bucket) error) error {
-	return filesRead(db.nameWith, start, end, func(position int, fileName string) error {
+	return filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
 		return db.read(fileName, cb)
 	})
 }
 
 func (db *TSEngine) Get(id string, record interface{}) error {
-	time := TimeFromID(id)
-	if time.IsZero() {
-		return ErrKeyExists
+	if err := ValidateID(id); err != nil {
+		return err
 	}
+	time := TimeFromID(id)
 
 	fileName := db.nameWith(time)
 	return db.read(fileName, func(bkt *Bucket) error {
-		return bkt.Get(id, record)
+		err := bkt.Get(id, record)
+		if err == nil {
+			db.recordsRead.Add(1)
+		}
+		return err
 	})
 }
 
 func (db *TSEngine) read(fileName string, cb func(bkt *Bucket) error) error {
-	if fileName == db.currentFile {
-		if db.store == nil {
-			store, bkt, err := db.open(db.currentFile)
-			if err != nil {
-				return err
-			}
-			db.store = store
-			db.bkt = bkt
-		}
+	return db.readIn(ForegroundRead, fileName, cb)
+}
 
-		return cb(db.bkt)
+// readIn reads the shard file in the lane.
+func (db *TSEngine) readIn(lane ReadPriority, fileName string, cb func(bkt *Bucket) error) error {
+	defer db.lanes.enter(lane, db.backgroundYield())()
+	if h := db.acquireHandle(fileName); h != nil {
+		defer db.unref(h)
+		return cb(h.bkt)
 	}
+	db.waitClosed(fileName)
 	store, bkt, err := db.open(fileName)
 	if err != nil {
 		return err
@@ -134,23 +889,7 @@ func (db *TSEngine) read(fileName string, cb func(bkt *Bucket) error) error {
 }
 
 func (db *TSEngine) Query(start, end time.Time, cb func(it *Iterator) error) error {
-	startID := CreateID(start, 0)
-	endID := CreateID(end, 0)
-
-	return filesRead(db.nameWith, start, end, func(position int, fileName string) error {
-		return db.read(fileName, func(bkt *Bucket) error {
-			switch position {
-			case positionStart:
-				return bkt.GetRange(startID, "", cb)
-			case positionEnd:
-				return bkt.GetRange("", endID, cb)
-			case positionStartEnd:
-				return bkt.GetRange(startID, endID, cb)
-			default:
-				return bkt.GetRange("", "", cb)
-			}
-		})
-	})
+	return db.QueryWith(start, end, nil, cb)
 }
 
 const positionMiddle = 0
@@ -158,63 +897,113 @@ const positionStart = 1
 const positionEnd = 2
 const positionStartEnd = 3
 
-type fileCallback func(position int, fileName string) error
+// fileCallback is called with the start time of every shard in a time range.
+type fileCallback func(position int, day time.Time, fileName string) error
 
-func filesRead(nameWith func(t time.Time) string, start, end time.Time, cb fileCallback) error {
+func filesRead(nameWith func(t time.Time) string, g Granularity, start, end time.Time, cb fileCallback) error {
 	if start.After(end) {
 		return errors.New("time range is invalid")
 	}
 
-	startY := start.Year()
-	startYD := start.YearDay()
-	endY := end.Year()
-	endYD := end.YearDay()
-
-	current := start
-
-	currentY := current.Year()
-	currentYD := current.YearDay()
-
-	for currentY < endY || (currentY == endY && currentYD <= endYD) {
-		///handle := cb
-		//inStart := currentY == startY && currentYD == startYD
-		//inEnd := currentY == endY && currentYD == endYD
-
-		fileName := nameWith(current)
+	first := g.Truncate(start)
+	last := g.Truncate(end)
+	for current := first; !current.After(last); current = g.Next(current) {
 		position := positionMiddle
-		if currentY == startY && currentYD == startYD {
-			if currentY == endY && currentYD == endYD {
+		if current.Equal(first) {
+			if current.Equal(last) {
 				position = positionStartEnd
 			} else {
 				position = positionStart
 			}
-		} else if currentY == endY && currentYD == endYD {
+		} else if current.Equal(last) {
 			position = positionEnd
 		}
 
-		if err := cb(position, fileName); nil != err {
+		if err := cb(position, current, nameWith(current)); nil != err {
 			return err
 		}
-
-		current = current.Add(24 * time.Hour)
-		currentY = current.Year()
-		currentYD = current.YearDay()
 	}
 	return nil
 }
 
 func OpenTSEngine(path string, nameWith func(t time.Time) string) (*TSEngine, error) {
-	return &TSEngine{
-		basePath: path,
-		nameWith: func(t time.Time) string {
-			return filepath.Join(path, nameWith(t))
-		}}, nil
+	return OpenTSWithOptions(path, &TSOptions{NameWith: nameWith})
 }
 
 func OpenTS(path string) (*TSEngine, error) {
-	return &TSEngine{
+	return OpenTSWithOptions(path, nil)
+}
+
+// OpenTSWithOptions opens a TSEngine at path with the given options.
+func OpenTSWithOptions(path string, options *TSOptions) (*TSEngine, error) {
+	options = fillTSOptions(options)
+	if err := checkBuckets(options); err != nil {
+		return nil, err
+	}
+	var pattern *ShardPattern
+	if options.NamePattern != "" {
+		var err error
+		if pattern, err = ParseShardPattern(options.NamePattern); err != nil {
+			return nil, err
+		}
+		if pattern.Granularity() != options.ShardInterval {
+			return nil, errors.New("shard pattern " + strconv.Quote(options.NamePattern) + " doesn't name " + options.ShardInterval.String() + " shards")
+		}
+		options.NameWith = pattern.Name
+	}
+	nameWith := options.NameWith
+	db := &TSEngine{
 		basePath: path,
+		options:  *options,
+		pattern:  pattern,
+		lanes:    options.lanes,
 		nameWith: func(t time.Time) string {
-			return filepath.Join(path, strconv.Itoa(t.Year())+"_"+strconv.Itoa(t.YearDay())+".ts")
-		}}, nil
+			return filepath.Join(path, nameWith(t))
+		}}
+	if !options.NoRecovery {
+		var err error
+		if db.repairs, err = db.recoverCrash(); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.checkManifest(); err != nil {
+		return nil, err
+	}
+	if options.ChangeLog && !options.NoRecovery && !db.boltOptions().ReadOnly {
+		repairs, err := db.recoverChanges()
+		db.repairs = append(db.repairs, repairs...)
+		if err != nil {
+			db.closeMeta()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// set any unspecified options to defaults
+func fillTSOptions(options *TSOptions) *TSOptions {
+	if options == nil {
+		options = &TSOptions{}
+	} else {
+		copied := *options
+		options = &copied
+	}
+	if options.lanes == nil {
+		options.lanes = &readLanes{}
+	}
+	if options.NameWith == nil && options.NamePattern == "" {
+		g, ext := options.ShardInterval, options.ShardExtension
+		if ext == "" {
+			options.ShardExtension = DefaultShardExtension
+			options.NameWith = g.Name
+		} else {
+			options.NameWith = func(t time.Time) string {
+				return strings.TrimSuffix(g.Name(t), DefaultShardExtension) + ext
+			}
+		}
+	}
+	if options.NameWith == nil {
+		options.NameWith = options.ShardInterval.Name
+	}
+	return options
 }
@@ -0,0 +1,722 @@
+package borm_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+)
+
+func TestTSInterleavedWrites(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		yesterday := now.AddDate(0, 0, -1)
+
+		for i := 0; i < 10; i++ {
+			created := now
+			if i%2 == 1 {
+				created = yesterday
+			}
+			err := db.Write(created, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(created, uint32(i)), &ItemTest{ID: i, Created: created})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+
+		count := 0
+		err := db.Query(yesterday.Add(-time.Second), now.Add(time.Second), func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 10 {
+			t.Fatalf("Query result count is %d wanted %d.", count, 10)
+		}
+	})
+}
+
+func TestTSShardDiscovery(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{ShardExtension: ".db"})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(borm.CreateID(now, 1), &ItemTest{Created: now})
+	})
+	if err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+	name := strings.TrimSuffix(borm.Daily.Name(now), ".ts") + ".db"
+	for _, noise := range []string{name + ".tmp", name + "~", "notes.txt", borm.Daily.Name(now)} {
+		if err := ioutil.WriteFile(filepath.Join(dir, noise), []byte("noise"), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", noise, err)
+		}
+	}
+
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 1 || filepath.Base(shards[0].Path) != name {
+		t.Fatalf("Listed %v wanted only %s.", shards, name)
+	}
+	if err := db.EnforceRetention(now.AddDate(0, 0, -1)); err != nil {
+		t.Fatalf("Error applying retention: %s", err)
+	}
+
+	// the default listing skips the temporary files but not the others
+	shards2, err := borm.ListShardsWith(dir, time.Local, &borm.ListOptions{Extension: ".db"})
+	if err != nil || len(shards2) != 1 {
+		t.Fatalf("Listed %d shards wanted 1: %v", len(shards2), err)
+	}
+	if _, err := borm.ListShards(dir, time.Local); err == nil {
+		t.Fatalf("Listing notes.txt as a shard didn't fail!")
+	}
+}
+
+func TestTSShardCreation(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	// the temporary file of a creation interrupted by a crash
+	now := time.Now()
+	name := borm.Daily.Name(now)
+	if err := ioutil.WriteFile(filepath.Join(dir, "."+name+".create-1"), nil, 0644); err != nil {
+		t.Fatalf("Error writing temporary file: %s", err)
+	}
+
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(borm.CreateID(now, 1), &ItemTest{Created: now})
+	})
+	if err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", dir, err)
+	}
+	for _, fi := range files {
+		if strings.Contains(fi.Name(), ".create-") && fi.Name() != "."+name+".create-1" {
+			t.Fatalf("Temporary file %s left behind.", fi.Name())
+		}
+	}
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 1 || filepath.Base(shards[0].Path) != name || shards[0].Size == 0 {
+		t.Fatalf("Listed %v wanted %s.", shards, name)
+	}
+}
+
+func TestTSReadYourWritesAcrossRollover(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{MaxOpenWriters: 1})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	midnight := startOfDay(time.Now())
+	before, after := midnight.Add(-time.Second), midnight.Add(time.Second)
+	count := func() int {
+		n, err := db.Count(before.Add(-time.Second), after.Add(4*time.Second), nil)
+		if err != nil {
+			t.Fatalf("Error counting: %s", err)
+		}
+		return int(n)
+	}
+
+	err = db.Write(before, func(bkt *borm.Bucket) error {
+		if err := bkt.Insert(borm.CreateID(before, 1), &ItemTest{Created: before}); err != nil {
+			return err
+		}
+		// the write after midnight retires the handle of yesterday, still
+		// in use here
+		err := db.Write(after, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(after, 1), &ItemTest{Created: after})
+		})
+		if err != nil {
+			return err
+		}
+		if n := count(); n != 2 {
+			t.Fatalf("Counted %d records after the rollover wanted 2.", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("Counted %d records once the old shard is closed wanted 2.", n)
+	}
+}
+
+func TestTSClockSkew(t *testing.T) {
+	dir := tempfile()
+	defer os.RemoveAll(dir)
+
+	var skewed int
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		MaxFutureSkew: time.Hour,
+		OnSkew:        func(t, now time.Time) { skewed++ },
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	future := time.Now().AddDate(80, 0, 0)
+	err = db.Write(future, func(bkt *borm.Bucket) error {
+		return bkt.Insert(borm.CreateID(future, 1), &ItemTest{Created: future})
+	})
+	if err != borm.ErrClockSkew {
+		t.Fatalf("Write in the future didn't fail! Expected %s got %s", borm.ErrClockSkew, err)
+	}
+	if skewed != 1 {
+		t.Fatalf("OnSkew called %d times wanted %d.", skewed, 1)
+	}
+
+	shards, err := borm.ListShards(dir, time.Local)
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	if len(shards) != 0 {
+		t.Fatalf("Skewed write created %d shards.", len(shards))
+	}
+}
+
+func TestTSQueryShardFilter(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		yesterday := now.AddDate(0, 0, -1)
+		for i, created := range []time.Time{yesterday, now} {
+			err := db.Write(created, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(created, uint32(i)), &ItemTest{ID: i, Created: created})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+
+		var visited int
+		count := 0
+		err := db.QueryWith(yesterday.Add(-time.Second), now.Add(time.Second), &borm.QueryOptions{
+			ShardFilter: func(info borm.ShardInfo) bool {
+				visited++
+				return !info.StartTime.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+			},
+		}, func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if visited != 2 || count != 1 {
+			t.Fatalf("Query visited %d shards and found %d records, wanted %d and %d.", visited, count, 2, 1)
+		}
+	})
+}
+
+func TestTSShardTags(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(borm.CreateID(now, 1), &ItemTest{Created: now})
+		})
+		if err != nil {
+			t.Fatalf("Error writing record: %s", err)
+		}
+
+		if err := db.SetShardTag(now, "source", "sensor-7"); err != nil {
+			t.Fatalf("Error tagging shard: %s", err)
+		}
+
+		shards, err := db.Shards()
+		if err != nil {
+			t.Fatalf("Error listing shards: %s", err)
+		}
+		if len(shards) != 1 || shards[0].Tags["source"] != "sensor-7" {
+			t.Fatalf("Shards returned %v wanted one shard tagged source=sensor-7.", shards)
+		}
+
+		count := 0
+		err = db.QueryWith(now.Add(-time.Second), now.Add(time.Second), &borm.QueryOptions{
+			ShardFilter: func(info borm.ShardInfo) bool {
+				return info.Tags["source"] != "sensor-7"
+			},
+		}, func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 0 {
+			t.Fatalf("Query found %d records in a filtered out shard.", count)
+		}
+	})
+}
+
+func TestTSShardsLocation(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	// far from any local zone, the day of the shard differs from the local one
+	zone := time.FixedZone("LINT", 14*60*60)
+	now := time.Date(2020, 3, 1, 1, 0, 0, 0, zone)
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{Clock: borm.NewManualClock(now)})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	if err := db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(db.NewID(now), &ItemTest{Created: now})
+	}); err != nil {
+		t.Fatalf("Error writing record: %s", err)
+	}
+	shards, err := db.Shards()
+	if err != nil {
+		t.Fatalf("Error listing shards: %s", err)
+	}
+	start := time.Date(2020, 3, 1, 0, 0, 0, 0, zone)
+	if len(shards) != 1 || !shards[0].StartTime.Equal(start) || shards[0].StartTime.Location() != zone {
+		t.Fatalf("Shards returned %v wanted one shard starting at %s.", shards, start)
+	}
+}
+
+func TestTSQueryProgress(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		err := db.Write(now, func(bkt *borm.Bucket) error {
+			for i := range testData {
+				if err := bkt.Insert(borm.CreateID(now, uint32(i)), &testData[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error writing test data: %s", err)
+		}
+
+		var last borm.Progress
+		err = db.QueryWith(now.Add(-time.Second), now.Add(time.Second), &borm.QueryOptions{
+			Progress: func(p borm.Progress) { last = p },
+		}, func(it *borm.Iterator) error {
+			for it.Next() {
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if last.Items != int64(len(testData)) || last.Bytes == 0 || last.Shard == "" {
+			t.Fatalf("Last progress is %v wanted %d items.", last, len(testData))
+		}
+	})
+}
+
+func TestTSQueryResumeToken(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		yesterday := now.AddDate(0, 0, -1)
+		for i := 0; i < 10; i++ {
+			created := yesterday
+			if i >= 5 {
+				created = now
+			}
+			err := db.Write(created, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(created, uint32(i)), &ItemTest{ID: i, Created: created})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+
+		start, end := yesterday.Add(-time.Second), now.Add(time.Second)
+		read := func(opts *borm.QueryOptions, limit int) ([]int, string) {
+			var ids []int
+			var token string
+			err := db.QueryWith(start, end, opts, func(it *borm.Iterator) error {
+				for len(ids) < limit && it.Next() {
+					var item ItemTest
+					if err := it.Read(&item); err != nil {
+						return err
+					}
+					ids = append(ids, item.ID)
+					token = it.Token()
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error querying: %s", err)
+			}
+			return ids, token
+		}
+
+		first, token := read(nil, 7)
+		if len(first) != 7 {
+			t.Fatalf("Query returned %v wanted 7 records.", first)
+		}
+		rest, _ := read(&borm.QueryOptions{ResumeToken: token}, 100)
+		if len(rest) != 3 || rest[0] != 7 || rest[2] != 9 {
+			t.Fatalf("Resumed query returned %v wanted records 7 to 9.", rest)
+		}
+
+		if err := db.QueryWith(start, end, &borm.QueryOptions{ResumeToken: "!"}, func(it *borm.Iterator) error {
+			return nil
+		}); err != borm.ErrInvalidToken {
+			t.Fatalf("Invalid token didn't fail! Expected %s got %s", borm.ErrInvalidToken, err)
+		}
+	})
+}
+
+func TestTSShadowWrite(t *testing.T) {
+	primaryDir, shadowDir := tempdir(t), tempdir(t)
+	defer os.RemoveAll(primaryDir)
+	defer os.RemoveAll(shadowDir)
+
+	shadow, err := borm.OpenTSWithOptions(shadowDir, &borm.TSOptions{ShardInterval: borm.Hourly})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", shadowDir, err)
+	}
+	defer shadow.Close()
+
+	var shadowErrors int
+	db, err := borm.OpenTSWithOptions(primaryDir, &borm.TSOptions{
+		Shadow:        shadow,
+		OnShadowError: func(time.Time, error) { shadowErrors++ },
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", primaryDir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	id := borm.CreateID(now, 1)
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(id, &ItemTest{ID: 1, Name: "shadow", Created: now})
+	})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+
+	var item ItemTest
+	if err := shadow.Get(id, &item); err != nil || item.Name != "shadow" {
+		t.Fatalf("Shadow didn't get the write: %v %v", item, err)
+	}
+
+	// the record exists in the shadow only, the primary write succeeds
+	id = borm.CreateID(now, 2)
+	shadow.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(id, &ItemTest{ID: 2, Created: now})
+	})
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		return bkt.Insert(id, &ItemTest{ID: 2, Created: now})
+	})
+	if err != nil {
+		t.Fatalf("Shadow error failed the write: %s", err)
+	}
+	if shadowErrors != 1 {
+		t.Fatalf("OnShadowError was called %d times, expected 1.", shadowErrors)
+	}
+}
+
+func TestTSDropCache(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		day := time.Now().AddDate(0, 0, -3)
+		if _, err := db.Backfill([]borm.Entry{{Time: day, Value: &ItemTest{ID: 1, Created: day}}}, nil); err != nil {
+			t.Fatalf("Error backfilling: %s", err)
+		}
+		if err := db.Prefetch(day.AddDate(0, 0, -1), day); err != nil {
+			t.Fatalf("Error prefetching: %s", err)
+		}
+
+		count := 0
+		err := db.QueryWith(day.Add(-time.Hour), day.Add(time.Hour), &borm.QueryOptions{DropCache: true}, func(it *borm.Iterator) error {
+			for it.Next() {
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		if count != 1 {
+			t.Fatalf("Query returned %d records, expected 1.", count)
+		}
+	})
+}
+
+func TestTSMemoryBudget(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		for i := 0; i < 10; i++ {
+			err := db.Write(now, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(now, uint32(i)), &ItemTest{ID: i, Created: now})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+
+		read := 0
+		opts := &borm.QueryOptions{MemoryBudget: 1}
+		err := db.QueryWith(now.Add(-time.Minute), now.Add(time.Minute), opts, func(it *borm.Iterator) error {
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					// keep going, the query aborts anyway
+					continue
+				}
+				read++
+			}
+			return nil
+		})
+		if err != borm.ErrMemoryBudget {
+			t.Fatalf("Query over budget didn't fail! Expected %s got %v", borm.ErrMemoryBudget, err)
+		}
+		if read != 0 {
+			t.Fatalf("Query over budget decoded %d records.", read)
+		}
+	})
+}
+
+func TestTSIterError(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		bad := borm.CreateID(now, 1)
+		for i := 0; i < 3; i++ {
+			err := db.Write(now, func(bkt *borm.Bucket) error {
+				if i == 1 {
+					// a record of another type, which doesn't decode
+					return bkt.Insert(bad, "garbage")
+				}
+				return bkt.Insert(borm.CreateID(now, uint32(i)), &ItemTest{ID: i, Created: now})
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+
+		read := 0
+		readAll := func(it *borm.Iterator) error {
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					return err
+				}
+				read++
+			}
+			return nil
+		}
+
+		err := db.Query(now.Add(-time.Minute), now.Add(time.Minute), readAll)
+		ie, ok := err.(*borm.IterError)
+		if !ok || ie.Op != borm.OpDecode || ie.Key != bad || ie.Shard == "" {
+			t.Fatalf("Expected a decode error at %s, got %v", bad, err)
+		}
+
+		read = 0
+		var skipped []string
+		opts := &borm.QueryOptions{
+			SkipUndecodable: true,
+			OnSkip:          func(err *borm.IterError) { skipped = append(skipped, err.Key) },
+		}
+		if err := db.QueryWith(now.Add(-time.Minute), now.Add(time.Minute), opts, readAll); err != nil {
+			t.Fatalf("Error querying with SkipUndecodable: %s", err)
+		}
+		if read != 2 || len(skipped) != 1 || skipped[0] != bad {
+			t.Fatalf("Read %d records and skipped %v, wanted 2 and %s.", read, skipped, bad)
+		}
+
+		stop := errors.New("stop")
+		err = db.Query(now.Add(-time.Minute), now.Add(time.Minute), func(it *borm.Iterator) error {
+			it.Next()
+			return stop
+		})
+		if ie, ok := err.(*borm.IterError); !ok || ie.Op != borm.OpCallback || !errors.Is(err, stop) {
+			t.Fatalf("Expected the callback error wrapped, got %v", err)
+		}
+	})
+}
+
+func TestTSStopIteration(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		now := time.Now()
+		start := startOfDay(now).AddDate(0, 0, -2)
+		for day := 0; day < 3; day++ {
+			at := start.AddDate(0, 0, day).Add(time.Hour)
+			for i := 0; i < 2; i++ {
+				err := db.Write(at, func(bkt *borm.Bucket) error {
+					return bkt.Insert(borm.CreateID(at, uint32(i)), &testData[i])
+				})
+				if err != nil {
+					t.Fatalf("Error writing record %d: %s", i, err)
+				}
+			}
+		}
+
+		shards, read := 0, 0
+		opts := &borm.QueryOptions{ShardFilter: func(info borm.ShardInfo) bool {
+			shards++
+			return true
+		}}
+		err := db.QueryWith(start, now.Add(time.Minute), opts, func(it *borm.Iterator) error {
+			for it.Next() {
+				read++
+				return borm.ErrStopIteration
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error stopping the query: %s", err)
+		}
+		if read != 1 || shards != 1 {
+			t.Fatalf("Stopped query read %d records of %d shards, wanted 1 of 1.", read, shards)
+		}
+
+		read = 0
+		err = db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.ForEach(func(it *borm.Iterator) error {
+				for it.Next() {
+					read++
+					return borm.ErrStopIteration
+				}
+				return nil
+			})
+		})
+		if err != nil || read != 1 {
+			t.Fatalf("Stopped ForEach read %d records, wanted 1: %v", read, err)
+		}
+	})
+}
+
+func TestTSShardLifecycle(t *testing.T) {
+	testTSWrap(t, func(db *borm.TSEngine, t *testing.T) {
+		var created, sealed, deleted []string
+		db.OnShardCreated(func(info borm.ShardInfo) { created = append(created, filepath.Base(info.Path)) })
+		db.OnShardSealed(func(info borm.ShardInfo) { sealed = append(sealed, filepath.Base(info.Path)) })
+		db.OnShardDeleted(func(info borm.ShardInfo) { deleted = append(deleted, filepath.Base(info.Path)) })
+
+		old := time.Now().AddDate(0, 0, -3)
+		for i := 0; i < 2; i++ {
+			err := db.Write(old, func(bkt *borm.Bucket) error {
+				return bkt.Insert(borm.CreateID(old, uint32(i)), &testData[i])
+			})
+			if err != nil {
+				t.Fatalf("Error writing record %d: %s", i, err)
+			}
+		}
+		name := borm.Daily.Name(old)
+		if len(created) != 1 || created[0] != name {
+			t.Fatalf("Expected the creation of %s, got %v.", name, created)
+		}
+
+		if err := db.Seal(old); err != nil {
+			t.Fatalf("Error sealing shard: %s", err)
+		}
+		if len(sealed) != 1 || sealed[0] != name {
+			t.Fatalf("Expected the seal of %s, got %v.", name, sealed)
+		}
+
+		if _, err := db.ApplyRetention(borm.RetentionPolicy{MaxAge: 24 * time.Hour}, false); err != nil {
+			t.Fatalf("Error applying retention: %s", err)
+		}
+		if len(deleted) != 1 || deleted[0] != name {
+			t.Fatalf("Expected the deletion of %s, got %v.", name, deleted)
+		}
+	})
+}
+
+func TestSyncHotOnly(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{SyncPolicy: borm.SyncHotOnly})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+
+	// the hook sees the bolt file of the write handle of the shard
+	now := time.Now()
+	old := now.AddDate(0, 0, -3)
+	noSync := map[string]bool{}
+	for _, at := range []time.Time{now, old} {
+		name := at.Format("2006-01-02")
+		err := db.Write(at, func(bkt *borm.Bucket) error {
+			bkt.AddWriteHook(func(tx *bolt.Tx, key, value []byte) error {
+				noSync[name] = tx.DB().NoSync
+				return nil
+			})
+			return bkt.Insert(db.NewID(at), &ItemTest{Name: name, Created: at})
+		})
+		if err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+	if len(noSync) != 2 || noSync[now.Format("2006-01-02")] || !noSync[old.Format("2006-01-02")] {
+		t.Fatalf("Expected only the historical shard without fsync, got %v.", noSync)
+	}
+
+	// the open historical handle is flushed, and kept open
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Error syncing: %s", err)
+	}
+	if n := db.Stats().OpenWriters; n != 2 {
+		t.Fatalf("Expected the 2 write handles open after Sync, got %d.", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+
+	db, err = borm.OpenTSWithOptions(dir, &borm.TSOptions{SyncPolicy: borm.SyncHotOnly})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	count := 0
+	err = db.Query(old.Add(-time.Hour), now.Add(time.Hour), func(it *borm.Iterator) error {
+		for it.Next() {
+			count++
+		}
+		return nil
+	})
+	if err != nil || count != 2 {
+		t.Fatalf("Expected the 2 records after reopening, got %d: %v", count, err)
+	}
+}
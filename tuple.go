@@ -0,0 +1,223 @@
+package borm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The type codes of the tuple elements, they follow the FoundationDB tuple
+// layer so keys of both sort alike.
+const (
+	tupleNil     = 0x00
+	tupleBytes   = 0x01
+	tupleString  = 0x02
+	tupleIntZero = 0x14
+	tupleDouble  = 0x21
+	tupleFalse   = 0x26
+	tupleTrue    = 0x27
+	// tupleTime is in the range FoundationDB leaves to user types.
+	tupleTime = 0x40
+)
+
+// ErrInvalidTuple is returned when a key is not an encoded tuple
+var ErrInvalidTuple = errors.New("invalid tuple")
+
+// EncodeTuple encodes the elements into a key, the lexicographic order of
+// the keys is the order of the tuples, element by element. Elements are nil,
+// bool, string, []byte, integers, float64 or time.Time.
+func EncodeTuple(elems ...interface{}) ([]byte, error) {
+	var buf []byte
+	for _, elem := range elems {
+		var err error
+		if buf, err = appendTupleElem(buf, elem); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendTupleElem(buf []byte, elem interface{}) ([]byte, error) {
+	switch v := elem.(type) {
+	case nil:
+		return append(buf, tupleNil), nil
+	case bool:
+		if v {
+			return append(buf, tupleTrue), nil
+		}
+		return append(buf, tupleFalse), nil
+	case []byte:
+		return appendTupleBytes(append(buf, tupleBytes), v), nil
+	case string:
+		return appendTupleBytes(append(buf, tupleString), []byte(v)), nil
+	case int:
+		return appendTupleInt(buf, int64(v)), nil
+	case int8:
+		return appendTupleInt(buf, int64(v)), nil
+	case int16:
+		return appendTupleInt(buf, int64(v)), nil
+	case int32:
+		return appendTupleInt(buf, int64(v)), nil
+	case int64:
+		return appendTupleInt(buf, v), nil
+	case uint8:
+		return appendTupleInt(buf, int64(v)), nil
+	case uint16:
+		return appendTupleInt(buf, int64(v)), nil
+	case uint32:
+		return appendTupleInt(buf, int64(v)), nil
+	case float64:
+		bits := math.Float64bits(v)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		buf = append(buf, tupleDouble)
+		return appendUint64(buf, bits), nil
+	case time.Time:
+		buf = append(buf, tupleTime)
+		return appendUint64(buf, uint64(v.UnixNano())^(1<<63)), nil
+	default:
+		return nil, fmt.Errorf("tuple element of type %T is not supported", elem)
+	}
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// appendTupleBytes appends the bytes with 0x00 escaped as 0x00 0xff, and
+// the 0x00 terminator.
+func appendTupleBytes(buf, data []byte) []byte {
+	for _, c := range data {
+		buf = append(buf, c)
+		if c == 0x00 {
+			buf = append(buf, 0xff)
+		}
+	}
+	return append(buf, 0x00)
+}
+
+// appendTupleInt appends the integer as its type code, which holds the sign
+// and the byte length, and its big endian bytes, ones' complement for the
+// negative ones.
+func appendTupleInt(buf []byte, v int64) []byte {
+	if v == 0 {
+		return append(buf, tupleIntZero)
+	}
+	magnitude := uint64(v)
+	if v < 0 {
+		magnitude = uint64(-(v + 1)) + 1
+	}
+	n := 8
+	for n > 1 && magnitude>>(8*uint(n-1)) == 0 {
+		n--
+	}
+
+	var b [8]byte
+	if v > 0 {
+		binary.BigEndian.PutUint64(b[:], magnitude)
+		buf = append(buf, byte(tupleIntZero+n))
+	} else {
+		binary.BigEndian.PutUint64(b[:], ^magnitude)
+		buf = append(buf, byte(tupleIntZero-n))
+	}
+	return append(buf, b[8-n:]...)
+}
+
+// DecodeTuple decodes a key encoded by EncodeTuple. Integers decode as
+// int64, strings as string, bytes as []byte.
+func DecodeTuple(key []byte) ([]interface{}, error) {
+	var elems []interface{}
+	for len(key) > 0 {
+		code := key[0]
+		key = key[1:]
+		switch {
+		case code == tupleNil:
+			elems = append(elems, nil)
+		case code == tupleFalse, code == tupleTrue:
+			elems = append(elems, code == tupleTrue)
+		case code == tupleBytes, code == tupleString:
+			data, rest, err := readTupleBytes(key)
+			if err != nil {
+				return nil, err
+			}
+			key = rest
+			if code == tupleBytes {
+				elems = append(elems, data)
+			} else {
+				elems = append(elems, string(data))
+			}
+		case code >= tupleIntZero-8 && code <= tupleIntZero+8:
+			n := int(code) - tupleIntZero
+			negative := n < 0
+			if negative {
+				n = -n
+			}
+			if len(key) < n {
+				return nil, ErrInvalidTuple
+			}
+			var b [8]byte
+			copy(b[8-n:], key[:n])
+			key = key[n:]
+			magnitude := binary.BigEndian.Uint64(b[:])
+			if negative {
+				magnitude = ^magnitude
+				if n < 8 {
+					magnitude &= 1<<(8*uint(n)) - 1
+				}
+				elems = append(elems, -int64(magnitude-1)-1)
+			} else {
+				elems = append(elems, int64(magnitude))
+			}
+		case code == tupleDouble || code == tupleTime:
+			if len(key) < 8 {
+				return nil, ErrInvalidTuple
+			}
+			bits := binary.BigEndian.Uint64(key[:8])
+			key = key[8:]
+			if code == tupleTime {
+				elems = append(elems, time.Unix(0, int64(bits^(1<<63))))
+			} else if bits&(1<<63) != 0 {
+				elems = append(elems, math.Float64frombits(bits&^(1<<63)))
+			} else {
+				elems = append(elems, math.Float64frombits(^bits))
+			}
+		default:
+			return nil, ErrInvalidTuple
+		}
+	}
+	return elems, nil
+}
+
+func readTupleBytes(key []byte) ([]byte, []byte, error) {
+	var data []byte
+	for i := 0; i < len(key); i++ {
+		if key[i] != 0x00 {
+			data = append(data, key[i])
+			continue
+		}
+		if i+1 < len(key) && key[i+1] == 0xff {
+			data = append(data, 0x00)
+			i++
+			continue
+		}
+		return data, key[i+1:], nil
+	}
+	return nil, nil, ErrInvalidTuple
+}
+
+// TupleRange returns the keys bounding, both included, the tuples starting
+// with the prefix elements, e.g. for Bucket.GetRange.
+func TupleRange(prefix ...interface{}) (string, string, error) {
+	key, err := EncodeTuple(prefix...)
+	if err != nil {
+		return "", "", err
+	}
+	return string(key), string(key) + "\xff", nil
+}
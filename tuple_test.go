@@ -0,0 +1,88 @@
+package borm_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestTupleRoundTrip(t *testing.T) {
+	now := time.Now()
+	tuples := [][]interface{}{
+		{nil, true, false},
+		{"", "a\x00b", []byte{0, 0xff, 1}},
+		{int64(0), int64(1), int64(-1), int64(255), int64(-256), int64(math.MaxInt64), int64(math.MinInt64)},
+		{1.5, -2.25, math.Inf(1)},
+		{now, "sensor", int64(42)},
+	}
+	for _, tuple := range tuples {
+		key, err := borm.EncodeTuple(tuple...)
+		if err != nil {
+			t.Fatalf("Error encoding %v: %s", tuple, err)
+		}
+		got, err := borm.DecodeTuple(key)
+		if err != nil {
+			t.Fatalf("Error decoding %v: %s", tuple, err)
+		}
+		for i := range tuple {
+			if want, ok := tuple[i].(time.Time); ok {
+				if !got[i].(time.Time).Equal(want) {
+					t.Fatalf("Element %d of %v decodes to %v.", i, tuple, got[i])
+				}
+			} else if !reflect.DeepEqual(got[i], tuple[i]) {
+				t.Fatalf("Element %d of %v decodes to %#v.", i, tuple, got[i])
+			}
+		}
+	}
+
+	if _, err := borm.DecodeTuple([]byte{0x02, 'a'}); err != borm.ErrInvalidTuple {
+		t.Fatalf("Truncated tuple didn't fail! Expected %s got %v", borm.ErrInvalidTuple, err)
+	}
+}
+
+func TestTupleOrder(t *testing.T) {
+	now := time.Now()
+	// in tuple order
+	tuples := [][]interface{}{
+		{now.Add(-time.Hour), "a", -1000},
+		{now.Add(-time.Hour), "a", -1},
+		{now.Add(-time.Hour), "a", 0},
+		{now.Add(-time.Hour), "a", 7},
+		{now.Add(-time.Hour), "a", 300},
+		{now.Add(-time.Hour), "ab", -5},
+		{now.Add(-time.Hour), "b", 0},
+		{now, "", 0},
+		{now, "a", math.MinInt64},
+		{now, "a", math.MaxInt64},
+	}
+	keys := make([][]byte, len(tuples))
+	for i, tuple := range tuples {
+		key, err := borm.EncodeTuple(tuple...)
+		if err != nil {
+			t.Fatalf("Error encoding %v: %s", tuple, err)
+		}
+		keys[i] = key
+	}
+	if !sort.SliceIsSorted(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 }) {
+		t.Fatalf("Encoded tuples are not in tuple order.")
+	}
+
+	from, to, err := borm.TupleRange(now.Add(-time.Hour), "a")
+	if err != nil {
+		t.Fatalf("Error encoding range: %s", err)
+	}
+	count := 0
+	for _, key := range keys {
+		if string(key) >= from && string(key) <= to {
+			count++
+		}
+	}
+	if count != 5 {
+		t.Fatalf("Range of the prefix covers %d tuples, expected 5.", count)
+	}
+}
@@ -0,0 +1,241 @@
+package borm
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrTxDone is returned by the operations of a WriteTx already committed or
+// rolled back.
+var ErrTxDone = errors.New("write transaction is already done")
+
+// ErrOtherShard is returned when a WriteTx operation targets a key outside
+// the shard of the transaction, the shard a Write at its time goes to.
+var ErrOtherShard = errors.New("key is outside the shard of the transaction")
+
+type txOpKind int
+
+const (
+	txInsert txOpKind = iota
+	txPut
+	txDelete
+	txPutIndex
+	txDeleteIndex
+)
+
+type txOp struct {
+	kind   txOpKind
+	index  []byte
+	key    []byte
+	record interface{}
+	value  []byte
+}
+
+// WriteTx accumulates the writes to the shard of a time, records and index
+// entries, and commits them in one transaction: either all of them are
+// written or none. A WriteTx isn't safe for concurrent use.
+//
+//	tx := db.Begin(t)
+//	defer tx.Rollback()
+//	key, _ := tx.Insert(record)
+//	tx.PutIndex("by-user", user, []byte(key))
+//	err := tx.Commit()
+type WriteTx struct {
+	db       *TSEngine
+	t        time.Time
+	fileName string
+	ops      []txOp
+	done     bool
+
+	// err is the error routing the transaction, e.g. ErrClockSkew.
+	err error
+}
+
+// Begin starts a write transaction on the shard a Write at t goes to, the
+// quarantine shard for a skewed t. A t rejected by the skew bounds fails the
+// operations and the commit with ErrClockSkew.
+func (db *TSEngine) Begin(t time.Time) *WriteTx {
+	fileName, err := db.route(t)
+	return &WriteTx{db: db, t: t, fileName: fileName, err: err}
+}
+
+// usable returns the error of a transaction done or failing to be routed.
+func (tx *WriteTx) usable() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return tx.err
+}
+
+// Insert adds a record with a new key at the time of the transaction and
+// returns the key.
+func (tx *WriteTx) Insert(record interface{}) (string, error) {
+	if err := tx.usable(); err != nil {
+		return "", err
+	}
+	key := tx.db.NewID(tx.t)
+	tx.ops = append(tx.ops, txOp{kind: txInsert, key: []byte(key), record: record})
+	return key, nil
+}
+
+// Put inserts or updates the record of the key, which must be in the shard
+// of the transaction.
+func (tx *WriteTx) Put(key string, record interface{}) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, txOp{kind: txPut, key: []byte(key), record: record})
+	return nil
+}
+
+// Delete deletes the record of the key, which must be in the shard of the
+// transaction.
+func (tx *WriteTx) Delete(key string) error {
+	if err := tx.check(key); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, txOp{kind: txDelete, key: []byte(key)})
+	return nil
+}
+
+// PutIndex sets the entry of the key in the named index of the shard, e.g.
+// a user ID to the key of its last record. Unlike a View, an index is
+// maintained by the caller only.
+func (tx *WriteTx) PutIndex(index, key string, value []byte) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, txOp{
+		kind:  txPutIndex,
+		index: indexBucketName(index),
+		key:   []byte(key),
+		value: append([]byte(nil), value...),
+	})
+	return nil
+}
+
+// DeleteIndex deletes the entry of the key from the named index of the shard.
+func (tx *WriteTx) DeleteIndex(index, key string) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, txOp{kind: txDeleteIndex, index: indexBucketName(index), key: []byte(key)})
+	return nil
+}
+
+func (tx *WriteTx) check(key string) error {
+	if err := tx.usable(); err != nil {
+		return err
+	}
+	if err := ValidateID(key); err != nil {
+		return err
+	}
+	if !tx.db.routesTo(TimeFromID(key), tx.fileName) {
+		return ErrOtherShard
+	}
+	return nil
+}
+
+// Commit writes the operations of the transaction in one transaction of the
+// shard. The transaction is done afterwards, even if the commit failed.
+func (tx *WriteTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	ops := tx.ops
+	tx.ops = nil
+	if tx.err != nil || len(ops) == 0 {
+		return tx.err
+	}
+
+	return tx.db.writeTo(tx.fileName, tx.t, func(bkt *Bucket) error {
+		values := make([][]byte, len(ops))
+		for i, op := range ops {
+			if op.kind != txInsert && op.kind != txPut {
+				continue
+			}
+			value, err := bkt.encodeValue(op.record)
+			if err != nil {
+				return err
+			}
+			values[i] = value
+		}
+
+		return bkt.store.db.Update(func(btx *bolt.Tx) error {
+			data := btx.Bucket(bkt.name)
+			if data == nil {
+				return ErrBucketNotFound
+			}
+			for i, op := range ops {
+				var err error
+				switch op.kind {
+				case txInsert:
+					if data.Get(op.key) != nil {
+						return ErrKeyExists
+					}
+					err = bkt.put(btx, data, op.key, values[i])
+				case txPut:
+					err = bkt.put(btx, data, op.key, values[i])
+				case txDelete:
+					err = bkt.del(btx, data, op.key)
+				case txPutIndex:
+					var idx *bolt.Bucket
+					if idx, err = btx.CreateBucketIfNotExists(op.index); err == nil {
+						err = idx.Put(op.key, op.value)
+					}
+				case txDeleteIndex:
+					if idx := btx.Bucket(op.index); idx != nil {
+						err = idx.Delete(op.key)
+					}
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// Rollback discards the operations of the transaction, it does nothing once
+// the transaction is committed, so it can be deferred.
+func (tx *WriteTx) Rollback() {
+	tx.done = true
+	tx.ops = nil
+}
+
+func indexBucketName(index string) []byte {
+	return []byte("index:" + index)
+}
+
+// LookupIndex returns the entry of the key in the named index of the shard
+// of t, written by WriteTx.PutIndex, or ErrNotFound.
+func (db *TSEngine) LookupIndex(t time.Time, index, key string) ([]byte, error) {
+	fileName := db.nameWith(t)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	var value []byte
+	err := db.read(fileName, func(bkt *Bucket) error {
+		return bkt.view(func(tx *bolt.Tx) error {
+			if idx := tx.Bucket(indexBucketName(index)); idx != nil {
+				if v := idx.Get([]byte(key)); v != nil {
+					value = append([]byte(nil), v...)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
@@ -0,0 +1,149 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/runner-mei/borm"
+)
+
+func TestTSWriteTx(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	tx := db.Begin(now)
+	car, err := tx.Insert(&ItemTest{Name: "car", Created: now})
+	if err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	apple, err := tx.Insert(&ItemTest{Name: "apple", Created: now})
+	if err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	if err := tx.PutIndex("by-name", "car", []byte(car)); err != nil {
+		t.Fatalf("Error indexing record: %s", err)
+	}
+	if err := tx.Put(borm.CreateID(now.AddDate(0, 0, -3), 1), &ItemTest{Name: "old"}); err != borm.ErrOtherShard {
+		t.Fatalf("Put of another shard got %v wanted ErrOtherShard.", err)
+	}
+
+	if _, err := db.LookupIndex(now, "by-name", "car"); err != borm.ErrNotFound {
+		t.Fatalf("Index entry is visible before the commit: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+	if err := tx.Commit(); err != borm.ErrTxDone {
+		t.Fatalf("Second commit got %v wanted ErrTxDone.", err)
+	}
+
+	var result ItemTest
+	if err := db.Get(apple, &result); err != nil || result.Name != "apple" {
+		t.Fatalf("Error reading %s: %v, got %v", apple, err, result)
+	}
+	if key, err := db.LookupIndex(now, "by-name", "car"); err != nil || string(key) != car {
+		t.Fatalf("Index entry got %s, %v wanted %s.", key, err, car)
+	}
+
+	// a failing operation writes none of the others
+	tx = db.Begin(now)
+	if err := tx.Delete(apple); err != nil {
+		t.Fatalf("Error deleting record: %s", err)
+	}
+	if err := tx.DeleteIndex("by-name", "car"); err != nil {
+		t.Fatalf("Error deleting index entry: %s", err)
+	}
+	if _, err := tx.Insert(make(chan int)); err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("Commit of a record which can't be encoded succeeded.")
+	}
+	if err := db.Get(apple, &result); err != nil {
+		t.Fatalf("Error reading %s after the failed commit: %s", apple, err)
+	}
+	if _, err := db.LookupIndex(now, "by-name", "car"); err != nil {
+		t.Fatalf("Error looking up the index after the failed commit: %s", err)
+	}
+
+	// a rolled back transaction writes nothing
+	tx = db.Begin(now)
+	if err := tx.Delete(apple); err != nil {
+		t.Fatalf("Error deleting record: %s", err)
+	}
+	tx.Rollback()
+	if err := tx.Commit(); err != borm.ErrTxDone {
+		t.Fatalf("Commit after rollback got %v wanted ErrTxDone.", err)
+	}
+	if err := db.Get(apple, &result); err != nil {
+		t.Fatalf("Error reading %s after the rollback: %s", apple, err)
+	}
+}
+
+func TestTSWriteTxSkew(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{MaxPastSkew: 24 * time.Hour, SkewAction: borm.SkewQuarantine})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+
+	// the transaction goes to the quarantine shard, as a Write would
+	now := time.Now()
+	old := now.AddDate(0, 0, -3)
+	tx := db.Begin(old)
+	if _, err := tx.Insert(&ItemTest{Name: "skewed"}); err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	if err := tx.Put(borm.CreateID(old.Add(-time.Hour), 1), &ItemTest{Name: "older"}); err != nil {
+		t.Fatalf("Error putting a skewed record: %s", err)
+	}
+	if err := tx.Put(borm.CreateID(now, 1), &ItemTest{Name: "now"}); err != borm.ErrOtherShard {
+		t.Fatalf("Put of the hot shard got %v wanted ErrOtherShard.", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+	if shards, err := db.Shards(); err != nil || len(shards) != 0 {
+		t.Fatalf("Expected no shard written, got %v: %v", shards, err)
+	}
+	quarantine := db.QuarantineFile()
+	db.Close()
+
+	raw, err := bolt.Open(quarantine, 0666, nil)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", quarantine, err)
+	}
+	defer raw.Close()
+	n := 0
+	raw.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte("attack")).Stats().KeyN
+		return nil
+	})
+	if n != 2 {
+		t.Fatalf("Expected 2 records in the quarantine shard, got %d.", n)
+	}
+
+	db, err = borm.OpenTSWithOptions(dir, &borm.TSOptions{MaxPastSkew: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	tx = db.Begin(old)
+	if _, err := tx.Insert(&ItemTest{Name: "rejected"}); err != borm.ErrClockSkew {
+		t.Fatalf("Insert of a rejected transaction got %v wanted ErrClockSkew.", err)
+	}
+	if err := tx.Commit(); err != borm.ErrClockSkew {
+		t.Fatalf("Commit of a rejected transaction got %v wanted ErrClockSkew.", err)
+	}
+}
@@ -0,0 +1,185 @@
+package borm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrInvalidRecord is matched by the errors of the writes rejected by a
+// validator, see ValidationError.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// Validator checks a record before it is put, with its key and its encoded
+// value, e.g. to reject the garbage of an upstream parser at the boundary
+// instead of storing it in the shards. An error rejects the write, return a
+// ValidationError to name the rule the record breaks.
+type Validator func(key, value []byte) error
+
+// Rules of the validators of the package, other validators may use their
+// own.
+const (
+	RuleSize      = "size"
+	RuleRequired  = "required"
+	RuleTimestamp = "timestamp"
+
+	// RuleValidator is the rule of the errors of the validators which are
+	// not ValidationErrors.
+	RuleValidator = "validator"
+)
+
+// ValidationError is the error of a write rejected by a validator,
+// errors.Is matches it with ErrInvalidRecord.
+type ValidationError struct {
+	// Rule is the rule the record breaks, e.g. RuleSize, the rejections
+	// are counted by rule, see EngineStats.Rejected.
+	Rule string
+	Key  string
+	Msg  string
+
+	// Err is the error of a validator which is not a ValidationError.
+	Err error
+
+	// value is the rejected value, for its dead letter.
+	value []byte
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Msg
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	return "invalid record " + strconv.Quote(e.Key) + ": " + e.Rule + ": " + msg
+}
+
+// Is makes errors.Is match the error with ErrInvalidRecord.
+func (e *ValidationError) Is(err error) bool {
+	return err == ErrInvalidRecord
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// MaxRecordSize returns a validator rejecting the records whose encoded value
+// is larger than max bytes.
+func MaxRecordSize(max int) Validator {
+	return func(key, value []byte) error {
+		if len(value) > max {
+			return &ValidationError{Rule: RuleSize, Msg: fmt.Sprintf("%d bytes exceeds %d", len(value), max)}
+		}
+		return nil
+	}
+}
+
+// RequireFields returns a validator rejecting the records which are not JSON
+// objects with the fields, dotted paths as in a Filter, e.g. "src.ip". A
+// null field is missing.
+func RequireFields(fields ...string) Validator {
+	return func(key, value []byte) error {
+		raw := map[string][]byte{}
+		err := scanJSONObject(value, func(key, rawKey, value []byte) {
+			for _, field := range fields {
+				if strings.SplitN(field, ".", 2)[0] == string(key) {
+					raw[string(key)] = value
+					return
+				}
+			}
+		})
+		if err != nil {
+			return &ValidationError{Rule: RuleRequired, Msg: "not a JSON object"}
+		}
+		r := &filterRecord{raw: raw}
+		for _, field := range fields {
+			if v, ok := r.lookup(field); !ok || v == nil {
+				return &ValidationError{Rule: RuleRequired, Msg: "missing field " + field}
+			}
+		}
+		return nil
+	}
+}
+
+// TimestampWithin returns a validator rejecting the records whose key has no
+// timestamp, or one more than past before or future after the time of the
+// clock, SystemClock if nil. A zero bound is unbounded. Unlike
+// TSOptions.MaxPastSkew it applies to every record put, those of
+// Backfill and of the imports included.
+func TimestampWithin(clock Clock, past, future time.Duration) Validator {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return func(key, value []byte) error {
+		t := TimeFromID(string(key))
+		if t.IsZero() {
+			return &ValidationError{Rule: RuleTimestamp, Msg: "key has no timestamp"}
+		}
+		now := clock.Now()
+		if past > 0 && t.Before(now.Add(-past)) {
+			return &ValidationError{Rule: RuleTimestamp, Msg: t.Format(time.RFC3339) + " is older than " + past.String()}
+		}
+		if future > 0 && t.After(now.Add(future)) {
+			return &ValidationError{Rule: RuleTimestamp, Msg: t.Format(time.RFC3339) + " is more than " + future.String() + " ahead"}
+		}
+		return nil
+	}
+}
+
+// rejections counts the records rejected by the validators by rule, or
+// the failures of the enrichers by name.
+type rejections struct {
+	mu     sync.Mutex
+	byRule map[string]int64
+}
+
+func (r *rejections) add(rule string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byRule == nil {
+		r.byRule = map[string]int64{}
+	}
+	r.byRule[rule]++
+}
+
+func (r *rejections) stats() map[string]int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[string]int64, len(r.byRule))
+	for rule, n := range r.byRule {
+		stats[rule] = n
+	}
+	return stats
+}
+
+// validate is the write hook running the validators on the records put, the
+// first of the hooks so a rejected record counts against no quota.
+func (db *TSEngine) validate(tx *bolt.Tx, key, value []byte) error {
+	if value == nil {
+		return nil
+	}
+	for _, validator := range db.options.Validators {
+		err := validator(key, value)
+		if err == nil {
+			continue
+		}
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			copied := *invalid
+			invalid = &copied
+		} else {
+			invalid = &ValidationError{Rule: RuleValidator, Err: err}
+		}
+		if invalid.Key == "" {
+			invalid.Key = string(key)
+		}
+		// the value may be in the arena of an append encoder
+		invalid.value = append([]byte(nil), value...)
+		db.rejected.add(invalid.Rule)
+		return invalid
+	}
+	return nil
+}
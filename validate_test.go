@@ -0,0 +1,78 @@
+package borm_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestValidators(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	db, err := borm.OpenTSWith(dir,
+		borm.WithCodec(borm.CodecJSON),
+		borm.WithChecksums(),
+		borm.WithValidators(
+			borm.MaxRecordSize(200),
+			borm.RequireFields("Name", "Created"),
+			borm.TimestampWithin(nil, 48*time.Hour, time.Hour),
+			func(key, value []byte) error {
+				if bytes.Contains(value, []byte(`"Name":"\u0000`)) {
+					return errors.New("unparsed name")
+				}
+				return nil
+			}))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	insert := func(key string, item interface{}) error {
+		return db.Write(now, func(bkt *borm.Bucket) error {
+			return bkt.Insert(key, item)
+		})
+	}
+	if err := insert(db.NewID(now), &ItemTest{Name: "car", Created: now}); err != nil {
+		t.Fatalf("Error inserting a valid record: %s", err)
+	}
+
+	for _, test := range []struct {
+		rule string
+		key  string
+		item interface{}
+	}{
+		{borm.RuleSize, db.NewID(now), &ItemTest{Name: string(make([]byte, 300)), Created: now}},
+		{borm.RuleRequired, db.NewID(now), map[string]interface{}{"Name": "car"}},
+		{borm.RuleRequired, db.NewID(now), map[string]interface{}{"Name": "car", "Created": nil}},
+		{borm.RuleTimestamp, "car", &ItemTest{Name: "car", Created: now}},
+		{borm.RuleTimestamp, db.NewID(now.AddDate(0, 0, -3)), &ItemTest{Name: "car", Created: now}},
+		{borm.RuleValidator, db.NewID(now), &ItemTest{Name: "\x00car", Created: now}},
+	} {
+		err := insert(test.key, test.item)
+		var invalid *borm.ValidationError
+		if !errors.Is(err, borm.ErrInvalidRecord) || !errors.As(err, &invalid) {
+			t.Fatalf("Inserting %+v got %v wanted %s.", test.item, err, borm.ErrInvalidRecord)
+		}
+		if invalid.Rule != test.rule || invalid.Key != test.key {
+			t.Fatalf("Inserting %+v got %+v wanted the rule %s of %s.", test.item, invalid, test.rule, test.key)
+		}
+	}
+
+	count, err := db.Count(now.Add(-time.Second), now.Add(time.Second), nil)
+	if err != nil || count != 1 {
+		t.Fatalf("Count got %d, %v wanted the valid record only.", count, err)
+	}
+	rejected := db.Stats().Rejected
+	want := map[string]int64{borm.RuleSize: 1, borm.RuleRequired: 2, borm.RuleTimestamp: 2, borm.RuleValidator: 1}
+	for rule, n := range want {
+		if rejected[rule] != n {
+			t.Fatalf("Rejected are %v wanted %v.", rejected, want)
+		}
+	}
+}
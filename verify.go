@@ -0,0 +1,171 @@
+package borm
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/boltdb/bolt"
+)
+
+// VerifyLevel is how much of the engine Verify checks.
+type VerifyLevel int
+
+const (
+	// VerifyQuick checks the manifest and the names of the shard files.
+	VerifyQuick VerifyLevel = iota
+
+	// VerifyDeep checks the pages of every shard too, and reads every
+	// record and index entry.
+	VerifyDeep
+)
+
+// Invariants of a Violation.
+const (
+	InvariantManifest   = "manifest"
+	InvariantShardName  = "shard-name"
+	InvariantPages      = "pages"
+	InvariantRecordTime = "record-time"
+	InvariantChecksum   = "checksum"
+	InvariantIndex      = "index"
+)
+
+// Violation is an invariant of the engine found broken by Verify, e.g. by a
+// bug or a shard file moved by hand.
+type Violation struct {
+	Invariant string
+	// Shard is the path of the shard, Key the record or the index entry,
+	// empty if the violation is not about one.
+	Shard string
+	Key   string
+	Msg   string
+}
+
+func (v Violation) String() string {
+	s := v.Invariant
+	if v.Shard != "" {
+		s += " " + filepath.Base(v.Shard)
+	}
+	if v.Key != "" {
+		s += " " + v.Key
+	}
+	return s + ": " + v.Msg
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Shards  int
+	Records int64
+	// Indexes are the index entries checked.
+	Indexes    int64
+	Violations []Violation
+}
+
+// OK reports whether no invariant is broken.
+func (r *VerifyReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *VerifyReport) violate(invariant, shard, key, format string, args ...interface{}) {
+	r.Violations = append(r.Violations, Violation{Invariant: invariant, Shard: shard, Key: key, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Verify checks the invariants of the engine at the level: the manifest
+// matches the options and the shard files, every shard file has the name of
+// its time range and, with VerifyDeep, the Bolt pages of the shards are
+// consistent, the records have a valid checksum and an embedded timestamp
+// in the range of their shard, and the index entries holding a record key
+// reference an existing record. The broken invariants are the violations of
+// the report, the error is the one of a shard failing to be read.
+func (db *TSEngine) Verify(level VerifyLevel) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	manifest, err := db.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		want := db.newManifest()
+		for _, field := range []struct{ name, have, want string }{
+			{"shard interval", manifest.ShardInterval, want.ShardInterval},
+			{"name pattern", manifest.NamePattern, want.NamePattern},
+			{"shard extension", manifest.ShardExtension, want.ShardExtension},
+			{"key codec", manifest.KeyCodec, want.KeyCodec},
+		} {
+			if field.have != field.want {
+				report.violate(InvariantManifest, "", "", "the %s is %q, the engine has %q", field.name, field.have, field.want)
+			}
+		}
+	}
+
+	shards, err := db.listShards(db.location())
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range shards {
+		report.Shards++
+		if name := db.nameWith(shard.startTime); !samePath(name, shard.path) {
+			report.violate(InvariantShardName, shard.path, "", "the shard from %s is named %s", shard.startTime, filepath.Base(name))
+		}
+		if manifest != nil && manifest.ShardInterval != "" && shard.granularity.String() != manifest.ShardInterval {
+			report.violate(InvariantManifest, shard.path, "", "the shard is %s, the manifest has %s", shard.granularity, manifest.ShardInterval)
+		}
+		if level < VerifyDeep {
+			continue
+		}
+		err := db.readBackground(shard.path, func(bkt *Bucket) error {
+			return bkt.store.db.View(func(tx *bolt.Tx) error {
+				return verifyShard(tx, bkt, shard, report)
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// verifyShard checks the pages, the records and the index entries of the
+// shard.
+func verifyShard(tx *bolt.Tx, bkt *Bucket, shard *Shard, report *VerifyReport) error {
+	for err := range tx.Check() {
+		report.violate(InvariantPages, shard.path, "", "%s", err)
+	}
+
+	data := tx.Bucket(bkt.name)
+	if data != nil {
+		err := data.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			report.Records++
+			if _, err := bkt.plain(v); err != nil {
+				report.violate(InvariantChecksum, shard.path, string(k), "%s", err)
+			}
+			if t := TimeFromID(string(k)); !t.IsZero() && (t.Before(shard.startTime) || !t.Before(shard.endTime)) {
+				report.violate(InvariantRecordTime, shard.path, string(k), "the record of %s is out of the shard from %s to %s", t, shard.startTime, shard.endTime)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	prefix := indexBucketName("")
+	return tx.ForEach(func(name []byte, index *bolt.Bucket) error {
+		if !bytes.HasPrefix(name, prefix) {
+			return nil
+		}
+		return index.ForEach(func(k, v []byte) error {
+			report.Indexes++
+			if ValidateID(string(v)) != nil {
+				// not a record key
+				return nil
+			}
+			if data == nil || data.Get(v) == nil {
+				report.violate(InvariantIndex, shard.path, string(k), "the entry of %s references the missing record %s", name[len(prefix):], v)
+			}
+			return nil
+		})
+	})
+}
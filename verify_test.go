@@ -0,0 +1,124 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestVerify(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	if err := db.Init(); err != nil {
+		t.Fatalf("Error initializing %s: %s", dir, err)
+	}
+
+	day := time.Now().AddDate(0, 0, -10)
+	var entries []borm.Entry
+	for i := 0; i < 6; i++ {
+		at := day.AddDate(0, 0, i%2).Add(time.Duration(i) * time.Second)
+		entries = append(entries, borm.Entry{Time: at, Value: &ItemTest{Name: "car", Created: at}})
+	}
+	if _, err := db.Backfill(entries, nil); err != nil {
+		t.Fatalf("Error backfilling: %s", err)
+	}
+
+	now := time.Now()
+	tx := db.Begin(now)
+	kept, err := tx.Insert(&ItemTest{Name: "kept", Created: now})
+	if err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	deleted, err := tx.Insert(&ItemTest{Name: "deleted", Created: now})
+	if err != nil {
+		t.Fatalf("Error inserting record: %s", err)
+	}
+	tx.PutIndex("by-name", "kept", []byte(kept))
+	tx.PutIndex("by-name", "deleted", []byte(deleted))
+	tx.PutIndex("by-name", "other", []byte("not a key"))
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+
+	report, err := db.Verify(borm.VerifyDeep)
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	if !report.OK() || report.Shards != 3 || report.Records != 8 || report.Indexes != 3 {
+		t.Fatalf("Report is %+v wanted 3 shards, 8 records and 3 index entries.", report)
+	}
+
+	tx = db.Begin(now)
+	tx.Delete(deleted)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %s", err)
+	}
+	moved := filepath.Join(dir, borm.Daily.Name(day.AddDate(0, 0, -3)))
+	if err := os.Rename(filepath.Join(dir, borm.Daily.Name(day)), moved); err != nil {
+		t.Fatalf("Error moving the shard: %s", err)
+	}
+
+	report, err = db.Verify(borm.VerifyDeep)
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	violations := map[string]int{}
+	for _, v := range report.Violations {
+		violations[v.Invariant]++
+		if v.Invariant == borm.InvariantRecordTime && v.Shard != moved {
+			t.Fatalf("Violation %s is not in the moved shard.", v)
+		}
+		if v.Invariant == borm.InvariantIndex && v.Key != "deleted" {
+			t.Fatalf("Violation %s is not the entry of the deleted record.", v)
+		}
+	}
+	if len(violations) != 2 || violations[borm.InvariantRecordTime] != 3 || violations[borm.InvariantIndex] != 1 {
+		t.Fatalf("Violations are %v wanted the 3 moved records and the deleted index entry.", report.Violations)
+	}
+
+	quick, err := db.Verify(borm.VerifyQuick)
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	if !quick.OK() || quick.Records != 0 {
+		t.Fatalf("Quick report is %+v wanted no record read.", quick)
+	}
+	db.Close()
+
+	// an engine of another layout is refused, a manifest changed since is a
+	// violation
+	if _, err := borm.OpenTSWith(dir, borm.WithShardInterval(borm.Hourly)); !errors.Is(err, borm.ErrIncompatible) {
+		t.Fatalf("Expected another shard interval refused, got %v", err)
+	}
+	db, err = borm.OpenTS(dir)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+	manifest, err := db.Manifest()
+	if err != nil {
+		t.Fatalf("Error reading the manifest: %s", err)
+	}
+	manifest.ShardInterval = borm.Hourly.String()
+	data, _ := json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(dir, ".borm.json"), data, 0666); err != nil {
+		t.Fatalf("Error writing the manifest: %s", err)
+	}
+	report, err = db.Verify(borm.VerifyQuick)
+	if err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+	if len(report.Violations) == 0 || report.Violations[0].Invariant != borm.InvariantManifest {
+		t.Fatalf("Violations are %v wanted the shard interval of the manifest.", report.Violations)
+	}
+}
@@ -0,0 +1,175 @@
+package borm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// versionsBucket is the shard bucket of the superseded versions of the
+// records of a versioned engine, keyed by the key of the record, the
+// versionSeparator and the time the version was superseded, see
+// TSOptions.Versioned.
+var versionsBucket = []byte("versions")
+
+// versionSeparator separates the key of a record from the suffix of its
+// versions, it is neither a hex digit nor the separator of composite keys.
+const versionSeparator = '@'
+
+// Version is a version of a record.
+type Version struct {
+	// Until is when the version was superseded by the next one, zero for
+	// the current version.
+	Until time.Time
+
+	// Value is the encoded value, decompressed.
+	Value []byte
+}
+
+// versionPrefix returns the prefix of the versions of the key.
+func versionPrefix(key []byte) []byte {
+	prefix := make([]byte, 0, len(key)+17)
+	prefix = append(prefix, key...)
+	return append(prefix, versionSeparator)
+}
+
+// versionKey returns the key of a version of the record superseded at t.
+func versionKey(prefix []byte, t time.Time) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(t.UnixNano()))
+	return append(prefix[:len(prefix):len(prefix)], hex.EncodeToString(b[:])...)
+}
+
+// keepVersions returns the write hook of a versioned engine saving the value
+// a put replaces as a version of the record. A delete deletes the versions
+// with the record, so a purge leaves nothing of it.
+func (db *TSEngine) keepVersions(bkt *Bucket) WriteHook {
+	return func(tx *bolt.Tx, key, value []byte) error {
+		versions, err := tx.CreateBucketIfNotExists(versionsBucket)
+		if err != nil {
+			return err
+		}
+		prefix := versionPrefix(key)
+		if value == nil {
+			c := versions.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		records := tx.Bucket(bkt.name)
+		if records == nil {
+			return nil
+		}
+		old := records.Get(key)
+		if old == nil {
+			return nil
+		}
+		plain, err := bkt.plain(old)
+		if err != nil {
+			return bkt.checksumError(key, err)
+		}
+		if bytes.Equal(plain, value) {
+			return nil
+		}
+		if bkt.checksums {
+			plain = addChecksum(plain)
+		} else {
+			plain = append([]byte(nil), plain...)
+		}
+		// versions superseded in the same nanosecond keep their order
+		t := db.now()
+		k := versionKey(prefix, t)
+		for versions.Get(k) != nil {
+			t = t.Add(1)
+			k = versionKey(prefix, t)
+		}
+		return versions.Put(k, plain)
+	}
+}
+
+// History returns the versions of the record of the ID, oldest first, the
+// last one is the current value. It returns ErrNotFound if the record
+// doesn't exist, a record of an engine which is not versioned has its
+// current version only.
+func (db *TSEngine) History(id string) ([]Version, error) {
+	var history []Version
+	err := db.history(id, func(bkt *Bucket, versions []Version) error {
+		history = versions
+		return nil
+	})
+	return history, err
+}
+
+// GetAt decodes into record the version of the record of the ID at t, the
+// one superseded first after t, or the current one. Of a record written
+// after t it returns its first version, the record times are up to the
+// caller.
+func (db *TSEngine) GetAt(id string, t time.Time, record interface{}) error {
+	return db.history(id, func(bkt *Bucket, history []Version) error {
+		version := history[len(history)-1]
+		for _, v := range history[:len(history)-1] {
+			if v.Until.After(t) {
+				version = v
+				break
+			}
+		}
+		return bkt.decodeValue(version.Value, record)
+	})
+}
+
+// history calls cb with the records bucket of the shard of the ID and the
+// versions of its record.
+func (db *TSEngine) history(id string, cb func(bkt *Bucket, history []Version) error) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	return db.read(db.nameWith(TimeFromID(id)), func(bkt *Bucket) error {
+		var history []Version
+		err := bkt.view(func(tx *bolt.Tx) error {
+			records := tx.Bucket(bkt.name)
+			if records == nil {
+				return ErrNotFound
+			}
+			current := records.Get([]byte(id))
+			if current == nil {
+				return ErrNotFound
+			}
+			if versions := tx.Bucket(versionsBucket); versions != nil {
+				prefix := versionPrefix([]byte(id))
+				c := versions.Cursor()
+				for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+					nanos, err := hex.DecodeString(string(k[len(prefix):]))
+					if err != nil || len(nanos) != 8 {
+						continue
+					}
+					value, err := bkt.plain(v)
+					if err != nil {
+						return bkt.checksumError(k, err)
+					}
+					history = append(history, Version{
+						Until: time.Unix(0, int64(binary.BigEndian.Uint64(nanos))),
+						Value: append([]byte(nil), value...),
+					})
+				}
+			}
+			value, err := bkt.plain(current)
+			if err != nil {
+				return bkt.checksumError([]byte(id), err)
+			}
+			history = append(history, Version{Value: append([]byte(nil), value...)})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		db.recordsRead.Add(1)
+		return cb(bkt, history)
+	})
+}
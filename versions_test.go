@@ -0,0 +1,82 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestVersions(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	day := time.Date(2020, 3, 1, 12, 0, 0, 0, time.Local)
+	clock := borm.NewManualClock(day)
+	db, err := borm.OpenTSWith(dir, borm.WithVersions(), borm.WithChecksums(), borm.WithClock(clock))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	id := db.NewID(day)
+	put := func(item *ItemTest) {
+		err := db.Write(day, func(bkt *borm.Bucket) error {
+			return bkt.Upsert(id, item)
+		})
+		if err != nil {
+			t.Fatalf("Error putting %+v: %s", item, err)
+		}
+	}
+	put(&ItemTest{Name: "car"})
+	clock.Advance(time.Hour)
+	put(&ItemTest{Name: "car", Category: "vehicle"})
+	put(&ItemTest{Name: "car", Category: "vehicle"})
+	clock.Advance(time.Hour)
+	put(&ItemTest{Name: "car", Category: "vehicle", Color: "red"})
+
+	history, err := db.History(id)
+	if err != nil {
+		t.Fatalf("Error reading history: %s", err)
+	}
+	if len(history) != 3 || !history[0].Until.Equal(day.Add(time.Hour)) || !history[1].Until.Equal(day.Add(2*time.Hour)) || !history[2].Until.IsZero() {
+		t.Fatalf("History is %+v wanted 3 versions.", history)
+	}
+
+	for _, test := range []struct {
+		at   time.Time
+		want ItemTest
+	}{
+		{day.Add(-time.Hour), ItemTest{Name: "car"}},
+		{day.Add(30 * time.Minute), ItemTest{Name: "car"}},
+		{day.Add(time.Hour), ItemTest{Name: "car", Category: "vehicle"}},
+		{day.Add(3 * time.Hour), ItemTest{Name: "car", Category: "vehicle", Color: "red"}},
+	} {
+		var item ItemTest
+		if err := db.GetAt(id, test.at, &item); err != nil {
+			t.Fatalf("Error getting at %s: %s", test.at, err)
+		}
+		if item.Name != test.want.Name || item.Category != test.want.Category || item.Color != test.want.Color {
+			t.Fatalf("Record at %s is %+v wanted %+v.", test.at, item, test.want)
+		}
+	}
+	var item ItemTest
+	if err := db.Get(id, &item); err != nil || item.Color != "red" {
+		t.Fatalf("Get got %+v, %v wanted the current version.", item, err)
+	}
+
+	err = db.Write(day, func(bkt *borm.Bucket) error {
+		return bkt.Delete(id)
+	})
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+	put(&ItemTest{Name: "bike"})
+	if history, err := db.History(id); err != nil || len(history) != 1 {
+		t.Fatalf("History got %+v, %v wanted the versions deleted.", history, err)
+	}
+	if _, err := db.History(db.NewID(day)); err != borm.ErrNotFound {
+		t.Fatalf("History of a missing record got %v wanted %s.", err, borm.ErrNotFound)
+	}
+}
@@ -0,0 +1,123 @@
+package borm
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// View is a dataset derived from the records, e.g. "only blocked attacks",
+// stored in its own bucket of every shard and maintained on write.
+type View struct {
+	Name string
+
+	// Bucket is the shard bucket holding the view, it defaults to "view:" + Name.
+	Bucket string
+
+	// Filter returns whether the record belongs to the view, nil keeps all records.
+	Filter func(key, value []byte) bool
+
+	// Transform returns the value stored in the view for the record, nil
+	// stores the record value itself.
+	Transform func(key, value []byte) ([]byte, error)
+}
+
+func (v *View) bucketName() []byte {
+	if v.Bucket != "" {
+		return []byte(v.Bucket)
+	}
+	return []byte("view:" + v.Name)
+}
+
+// apply updates the view for a write of the record, value is nil for a delete.
+func (v *View) apply(tx *bolt.Tx, key, value []byte) error {
+	bkt, err := tx.CreateBucketIfNotExists(v.bucketName())
+	if err != nil {
+		return err
+	}
+	if value == nil || (v.Filter != nil && !v.Filter(key, value)) {
+		return bkt.Delete(key)
+	}
+	if v.Transform != nil {
+		value, err = v.Transform(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return bkt.Put(key, value)
+}
+
+func (db *TSEngine) view(name string) (*View, error) {
+	for i := range db.options.Views {
+		if db.options.Views[i].Name == name {
+			return &db.options.Views[i], nil
+		}
+	}
+	return nil, errors.New("view " + name + " is not registered")
+}
+
+// addViewHooks makes the bucket maintain the registered views.
+func (db *TSEngine) addViewHooks(bkt *Bucket) {
+	for i := range db.options.Views {
+		bkt.AddWriteHook(db.options.Views[i].apply)
+	}
+}
+
+// RebuildView recomputes the view from the records of the shards in the time range.
+func (db *TSEngine) RebuildView(name string, start, end time.Time) error {
+	v, err := db.view(name)
+	if err != nil {
+		return err
+	}
+
+	return filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		return db.readBackground(fileName, func(bkt *Bucket) error {
+			return bkt.store.db.Update(func(tx *bolt.Tx) error {
+				if tx.Bucket(v.bucketName()) != nil {
+					if err := tx.DeleteBucket(v.bucketName()); err != nil {
+						return err
+					}
+				}
+				records := tx.Bucket(bkt.name)
+				if records == nil {
+					return ErrBucketNotFound
+				}
+				return records.ForEach(func(k, value []byte) error {
+					value, err := bkt.plain(value)
+					if err != nil {
+						return bkt.checksumError(k, err)
+					}
+					return v.apply(tx, k, value)
+				})
+			})
+		})
+	})
+}
+
+// QueryView retrieves the records of the view in the time range.
+func (db *TSEngine) QueryView(name string, start, end time.Time, cb func(it *Iterator) error) error {
+	v, err := db.view(name)
+	if err != nil {
+		return err
+	}
+
+	err = filesRead(db.nameWith, db.options.ShardInterval, start, end, func(position int, day time.Time, fileName string) error {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			return nil
+		}
+		return db.read(fileName, func(bkt *Bucket) error {
+			viewBkt := bkt.store.newBucket(string(v.bucketName()), bkt.encode, bkt.decode)
+			err := viewBkt.getRanges(keyRanges(position, start, end), cb)
+			if errors.Is(err, ErrBucketNotFound) {
+				return nil
+			}
+			return err
+		})
+	})
+	return stopped(err)
+}
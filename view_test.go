@@ -0,0 +1,71 @@
+package borm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestView(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	isFood := func(key, value []byte) bool {
+		var item ItemTest
+		return borm.DefaultDecode(value, &item) == nil && item.Category == "food"
+	}
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Views: []borm.View{{Name: "food", Filter: isFood}},
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	err = db.Write(now, func(bkt *borm.Bucket) error {
+		for i := range testData {
+			if err := bkt.Insert(borm.CreateID(now, uint32(i)), &testData[i]); err != nil {
+				return err
+			}
+		}
+		return bkt.Delete(borm.CreateID(now, 4))
+	})
+	if err != nil {
+		t.Fatalf("Error writing test data: %s", err)
+	}
+
+	countView := func() int {
+		count := 0
+		err := db.QueryView("food", now.Add(-time.Second), now.Add(time.Second), func(it *borm.Iterator) error {
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					return err
+				}
+				if item.Category != "food" {
+					t.Fatalf("View holds %v.", item)
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying view: %s", err)
+		}
+		return count
+	}
+
+	if count := countView(); count != 4 {
+		t.Fatalf("View holds %d records wanted %d.", count, 4)
+	}
+
+	if err := db.RebuildView("food", now, now); err != nil {
+		t.Fatalf("Error rebuilding view: %s", err)
+	}
+	if count := countView(); count != 4 {
+		t.Fatalf("Rebuilt view holds %d records wanted %d.", count, 4)
+	}
+}
@@ -0,0 +1,627 @@
+package borm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrWriterClosed is returned by the writes of a closed AsyncWriter.
+var ErrWriterClosed = errors.New("async writer is closed")
+
+// OverflowPolicy is what an AsyncWriter does with a write when it is
+// congested, its queue full or the disk slow.
+type OverflowPolicy int
+
+const (
+	// Block makes the write wait for room in the queue.
+	Block OverflowPolicy = iota
+	// DropOldest drops the oldest queued record to make room.
+	DropOldest
+	// DropNewest drops the record written.
+	DropNewest
+	// Spill appends the record to the overflow file, which is written into
+	// the engine once the queue is drained.
+	Spill
+)
+
+// AsyncOptions allows you set different options from the defaults for an
+// AsyncWriter
+type AsyncOptions struct {
+	// Capacity is the number of records queued, it defaults to 10000.
+	Capacity int
+
+	// BatchSize is the number of records written in one transaction, it
+	// defaults to 1000.
+	BatchSize int
+
+	// FlushInterval is the longest time a record stays queued, it defaults
+	// to a second.
+	FlushInterval time.Duration
+
+	// Overflow is the policy of the writes when the writer is congested,
+	// Block by default.
+	Overflow OverflowPolicy
+
+	// MaxLatency makes the writer congested, while the last batch took
+	// longer to commit and more than a batch is queued, 0 only considers the
+	// queue. A blocking writer only waits for a full queue.
+	MaxLatency time.Duration
+
+	// SpillFile is the overflow file of the Spill policy.
+	SpillFile string
+
+	// Adaptive adapts the batch size to the disk, the same options then work
+	// on SSDs and slow SD cards: the batch grows by a tenth of BatchSize
+	// while commits are faster than TargetLatency and records are waiting,
+	// and is halved when a commit is slower (AIMD).
+	Adaptive bool
+
+	// TargetLatency is the commit time the adaptive batches aim for, it
+	// defaults to 50ms.
+	TargetLatency time.Duration
+
+	// MinBatchSize and MaxBatchSize bound the adaptive batches, they default
+	// to 10 and to 10 times BatchSize.
+	MinBatchSize int
+	MaxBatchSize int
+
+	// OnError is called with the batches failing to be written, which are
+	// dropped, nil logs them.
+	OnError func(err error)
+}
+
+// AsyncStats are the counters of an AsyncWriter.
+type AsyncStats struct {
+	Queued  int
+	Written int64
+	Dropped int64
+	Spilled int64
+	Failed  int64
+
+	// BatchSize is the current size of the batches.
+	BatchSize int
+
+	// LastLatency is the commit time of the last batch.
+	LastLatency time.Duration
+}
+
+// AsyncWriter queues the writes of the engine and writes them in batches
+// from a background goroutine, so writers don't wait for the disk.
+type AsyncWriter struct {
+	db   *TSEngine
+	opts AsyncOptions
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []Entry
+	stats   AsyncStats
+	closed  bool
+	flushes int // the flushes requested but not done
+	spilled bool
+
+	// batchSize is BatchSize, or the adapted one.
+	batchSize int
+
+	// spillMu guards the overflow file.
+	spillMu sync.Mutex
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// NewAsyncWriter returns a writer queuing the writes of the engine, Close
+// it before the engine.
+func (db *TSEngine) NewAsyncWriter(opts *AsyncOptions) (*AsyncWriter, error) {
+	w := &AsyncWriter{db: db, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	if opts != nil {
+		w.opts = *opts
+	}
+	if w.opts.Capacity <= 0 {
+		w.opts.Capacity = 10000
+	}
+	if w.opts.BatchSize <= 0 {
+		w.opts.BatchSize = 1000
+	}
+	if w.opts.FlushInterval <= 0 {
+		w.opts.FlushInterval = time.Second
+	}
+	if w.opts.TargetLatency <= 0 {
+		w.opts.TargetLatency = 50 * time.Millisecond
+	}
+	if w.opts.MinBatchSize <= 0 {
+		w.opts.MinBatchSize = 10
+	}
+	if w.opts.MaxBatchSize < w.opts.BatchSize {
+		w.opts.MaxBatchSize = 10 * w.opts.BatchSize
+	}
+	w.batchSize = w.opts.BatchSize
+	if w.opts.Overflow == Spill {
+		if w.opts.SpillFile == "" {
+			return nil, errors.New("spill policy needs a spill file")
+		}
+		// records left by a previous run are written first
+		if fi, err := os.Stat(w.opts.SpillFile); err == nil && fi.Size() > 0 {
+			w.spilled = true
+		}
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w, nil
+}
+
+// Write queues the record of t, e.g. &ItemTest{...}.
+func (w *AsyncWriter) Write(t time.Time, value interface{}) error {
+	return w.WriteEntry(Entry{Time: t, Value: value})
+}
+
+// WriteEntry queues the entry, see Backfill for its key.
+func (w *AsyncWriter) WriteEntry(entry Entry) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+
+	if w.congested() {
+		switch w.opts.Overflow {
+		case DropOldest:
+			w.queue = w.queue[1:]
+			w.stats.Dropped++
+		case DropNewest:
+			w.stats.Dropped++
+			w.mu.Unlock()
+			return nil
+		case Spill:
+			w.mu.Unlock()
+			return w.spill(entry)
+		default:
+			for len(w.queue) >= w.opts.Capacity && !w.closed {
+				w.cond.Wait()
+			}
+			if w.closed {
+				w.mu.Unlock()
+				return ErrWriterClosed
+			}
+		}
+	}
+
+	w.queue = append(w.queue, entry)
+	full := len(w.queue) >= w.batchSize
+	w.mu.Unlock()
+	if full {
+		w.signal()
+	}
+	return nil
+}
+
+// congested returns whether a write needs the overflow policy, w.mu is held.
+func (w *AsyncWriter) congested() bool {
+	if len(w.queue) >= w.opts.Capacity {
+		return true
+	}
+	return w.opts.MaxLatency > 0 && w.stats.LastLatency > w.opts.MaxLatency &&
+		len(w.queue) >= w.batchSize && w.opts.Overflow != Block
+}
+
+// spilledEntry is an entry of the overflow file, encoded so it is written
+// with the key it was given.
+type spilledEntry struct {
+	Time  time.Time
+	Key   string
+	Value []byte
+}
+
+// encodedValue is the value of an entry replayed from the overflow file,
+// encoded already.
+type encodedValue []byte
+
+// spill appends the entry to the overflow file, encoded by the bucket of the
+// shard it is routed to.
+func (w *AsyncWriter) spill(entry Entry) error {
+	db := w.db
+	key := entry.Key
+	if key == "" {
+		key = db.NewID(entry.Time)
+	}
+	file, err := db.route(entry.Time)
+	if err != nil {
+		return err
+	}
+	var value []byte
+	err = db.read(file, func(bkt *Bucket) error {
+		value, err = bkt.encodeValue(entry.Value)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(&spilledEntry{Time: entry.Time, Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+
+	w.spillMu.Lock()
+	defer w.spillMu.Unlock()
+	f, err := os.OpenFile(w.opts.SpillFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.stats.Spilled++
+	w.spilled = true
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *AsyncWriter) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Flush waits until the records queued and spilled are written.
+func (w *AsyncWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.flushes++
+	w.signal()
+	for w.flushes > 0 && !w.closed {
+		w.cond.Wait()
+	}
+	return nil
+}
+
+// Close writes the queued records and stops the writer, the engine is not
+// closed.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	w.signal()
+	<-w.done
+	return nil
+}
+
+// Stats returns the counters of the writer.
+func (w *AsyncWriter) Stats() AsyncStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Queued = len(w.queue)
+	stats.BatchSize = w.batchSize
+	return stats
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.wake:
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		closed, flushes := w.closed, w.flushes
+		w.mu.Unlock()
+		w.drain()
+		if closed {
+			return
+		}
+		if flushes > 0 {
+			w.mu.Lock()
+			w.flushes -= flushes
+			w.cond.Broadcast()
+			w.mu.Unlock()
+		}
+	}
+}
+
+// drain writes the queue, then the overflow file.
+func (w *AsyncWriter) drain() {
+	for {
+		w.mu.Lock()
+		n := len(w.queue)
+		if n > w.batchSize {
+			n = w.batchSize
+		}
+		batch := w.queue[:n:n]
+		w.queue = w.queue[n:]
+		spilled := w.spilled
+		w.cond.Broadcast()
+		w.mu.Unlock()
+
+		if n == 0 {
+			if spilled {
+				w.replay()
+			}
+			return
+		}
+
+		w.write(batch)
+	}
+}
+
+// write writes a batch through the write path of the engine, the entries
+// failing are dropped. It returns the entries failing for another reason than
+// the entry itself, which may be written later.
+func (w *AsyncWriter) write(batch []Entry) []Entry {
+	var errs []error
+	var retry []Entry
+	failed := 0
+	start := time.Now()
+	w.db.writeBatch(batch, func(entries []Entry, err error) {
+		failed += len(entries)
+		errs = append(errs, err)
+		if !rejected(err) {
+			retry = append(retry, entries...)
+		}
+	})
+	latency := time.Since(start)
+
+	w.mu.Lock()
+	w.stats.LastLatency = latency
+	w.adapt(latency)
+	w.stats.Failed += int64(failed)
+	w.stats.Written += int64(len(batch) - failed)
+	w.mu.Unlock()
+	for _, err := range errs {
+		w.report(err)
+	}
+	return retry
+}
+
+// rejected returns whether err rejects the entry itself, so writing it again
+// fails the same.
+func rejected(err error) bool {
+	var mismatch *ErrTypeMismatch
+	return errors.Is(err, ErrInvalidRecord) || errors.Is(err, ErrEnrichment) ||
+		errors.Is(err, ErrKeyExists) || errors.Is(err, ErrClockSkew) ||
+		errors.Is(err, ErrQuotaExceeded) || errors.As(err, &mismatch)
+}
+
+// writeBatch writes the entries as Write does, routed, checked and shadowed,
+// with one transaction per shard. A transaction failing is retried entry by
+// entry, fail is called with each entry failing to be routed or written, the
+// others are written.
+func (db *TSEngine) writeBatch(entries []Entry, fail func(entries []Entry, err error)) {
+	var files []string
+	groups := map[string][]Entry{}
+	for _, entry := range entries {
+		if entry.Key == "" {
+			// created once, so the shadow gets the same keys
+			entry.Key = db.NewID(entry.Time)
+		}
+		file, err := db.route(entry.Time)
+		if err != nil {
+			fail([]Entry{entry}, err)
+			continue
+		}
+		if _, ok := groups[file]; !ok {
+			files = append(files, file)
+		}
+		groups[file] = append(groups[file], entry)
+	}
+
+	var written []Entry
+	for _, file := range files {
+		group := groups[file]
+		err := db.writeRouted(file, group[0].Time, func(bkt *Bucket) error {
+			return insertEntries(bkt, group)
+		})
+		if err == nil {
+			written = append(written, group...)
+			continue
+		}
+		if len(group) == 1 {
+			fail(group, err)
+			continue
+		}
+		// the transaction is rolled back, so only the entries failing alone
+		// are rejected
+		for _, entry := range group {
+			entry := entry
+			err := db.writeRouted(file, entry.Time, func(bkt *Bucket) error {
+				return insertEntries(bkt, []Entry{entry})
+			})
+			if err != nil {
+				fail([]Entry{entry}, err)
+				continue
+			}
+			written = append(written, entry)
+		}
+	}
+
+	if db.options.Shadow != nil && len(written) > 0 {
+		db.options.Shadow.writeBatch(written, func(entries []Entry, err error) {
+			db.shadowError(entries[0].Time, err)
+		})
+	}
+}
+
+// insertEntries inserts the entries in one transaction, the encoded values
+// are enriched and checked like the records.
+func insertEntries(bkt *Bucket, entries []Entry) error {
+	a := getArena()
+	defer a.release()
+	return bkt.store.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bkt.name)
+		if data == nil {
+			return ErrBucketNotFound
+		}
+		u := &txUpdater{b: bkt, tx: tx, bkt: data, arena: a}
+		for _, entry := range entries {
+			value, ok := entry.Value.(encodedValue)
+			if !ok {
+				if err := u.Insert(entry.Key, entry.Value); err != nil {
+					return err
+				}
+				continue
+			}
+			key := []byte(entry.Key)
+			if data.Get(key) != nil {
+				return ErrKeyExists
+			}
+			if err := bkt.put(tx, data, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// replay writes the overflow file in batches and truncates it, the entries
+// failing for another reason than the entry itself are kept in the file for
+// the next replay.
+func (w *AsyncWriter) replay() {
+	w.spillMu.Lock()
+	defer w.spillMu.Unlock()
+
+	f, err := os.Open(w.opts.SpillFile)
+	if err != nil && !os.IsNotExist(err) {
+		w.report(err)
+		return
+	}
+	var retry []spilledEntry
+	if f != nil {
+		retry, err = w.replayFile(f)
+		f.Close()
+		if err != nil {
+			w.report(err)
+			return
+		}
+	}
+	if len(retry) > 0 {
+		if err := w.rewrite(retry); err != nil {
+			w.report(err)
+		}
+		return
+	}
+	if err := os.Truncate(w.opts.SpillFile, 0); err != nil && !os.IsNotExist(err) {
+		w.report(err)
+		return
+	}
+	w.mu.Lock()
+	w.spilled = false
+	w.mu.Unlock()
+}
+
+func (w *AsyncWriter) replayFile(f *os.File) ([]spilledEntry, error) {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	var batch, retry []spilledEntry
+	write := func() {
+		entries := make([]Entry, len(batch))
+		for i, entry := range batch {
+			entries[i] = Entry{Time: entry.Time, Key: entry.Key, Value: encodedValue(entry.Value)}
+		}
+		for _, entry := range w.write(entries) {
+			retry = append(retry, spilledEntry{Time: entry.Time, Key: entry.Key, Value: entry.Value.(encodedValue)})
+		}
+		batch = nil
+	}
+	for scanner.Scan() {
+		var entry spilledEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		w.mu.Lock()
+		size := w.batchSize
+		w.mu.Unlock()
+		batch = append(batch, entry)
+		if len(batch) >= size {
+			write()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(batch) > 0 {
+		write()
+	}
+	return retry, nil
+}
+
+// rewrite replaces the overflow file with the entries, w.spillMu is held.
+func (w *AsyncWriter) rewrite(entries []spilledEntry) error {
+	tmp := w.opts.SpillFile + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	enc := json.NewEncoder(out)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.opts.SpillFile)
+}
+
+// adapt sizes the next batch after the commit of one, w.mu is held.
+func (w *AsyncWriter) adapt(latency time.Duration) {
+	if !w.opts.Adaptive {
+		return
+	}
+	if latency > w.opts.TargetLatency {
+		if w.batchSize /= 2; w.batchSize < w.opts.MinBatchSize {
+			w.batchSize = w.opts.MinBatchSize
+		}
+		return
+	}
+	if len(w.queue) >= w.batchSize || w.spilled {
+		step := w.opts.BatchSize / 10
+		if step < 1 {
+			step = 1
+		}
+		if w.batchSize += step; w.batchSize > w.opts.MaxBatchSize {
+			w.batchSize = w.opts.MaxBatchSize
+		}
+	}
+}
+
+func (w *AsyncWriter) report(err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(err)
+	} else {
+		w.db.logger().Printf("engine failed to write async records: %s", err)
+	}
+}
@@ -0,0 +1,300 @@
+package borm_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/borm"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	names := func(db *borm.TSEngine) []string {
+		var names []string
+		err := db.Query(start, time.Now().Add(time.Hour), func(it *borm.Iterator) error {
+			for it.Next() {
+				var item ItemTest
+				if err := it.Read(&item); err != nil {
+					return err
+				}
+				names = append(names, item.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error querying: %s", err)
+		}
+		return names
+	}
+
+	for _, c := range []struct {
+		policy  borm.OverflowPolicy
+		first   string
+		written int
+		dropped int64
+		spilled int64
+	}{
+		{borm.Block, "a", 1200, 0, 0},
+		{borm.DropOldest, "d", 5, 3, 0},
+		{borm.DropNewest, "a", 5, 3, 0},
+		{borm.Spill, "a", 8, 0, 3},
+	} {
+		dir := tempdir(t)
+		db, err := borm.OpenTSWithOptions(dir, nil)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", dir, err)
+		}
+
+		// nothing is flushed before Close, so the queue of 5 is full
+		opts := &borm.AsyncOptions{Capacity: 5, BatchSize: 100, FlushInterval: time.Hour, Overflow: c.policy, SpillFile: filepath.Join(dir, "spill")}
+		n := 8
+		if c.policy == borm.Block {
+			opts = &borm.AsyncOptions{Capacity: 100, BatchSize: 50, Overflow: borm.Block}
+			n = 1200
+		}
+		w, err := db.NewAsyncWriter(opts)
+		if err != nil {
+			t.Fatalf("Error creating writer: %s", err)
+		}
+		for i := 0; i < n; i++ {
+			if err := w.Write(start.Add(time.Duration(i)*time.Second), &ItemTest{Name: string(rune('a' + i%26))}); err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+		stats := w.Stats()
+		if stats.Dropped != c.dropped || stats.Spilled != c.spilled {
+			t.Fatalf("Policy %d: expected %d dropped and %d spilled, got %+v.", c.policy, c.dropped, c.spilled, stats)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Error closing writer: %s", err)
+		}
+		if err := w.Write(start, &ItemTest{}); err != borm.ErrWriterClosed {
+			t.Fatalf("Expected ErrWriterClosed, got %v.", err)
+		}
+
+		got := names(db)
+		if len(got) != c.written || got[0] != c.first {
+			t.Fatalf("Policy %d: expected %d records from %q, got %d %v.", c.policy, c.written, c.first, len(got), got)
+		}
+		if w.Stats().Written != int64(c.written) {
+			t.Fatalf("Policy %d: expected %d written, got %+v.", c.policy, c.written, w.Stats())
+		}
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAsyncWriterAdaptive(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	// the namespace of the quotas slows the commits down like a slow disk
+	var slow int32
+	db, err := borm.OpenTSWithOptions(dir, &borm.TSOptions{
+		Namespace: func(key, value []byte) string {
+			if atomic.LoadInt32(&slow) != 0 {
+				time.Sleep(2 * time.Millisecond)
+			}
+			return ""
+		},
+		DefaultQuota: borm.Quota{Records: 1 << 30},
+	})
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	w, err := db.NewAsyncWriter(&borm.AsyncOptions{
+		Capacity:      10000,
+		BatchSize:     100,
+		Adaptive:      true,
+		TargetLatency: 50 * time.Millisecond,
+		MinBatchSize:  10,
+	})
+	if err != nil {
+		t.Fatalf("Error creating writer: %s", err)
+	}
+	defer w.Close()
+	write := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := w.Write(time.Now(), &ItemTest{Name: "x"}); err != nil {
+				t.Fatalf("Error writing: %s", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Error flushing: %s", err)
+		}
+	}
+
+	atomic.StoreInt32(&slow, 1)
+	write(300)
+	if size := w.Stats().BatchSize; size >= 100 {
+		t.Fatalf("Expected the batches to shrink on a slow disk, got %d.", size)
+	}
+
+	atomic.StoreInt32(&slow, 0)
+	before := w.Stats().BatchSize
+	write(5000)
+	if size := w.Stats().BatchSize; size <= before {
+		t.Fatalf("Expected the batches to grow from %d with a backlog, got %d.", before, size)
+	}
+}
+
+func TestAsyncWriterWritePath(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWith(dir,
+		borm.WithTSOptions(&borm.TSOptions{MaxPastSkew: 24 * time.Hour, SkewAction: borm.SkewQuarantine}),
+		borm.WithCodec(borm.CodecJSON),
+		borm.WithEnrichers(borm.Enricher{Name: "tag", Enrich: func(key, value []byte) ([]byte, error) {
+			var record map[string]string
+			if err := json.Unmarshal(value, &record); err != nil {
+				return nil, err
+			}
+			record["Tag"] = "enriched"
+			return json.Marshal(record)
+		}}),
+		borm.WithValidators(func(key, value []byte) error {
+			if strings.Contains(string(value), "invalid") {
+				return errors.New("invalid record")
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	var failures int32
+	w, err := db.NewAsyncWriter(&borm.AsyncOptions{
+		Capacity:      2,
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+		Overflow:      borm.Spill,
+		SpillFile:     filepath.Join(dir, "spill"),
+		OnError:       func(err error) { atomic.AddInt32(&failures, 1) },
+	})
+	if err != nil {
+		t.Fatalf("Error creating writer: %s", err)
+	}
+
+	// the first two of a flush are queued, the others spilled
+	now := time.Now()
+	write := func(at time.Time, name string) {
+		if err := w.WriteEntry(borm.Entry{Time: at, Key: db.NewID(at), Value: map[string]string{"Name": name}}); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+	write(now, "queued")
+	write(now.AddDate(0, 0, -10), "skewed")
+	write(now, "spilled")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if stats := w.Stats(); stats.Spilled != 1 || stats.Written != 3 {
+		t.Fatalf("Expected 3 written, got %+v.", stats)
+	}
+	write(now, "queued")
+	write(now, "queued")
+	write(now, "invalid")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error closing writer: %s", err)
+	}
+	if stats := w.Stats(); stats.Spilled != 2 || stats.Written != 5 || stats.Failed != 1 || failures != 1 {
+		t.Fatalf("Expected 5 written and the invalid record failed, got %+v.", stats)
+	}
+
+	var names []string
+	err = db.Query(now.Add(-time.Hour), now.Add(time.Hour), func(it *borm.Iterator) error {
+		for it.Next() {
+			var record map[string]string
+			if err := it.Read(&record); err != nil {
+				return err
+			}
+			if record["Tag"] != "enriched" {
+				t.Errorf("Record %v is not enriched.", record)
+			}
+			names = append(names, record["Name"])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	sort.Strings(names)
+	if len(names) != 4 || names[0] != "queued" || names[3] != "spilled" {
+		t.Fatalf("Expected the queued and spilled records, got %v.", names)
+	}
+	if _, err := os.Stat(db.QuarantineFile()); err != nil {
+		t.Fatalf("Expected the skewed record in the quarantine shard: %s", err)
+	}
+}
+
+func TestAsyncWriterBadEntry(t *testing.T) {
+	dir := tempdir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := borm.OpenTSWith(dir,
+		borm.WithCodec(borm.CodecJSON),
+		borm.WithValidators(func(key, value []byte) error {
+			if strings.Contains(string(value), "invalid") {
+				return errors.New("invalid record")
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", dir, err)
+	}
+	defer db.Close()
+
+	var failures int32
+	w, err := db.NewAsyncWriter(&borm.AsyncOptions{
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+		OnError:       func(err error) { atomic.AddInt32(&failures, 1) },
+	})
+	if err != nil {
+		t.Fatalf("Error creating writer: %s", err)
+	}
+	defer w.Close()
+
+	// one batch, one shard, one transaction failing on the invalid record
+	now := time.Now()
+	for i, name := range []string{"a", "b", "invalid", "c", "d"} {
+		if err := w.Write(now.Add(time.Duration(i)*time.Millisecond), map[string]string{"Name": name}); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if stats := w.Stats(); stats.Written != 4 || stats.Failed != 1 || failures != 1 {
+		t.Fatalf("Expected only the invalid record failed, got %+v.", stats)
+	}
+
+	var names []string
+	err = db.Query(now.Add(-time.Hour), now.Add(time.Hour), func(it *borm.Iterator) error {
+		for it.Next() {
+			var record map[string]string
+			if err := it.Read(&record); err != nil {
+				return err
+			}
+			names = append(names, record["Name"])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error querying: %s", err)
+	}
+	if strings.Join(names, ",") != "a,b,c,d" {
+		t.Fatalf("Expected the valid records of the batch, got %v.", names)
+	}
+}